@@ -0,0 +1,107 @@
+package cellar
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// AsRecordWriter returns an io.Writer appending every Write call as a single record, which makes the writer
+// a sink for encoders such as json.Encoder. Records are all or nothing: Write either appends all of p and
+// returns len(p), or appends nothing and returns 0 with the error of Append.
+func (w *Writer) AsRecordWriter() io.Writer {
+	return recordWriter{w}
+}
+
+type recordWriter struct {
+	w *Writer
+}
+
+func (r recordWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Append(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// AsByteReader returns an io.Reader streaming the concatenated payloads of all records, without their
+// length prefixes, for example to export a cellar to a flat file with io.Copy. Chunks are loaded one at a
+// time as reading progresses.
+//
+// Once ctx is cancelled, Read returns its error. Cancel ctx when abandoning the reader before io.EOF, to
+// stop the scan feeding it.
+func (r *Reader) AsByteReader(ctx context.Context) io.Reader {
+	vals, errs := r.ScanAsync(ctx, 0)
+	return &byteReader{ctx: ctx, vals: vals, errs: errs}
+}
+
+type byteReader struct {
+	ctx  context.Context
+	vals chan *Rec
+	errs chan error
+
+	// cur holds the unread part of the current record
+	cur []byte
+	err error
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	if err := b.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for len(b.cur) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+
+		rec, ok := <-b.vals
+		if !ok {
+			b.err = io.EOF
+			if err := <-b.errs; err != nil {
+				b.err = err
+			}
+			continue
+		}
+		b.cur = rec.Data
+	}
+
+	n := copy(p, b.cur)
+	b.cur = b.cur[n:]
+	return n, nil
+}
+
+// ImportStream appends the records read from r, which holds varint length prefixed records in the encoding
+// Append uses for cellars without record checksums. Records are streamed into the buffer through AppendFrom,
+// which seals buffers as needed. It returns the number of records appended; a truncated final record is
+// discarded, and fails with io.ErrUnexpectedEOF. Like Append, it does not checkpoint.
+func (w *Writer) ImportStream(r io.Reader) (count int64, err error) {
+	br := bufio.NewReader(r)
+
+	for {
+		size, err := binary.ReadVarint(br)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, errors.Wrapf(err, "read length of record %d", count)
+		}
+		if size < 0 {
+			return count, errors.Errorf("cellar: record %d has negative length %d", count, size)
+		}
+
+		if _, err = w.AppendFrom(br, size); err != nil {
+			if errors.Cause(err) == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return count, errors.Wrapf(err, "append record %d", count)
+		}
+		count++
+	}
+}
@@ -0,0 +1,168 @@
+package cellar
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type adapterValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestWriter_AsRecordWriter(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	values := []adapterValue{{"a", 1}, {"b", 2}, {"c", 3}}
+
+	enc := json.NewEncoder(w.AsRecordWriter())
+	for _, v := range values {
+		require.NoError(t, enc.Encode(v))
+	}
+	_, err = w.Checkpoint()
+	require.NoError(t, err)
+
+	var decoded []adapterValue
+	err = NewReader(folder, newCipher(), newDecompressor(), meta).ForEach(func(rec *Rec) error {
+		var v adapterValue
+		if err := json.Unmarshal(rec.Data, &v); err != nil {
+			return err
+		}
+		decoded = append(decoded, v)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, values, decoded)
+}
+
+func TestWriter_AsRecordWriter_TooLarge(t *testing.T) {
+	w, err := OpenWriter(getFolder(), NewInMemoryMetaDB(), WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	w.valueSizeLimit = 10
+
+	n, err := w.AsRecordWriter().Write(make([]byte, 11))
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, int64(0), w.VolatilePos())
+}
+
+func TestReader_AsByteReader(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	var expected []byte
+	for i := 0; i < 30; i++ {
+		data := genSeedBytes(100, i)
+		expected = append(expected, data...)
+		_, err = w.Append(data)
+		require.NoError(t, err)
+	}
+	_, err = w.Checkpoint()
+	require.NoError(t, err)
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.True(t, len(chunks) > 1)
+
+	var out bytes.Buffer
+	_, err = io.Copy(&out, NewReader(folder, newCipher(), newDecompressor(), meta).AsByteReader(context.Background()))
+	require.NoError(t, err)
+	assert.Equal(t, expected, out.Bytes())
+}
+
+func TestReader_AsByteReader_Cancelled(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	for i := 0; i < 30; i++ {
+		_, err = w.Append(genSeedBytes(100, i))
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewReader(folder, newCipher(), newDecompressor(), meta).AsByteReader(ctx)
+
+	p := make([]byte, 10)
+	_, err = r.Read(p)
+	require.NoError(t, err)
+
+	cancel()
+	_, err = r.Read(p)
+	assert.Equal(t, context.Canceled, err)
+}
+
+// lengthPrefixed encodes records the way Append writes them.
+func lengthPrefixed(records ...[]byte) []byte {
+	var out []byte
+	prefix := make([]byte, binary.MaxVarintLen64)
+	for _, data := range records {
+		n := binary.PutVarint(prefix, int64(len(data)))
+		out = append(out, prefix[:n]...)
+		out = append(out, data...)
+	}
+	return out
+}
+
+func TestWriter_ImportStream(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	var records [][]byte
+	for i := 0; i < 30; i++ {
+		records = append(records, genSeedBytes(100, i))
+	}
+
+	count, err := w.ImportStream(bytes.NewReader(lengthPrefixed(records...)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), count)
+	_, err = w.Checkpoint()
+	require.NoError(t, err)
+
+	var imported [][]byte
+	err = NewReader(folder, newCipher(), newDecompressor(), meta).ForEach(func(rec *Rec) error {
+		imported = append(imported, append([]byte{}, rec.Data...))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, records, imported)
+}
+
+func TestWriter_ImportStream_Truncated(t *testing.T) {
+	w, err := OpenWriter(getFolder(), NewInMemoryMetaDB(), WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	stream := lengthPrefixed(genSeedBytes(100, 1), genSeedBytes(100, 2))
+
+	count, err := w.ImportStream(bytes.NewReader(stream[:len(stream)-10]))
+	assert.Equal(t, io.ErrUnexpectedEOF, errors.Cause(err))
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, int64(102), w.VolatilePos())
+}
@@ -19,8 +19,29 @@ var (
 	BufferKey           = []byte("d")
 	CellarBucketKey     = []byte("e")
 	CellarKey           = []byte("f")
+	// ChunkKeyFormatKey is set in the cellar bucket once chunk keys are stored big endian
+	ChunkKeyFormatKey  = []byte("g")
+	TimeIndexBucketKey = []byte("h")
+	KeyIndexBucketKey  = []byte("i")
+	// KeyIndexPosKey is set in the cellar bucket to the position up to which records are in the key index
+	KeyIndexPosKey = []byte("j")
 )
 
+// chunkKey encodes the position of a chunk as a big endian key, so cursors iterate chunks in order.
+func chunkKey(pos int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(pos))
+	return b
+}
+
+// timeKey encodes a timestamp as a big endian key with the sign bit flipped, so cursors iterate negative
+// timestamps before positive ones.
+func timeKey(ts int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(ts)^(1<<63))
+	return b
+}
+
 var _ MetaDB = &BoltMetaDB{} // compile time assertion to verify we match the interface metaDB
 type BoltMetaDB struct {
 	*bolt.DB
@@ -123,14 +144,14 @@ func (b *BoltMetaDB) PutCheckpoint(name string, pos int64) error {
 }
 
 func (b *BoltMetaDB) GetCheckpoint(name string) (pos int64, err error) {
-	b.View(func(tx *bolt.Tx) error {
+	err = b.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(CheckPointBucketKey)
 		if bucket == nil {
 			return ErrBucketNotExists
 		}
 		res := bucket.Get([]byte(name))
 		if res == nil {
-			return errors.New("checkpoint does not exist")
+			return ErrCheckpointNotExists
 		}
 		pos = int64(binary.LittleEndian.Uint64(res))
 		return nil
@@ -138,6 +159,55 @@ func (b *BoltMetaDB) GetCheckpoint(name string) (pos int64, err error) {
 	return
 }
 
+func (b *BoltMetaDB) ListCheckpoints() (checkpoints map[string]int64, err error) {
+	checkpoints = make(map[string]int64)
+	err = b.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(CheckPointBucketKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			checkpoints[string(k)] = int64(binary.LittleEndian.Uint64(v))
+			return nil
+		})
+	})
+	return
+}
+
+func (b *BoltMetaDB) DeleteCheckpoint(name string) error {
+	return b.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(CheckPointBucketKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+		if bucket.Get([]byte(name)) == nil {
+			return ErrCheckpointNotExists
+		}
+		return bucket.Delete([]byte(name))
+	})
+}
+
+func (b *BoltMetaDB) CasCheckpoint(name string, expected, pos int64) (swapped bool, err error) {
+	err = b.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(CheckPointBucketKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+		res := bucket.Get([]byte(name))
+		if res == nil {
+			return ErrCheckpointNotExists
+		}
+		if int64(binary.LittleEndian.Uint64(res)) != expected {
+			return nil
+		}
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(pos))
+		swapped = true
+		return bucket.Put([]byte(name), b)
+	})
+	return swapped && err == nil, err
+}
+
 func (b *BoltMetaDB) SetCellarMeta(dto *MetaDto) (err error) {
 	return b.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(CellarBucketKey)
@@ -145,7 +215,7 @@ func (b *BoltMetaDB) SetCellarMeta(dto *MetaDto) (err error) {
 			return ErrBucketNotExists
 		}
 		val, err := proto.Marshal(dto)
-		if err == nil {
+		if err != nil {
 			return err
 		}
 		return bucket.Put(CellarKey, val)
@@ -153,20 +223,280 @@ func (b *BoltMetaDB) SetCellarMeta(dto *MetaDto) (err error) {
 }
 
 func (b *BoltMetaDB) AddChunk(pos int64, dto *ChunkDto) error {
+	return b.putChunk(pos, dto, false)
+}
+
+func (b *BoltMetaDB) AddChunks(chunks []*ChunkDto, buffer *BufferDto) error {
 	return b.Update(func(tx *bolt.Tx) error {
-		b := make([]byte, 8)
-		binary.LittleEndian.PutUint64(b, uint64(pos))
 		bucket := tx.Bucket(ChunkTableKey)
 		if bucket == nil {
 			return ErrBucketNotExists
 		}
+		for _, dto := range chunks {
+			if bucket.Get(chunkKey(dto.StartPos)) != nil {
+				return ErrChunkExists
+			}
+			val, err := proto.Marshal(dto)
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put(chunkKey(dto.StartPos), val); err != nil {
+				return err
+			}
+		}
+
+		val, err := proto.Marshal(buffer)
+		if err != nil {
+			return err
+		}
+		bucket = tx.Bucket(BufferBucketKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+		return bucket.Put(BufferKey, val)
+	})
+}
+
+func (b *BoltMetaDB) PutChunk(pos int64, dto *ChunkDto) error {
+	return b.putChunk(pos, dto, true)
+}
+
+// putChunk stores dto under pos, failing with ErrChunkExists unless replace is set.
+func (b *BoltMetaDB) putChunk(pos int64, dto *ChunkDto, replace bool) error {
+	return b.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ChunkTableKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+		if !replace && bucket.Get(chunkKey(pos)) != nil {
+			return ErrChunkExists
+		}
 		val, err := proto.Marshal(dto)
 		if err != nil {
 			return err
 		}
-		return bucket.Put(b, val)
+		return bucket.Put(chunkKey(pos), val)
+	})
+
+}
+
+func (b *BoltMetaDB) DeleteChunk(startPos int64) error {
+	return b.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ChunkTableKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+		return bucket.Delete(chunkKey(startPos))
+	})
+}
+
+func (b *BoltMetaDB) ReplaceChunks(old []int64, dto *ChunkDto) error {
+	return b.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ChunkTableKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+		for _, pos := range old {
+			if err := bucket.Delete(chunkKey(pos)); err != nil {
+				return err
+			}
+		}
+		val, err := proto.Marshal(dto)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(chunkKey(dto.StartPos), val)
+	})
+}
+
+// Truncate recreates the buckets of the chunks, the user checkpoints, the time index and the key index, so
+// they are emptied along with the stored buffer state in a single transaction.
+func (b *BoltMetaDB) Truncate(buffer *BufferDto) error {
+	return b.Update(func(tx *bolt.Tx) error {
+		for _, key := range [][]byte{ChunkTableKey, CheckPointBucketKey, TimeIndexBucketKey, KeyIndexBucketKey} {
+			if err := tx.DeleteBucket(key); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(key); err != nil {
+				return err
+			}
+		}
+
+		cellar := tx.Bucket(CellarBucketKey)
+		if cellar == nil {
+			return ErrBucketNotExists
+		}
+		if err := cellar.Delete(KeyIndexPosKey); err != nil {
+			return err
+		}
+
+		val, err := proto.Marshal(buffer)
+		if err != nil {
+			return err
+		}
+		bucket := tx.Bucket(BufferBucketKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+		return bucket.Put(BufferKey, val)
+	})
+}
+
+// ListChunksRange seeks to the chunk containing fromPos, and walks the ordered chunk keys from there.
+func (b *BoltMetaDB) ListChunksRange(fromPos, toPos int64, limit int) (dto []*ChunkDto, err error) {
+	dto = []*ChunkDto{}
+	err = b.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ChunkTableKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+
+		// the chunk containing fromPos starts at or before it
+		c := bucket.Cursor()
+		k, v := c.Seek(chunkKey(fromPos))
+		if k == nil {
+			k, v = c.Last()
+		} else if int64(binary.BigEndian.Uint64(k)) > fromPos {
+			if k, v = c.Prev(); k == nil {
+				k, v = c.First()
+			}
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			chunk := &ChunkDto{}
+			if err := proto.Unmarshal(v, chunk); err != nil {
+				return err
+			}
+			if chunk.StartPos >= toPos {
+				return nil
+			}
+			if chunk.StartPos+chunk.UncompressedByteSize <= fromPos {
+				continue
+			}
+
+			dto = append(dto, chunk)
+			if limit > 0 && len(dto) == limit {
+				return nil
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (b *BoltMetaDB) PutTimeIndex(ts, pos int64) error {
+	return b.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(TimeIndexBucketKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+		key := timeKey(ts)
+		if bucket.Get(key) != nil {
+			return nil
+		}
+		return bucket.Put(key, chunkKey(pos))
+	})
+}
+
+// SeekTimeIndex seeks to the first sample at or after ts, and steps back to the one before it.
+func (b *BoltMetaDB) SeekTimeIndex(ts int64) (pos int64, ok bool, err error) {
+	err = b.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(TimeIndexBucketKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+
+		c := bucket.Cursor()
+		k, v := c.Seek(timeKey(ts))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+
+		if k != nil {
+			pos, ok = int64(binary.BigEndian.Uint64(v)), true
+		}
+		return nil
 	})
+	return
+}
 
+func (b *BoltMetaDB) ListTimeIndex() (index map[int64]int64, err error) {
+	index = make(map[int64]int64)
+	err = b.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(TimeIndexBucketKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			index[int64(binary.BigEndian.Uint64(k)^(1<<63))] = int64(binary.BigEndian.Uint64(v))
+			return nil
+		})
+	})
+	return
+}
+
+func (b *BoltMetaDB) PutKeys(keys map[string]int64, indexedPos int64) error {
+	return b.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(KeyIndexBucketKey)
+		cellar := tx.Bucket(CellarBucketKey)
+		if bucket == nil || cellar == nil {
+			return ErrBucketNotExists
+		}
+		for key, pos := range keys {
+			if err := bucket.Put([]byte(key), chunkKey(pos)); err != nil {
+				return err
+			}
+		}
+		return cellar.Put(KeyIndexPosKey, chunkKey(indexedPos))
+	})
+}
+
+func (b *BoltMetaDB) GetKey(key []byte) (pos int64, ok bool, err error) {
+	err = b.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(KeyIndexBucketKey)
+		if bucket == nil {
+			return ErrBucketNotExists
+		}
+		if v := bucket.Get(key); v != nil {
+			pos, ok = int64(binary.BigEndian.Uint64(v)), true
+		}
+		return nil
+	})
+	return
+}
+
+func (b *BoltMetaDB) KeyIndexPos() (pos int64, err error) {
+	err = b.View(func(tx *bolt.Tx) error {
+		cellar := tx.Bucket(CellarBucketKey)
+		if cellar == nil {
+			return ErrBucketNotExists
+		}
+		if v := cellar.Get(KeyIndexPosKey); v != nil {
+			pos = int64(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+	return
+}
+
+// ClearKeys recreates the key index bucket, and deletes the key index position along with it.
+func (b *BoltMetaDB) ClearKeys() error {
+	return b.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(KeyIndexBucketKey); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket(KeyIndexBucketKey); err != nil {
+			return err
+		}
+
+		cellar := tx.Bucket(CellarBucketKey)
+		if cellar == nil {
+			return ErrBucketNotExists
+		}
+		return cellar.Delete(KeyIndexPosKey)
+	})
 }
 
 // Init creates all needed buckets
@@ -188,10 +518,97 @@ func (b *BoltMetaDB) Init() error {
 			return err
 		}
 
-		_, err = tx.CreateBucketIfNotExists(CellarBucketKey)
+		_, err = tx.CreateBucketIfNotExists(TimeIndexBucketKey)
 		if err != nil {
 			return err
 		}
+
+		_, err = tx.CreateBucketIfNotExists(KeyIndexBucketKey)
+		if err != nil {
+			return err
+		}
+
+		cellar, err := tx.CreateBucketIfNotExists(CellarBucketKey)
+		if err != nil {
+			return err
+		}
+
+		if cellar.Get(ChunkKeyFormatKey) == nil {
+			if err = rekeyChunks(tx.Bucket(ChunkTableKey)); err != nil {
+				return errors.Wrap(err, "rekeyChunks")
+			}
+			return cellar.Put(ChunkKeyFormatKey, []byte{1})
+		}
+		return nil
+	})
+}
+
+// rekeyChunks converts the little endian chunk keys written by earlier versions to big endian keys.
+func rekeyChunks(bucket *bolt.Bucket) error {
+	chunks := make(map[int64][]byte)
+	err := bucket.ForEach(func(k, v []byte) error {
+		chunks[int64(binary.LittleEndian.Uint64(k))] = append([]byte{}, v...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for pos := range chunks {
+		le := make([]byte, 8)
+		binary.LittleEndian.PutUint64(le, uint64(pos))
+		if err = bucket.Delete(le); err != nil {
+			return err
+		}
+	}
+	for pos, v := range chunks {
+		if err = bucket.Put(chunkKey(pos), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyChunkKeys reports whether chunks are still keyed little endian, as they are until Init converts them.
+func (b *BoltMetaDB) legacyChunkKeys() (legacy bool, err error) {
+	err = b.View(func(tx *bolt.Tx) error {
+		if cellar := tx.Bucket(CellarBucketKey); cellar != nil && cellar.Get(ChunkKeyFormatKey) != nil {
+			return nil
+		}
+		if chunks := tx.Bucket(ChunkTableKey); chunks != nil {
+			k, _ := chunks.Cursor().First()
+			legacy = k != nil
+		}
 		return nil
 	})
+	return
+}
+
+// snapshot copies the chunks, buffer state, cellar metadata, user checkpoints and time index into an in-memory
+// meta DB, leaving the bolt meta DB untouched. Missing buckets are copied as empty. Chunks are looked up by
+// the start position stored with them, so the copy does not depend on the format of the chunk keys.
+func (b *BoltMetaDB) snapshot() (*InMemoryMetaDB, error) {
+	m := NewInMemoryMetaDB()
+
+	chunks, err := b.ListChunks()
+	if err != nil && err != ErrBucketNotExists {
+		return nil, errors.Wrap(err, "ListChunks")
+	}
+	for _, c := range chunks {
+		m.chunks[c.StartPos] = c
+	}
+
+	if m.buffer, err = b.GetBuffer(); err != nil && err != ErrBucketNotExists {
+		return nil, errors.Wrap(err, "GetBuffer")
+	}
+	if m.meta, err = b.CellarMeta(); err != nil && err != ErrBucketNotExists {
+		return nil, errors.Wrap(err, "CellarMeta")
+	}
+	if m.checkpoints, err = b.ListCheckpoints(); err != nil && err != ErrBucketNotExists {
+		return nil, errors.Wrap(err, "ListCheckpoints")
+	}
+	if m.timeIndex, err = b.ListTimeIndex(); err != nil && err != ErrBucketNotExists {
+		return nil, errors.Wrap(err, "ListTimeIndex")
+	}
+	return m, nil
 }
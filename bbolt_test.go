@@ -1,6 +1,7 @@
 package cellar
 
 import (
+	"encoding/binary"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -8,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"go.etcd.io/bbolt"
 )
 
@@ -44,3 +46,39 @@ func TestBoltMetaDB_AddChunk_ListChunk(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, len(chunks) == 2)
 }
+
+func TestBoltMetaDB_GetCheckpoint_Missing(t *testing.T) {
+	db := newBoltMetaDB()
+
+	_, err := db.GetCheckpoint("missing")
+	assert.Equal(t, ErrCheckpointNotExists, err)
+}
+
+func TestBoltMetaDB_Init_RekeysChunks(t *testing.T) {
+	db := newBoltMetaDB()
+
+	// chunks keyed little endian, as written before keys were sortable
+	err := db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.Bucket(CellarBucketKey).Delete(ChunkKeyFormatKey))
+		for _, pos := range []int64{1, 256, 512} {
+			k := make([]byte, 8)
+			binary.LittleEndian.PutUint64(k, uint64(pos))
+			v, err := proto.Marshal(&ChunkDto{StartPos: pos, UncompressedByteSize: 1})
+			require.NoError(t, err)
+			require.NoError(t, tx.Bucket(ChunkTableKey).Put(k, v))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Init())
+	// converting the keys only happens once
+	require.NoError(t, db.Init())
+
+	chunks, err := db.ListChunksRange(0, 1000, 0)
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	assert.Equal(t, int64(1), chunks[0].StartPos)
+	assert.Equal(t, int64(256), chunks[1].StartPos)
+	assert.Equal(t, int64(512), chunks[2].StartPos)
+}
@@ -0,0 +1,341 @@
+package cellar
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"path"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// defaultBlockSize is the size of the blocks of large chunks sealed with WithSealConcurrency but without
+// WithBlockSize.
+const defaultBlockSize = 4 << 20
+
+// compressBlocks reads n bytes of records from src and splits them into blocks of whole records, each ending
+// at the first record boundary past blockSize. The blocks are read in order and compressed independently by up
+// to concurrency goroutines, and written to w in order as they complete, so only about concurrency blocks are
+// held in memory at once. It returns the layout of the blocks. Since every block is a complete stream of the
+// compressor, their concatenation is read by decompressors which continue with the next stream, such as those
+// for LZ4 and zstd, like any other chunk.
+func compressBlocks(ctx context.Context, w io.Writer, src io.Reader, n int64, checksums bool, compressor Compressor, blockSize int64, concurrency int) ([]*BlockDto, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// the writer waits for the blocks in order, while up to concurrency blocks are queued behind it
+	jobs := make(chan *blockJob, concurrency)
+	written := make(chan error, 1)
+	go func() {
+		var err error
+		for job := range jobs {
+			<-job.done
+			if err == nil {
+				err = job.err
+			}
+			if err == nil {
+				job.block.Size = int64(len(job.out))
+				if _, werr := w.Write(job.out); werr != nil {
+					err = errors.Wrap(werr, "write block")
+				}
+			}
+		}
+		written <- err
+	}()
+
+	reader := &blockReader{r: bufio.NewReader(io.LimitReader(ctxReader{ctx, src}, n)), remaining: n,
+		checksums: checksums, blockSize: blockSize}
+
+	var layout []*BlockDto
+	var err error
+	for {
+		var block *BlockDto
+		var data []byte
+		if block, data, err = reader.next(); err != nil || block == nil {
+			break
+		}
+		layout = append(layout, block)
+
+		job := &blockJob{block: block, done: make(chan struct{})}
+		jobs <- job
+		go func() {
+			job.out, job.err = compressBlock(compressor, data)
+			close(job.done)
+		}()
+	}
+	close(jobs)
+
+	if werr := <-written; err == nil {
+		err = werr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+// blockJob is a block compressed by compressBlocks, which is done once the block is compressed into out.
+type blockJob struct {
+	block *BlockDto
+	out   []byte
+	err   error
+	done  chan struct{}
+}
+
+// blockReader reads the records of a buffer from a stream, in blocks of whole records. Like validateRecords,
+// it does not trust the length prefixes of the records.
+type blockReader struct {
+	r         *bufio.Reader
+	pos       int64
+	remaining int64
+	checksums bool
+	blockSize int64
+}
+
+// next returns the next block and its records, or a nil block once all records are read. A length prefix
+// reaching past the end of the records returns ErrRecordBounds.
+func (b *blockReader) next() (*BlockDto, []byte, error) {
+	if b.remaining == 0 {
+		return nil, nil, nil
+	}
+
+	block := &BlockDto{Pos: b.pos}
+	data := make([]byte, 0, b.blockSize)
+	for b.remaining > 0 && (len(data) == 0 || int64(len(data)) < b.blockSize) {
+		// the prefix is shorter near the end of the records, in which case the bounds check fails
+		prefix, err := b.r.Peek(binary.MaxVarintLen64)
+		if err != nil && err != io.EOF {
+			return nil, nil, errors.Wrap(err, "read chunk")
+		}
+
+		size, shift := binary.Varint(prefix)
+		header := shift
+		if b.checksums {
+			header += recordChecksumSize
+		}
+		if shift <= 0 || size < 0 || b.remaining-int64(header) < size {
+			return nil, nil, errors.Wrapf(ErrRecordBounds, "offset %d", b.pos)
+		}
+
+		start := len(data)
+		end := start + header + int(size)
+		if end > cap(data) {
+			data = append(data[:cap(data)], make([]byte, end-cap(data))...)
+		}
+		data = data[:end]
+		if _, err = io.ReadFull(b.r, data[start:]); err != nil {
+			return nil, nil, errors.Wrap(err, "read chunk")
+		}
+
+		b.pos += int64(end - start)
+		b.remaining -= int64(end - start)
+		block.Records++
+	}
+	return block, data, nil
+}
+
+// compressBlock compresses data into a stream of its own.
+func compressBlock(compressor Compressor, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := compressor.Compress(&buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "chain compressor")
+	}
+	if _, err = zw.Write(data); err != nil {
+		zw.Close()
+		return nil, errors.Wrap(err, "compress block")
+	}
+	if err = zw.Close(); err != nil {
+		return nil, errors.Wrap(err, "compressor.Close")
+	}
+	return buf.Bytes(), nil
+}
+
+// blockEnd returns the end of the uncompressed block i of a chunk of size bytes, which is where the next one
+// starts.
+func blockEnd(layout []*BlockDto, i int, size int64) int64 {
+	if i+1 < len(layout) {
+		return layout[i+1].Pos
+	}
+	return size
+}
+
+// checkBlocks returns ErrChunkCorrupted unless the blocks of the chunk c cover it in order.
+func checkBlocks(c *ChunkDto) error {
+	var pos int64
+	for i, block := range c.Blocks {
+		if (i == 0 && block.Pos != 0) || block.Pos < pos || block.Size < 0 {
+			return errors.Wrapf(ErrChunkCorrupted, "block %d of chunk %s", i, c.FileName)
+		}
+		pos = block.Pos
+	}
+	if pos > c.UncompressedByteSize {
+		return errors.Wrapf(ErrChunkCorrupted, "blocks of chunk %s", c.FileName)
+	}
+	return nil
+}
+
+// decompressBlocks reads the consecutive blocks of a chunk from the decrypted stream src, and decompresses
+// them into b concurrently. b starts at the first of the blocks, and the last one ends at end within the
+// chunk.
+func decompressBlocks(src io.Reader, blocks []*BlockDto, end int64, decompressor Decompressor, b []byte) error {
+	var size int64
+	for _, block := range blocks {
+		size += block.Size
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(src, data); err != nil {
+		return errors.Wrap(err, "read blocks")
+	}
+
+	errs := make([]error, len(blocks))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	wg := &sync.WaitGroup{}
+
+	var offset int64
+	start := blocks[0].Pos
+	for i, block := range blocks {
+		in := data[offset : offset+block.Size]
+		out := b[block.Pos-start : blockEnd(blocks, i, end)-start]
+		offset += block.Size
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = decompressBlock(bytes.NewReader(in), decompressor, out)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decompressBlock decompresses the block read from src into b, which must hold exactly the block.
+func decompressBlock(src io.Reader, decompressor Decompressor, b []byte) error {
+	zr, err := decompressor.Decompress(src)
+	if err != nil {
+		return errors.Wrap(err, "chain decompressor")
+	}
+	if closer, ok := zr.(io.Closer); ok {
+		// pooled decompressors are returned once the block is read
+		defer closer.Close()
+	}
+
+	if _, err = io.ReadFull(zr, b); err != nil {
+		return errors.Wrap(err, "read block")
+	}
+	return nil
+}
+
+// blockAt returns the index of the block of the chunk c holding pos, and the number of records in the
+// blocks in front of it.
+func blockAt(c *ChunkDto, pos int64) (int, int64, error) {
+	if err := checkBlocks(c); err != nil {
+		return 0, 0, err
+	}
+
+	i := sort.Search(len(c.Blocks), func(i int) bool { return c.StartPos+c.Blocks[i].Pos > pos }) - 1
+	if i < 0 {
+		i = 0
+	}
+	var records int64
+	for _, block := range c.Blocks[:i] {
+		records += block.Records
+	}
+	return i, records, nil
+}
+
+// loadBlocks decompresses the blocks of the chunk c from first up to, but not including, last into b, which
+// starts at the first of them. The blocks in front of them are decrypted, but not decompressed.
+func (r *Reader) loadBlocks(c *ChunkDto, first, last int, b []byte) error {
+	loc := path.Join(r.Folder, c.FileName)
+	if r.VerifyOnRead {
+		if err := verifyChunkFile(r.fs, loc, c); err != nil {
+			return missingChunk(c, err)
+		}
+	}
+
+	decompressor, err := r.decompressorFor(c)
+	if err != nil {
+		return err
+	}
+	cipher, err := r.cipherFor(c)
+	if err != nil {
+		return err
+	}
+
+	decryptor, done, err := r.openChunk(loc, c, cipher)
+	if err != nil {
+		return missingChunk(c, err)
+	}
+	defer done()
+
+	var skip int64
+	for _, block := range c.Blocks[:first] {
+		skip += block.Size
+	}
+	if _, err = io.CopyN(ioutil.Discard, decryptor, skip); err != nil {
+		return errors.Wrapf(err, "skip to block %d of chunk %s", first, loc)
+	}
+
+	end := blockEnd(c.Blocks, last-1, c.UncompressedByteSize)
+	if err = decompressBlocks(decryptor, c.Blocks[first:last], end, decompressor, b); err != nil {
+		return errors.Wrapf(err, "blocks %d to %d of chunk %s", first, last, loc)
+	}
+	return nil
+}
+
+// loadBlock decompresses only the block of the chunk c holding pos, for chunks sealed in blocks, see
+// WithBlockSize. It returns the block, along with its position and the index of its first record.
+func (r *Reader) loadBlock(c *ChunkDto, pos int64) ([]byte, int64, int64, error) {
+	i, records, err := blockAt(c, pos)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	block := make([]byte, blockEnd(c.Blocks, i, c.UncompressedByteSize)-c.Blocks[i].Pos)
+	if err = r.loadBlocks(c, i, i+1, block); err != nil {
+		return nil, 0, 0, err
+	}
+	return block, c.StartPos + c.Blocks[i].Pos, c.StartIndex + records, nil
+}
+
+// loadChunkFrom loads the chunk c to be read from pos on. Of chunks sealed in blocks, only the blocks from
+// the one holding pos on are decompressed, unless the reader has a read cache, which holds whole chunks. It
+// returns the offset of the first record decompressed within the chunk, and the number of records in front
+// of it.
+func (r *Reader) loadChunkFrom(c *ChunkDto, pos int64) (chunk []byte, start int, records int64, err error) {
+	var i int
+	if len(c.Blocks) > 1 && r.cache == nil {
+		if i, records, err = blockAt(c, pos); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	if i == 0 {
+		chunk, err = r.loadChunk(c)
+		return chunk, 0, 0, err
+	}
+
+	chunk = make([]byte, c.UncompressedByteSize)
+	start = int(c.Blocks[i].Pos)
+	if err = r.loadBlocks(c, i, len(c.Blocks), chunk[start:]); err != nil {
+		return nil, 0, 0, err
+	}
+	return chunk, start, records, nil
+}
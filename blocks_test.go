@@ -0,0 +1,157 @@
+package cellar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_BlockSize(t *testing.T) {
+	_, err := New(getFolder(), WithSealConcurrency(0))
+	assert.Error(t, err)
+	_, err = New(getFolder(), WithBlockSize(0))
+	assert.Error(t, err)
+
+	gcm, err := NewAESGCMCipher(gcmKey)
+	require.NoError(t, err)
+
+	folder := getFolder()
+	db, err := New(folder, WithCipher(gcm), WithCompressor(Lz4Compressor{}), WithRecordChecksums(),
+		WithBlockSize(100), WithSealConcurrency(4))
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	var positions, ends []int64
+	for i := 0; i < 50; i++ {
+		positions = append(positions, db.SealedPos()+db.writer.b.pos)
+		pos, err := db.Append([]byte(fmt.Sprintf("record %d of the blocks", i)))
+		require.NoError(t, err)
+		ends = append(ends, pos)
+	}
+	require.NoError(t, db.SealTheBuffer())
+
+	reader := db.Reader()
+	chunks, err := reader.sortedChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	c := chunks[0]
+
+	// blocks hold whole records
+	require.True(t, len(c.Blocks) > 1, "%d blocks", len(c.Blocks))
+	var records int64
+	for _, b := range c.Blocks {
+		records += b.Records
+	}
+	assert.Equal(t, c.Records, records)
+
+	// the header describes the blocks, so they survive RebuildMeta
+	rebuilt, err := readChunkFile(path.Join(folder, c.FileName), c.FileName)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(c, rebuilt.chunk))
+
+	var found []string
+	require.NoError(t, reader.ForEach(func(rec *Rec) error {
+		found = append(found, string(rec.Data))
+		return nil
+	}))
+	require.Len(t, found, 50)
+	assert.Equal(t, "record 49 of the blocks", found[49])
+
+	// a single record decompresses only its block
+	var loaded int32
+	reader.decompressor = countingDecompressor{reader.decompressor, &loaded}
+	for i, pos := range ends {
+		rec, err := reader.ReadAt(pos)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("record %d of the blocks", i), string(rec.Data))
+		assert.Equal(t, int64(i), rec.Index)
+		assert.Equal(t, int64(0), rec.ChunkPos)
+	}
+	assert.Equal(t, int32(len(positions)), loaded)
+
+	// a scan from a record decompresses the blocks from its block on
+	i, _, err := blockAt(c, positions[30])
+	require.NoError(t, err)
+	require.True(t, i > 0)
+	loaded = 0
+	found = nil
+	var indexes []int64
+	require.NoError(t, reader.scanFrom(positions[30], func(info *ReaderInfo, data []byte) error {
+		found = append(found, string(data))
+		indexes = append(indexes, info.Index)
+		assert.Equal(t, int64(0), info.ChunkPos)
+		return nil
+	}))
+	require.Len(t, found, 20)
+	assert.Equal(t, "record 30 of the blocks", found[0])
+	assert.Equal(t, int64(30), indexes[0])
+	assert.Equal(t, int64(49), indexes[19])
+	assert.Equal(t, int32(len(c.Blocks)-i), loaded)
+
+	// readers without blocks decompress the chunk as one stream
+	whole := proto.Clone(c).(*ChunkDto)
+	whole.Blocks = nil
+	data, err := reader.readChunk(whole, nil)
+	require.NoError(t, err)
+	chunk, err := reader.loadChunk(c)
+	require.NoError(t, err)
+	assert.Equal(t, chunk, data)
+
+	report, err := reader.Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Bad)
+}
+
+func TestDB_SealConcurrency_DefaultBlockSize(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithSealConcurrency(2))
+	require.NoError(t, err)
+	defer checkedClose(db)
+	assert.Equal(t, int64(defaultBlockSize), db.writer.blockSize)
+
+	// small buffers are sealed in one stream
+	_, err = db.Append([]byte("small"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	chunks, err := db.Reader().sortedChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Empty(t, chunks[0].Blocks)
+}
+
+func Test_compressBlocks(t *testing.T) {
+	// four records of 10 bytes, each with a 1 byte length prefix
+	var data []byte
+	for i := 0; i < 4; i++ {
+		data = append(data, 20)
+		data = append(data, genSeedBytes(10, i)...)
+	}
+
+	var out bytes.Buffer
+	layout, err := compressBlocks(context.Background(), &out, bytes.NewReader(data), int64(len(data)), false,
+		Lz4Compressor{}, 15, 2)
+	require.NoError(t, err)
+	require.Len(t, layout, 2)
+	assert.Equal(t, &BlockDto{Pos: 0, Size: layout[0].Size, Records: 2}, layout[0])
+	assert.Equal(t, &BlockDto{Pos: 22, Size: layout[1].Size, Records: 2}, layout[1])
+	assert.Equal(t, int64(out.Len()), layout[0].Size+layout[1].Size)
+
+	decompressed := make([]byte, len(data))
+	require.NoError(t, decompressBlocks(&out, layout, int64(len(data)), ChainDecompressor{}, decompressed))
+	assert.Equal(t, data, decompressed)
+
+	// a length prefix reaching past the records fails rather than panicking
+	data[33] = 0x7e
+	_, err = compressBlocks(context.Background(), ioutil.Discard, bytes.NewReader(data), int64(len(data)), false,
+		Lz4Compressor{}, 15, 2)
+	assert.Equal(t, ErrRecordBounds, errors.Cause(err))
+	assert.Contains(t, err.Error(), "offset 33")
+}
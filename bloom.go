@@ -0,0 +1,114 @@
+package cellar
+
+import (
+	"hash/fnv"
+
+	"github.com/pkg/errors"
+)
+
+// Sizing of the bloom filters of chunks, which gives a false positive rate of about 1%.
+const (
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+)
+
+// bloomFilter records the keys of the records of a chunk, see WithKeyExtractor. It is stored in
+// ChunkDto.Bloom, along with the number of hashes per key.
+type bloomFilter struct {
+	bits   []byte
+	hashes uint32
+}
+
+// newBloomFilter returns an empty filter sized for keys keys.
+func newBloomFilter(keys int64) *bloomFilter {
+	n := (keys*bloomBitsPerKey + 7) / 8
+	if n < 8 {
+		n = 8
+	}
+	return &bloomFilter{bits: make([]byte, n), hashes: bloomHashes}
+}
+
+// chunkBloom returns the filter stored with the chunk, or nil if the chunk has none.
+func chunkBloom(c *ChunkDto) *bloomFilter {
+	if len(c.Bloom) == 0 || c.BloomHashes == 0 {
+		return nil
+	}
+	return &bloomFilter{bits: c.Bloom, hashes: c.BloomHashes}
+}
+
+// locations derives the bit positions of key by double hashing, from the two halves of its FNV-1a hash.
+func (f *bloomFilter) locations(key []byte, fn func(bit uint64) bool) bool {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+
+	m := uint64(len(f.bits)) * 8
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		if !fn((h1 + i*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(key []byte) {
+	f.locations(key, func(bit uint64) bool {
+		f.bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+// mayContain returns false if key was never added, and true if it probably was.
+func (f *bloomFilter) mayContain(key []byte) bool {
+	return f.locations(key, func(bit uint64) bool {
+		return f.bits[bit/8]&(1<<(bit%8)) != 0
+	})
+}
+
+// buildBloom returns a filter over the keys of the records in data, which holds the given number of records,
+// or nil if the writer extracts no keys.
+func (w *Writer) buildBloom(data []byte, records int64) (*bloomFilter, error) {
+	if w.keyExtractor == nil {
+		return nil, nil
+	}
+
+	f := newBloomFilter(records)
+	err := forEachKey(data, w.recordChecksums, w.recordTimestamps, w.keyExtractor, func(key []byte, offset int) {
+		f.add(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// MayContain returns the start positions of the chunks which may hold a record with the key, as extracted by
+// the function passed to WithKeyExtractor, followed by the start position of the current buffer if it holds
+// any records. All other chunks certainly do not hold the key, so scanning the returned positions, for example
+// through ScanFrom, finds all records with the key. Chunks sealed without a key extractor may hold any key,
+// and are always returned.
+func (r *Reader) MayContain(key []byte) ([]int64, error) {
+	b, err := r.buffer()
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := r.sortedChunks()
+	if err != nil {
+		return nil, errors.Wrap(err, "db.Read")
+	}
+	chunks = sealedBefore(chunks, b)
+
+	var positions []int64
+	for _, c := range chunks {
+		if f := chunkBloom(c); f == nil || f.mayContain(key) {
+			positions = append(positions, c.StartPos)
+		}
+	}
+
+	if b != nil && b.Pos > 0 {
+		positions = append(positions, b.StartPos)
+	}
+	return positions, nil
+}
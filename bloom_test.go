@@ -0,0 +1,111 @@
+package cellar
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(1000)
+	for i := 0; i < 1000; i++ {
+		f.add([]byte(fmt.Sprintf("key-%d", i)))
+	}
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, f.mayContain([]byte(fmt.Sprintf("key-%d", i))))
+	}
+
+	var falsePositives int
+	for i := 1000; i < 11000; i++ {
+		if f.mayContain([]byte(fmt.Sprintf("key-%d", i))) {
+			falsePositives++
+		}
+	}
+	assert.True(t, falsePositives < 300, "%d false positives in 10000", falsePositives)
+}
+
+// recordKey extracts the key of records formatted as "key:value".
+func recordKey(data []byte) []byte {
+	i := bytes.IndexByte(data, ':')
+	if i < 0 {
+		return nil
+	}
+	return data[:i]
+}
+
+func TestReader_MayContain(t *testing.T) {
+	_, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithKeyExtractor(nil))
+	assert.Error(t, err)
+
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	// a chunk sealed without filter
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithCompressor(Lz4Compressor{}), WithRecordTimestamps())
+	require.NoError(t, err)
+	_, err = db.Append([]byte("old:0"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+	require.NoError(t, db.Close())
+
+	db, err = New(folder, WithNoFileLock, WithMetaDB(meta), WithCompressor(Lz4Compressor{}), WithRecordTimestamps(),
+		WithKeyExtractor(recordKey))
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	// chunks of 100 records, each with their own keys
+	var starts []int64
+	for c := 0; c < 5; c++ {
+		starts = append(starts, db.SealedPos())
+		for i := 0; i < 100; i++ {
+			_, err = db.Append([]byte(fmt.Sprintf("key-%d-%d:%d", c, i, i)))
+			require.NoError(t, err)
+		}
+		require.NoError(t, db.SealTheBuffer())
+	}
+	_, err = db.Append([]byte("key-buffer:0"))
+	require.NoError(t, err)
+	require.NoError(t, db.Flush())
+
+	reader := db.Reader()
+	for c := 0; c < 5; c++ {
+		positions, err := reader.MayContain([]byte(fmt.Sprintf("key-%d-42", c)))
+		require.NoError(t, err)
+		// the unfiltered chunk and the buffer can hold any key
+		assert.Contains(t, positions, int64(0))
+		assert.Contains(t, positions, starts[c])
+		assert.Contains(t, positions, db.SealedPos())
+		assert.True(t, len(positions) <= 4, "%d candidates", len(positions))
+	}
+
+	// found by scanning the candidates
+	positions, err := reader.MayContain([]byte("key-3-7"))
+	require.NoError(t, err)
+	var found []string
+	require.NoError(t, reader.ForEach(func(rec *Rec) error {
+		if bytes.Equal(recordKey(rec.Data), []byte("key-3-7")) {
+			found = append(found, string(rec.Data))
+			assert.Contains(t, positions, rec.ChunkPos)
+		}
+		return nil
+	}))
+	assert.Equal(t, []string{"key-3-7:7"}, found)
+
+	// compaction rebuilds the filter of the merged chunk
+	_, err = db.Compact(2, 1<<20)
+	require.NoError(t, err)
+	positions, err = reader.MayContain([]byte("key-4-99"))
+	require.NoError(t, err)
+	chunks, err := reader.Chunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, []int64{0, db.SealedPos()}, positions)
+
+	positions, err = reader.MayContain([]byte("missing"))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{db.SealedPos()}, positions)
+}
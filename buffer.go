@@ -2,31 +2,51 @@ package cellar
 
 import (
 	"bufio"
-	"crypto/cipher"
+	"context"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 )
 
+var ErrBufferTruncated = errors.New("cellar: buffer file is shorter than its persisted position")
+
 type Buffer struct {
 	fileName string
 	maxBytes int64
 	startPos int64
 
+	// startIndex is the index of the first record in the buffer, see Writer.AppendIndexed
+	startIndex int64
+
 	records int64
 	pos     int64
 
+	// range of the record timestamps, in cellars storing them
+	minTimestamp int64
+	maxTimestamp int64
+
+	// position and record count as of the last flush to disk
+	flushedPos     int64
+	flushedRecords int64
+
 	writer *bufio.Writer
-	stream *os.File
+	stream File
+	fs     FileSystem
 
 	cipher     Cipher
 	compressor Compressor
+
+	// durability selects which flushes and seals sync to disk, see WithDurability
+	durability Durability
 }
 
-func openBuffer(d *BufferDto, folder string, cipher Cipher, compressor Compressor) (*Buffer, error) {
+func openBuffer(fs FileSystem, d *BufferDto, folder string, cipher Cipher, compressor Compressor) (*Buffer, error) {
 
 	if len(d.FileName) == 0 {
 		return nil, errors.New("empty filename")
@@ -34,29 +54,53 @@ func openBuffer(d *BufferDto, folder string, cipher Cipher, compressor Compresso
 
 	fullPath := path.Join(folder, d.FileName)
 
-	f, err := os.OpenFile(fullPath, os.O_CREATE|os.O_RDWR, 0644)
+	f, err := fs.OpenFile(fullPath, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return nil, errors.Wrap(err, "Open file")
 	}
-	err = f.Truncate(int64(d.MaxBytes))
+	// a file shorter than the persisted position lost records which were checkpointed, truncating it to the
+	// position below would fill them with zeros
+	stat, err := f.Stat()
 	if err != nil {
-		return nil, err
+		f.Close()
+		return nil, errors.Wrap(err, "Stat")
+	}
+	if stat.Size() < d.Pos {
+		f.Close()
+		return nil, errors.Wrapf(ErrBufferTruncated, "%s has %d bytes, expected at least %d", fullPath, stat.Size(), d.Pos)
+	}
+	// anything past the persisted position was written after the last checkpoint, and may contain a torn
+	// record. Cut it off before preallocating the buffer again.
+	if err = f.Truncate(d.Pos); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "Truncate")
+	}
+	if err = f.Truncate(d.MaxBytes); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "Truncate")
 	}
 
 	if _, err := f.Seek(int64(d.Pos), io.SeekStart); err != nil {
+		f.Close()
 		return nil, errors.Wrap(err, "Seek")
 	}
 
 	b := &Buffer{
-		fileName:   d.FileName,
-		startPos:   d.StartPos,
-		maxBytes:   d.MaxBytes,
-		pos:        d.Pos,
-		records:    d.Records,
-		stream:     f,
-		writer:     bufio.NewWriter(f),
-		cipher:     cipher,
-		compressor: compressor,
+		fileName:       d.FileName,
+		startPos:       d.StartPos,
+		startIndex:     d.StartIndex,
+		maxBytes:       d.MaxBytes,
+		pos:            d.Pos,
+		records:        d.Records,
+		minTimestamp:   d.MinTimestamp,
+		maxTimestamp:   d.MaxTimestamp,
+		flushedPos:     d.Pos,
+		flushedRecords: d.Records,
+		stream:         f,
+		fs:             fs,
+		writer:         bufio.NewWriter(f),
+		cipher:         cipher,
+		compressor:     compressor,
 	}
 	return b, nil
 }
@@ -68,6 +112,10 @@ func (b *Buffer) getState() *BufferDto {
 		StartPos: b.startPos,
 		Pos:      b.pos,
 		Records:  b.records,
+
+		StartIndex:   b.startIndex,
+		MinTimestamp: b.minTimestamp,
+		MaxTimestamp: b.maxTimestamp,
 	}
 }
 
@@ -83,6 +131,51 @@ func (b *Buffer) writeBytes(bs []byte) error {
 	return nil
 }
 
+// writeFrom copies exactly n bytes from r into the buffer.
+func (b *Buffer) writeFrom(r io.Reader, n int64) error {
+	written, err := io.CopyN(b.writer, r, n)
+	b.pos += written
+	if err != nil {
+		return errors.Wrap(err, "CopyN")
+	}
+	return nil
+}
+
+// patch overwrites len(bs) bytes of the buffer starting at pos, which must already have been written.
+func (b *Buffer) patch(pos int64, bs []byte) error {
+	if err := b.writer.Flush(); err != nil {
+		return errors.Wrap(err, "Flush")
+	}
+	if _, err := b.stream.WriteAt(bs, pos); err != nil {
+		return errors.Wrap(err, "WriteAt")
+	}
+	return nil
+}
+
+// rewind discards everything written to the buffer after pos.
+func (b *Buffer) rewind(pos int64) error {
+	if err := b.writer.Flush(); err != nil {
+		return errors.Wrap(err, "Flush")
+	}
+	if _, err := b.stream.Seek(pos, io.SeekStart); err != nil {
+		return errors.Wrap(err, "Seek")
+	}
+	b.writer.Reset(b.stream)
+	b.pos = pos
+	return nil
+}
+
+// stampRecord widens the timestamp range of the buffer to include ts, the timestamp of the record being
+// ended.
+func (b *Buffer) stampRecord(ts int64) {
+	if b.records == 0 || ts < b.minTimestamp {
+		b.minTimestamp = ts
+	}
+	if b.records == 0 || ts > b.maxTimestamp {
+		b.maxTimestamp = ts
+	}
+}
+
 func (b *Buffer) endRecord() {
 	b.records++
 }
@@ -91,9 +184,25 @@ func (b *Buffer) flush() error {
 	if err := b.writer.Flush(); err != nil {
 		return errors.Wrap(err, "Flush")
 	}
+	if b.durability == DurabilityFsync {
+		if err := b.stream.Sync(); err != nil {
+			return errors.Wrap(err, "Sync")
+		}
+	}
+	b.flushedPos = b.pos
+	b.flushedRecords = b.records
 	return nil
 }
 
+// getFlushedState returns the state of the buffer as of the last flush, describing the part of the buffer
+// file which can be read back.
+func (b *Buffer) getFlushedState() *BufferDto {
+	dto := b.getState()
+	dto.Pos = b.flushedPos
+	dto.Records = b.flushedRecords
+	return dto
+}
+
 func (b *Buffer) close() error {
 	if b.stream == nil {
 		return nil
@@ -106,79 +215,293 @@ func (b *Buffer) close() error {
 	return nil
 }
 
-func (b *Buffer) compress() (dto *ChunkDto, err error) {
+// compress seals the buffer into a chunk file next to it, created at createdAt in unix seconds. The cellar
+// metadata meta is written to the header of the chunk file, and large chunks are spilled through tempDir, or
+// compressed in blocks of blockSize by up to concurrency goroutines, see sealChunk. If sealing fails or ctx is
+// done, the chunk file is removed and the buffer remains open for writing. The buffer is left open on success
+// as well, so it can be resumed if the chunk can't be recorded; the caller closes it once it is.
+func (b *Buffer) compress(ctx context.Context, meta *MetaDto, createdAt int64, tempDir string, blockSize int64, concurrency int, trace TraceHook) (dto *ChunkDto, err error) {
 
 	loc := b.stream.Name() + ".lz4"
 
+	end := trace.Begin(SpanFlush)
 	if err = b.writer.Flush(); err != nil {
 		log.Panicf("Failed to flush buffer: %s", err)
 	}
-	if err = b.stream.Sync(); err != nil {
-		log.Panicf("Failed to Fsync buffer: %s", err)
+	if b.durability != DurabilityNone {
+		if err = b.stream.Sync(); err != nil {
+			log.Panicf("Failed to Fsync buffer: %s", err)
+		}
 	}
+	end()
 
 	if _, err = b.stream.Seek(0, io.SeekStart); err != nil {
 		log.Panicf("Failed to seek to 0 in buffer: %s", err)
 	}
 
+	info := &ChunkDto{
+		FileName:             b.fileName + ".lz4",
+		Records:              b.records,
+		UncompressedByteSize: b.pos,
+		StartPos:             b.startPos,
+		StartIndex:           b.startIndex,
+		MinTimestamp:         b.minTimestamp,
+		MaxTimestamp:         b.maxTimestamp,
+		CreatedAtUnix:        createdAt,
+	}
+
+	if dto, err = sealChunk(ctx, b.fs, loc, b.stream, info, meta, b.cipher, b.compressor, b.durability, tempDir, blockSize, concurrency, trace); err != nil {
+		if serr := b.resume(); serr != nil {
+			log.Panicf("Failed to resume buffer: %s", serr)
+		}
+		return nil, err
+	}
+	return dto, nil
+}
+
+// resume seeks the buffer file back to the end of the buffer after a seal which was not completed, so writing
+// continues where the buffer left off.
+func (b *Buffer) resume() error {
+	if _, err := b.stream.Seek(b.pos, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "Seek to %d", b.pos)
+	}
+	return nil
+}
+
+// sealChunk compresses and encrypts info.UncompressedByteSize bytes from src into a new chunk file at loc in
+// fs, which is synced to disk before returning unless durability is DurabilityNone. The file starts with a
+// header describing the chunk and the cellar meta, see writeChunkHeader, unless meta.FormatVersion predates
+// chunk headers. The returned dto is info completed with how the chunk was written, including the CRC32 of
+// the data and of the file. The expensive steps are traced through trace.
+//
+// Chunks larger than blockSize are compressed in blocks by up to concurrency goroutines into a temp file in
+// tempDir, or the default directory for temp files, see compressBlocks, unless blockSize is 0. Otherwise,
+// ciphers which hold the chunk in memory get chunks larger than tempDirThreshold compressed into a temp file
+// in tempDir first, unless tempDir is empty, see WithTempDir.
+//
+// Sealing is aborted once ctx is done, in which case the chunk file is removed and ctx.Err() is returned.
+func sealChunk(ctx context.Context, fs FileSystem, loc string, src io.ReadSeeker, info *ChunkDto, meta *MetaDto, cipher Cipher, compressor Compressor, durability Durability, tempDir string, blockSize int64, concurrency int, trace TraceHook) (dto *ChunkDto, err error) {
+
 	// create chunk file
-	var chunkFile *os.File
-	if chunkFile, err = os.Create(loc); err != nil {
-		return nil, errors.Wrap(err, "os.Create")
+	var chunkFile File
+	if chunkFile, err = fs.Create(loc); err != nil {
+		return nil, errors.Wrap(err, "Create")
 	}
 
 	defer func() {
-		if err := chunkFile.Sync(); err != nil {
-			panic("Failed to sync")
+		if cerr := chunkFile.Close(); cerr != nil && err == nil {
+			err = errors.Wrap(cerr, "Close")
 		}
-		if err := chunkFile.Close(); err != nil {
-			panic("Failed to close")
+		// the chunk was never recorded, so nothing refers to it
+		if err != nil {
+			fs.Remove(loc)
 		}
 	}()
 
-	// buffer writes to file
-	buffer := bufio.NewWriter(chunkFile)
+	// buffer writes to file, hashing the bytes as they are written
+	sum := crc32.NewIEEE()
+	buffer := bufio.NewWriter(io.MultiWriter(chunkFile, sum))
 
-	defer buffer.Flush()
+	// encrypt before buffering, with a fresh nonce for ciphers which need one
+	var nonce []byte
+	if nonce, err = newNonce(cipher); err != nil {
+		return nil, err
+	}
 
-	// encrypt before buffering
-	var encryptor *cipher.StreamWriter
-	if encryptor, err = b.cipher.Encrypt(buffer); err != nil {
-		log.Panicf("Failed to chain encryptor for %s: %s", loc, err)
+	dto = proto.Clone(info).(*ChunkDto)
+	dto.Codec = compressor.Codec()
+	dto.Cipher = cipher.Algorithm()
+	dto.Nonce = nonce
+	if keyed, ok := cipher.(KeyedCipher); ok {
+		dto.KeyID = keyed.KeyID()
+	}
+	if dc, ok := compressor.(dictCompressor); ok {
+		dto.DictID = dc.DictID()
+	}
+	if dto.DataChecksum, err = dataChecksum(ctx, src, dto.UncompressedByteSize); err != nil {
+		return nil, err
 	}
 
-	defer encryptor.Close()
+	// blocks are compressed up front into a temp file, so the header can describe them
+	var blocks *os.File
+	if blockSize > 0 && dto.UncompressedByteSize > blockSize {
+		if blocks, err = ioutil.TempFile(tempDir, "cellar-seal-"); err != nil {
+			return nil, errors.Wrap(err, "TempFile")
+		}
+		defer func() {
+			blocks.Close()
+			os.Remove(blocks.Name())
+		}()
+
+		end := trace.Begin(SpanCompress)
+		dto.Blocks, err = compressBlocks(ctx, blocks, src, dto.UncompressedByteSize, meta.RecordChecksums, compressor, blockSize, concurrency)
+		end()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the header goes in front of the encrypted stream, so the chunk can be described without its meta DB.
+	// Cellars in a format from before chunk headers are written without, so older versions can read them.
+	var header int
+	if meta.FormatVersion >= formatChunkHeaders {
+		if header, err = writeChunkHeader(buffer, dto, meta); err != nil {
+			return nil, err
+		}
+	}
 
-	// compress before encrypting
+	var encryptor io.WriteCloser
+	if encryptor, err = cipher.Encrypt(buffer, nonce); err != nil {
+		return nil, errors.Wrapf(err, "chain encryptor for %s", loc)
+	}
 
-	zw, err := b.compressor.Compress(encryptor)
+	end := trace.Begin(SpanCompress)
+	if blocks != nil {
+		err = unspill(blocks, encryptor)
+	} else {
+		err = compressChunk(ctx, encryptor, src, dto.UncompressedByteSize, compressor, tempDir)
+	}
+	end()
 	if err != nil {
-		log.Panicf("Failed to chain compressor: %s", err)
+		return nil, err
 	}
 
-	// copy chunk to the chain
-	if _, err = io.CopyN(zw, b.stream, b.pos); err != nil {
-		return nil, errors.Wrap(err, "CopyN")
+	end = trace.Begin(SpanEncrypt)
+	err = encryptor.Close()
+	end()
+	if err != nil {
+		return nil, errors.Wrap(err, "encryptor.Close")
 	}
 
-	zw.Close()
-	err = chunkFile.Sync()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	end = trace.Begin(SpanSync)
+	if err = buffer.Flush(); err != nil {
+		err = errors.Wrap(err, "Flush")
+	} else if durability != DurabilityNone {
+		if err = chunkFile.Sync(); err != nil {
+			err = errors.Wrap(err, "Sync")
+		}
+	}
+	end()
 	if err != nil {
 		return nil, err
 	}
-	b.close()
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	var size int64
 	if size, err = chunkFile.Seek(0, io.SeekEnd); err != nil {
 		return nil, errors.Wrap(err, "Seek")
 	}
 
-	dto = &ChunkDto{
-		FileName:             b.fileName + ".lz4",
-		Records:              b.records,
-		UncompressedByteSize: b.pos,
-		StartPos:             b.startPos,
-		CompressedDiskSize:   size,
-	}
+	dto.CompressedDiskSize = size
+	dto.HeaderSize = uint32(header)
+	dto.Checksum = sum.Sum32()
 	return dto, nil
 }
+
+// compressChunk compresses n bytes from src into the encryptor w, closing the compressor so everything
+// reaches w. Compressing goes through a temp file in tempDir for ciphers which would hold the compressed chunk
+// in memory while it grows.
+func compressChunk(ctx context.Context, w io.Writer, src io.Reader, n int64, compressor Compressor, tempDir string) (err error) {
+	out := w
+	var spill *os.File
+	if _, ok := w.(growingWriter); ok && tempDir != "" && n > tempDirThreshold {
+		if spill, err = ioutil.TempFile(tempDir, "cellar-seal-"); err != nil {
+			return errors.Wrap(err, "TempFile")
+		}
+		defer func() {
+			spill.Close()
+			os.Remove(spill.Name())
+		}()
+		out = spill
+	}
+
+	zw, err := compressor.Compress(out)
+	if err != nil {
+		return errors.Wrap(err, "chain compressor")
+	}
+
+	// copy chunk to the chain
+	copyBuf := copyPool.Get().(*[]byte)
+	copied, err := io.CopyBuffer(zw, io.LimitReader(ctxReader{ctx, src}, n), *copyBuf)
+	copyPool.Put(copyBuf)
+	if err == nil && copied < n {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return errors.Wrap(err, "copy")
+	}
+
+	// close the chain front to back, so everything reaches the file before measuring its size
+	if err = zw.Close(); err != nil {
+		return errors.Wrap(err, "compressor.Close")
+	}
+	if spill != nil {
+		return unspill(spill, w)
+	}
+	return nil
+}
+
+// tempDirThreshold is the size above which chunks are spilled through the temp dir, see WithTempDir. It is
+// fixed, and only changed by tests.
+var tempDirThreshold int64 = 64 << 20
+
+// unspill copies the compressed chunk spilled to f into w, which is grown to hold all of it at once if it
+// holds the chunk in memory.
+func unspill(f *os.File, w io.Writer) error {
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errors.Wrap(err, "Seek")
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "Seek")
+	}
+
+	if g, ok := w.(growingWriter); ok {
+		g.grow(int(size))
+	}
+	copyBuf := copyPool.Get().(*[]byte)
+	defer copyPool.Put(copyBuf)
+	if _, err = io.CopyBuffer(w, f, *copyBuf); err != nil {
+		return errors.Wrap(err, "copy spilled chunk")
+	}
+	return nil
+}
+
+// repairTruncatedBuffer rewinds the persisted position of a buffer file which is shorter than it, to the end
+// of the last complete record in the file. It returns false if the buffer file was not truncated.
+func repairTruncatedBuffer(fs FileSystem, d *BufferDto, folder string, checksums bool) (bool, error) {
+	data, err := readFile(fs, path.Join(folder, d.FileName))
+	if err != nil && !os.IsNotExist(err) {
+		return false, errors.Wrap(err, "ReadFile")
+	}
+	if int64(len(data)) >= d.Pos {
+		return false, nil
+	}
+
+	// anything from the first invalid record onwards is lost
+	records, end, _ := validateRecords(data, d.StartPos, checksums)
+
+	d.Pos = int64(end)
+	d.Records = records
+	return true, nil
+}
+
+// ctxReader fails reads once ctx is done, so that long copies can be aborted.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
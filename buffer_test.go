@@ -1,11 +1,16 @@
 package cellar
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWrites(t *testing.T) {
@@ -22,7 +27,7 @@ func TestWrites(t *testing.T) {
 		err error
 	)
 
-	if buf, err = openBuffer(b, folder, newCipher(), newCompressor()); err != nil {
+	if buf, err = openBuffer(osFS{}, b, folder, newCipher(), newCompressor()); err != nil {
 		panic(err)
 	}
 
@@ -58,7 +63,7 @@ func TestExist(t *testing.T) {
 	var buf *Buffer
 	var err error
 
-	buf, err = openBuffer(b, folder, newCipher(), newCompressor())
+	buf, err = openBuffer(osFS{}, b, folder, newCipher(), newCompressor())
 
 	assert.NoError(t, err, "openBuffer")
 
@@ -72,7 +77,7 @@ func TestExist(t *testing.T) {
 	buf.endRecord()
 
 	var chunk *ChunkDto
-	chunk, err = buf.compress()
+	chunk, err = buf.compress(context.Background(), &MetaDto{}, 0, "", 0, 1, nopTrace{})
 
 	assert.NoError(t, err, "compress")
 	assertExists(t, path.Join(folder, chunk.FileName))
@@ -99,3 +104,169 @@ func assertExists(t *testing.T, path string) {
 		t.Fatal("buffer files should exist")
 	}
 }
+
+func TestOpenBuffer_DiscardsTornWrites(t *testing.T) {
+	folder := getFolder()
+	meta := newBoltMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+
+	_, err = w.Append([]byte("checkpointed"))
+	require.NoError(t, err)
+	pos, err := w.Checkpoint()
+	require.NoError(t, err)
+
+	// simulate a crash after a partial write past the checkpoint
+	dto, err := meta.GetBuffer()
+	require.NoError(t, err)
+	f, err := os.OpenFile(path.Join(folder, dto.FileName), os.O_RDWR, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, pos)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	w, err = OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(path.Join(folder, dto.FileName))
+	require.NoError(t, err)
+	assert.Equal(t, makeSlice(4), data[pos:pos+4])
+
+	_, err = w.Append([]byte("appended"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	var found []string
+	err = NewReader(folder, newCipher(), newDecompressor(), meta).ForEach(func(rec *Rec) error {
+		found = append(found, string(rec.Data))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"checkpointed", "appended"}, found)
+}
+
+func TestOpenBuffer_Truncated(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = db.Append(genSeedBytes(50, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	// cut the last record in half
+	require.NoError(t, os.Truncate(path.Join(folder, "000000000000"), 51*2+25))
+
+	_, err = New(folder, WithNoFileLock, WithMetaDB(meta))
+	assert.Equal(t, ErrBufferTruncated, errors.Cause(err))
+	assert.Contains(t, err.Error(), "has 127 bytes, expected at least 153")
+
+	db, err = New(folder, WithNoFileLock, WithMetaDB(meta), WithRepairTruncated())
+	require.NoError(t, err)
+	defer db.Close()
+
+	b, err := meta.GetBuffer()
+	require.NoError(t, err)
+	assert.Equal(t, int64(102), b.Pos)
+	assert.Equal(t, int64(2), b.Records)
+
+	var seeds []int
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, seeds)
+	assert.Equal(t, int64(102), db.VolatilePos())
+}
+
+// truncateFailingFS hands out files which fail to truncate, counting how many of them are closed.
+type truncateFailingFS struct {
+	*memFS
+	closed int
+}
+
+func (fs *truncateFailingFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := fs.memFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &truncateFailingFile{File: f, fs: fs}, nil
+}
+
+type truncateFailingFile struct {
+	File
+	fs *truncateFailingFS
+}
+
+func (f *truncateFailingFile) Truncate(size int64) error {
+	return errors.New("failing truncate")
+}
+
+func (f *truncateFailingFile) Close() error {
+	f.fs.closed++
+	return f.File.Close()
+}
+
+func TestOpenBuffer_TruncateFails(t *testing.T) {
+	fs := &truncateFailingFS{memFS: newMemFS()}
+
+	_, err := openBuffer(fs, &BufferDto{FileName: "000000000000", MaxBytes: 100}, ".", nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 1, fs.closed)
+}
+
+// spillSpy records whether compressed chunks were written to a temp file.
+type spillSpy struct {
+	Compressor
+	spilled *bool
+}
+
+func (s spillSpy) Compress(w io.Writer) (CompressionWriter, error) {
+	_, *s.spilled = w.(*os.File)
+	return s.Compressor.Compress(w)
+}
+
+func TestDB_TempDir(t *testing.T) {
+	defer func(threshold int64) { tempDirThreshold = threshold }(tempDirThreshold)
+	tempDirThreshold = 100
+
+	gcm, err := NewAESGCMCipher(gcmKey)
+	require.NoError(t, err)
+	tempDir := getFolder()
+	var spilled bool
+
+	_, err = New(getFolder(), WithTempDir(path.Join(tempDir, "missing")))
+	assert.Error(t, err)
+
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCipher(gcm),
+		WithCompressor(spillSpy{Lz4Compressor{}, &spilled}), WithTempDir(tempDir))
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	// small buffers are sealed in memory
+	_, err = db.Append([]byte("small"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+	assert.False(t, spilled)
+
+	_, err = db.Append(genSeedBytes(1000, 1))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+	assert.True(t, spilled)
+
+	files, err := ioutil.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	var records [][]byte
+	require.NoError(t, db.Reader().ForEach(func(rec *Rec) error {
+		records = append(records, rec.Data)
+		return nil
+	}))
+	assert.Equal(t, [][]byte{[]byte("small"), genSeedBytes(1000, 1)}, records)
+}
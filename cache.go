@@ -0,0 +1,149 @@
+package cellar
+
+import (
+	"container/list"
+	"sync"
+)
+
+// chunkCache is an LRU cache of decompressed chunks, keyed by ChunkDto.StartPos and bounded by the total
+// size of the cached chunks, including the record offsets kept along with them, see putOffsets. Entries are
+// tagged with the generation they were added in; bumping the generation through invalidate drops all of
+// them, which is needed once chunks are deleted or rewritten.
+type chunkCache struct {
+	mu *sync.Mutex
+
+	maxBytes   int64
+	size       int64
+	generation int64
+
+	lru     *list.List
+	entries map[int64]*list.Element
+}
+
+type cacheEntry struct {
+	startPos   int64
+	generation int64
+	data       []byte
+	offsets    []int
+}
+
+// size returns the bytes taken by the entry, counting the offsets as 8 bytes each.
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.data)) + 8*int64(len(e.offsets))
+}
+
+func newChunkCache(maxBytes int64) *chunkCache {
+	return &chunkCache{
+		mu:       &sync.Mutex{},
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[int64]*list.Element),
+	}
+}
+
+// get returns the cached chunk starting at startPos. Entries from an older generation are evicted.
+func (c *chunkCache) get(startPos int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[startPos]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*cacheEntry)
+	if entry.generation != c.generation {
+		c.remove(el)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	return entry.data, true
+}
+
+// put adds a chunk to the cache, evicting the least recently used chunks until it fits. Chunks larger
+// than the cache itself are not cached.
+func (c *chunkCache) put(startPos int64, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+
+	if el, ok := c.entries[startPos]; ok {
+		c.remove(el)
+	}
+
+	for c.size+int64(len(data)) > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+
+	c.entries[startPos] = c.lru.PushFront(&cacheEntry{startPos: startPos, generation: c.generation, data: data})
+	c.size += int64(len(data))
+}
+
+// offsets returns the record offsets kept along with the chunk data, if data is the chunk cached at startPos
+// and its offsets were put.
+func (c *chunkCache) offsets(startPos int64, data []byte) ([]int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[startPos]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if entry.generation != c.generation || entry.offsets == nil || !sameBytes(entry.data, data) {
+		return nil, false
+	}
+	return entry.offsets, true
+}
+
+// putOffsets keeps the record offsets of the chunk data along with it, if data is the chunk cached at
+// startPos. Other chunks are evicted as needed to make room for them.
+func (c *chunkCache) putOffsets(startPos int64, data []byte, offsets []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[startPos]
+	if !ok {
+		return
+	}
+	entry := el.Value.(*cacheEntry)
+	if entry.generation != c.generation || entry.offsets != nil || !sameBytes(entry.data, data) {
+		return
+	}
+	if entry.size()+8*int64(len(offsets)) > c.maxBytes {
+		return
+	}
+
+	c.size += 8 * int64(len(offsets))
+	entry.offsets = offsets
+	c.lru.MoveToFront(el)
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// sameBytes reports whether a and b are the same slice, rather than equal ones.
+func sameBytes(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// invalidate drops all cached chunks by moving to a new generation.
+func (c *chunkCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *chunkCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, entry.startPos)
+	c.size -= entry.size()
+}
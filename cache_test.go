@@ -0,0 +1,91 @@
+package cellar
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkCache_Evicts_LRU(t *testing.T) {
+	cache := newChunkCache(10)
+
+	cache.put(0, makeSlice(4))
+	cache.put(4, makeSlice(4))
+
+	// touch 0 so 4 becomes the least recently used entry
+	_, ok := cache.get(0)
+	require.True(t, ok)
+
+	cache.put(8, makeSlice(4))
+
+	_, ok = cache.get(4)
+	assert.False(t, ok)
+	_, ok = cache.get(0)
+	assert.True(t, ok)
+	_, ok = cache.get(8)
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), cache.size)
+
+	// too large to cache at all
+	cache.put(12, makeSlice(11))
+	_, ok = cache.get(12)
+	assert.False(t, ok)
+}
+
+func TestChunkCache_Invalidate(t *testing.T) {
+	cache := newChunkCache(10)
+
+	cache.put(0, makeSlice(4))
+	cache.invalidate()
+
+	_, ok := cache.get(0)
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), cache.size)
+}
+
+func TestReader_ReadAt_Cached(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithReadCache(1<<20))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	pos, err := db.Append([]byte("cached"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	for i := 0; i < 2; i++ {
+		rec, err := db.Reader().ReadAt(pos)
+		require.NoError(t, err)
+		assert.Equal(t, "cached", string(rec.Data))
+	}
+
+	_, ok := db.cache.get(0)
+	assert.True(t, ok)
+}
+
+func TestChunkCache_Offsets(t *testing.T) {
+	cache := newChunkCache(40)
+
+	chunk := makeSlice(8)
+	cache.put(0, chunk)
+
+	// offsets are only kept along with the very chunk they were computed for
+	cache.putOffsets(0, makeSlice(8), []int{0, 4})
+	_, ok := cache.offsets(0, chunk)
+	assert.False(t, ok)
+
+	cache.putOffsets(0, chunk, []int{0, 4})
+	offsets, ok := cache.offsets(0, chunk)
+	require.True(t, ok)
+	assert.Equal(t, []int{0, 4}, offsets)
+	assert.Equal(t, int64(24), cache.size)
+	_, ok = cache.offsets(0, makeSlice(8))
+	assert.False(t, ok)
+
+	// the offsets count towards the size of the cache
+	cache.put(8, makeSlice(20))
+	_, ok = cache.get(0)
+	assert.False(t, ok)
+	assert.Equal(t, int64(20), cache.size)
+}
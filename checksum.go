@@ -0,0 +1,58 @@
+package cellar
+
+import (
+	"hash/crc32"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("cellar: record does not match its checksum")
+	ErrChecksumsEnabled = errors.New("cellar: record checksums can only be enabled on an empty cellar")
+	ErrChunkCorrupted   = errors.New("cellar: chunk file does not match its checksum")
+	ErrChunkNotFound    = errors.New("cellar: no chunk starts at position")
+)
+
+// recordChecksumSize is the size of the CRC32 following the length prefix of every record, in cellars
+// created with record checksums.
+const recordChecksumSize = 4
+
+// readRecord decodes the record starting at pos in a chunk starting at chunkPos, verifying its checksum if
+// the cellar stores them. A mismatch returns ErrChecksumMismatch, and a length prefix reaching past the end of
+// the chunk ErrRecordBounds, identifying the position of the record.
+func readRecord(chunk []byte, pos int, chunkPos int64, checksums bool) (record []byte, next int, err error) {
+	var sum uint32
+	if record, sum, next, err = decodeRecord(chunk, pos, checksums); err != nil {
+		return nil, 0, errors.Wrapf(err, "position %d", chunkPos+int64(pos))
+	}
+
+	if checksums && crc32.ChecksumIEEE(record) != sum {
+		return nil, 0, errors.Wrapf(ErrChecksumMismatch, "position %d", chunkPos+int64(pos))
+	}
+	return record, next, nil
+}
+
+// verifyChunkFile compares the CRC32 of the chunk file at loc in fs with the checksum recorded when it was
+// sealed. Chunks sealed before checksums were recorded have checksum 0, and are not verified.
+func verifyChunkFile(fs FileSystem, loc string, c *ChunkDto) error {
+	if c.Checksum == 0 {
+		return nil
+	}
+
+	f, err := fs.Open(loc)
+	if err != nil {
+		return errors.Wrapf(err, "open chunk %s", loc)
+	}
+	defer f.Close()
+
+	sum := crc32.NewIEEE()
+	if _, err = io.Copy(sum, f); err != nil {
+		return errors.Wrapf(err, "read chunk %s", loc)
+	}
+
+	if sum.Sum32() != c.Checksum {
+		return errors.Wrapf(ErrChunkCorrupted, "chunk %s", c.FileName)
+	}
+	return nil
+}
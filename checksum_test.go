@@ -0,0 +1,208 @@
+package cellar
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_RecordChecksums(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithCipher(nil), WithCompressor(nil), WithRecordChecksums())
+	require.NoError(t, err)
+
+	pos, err := db.Append(genSeedBytes(50, 1))
+	require.NoError(t, err)
+	// length prefix, checksum and record
+	assert.Equal(t, int64(55), pos)
+
+	pos, err = db.AppendFrom(bytes.NewReader(genSeedBytes(50, 2)), 50)
+	require.NoError(t, err)
+	_, err = db.AppendBatch([][]byte{genSeedBytes(50, 3)})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = New(folder, WithNoFileLock, WithMetaDB(meta), WithCipher(nil), WithCompressor(nil))
+	require.NoError(t, err)
+	defer db.Close()
+
+	var seeds []int
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, seeds)
+
+	rec, err := db.Reader().ReadAt(pos)
+	require.NoError(t, err)
+	assert.Equal(t, genSeedBytes(50, 2), rec.Data)
+}
+
+func TestDB_RecordChecksums_Corrupted(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCipher(nil), WithCompressor(nil), WithRecordChecksums())
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.AppendBatch([][]byte{genSeedBytes(50, 1), genSeedBytes(50, 2)})
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	// chunks are stored as is after their header, so flip a byte in the body of the second record
+	chunks, err := db.meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	file := path.Join(folder, "000000000000.lz4")
+	chunk, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	chunk[chunks[0].HeaderSize+55+10] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(file, chunk, 0644))
+
+	err = db.Reader().ForEach(func(rec *Rec) error { return nil })
+	assert.Equal(t, ErrChecksumMismatch, errors.Cause(err))
+	assert.Contains(t, err.Error(), "position 55")
+
+	_, err = db.Reader().ReadAt(110)
+	assert.Equal(t, ErrChecksumMismatch, errors.Cause(err))
+
+	rec, err := db.Reader().ReadAt(55)
+	require.NoError(t, err)
+	assert.Equal(t, genSeedBytes(50, 1), rec.Data)
+}
+
+func TestDB_RecordBounds_Corrupted(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCipher(nil), WithCompressor(nil), WithRecordChecksums())
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.AppendBatch([][]byte{genSeedBytes(50, 1), genSeedBytes(50, 2)})
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	// make the length prefix of the second record reach past the end of the chunk
+	chunks, err := db.meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	file := path.Join(folder, "000000000000.lz4")
+	chunk, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	chunk[chunks[0].HeaderSize+55] = 0x7e
+	require.NoError(t, ioutil.WriteFile(file, chunk, 0644))
+
+	err = db.Reader().ForEach(func(rec *Rec) error { return nil })
+	assert.Equal(t, ErrRecordBounds, errors.Cause(err))
+	assert.Contains(t, err.Error(), "position 55")
+
+	_, err = db.Reader().ReadAt(110)
+	assert.Equal(t, ErrRecordBounds, errors.Cause(err))
+
+	_, err = db.Reader().RecordOffsets(0)
+	assert.Equal(t, ErrRecordBounds, errors.Cause(err))
+
+	err = db.Reader().scanFrom(100, func(*ReaderInfo, []byte) error { return nil })
+	assert.Equal(t, ErrRecordBounds, errors.Cause(err))
+}
+
+func TestDB_RecordChecksums_ExistingCellar(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta))
+	require.NoError(t, err)
+	_, err = db.Append(genSeedBytes(50, 1))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = New(folder, WithNoFileLock, WithMetaDB(meta), WithRecordChecksums())
+	assert.Equal(t, ErrChecksumsEnabled, errors.Cause(err))
+}
+
+func TestDB_RecordChecksums_ExistingCellar_Unlocks(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder)
+	require.NoError(t, err)
+	_, err = db.Append(genSeedBytes(50, 1))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// the failed open releases the file lock and the meta DB, so the cellar can be opened again
+	_, err = New(folder, WithRecordChecksums())
+	assert.Equal(t, ErrChecksumsEnabled, errors.Cause(err))
+
+	db, err = New(folder)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestReader_VerifyChunk(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	_, err = w.Append(genSeedBytes(100, 1))
+	require.NoError(t, err)
+	require.NoError(t, w.SealTheBuffer())
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.NotZero(t, chunks[0].Checksum)
+
+	reader := NewReader(folder, newCipher(), newDecompressor(), meta)
+	assert.NoError(t, reader.VerifyChunk(0))
+	assert.Equal(t, ErrChunkNotFound, errors.Cause(reader.VerifyChunk(1)))
+
+	file := path.Join(folder, chunks[0].FileName)
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(file, data, 0644))
+
+	assert.Equal(t, ErrChunkCorrupted, errors.Cause(reader.VerifyChunk(0)))
+}
+
+func TestDB_VerifyOnRead(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCipher(nil), WithCompressor(nil), WithVerifyOnRead())
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Append(genSeedBytes(50, 1))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	err = db.Reader().ForEach(func(rec *Rec) error { return nil })
+	require.NoError(t, err)
+
+	file := path.Join(folder, "000000000000.lz4")
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	// flip a byte of the record, rather than of the chunk header
+	data[len(data)-10] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(file, data, 0644))
+
+	err = db.Reader().ForEach(func(rec *Rec) error { return nil })
+	assert.Equal(t, ErrChunkCorrupted, errors.Cause(err))
+
+	// without verification, the corrupted record is returned as is
+	reader := db.Reader()
+	reader.VerifyOnRead = false
+	err = reader.ForEach(func(rec *Rec) error { return nil })
+	assert.NoError(t, err)
+}
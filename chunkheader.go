@@ -0,0 +1,148 @@
+package cellar
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNoChunkHeader       = errors.New("cellar: chunk file has no header")
+	ErrChunkHeaderVersion  = errors.New("cellar: chunk header version is not supported")
+	ErrChunkHeaderMismatch = errors.New("cellar: chunk header does not match the chunk metadata")
+)
+
+// chunkMagic starts the header of every chunk file, followed by the header version.
+var chunkMagic = []byte("CLR")
+
+// chunkHeaderVersion is the version of the headers written to chunk files. Cellars created before chunk
+// files had headers store version 0 in MetaDto.ChunkHeaderVersion, and their chunks have HeaderSize 0.
+const chunkHeaderVersion = 1
+
+// maxChunkHeaderPart bounds the size of the messages in a chunk header, so a corrupt header can't make
+// readChunkHeader allocate arbitrary amounts of memory.
+const maxChunkHeaderPart = 1 << 20
+
+// writeChunkHeader writes the plaintext header of a chunk file, which describes the chunk well enough to
+// decode it and to rebuild its metadata, see RebuildMeta. It holds chunkMagic, the version, the sizes of the
+// two messages following it as big endian uint32s, the chunk c without the fields only known once the file
+// is written, which includes the layout of its blocks, and the cellar metadata meta. It returns the size of
+// the header.
+func writeChunkHeader(w io.Writer, c *ChunkDto, meta *MetaDto) (int, error) {
+	chunk, err := proto.Marshal(&ChunkDto{
+		UncompressedByteSize: c.UncompressedByteSize,
+		Records:              c.Records,
+		StartPos:             c.StartPos,
+		StartIndex:           c.StartIndex,
+		MinTimestamp:         c.MinTimestamp,
+		MaxTimestamp:         c.MaxTimestamp,
+		CreatedAtUnix:        c.CreatedAtUnix,
+		Codec:                c.Codec,
+		Cipher:               c.Cipher,
+		Nonce:                c.Nonce,
+		KeyID:                c.KeyID,
+		DictID:               c.DictID,
+		DataChecksum:         c.DataChecksum,
+		Blocks:               c.Blocks,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "marshal chunk")
+	}
+	cellar, err := proto.Marshal(meta)
+	if err != nil {
+		return 0, errors.Wrap(err, "marshal meta")
+	}
+
+	prefix := len(chunkMagic) + 1
+	header := make([]byte, prefix+8, prefix+8+len(chunk)+len(cellar))
+	copy(header, chunkMagic)
+	header[len(chunkMagic)] = chunkHeaderVersion
+	binary.BigEndian.PutUint32(header[prefix:], uint32(len(chunk)))
+	binary.BigEndian.PutUint32(header[prefix+4:], uint32(len(cellar)))
+	header = append(append(header, chunk...), cellar...)
+
+	if _, err = w.Write(header); err != nil {
+		return 0, errors.Wrap(err, "write header")
+	}
+	return len(header), nil
+}
+
+// readChunkHeader reads the header written by writeChunkHeader from r. Files without one, which includes
+// chunks sealed before headers were written, return ErrNoChunkHeader, and headers written by a later version
+// of cellar ErrChunkHeaderVersion.
+func readChunkHeader(r io.Reader) (c *ChunkDto, meta *MetaDto, size int64, err error) {
+	prefix := make([]byte, len(chunkMagic)+1+8)
+	if _, err = io.ReadFull(r, prefix); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil, 0, ErrNoChunkHeader
+		}
+		return nil, nil, 0, errors.Wrap(err, "read header")
+	}
+	if string(prefix[:len(chunkMagic)]) != string(chunkMagic) {
+		return nil, nil, 0, ErrNoChunkHeader
+	}
+	if version := prefix[len(chunkMagic)]; version != chunkHeaderVersion {
+		return nil, nil, 0, errors.Wrapf(ErrChunkHeaderVersion, "version %d", version)
+	}
+
+	chunkSize := binary.BigEndian.Uint32(prefix[len(chunkMagic)+1:])
+	metaSize := binary.BigEndian.Uint32(prefix[len(chunkMagic)+5:])
+	if chunkSize > maxChunkHeaderPart || metaSize > maxChunkHeaderPart {
+		return nil, nil, 0, errors.Errorf("cellar: chunk header of %d and %d bytes", chunkSize, metaSize)
+	}
+
+	data := make([]byte, chunkSize+metaSize)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, nil, 0, errors.Wrap(err, "read header")
+	}
+
+	c, meta = &ChunkDto{}, &MetaDto{}
+	if err = proto.Unmarshal(data[:chunkSize], c); err != nil {
+		return nil, nil, 0, errors.Wrap(err, "unmarshal chunk")
+	}
+	if err = proto.Unmarshal(data[chunkSize:], meta); err != nil {
+		return nil, nil, 0, errors.Wrap(err, "unmarshal meta")
+	}
+	return c, meta, int64(len(prefix) + len(data)), nil
+}
+
+// checkChunkHeader reads the header of the chunk c from r, and checks that it describes c. On success, r is
+// positioned at the encrypted stream following the header.
+func checkChunkHeader(r io.Reader, c *ChunkDto) error {
+	header, _, size, err := readChunkHeader(r)
+	if err != nil {
+		return err
+	}
+	if size != int64(c.HeaderSize) || header.StartPos != c.StartPos ||
+		header.UncompressedByteSize != c.UncompressedByteSize || header.Codec != c.Codec || header.Cipher != c.Cipher {
+		return ErrChunkHeaderMismatch
+	}
+	return nil
+}
+
+// dataChecksum returns the CRC32 of the n bytes following the current position of src, which is left in
+// place.
+func dataChecksum(ctx context.Context, src io.ReadSeeker, n int64) (uint32, error) {
+	start, err := src.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, errors.Wrap(err, "Seek")
+	}
+
+	sum := crc32.NewIEEE()
+	copied, err := io.Copy(sum, io.LimitReader(ctxReader{ctx, src}, n))
+	if err == nil && copied < n {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "checksum")
+	}
+
+	if _, err = src.Seek(start, io.SeekStart); err != nil {
+		return 0, errors.Wrap(err, "Seek")
+	}
+	return sum.Sum32(), nil
+}
@@ -0,0 +1,96 @@
+package cellar
+
+import (
+	"bytes"
+	"context"
+	"hash/crc32"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkHeader(t *testing.T) {
+	var buf bytes.Buffer
+	c := &ChunkDto{StartPos: 42, Records: 3, UncompressedByteSize: 100, Codec: CodecZstd, Nonce: []byte("nonce"),
+		DataChecksum: 7, FileName: "not in the header", Checksum: 8}
+	size, err := writeChunkHeader(&buf, c, &MetaDto{RecordChecksums: true, ChunkHeaderVersion: chunkHeaderVersion})
+	require.NoError(t, err)
+	assert.Equal(t, buf.Len(), size)
+
+	header := buf.Bytes()
+	read, meta, n, err := readChunkHeader(bytes.NewReader(append(header, "data"...)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), n)
+	assert.Equal(t, &ChunkDto{StartPos: 42, Records: 3, UncompressedByteSize: 100, Codec: CodecZstd,
+		Nonce: []byte("nonce"), DataChecksum: 7}, read)
+	assert.True(t, meta.RecordChecksums)
+
+	_, _, _, err = readChunkHeader(bytes.NewReader([]byte("no header")))
+	assert.Equal(t, ErrNoChunkHeader, errors.Cause(err))
+	_, _, _, err = readChunkHeader(bytes.NewReader([]byte("CLR")))
+	assert.Equal(t, ErrNoChunkHeader, errors.Cause(err))
+
+	future := append([]byte(nil), header...)
+	future[len(chunkMagic)]++
+	_, _, _, err = readChunkHeader(bytes.NewReader(future))
+	assert.Equal(t, ErrChunkHeaderVersion, errors.Cause(err))
+}
+
+func TestDB_ChunkHeader(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithCipher(nil), WithCompressor(nil))
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	cellarMeta, err := meta.CellarMeta()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(chunkHeaderVersion), cellarMeta.ChunkHeaderVersion)
+
+	var positions []int64
+	for i := 0; i < 2; i++ {
+		pos, err := db.Append(genSeedBytes(50, i))
+		require.NoError(t, err)
+		positions = append(positions, pos)
+		require.NoError(t, db.SealTheBuffer())
+	}
+
+	chunks, err := db.Reader().sortedChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+
+	// chunks are stored as is after their header
+	first := chunks[0]
+	data, err := ioutil.ReadFile(path.Join(folder, first.FileName))
+	require.NoError(t, err)
+	require.NotZero(t, first.HeaderSize)
+	assert.Equal(t, crc32.ChecksumIEEE(data[first.HeaderSize:]), first.DataChecksum)
+
+	// a chunk file swapped for another one is detected before decoding it
+	other, err := ioutil.ReadFile(path.Join(folder, chunks[1].FileName))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path.Join(folder, first.FileName), other, 0644))
+	_, err = db.Reader().ReadAt(positions[0])
+	assert.Equal(t, ErrChunkHeaderMismatch, errors.Cause(err))
+
+	// chunks sealed without header are read as before
+	require.NoError(t, ioutil.WriteFile(path.Join(folder, first.FileName), data[first.HeaderSize:], 0644))
+	first.HeaderSize, first.Checksum, first.DataChecksum = 0, 0, 0
+	first.CompressedDiskSize = first.UncompressedByteSize
+	require.NoError(t, meta.PutChunk(first.StartPos, first))
+
+	rec, err := db.Reader().ReadAt(positions[0])
+	require.NoError(t, err)
+	require.NoError(t, checkSeedBytes(rec.Data, 0))
+
+	// but not expected in a cellar whose format has headers
+	report, err := db.Reader().Verify(context.Background())
+	require.NoError(t, err)
+	require.Len(t, report.Bad, 1)
+	assert.Equal(t, ErrNoChunkHeader, errors.Cause(report.Bad[0].Err))
+}
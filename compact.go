@@ -0,0 +1,177 @@
+package cellar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// Compact merges runs of adjacent sealed chunks into single chunks, to undo the effect of frequent seals on
+// scan throughput. Only runs of at least minChunks chunks are merged, and merged chunks hold at most
+// maxMergedBytes of uncompressed records. Merged chunks are written with the current cipher and compressor.
+//
+// Records keep their exact positions, since a merged chunk is the concatenation of the chunks it replaces.
+// The metadata is only swapped once the merged chunk is durable on disk, so concurrent readers see either the
+// old chunks or the merged one. The replaced chunk files are kept for readers which listed the chunks before
+// the swap, and deleted by the next Compact or by Close.
+//
+// It returns the number of chunks which were merged away.
+func (w *Writer) Compact(minChunks int, maxMergedBytes int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if err := w.commitPending(); err != nil {
+		return 0, err
+	}
+	if err := w.removeCompacted(); err != nil {
+		return 0, err
+	}
+
+	chunks, err := w.db.ListChunksRange(0, math.MaxInt64, 0)
+	if err != nil {
+		return 0, errors.Wrap(err, "ListChunksRange")
+	}
+
+	reader := w.chunkReader()
+
+	var compacted int
+	var run []*ChunkDto
+	var runBytes int64
+
+	mergeRun := func() error {
+		if len(run) >= minChunks && len(run) > 1 {
+			if err := w.mergeChunks(reader, run, runBytes); err != nil {
+				return err
+			}
+			compacted += len(run) - 1
+		}
+		run, runBytes = nil, 0
+		return nil
+	}
+
+	for _, c := range chunks {
+		if len(run) > 0 {
+			last := run[len(run)-1]
+			if last.StartPos+last.UncompressedByteSize != c.StartPos || runBytes+c.UncompressedByteSize > maxMergedBytes {
+				if err = mergeRun(); err != nil {
+					return compacted, err
+				}
+			}
+		}
+
+		run = append(run, c)
+		runBytes += c.UncompressedByteSize
+	}
+
+	if err = mergeRun(); err != nil {
+		return compacted, err
+	}
+	return compacted, nil
+}
+
+// mergeChunks replaces the adjacent chunks in run by a single chunk.
+func (w *Writer) mergeChunks(reader *Reader, run []*ChunkDto, size int64) error {
+	data := make([]byte, 0, size)
+	var records, createdAt int64
+	minTimestamp, maxTimestamp := run[0].MinTimestamp, run[0].MaxTimestamp
+	old := make([]int64, 0, len(run))
+
+	for _, c := range run {
+		chunk, err := reader.loadChunk(c)
+		if err != nil {
+			return errors.Wrapf(err, "load chunk %s", c.FileName)
+		}
+
+		data = append(data, chunk...)
+		records += c.Records
+		old = append(old, c.StartPos)
+		// the merged chunk is as young as its youngest part, so retention keeps it as long as needed
+		if c.CreatedAtUnix > createdAt {
+			createdAt = c.CreatedAtUnix
+		}
+		if c.MinTimestamp < minTimestamp {
+			minTimestamp = c.MinTimestamp
+		}
+		if c.MaxTimestamp > maxTimestamp {
+			maxTimestamp = c.MaxTimestamp
+		}
+	}
+
+	// merged chunks start at the same position as the first chunk they replace, so their name includes the end
+	startPos := run[0].StartPos
+	name := shardedName(fmt.Sprintf("%012d-%012d.lz4", startPos, startPos+size), w.shardLevels)
+	if err := createShardDir(w.fs, w.folder, name); err != nil {
+		return err
+	}
+
+	if err := w.refreshCompressor(); err != nil {
+		return err
+	}
+	info := &ChunkDto{
+		FileName:             name,
+		StartPos:             startPos,
+		StartIndex:           run[0].StartIndex,
+		Records:              records,
+		UncompressedByteSize: size,
+		CreatedAtUnix:        createdAt,
+		MinTimestamp:         minTimestamp,
+		MaxTimestamp:         maxTimestamp,
+	}
+	dto, err := sealChunk(context.Background(), w.fs, path.Join(w.folder, name), bytes.NewReader(data), info, w.cellarMeta(), w.cipher, w.compressor, w.durability, w.tempDir, w.blockSize, w.sealConcurrency, w.trace)
+	if err != nil {
+		return errors.Wrapf(err, "seal chunk %s", name)
+	}
+
+	bloom, err := w.buildBloom(data, records)
+	if err != nil {
+		return errors.Wrap(err, "buildBloom")
+	}
+	if bloom != nil {
+		dto.Bloom = bloom.bits
+		dto.BloomHashes = bloom.hashes
+	}
+
+	if err = w.db.ReplaceChunks(old, dto); err != nil {
+		return errors.Wrap(err, "ReplaceChunks")
+	}
+
+	for _, c := range run {
+		w.countChunk(c, -1)
+		w.compacted = append(w.compacted, c.FileName)
+	}
+	w.countChunk(dto, 1)
+	return nil
+}
+
+// removeCompacted removes the files of the chunks replaced by the last Compact.
+func (w *Writer) removeCompacted() error {
+	for len(w.compacted) > 0 {
+		if err := w.removeChunkFile(w.compacted[0]); err != nil {
+			return err
+		}
+		w.compacted = w.compacted[1:]
+	}
+	return nil
+}
+
+// chunkReader returns a reader of the chunks of the writer, which decodes every chunk the readers of its DB
+// decode: chunks sealed with codec 0 are LZ4, any other codec comes from the registry, and chunks compressed
+// with a dictionary or encrypted with a cipher other than the current one use those passed to the writer.
+func (w *Writer) chunkReader() *Reader {
+	reader := NewReader(w.folder, w.cipher, ChainDecompressor{}, w.db)
+	if w.registry != nil {
+		reader.registry = w.registry
+	}
+	reader.ciphers = w.ciphers
+	reader.dicts = w.dicts
+	reader.logger = w.logger
+	reader.fs = w.fs
+	return reader
+}
@@ -0,0 +1,174 @@
+package cellar
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter_Compact(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+
+	defer checkedClose(w)
+
+	// five chunks of two 51 byte records each
+	for i := 0; i < 10; i++ {
+		_, err = w.Append(genSeedBytes(50, i))
+		require.NoError(t, err)
+		if i%2 == 1 {
+			require.NoError(t, w.SealTheBuffer())
+		}
+	}
+
+	reader := NewReader(folder, newCipher(), newDecompressor(), meta)
+	positions := func() map[int64]int {
+		found := make(map[int64]int)
+		err := reader.ForEach(func(rec *Rec) error {
+			found[rec.NextPos] = int(rec.Data[0])
+			return nil
+		})
+		require.NoError(t, err)
+		return found
+	}
+	before := positions()
+	require.Len(t, before, 10)
+
+	old, err := meta.ListChunks()
+	require.NoError(t, err)
+
+	// runs are capped at 3 chunks by the size limit, leaving the last two chunks as a run of 2
+	compacted, err := w.Compact(2, 3*102)
+	require.NoError(t, err)
+	assert.Equal(t, 3, compacted)
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, int64(6), chunks[0].Records)
+	assert.Equal(t, int64(3*102), chunks[0].UncompressedByteSize)
+	assert.Equal(t, int64(3*102), chunks[1].StartPos)
+	assert.Equal(t, int64(4), chunks[1].Records)
+
+	// readers which listed the chunks before they were merged can still read them
+	for _, c := range old {
+		_, err = reader.readChunk(c, nil)
+		require.NoError(t, err)
+	}
+
+	// positions survive compaction
+	assert.Equal(t, before, positions())
+	for pos, seed := range before {
+		rec, err := reader.ReadAt(pos)
+		require.NoError(t, err)
+		require.NoError(t, checkSeedBytes(rec.Data, seed))
+	}
+
+	// runs shorter than minChunks are left alone
+	compacted, err = w.Compact(3, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 0, compacted)
+
+	// the next Compact removes the files of the merged chunks
+	for _, c := range old {
+		_, err = os.Stat(path.Join(folder, c.FileName))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestWriter_Compact_RemovedOnClose(t *testing.T) {
+	folder := getFolder()
+
+	w, err := OpenWriter(folder, NewInMemoryMetaDB(), WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = w.Append(genSeedBytes(50, i))
+		require.NoError(t, err)
+		require.NoError(t, w.SealTheBuffer())
+	}
+
+	compacted, err := w.Compact(2, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 1, compacted)
+	_, err = os.Stat(path.Join(folder, "000000000000.lz4"))
+	require.NoError(t, err)
+
+	require.NoError(t, w.Close())
+	_, err = os.Stat(path.Join(folder, "000000000000.lz4"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_Compact_ReadCache(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithReadCache(1<<20))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	for i := 0; i < 4; i++ {
+		_, err = db.Append(genSeedBytes(50, i))
+		require.NoError(t, err)
+		require.NoError(t, db.SealTheBuffer())
+	}
+
+	// caches the first chunk, which starts at the same position as the merged chunk
+	rec, err := db.Reader().ReadAt(51)
+	require.NoError(t, err)
+	require.NoError(t, checkSeedBytes(rec.Data, 0))
+
+	compacted, err := db.Compact(2, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 3, compacted)
+
+	for i := 0; i < 4; i++ {
+		rec, err = db.Reader().ReadAt(int64((i + 1) * 51))
+		require.NoError(t, err)
+		require.NoError(t, checkSeedBytes(rec.Data, i))
+	}
+}
+
+func TestDB_Compact_DecryptionCiphers(t *testing.T) {
+	gcm, err := NewAESGCMCipher(gcmKey)
+	require.NoError(t, err)
+	chacha, err := NewChaCha20Cipher(gcmKey)
+	require.NoError(t, err)
+
+	folder := getFolder()
+	db, err := New(folder, WithCipher(gcm))
+	require.NoError(t, err)
+
+	_, err = db.Append(genSeedBytes(50, 0))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+	require.NoError(t, db.Close())
+
+	// the chunks sealed with the previous cipher are read through the decryption ciphers
+	db, err = New(folder, WithCipher(chacha), WithDecryptionCiphers(gcm))
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	_, err = db.Append(genSeedBytes(50, 1))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	compacted, err := db.Compact(2, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 1, compacted)
+
+	chunks, err := db.Reader().sortedChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, CipherChaCha20, chunks[0].Cipher)
+
+	for i := 0; i < 2; i++ {
+		rec, err := db.Reader().ReadAt(int64((i + 1) * 51))
+		require.NoError(t, err)
+		require.NoError(t, checkSeedBytes(rec.Data, i))
+	}
+}
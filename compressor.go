@@ -2,12 +2,31 @@ package cellar
 
 import (
 	"io"
+	"io/ioutil"
+	"sync"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4"
+	"github.com/pkg/errors"
+)
+
+// Codec ids, stored in ChunkDto.Codec so readers know how a chunk was compressed. Chunks written before
+// codec ids were recorded have codec 0, and are compressed with LZ4.
+const (
+	CodecLZ4  uint32 = 0
+	CodecZstd uint32 = 1
+	CodecNone uint32 = 2
+)
+
+var (
+	ErrUnknownCodec    = errors.New("cellar: no decompressor registered for codec")
+	ErrCodecRegistered = errors.New("cellar: codec is already registered")
 )
 
 type Compressor interface {
 	Compress(io.Writer) (CompressionWriter, error)
+	// Codec returns the id recorded in the chunks written by the compressor.
+	Codec() uint32
 }
 
 type Decompressor interface {
@@ -27,16 +46,284 @@ type ChainCompressor struct {
 }
 
 func (c ChainCompressor) Compress(w io.Writer) (CompressionWriter, error) {
-	zw := lz4.NewWriter(w)
+	zw := newPooledLz4Writer(w)
 	zw.Header.CompressionLevel = c.CompressionLevel
 	return zw, nil
 }
 
+func (c ChainCompressor) Codec() uint32 {
+	return CodecLZ4
+}
+
+var _ Compressor = &Lz4Compressor{}
+
+// Lz4Compressor compresses chunks using LZ4 in its fast mode, for workloads where seal latency matters more
+// than the compression ratio. It writes the same frame format as ChainCompressor, so its chunks are read
+// back by ChainDecompressor.
+type Lz4Compressor struct{}
+
+func (c Lz4Compressor) Compress(w io.Writer) (CompressionWriter, error) {
+	return newPooledLz4Writer(w), nil
+}
+
+func (c Lz4Compressor) Codec() uint32 {
+	return CodecLZ4
+}
+
+// lz4Writers holds lz4 writers for reuse, since every writer allocates block buffers of several MB on its
+// first write.
+var lz4Writers = sync.Pool{New: func() interface{} { return lz4.NewWriter(nil) }}
+
+// pooledLz4Writer returns its lz4 writer to lz4Writers once closed.
+type pooledLz4Writer struct {
+	*lz4.Writer
+}
+
+func newPooledLz4Writer(w io.Writer) *pooledLz4Writer {
+	zw := lz4Writers.Get().(*lz4.Writer)
+	zw.Reset(w)
+	return &pooledLz4Writer{zw}
+}
+
+func (p *pooledLz4Writer) Close() error {
+	if p.Writer == nil {
+		return nil
+	}
+	err := p.Writer.Close()
+
+	p.Writer.Reset(nil)
+	lz4Writers.Put(p.Writer)
+	p.Writer = nil
+	return err
+}
+
 var _ Decompressor
 
 type ChainDecompressor struct{}
 
 func (c ChainDecompressor) Decompress(r io.Reader) (io.Reader, error) {
-	zr := lz4.NewReader(r)
-	return zr, nil
+	zr := lz4Readers.Get().(*lz4.Reader)
+	zr.Reset(r)
+	return &pooledLz4Reader{zr}, nil
+}
+
+// lz4Readers holds lz4 readers for reuse, since every reader allocates block buffers of several MB when
+// reading the frame header.
+var lz4Readers = sync.Pool{New: func() interface{} { return lz4.NewReader(nil) }}
+
+// pooledLz4Reader returns its lz4 reader to lz4Readers once closed. Readers which are never closed are left
+// to the garbage collector.
+type pooledLz4Reader struct {
+	*lz4.Reader
+}
+
+func (p *pooledLz4Reader) Close() error {
+	if p.Reader == nil {
+		return nil
+	}
+	p.Reader.Reset(nil)
+	lz4Readers.Put(p.Reader)
+	p.Reader = nil
+	return nil
+}
+
+var _ Compressor = &ZstdCompressor{}
+
+var _ dictCompressor = &ZstdCompressor{}
+
+// ZstdCompressor compresses chunks using Zstandard, which usually gives better ratios than LZ4 at the cost
+// of slower seals. Level follows the zstd command line levels (1-22); 0 selects the default level. Dict is an
+// optional zstd dictionary, see TrainDictionary.
+type ZstdCompressor struct {
+	Level int
+	Dict  []byte
+}
+
+// Range of the zstd command line levels accepted by NewZstdCompressor.
+const (
+	MinZstdLevel = 1
+	MaxZstdLevel = 22
+)
+
+// NewZstdCompressor returns a zstd compressor at the given level, which ranges from MinZstdLevel, the
+// fastest, to MaxZstdLevel, the best ratio. Levels beyond 19 take a lot of memory and CPU for a small gain.
+func NewZstdCompressor(level int) (*ZstdCompressor, error) {
+	if level < MinZstdLevel || level > MaxZstdLevel {
+		return nil, errors.Errorf("cellar: zstd level must be between %d and %d, got %d", MinZstdLevel, MaxZstdLevel, level)
+	}
+	return &ZstdCompressor{Level: level}, nil
+}
+
+func (c ZstdCompressor) Compress(w io.Writer) (CompressionWriter, error) {
+	level := zstd.SpeedDefault
+	if c.Level > 0 {
+		level = zstd.EncoderLevelFromZstd(c.Level)
+	}
+	options := []zstd.EOption{zstd.WithEncoderLevel(level)}
+	if len(c.Dict) > 0 {
+		options = append(options, zstd.WithEncoderDict(c.Dict))
+	}
+	return zstd.NewWriter(w, options...)
+}
+
+func (c ZstdCompressor) Codec() uint32 {
+	return CodecZstd
+}
+
+// DictID returns the id of the dictionary, or 0 without one.
+func (c ZstdCompressor) DictID() uint32 {
+	if len(c.Dict) == 0 {
+		return 0
+	}
+	id, err := DictionaryID(c.Dict)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+var _ Decompressor = &ZstdDecompressor{}
+
+// ZstdDecompressor reads chunks compressed by ZstdCompressor. Chunks compressed with a dictionary need it in
+// Dicts.
+type ZstdDecompressor struct {
+	Dicts [][]byte
+}
+
+func (c ZstdDecompressor) Decompress(r io.Reader) (io.Reader, error) {
+	options := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if len(c.Dicts) > 0 {
+		options = append(options, zstd.WithDecoderDicts(c.Dicts...))
+	}
+	// a single goroutine decodes synchronously, so the decoder needs no explicit Close
+	return zstd.NewReader(r, options...)
+}
+
+// checkCompressor compresses a small vector, to catch a misconfigured compressor before it is used to seal
+// a chunk.
+func checkCompressor(c Compressor) error {
+	zw, err := c.Compress(ioutil.Discard)
+	if err != nil {
+		return errors.Wrap(err, "Compress")
+	}
+	if _, err = zw.Write(selfTestVector); err != nil {
+		return errors.Wrap(err, "Write")
+	}
+	return zw.Close()
+}
+
+var _ Compressor = &NoCompressor{}
+var _ Decompressor = &NoCompressor{}
+
+// NoCompressor stores chunks uncompressed. It is used by NewWriter and NewReader when no compressor is given.
+type NoCompressor struct{}
+
+func (c NoCompressor) Compress(w io.Writer) (CompressionWriter, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (c NoCompressor) Codec() uint32 {
+	return CodecNone
+}
+
+func (c NoCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+// nopWriteCloser passes writes through, and does nothing on Close.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// CompressorRegistry maps codec ids to factories for the compressor and decompressor implementing them, so
+// that writers can select a compressor by id, and readers can select the matching decompressor for every
+// chunk. It is safe for concurrent use.
+type CompressorRegistry struct {
+	mu *sync.RWMutex
+
+	compressors   map[uint32]func() Compressor
+	decompressors map[uint32]func() Decompressor
+}
+
+// defaultRegistry is used by DBs and readers unless configured otherwise, and is extended through the
+// package level RegisterCompressor and RegisterDecompressor.
+var defaultRegistry = NewCompressorRegistry()
+
+// NewCompressorRegistry returns a registry holding the codecs built into cellar.
+func NewCompressorRegistry() *CompressorRegistry {
+	r := &CompressorRegistry{
+		mu:            &sync.RWMutex{},
+		compressors:   make(map[uint32]func() Compressor),
+		decompressors: make(map[uint32]func() Decompressor),
+	}
+
+	r.compressors[CodecLZ4] = func() Compressor { return ChainCompressor{CompressionLevel: 10} }
+	r.decompressors[CodecLZ4] = func() Decompressor { return ChainDecompressor{} }
+	r.compressors[CodecZstd] = func() Compressor { return ZstdCompressor{} }
+	r.decompressors[CodecZstd] = func() Decompressor { return ZstdDecompressor{} }
+	r.compressors[CodecNone] = func() Compressor { return NoCompressor{} }
+	r.decompressors[CodecNone] = func() Decompressor { return NoCompressor{} }
+	return r
+}
+
+// RegisterCompressor adds a compressor factory to the default registry.
+func RegisterCompressor(id uint32, factory func() Compressor) error {
+	return defaultRegistry.RegisterCompressor(id, factory)
+}
+
+// RegisterDecompressor adds a decompressor factory to the default registry.
+func RegisterDecompressor(id uint32, factory func() Decompressor) error {
+	return defaultRegistry.RegisterDecompressor(id, factory)
+}
+
+// RegisterCompressor adds a compressor factory for codec id, failing if the id is already taken.
+func (r *CompressorRegistry) RegisterCompressor(id uint32, factory func() Compressor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.compressors[id]; ok {
+		return errors.Wrapf(ErrCodecRegistered, "compressor %d", id)
+	}
+	r.compressors[id] = factory
+	return nil
+}
+
+// RegisterDecompressor adds a decompressor factory for codec id, failing if the id is already taken.
+func (r *CompressorRegistry) RegisterDecompressor(id uint32, factory func() Decompressor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.decompressors[id]; ok {
+		return errors.Wrapf(ErrCodecRegistered, "decompressor %d", id)
+	}
+	r.decompressors[id] = factory
+	return nil
+}
+
+// Compressor returns a new compressor for codec.
+func (r *CompressorRegistry) Compressor(codec uint32) (Compressor, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	factory, ok := r.compressors[codec]
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// Decompressor returns a new decompressor for codec.
+func (r *CompressorRegistry) Decompressor(codec uint32) (Decompressor, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	factory, ok := r.decompressors[codec]
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
 }
@@ -0,0 +1,266 @@
+package cellar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZstdCompressor_Roundtrip(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()), WithCompressor(ZstdCompressor{Level: 3}))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	for i := 0; i < 10; i++ {
+		_, err = db.Append(genSeedBytes(1000, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.SealTheBuffer())
+
+	chunks, err := db.meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, CodecZstd, chunks[0].Codec)
+
+	i := 0
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		require.NoError(t, checkSeedBytes(rec.Data, i))
+		i++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 10, i)
+}
+
+func TestCompressor_MixedCodecs(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder)
+	require.NoError(t, err)
+
+	_, err = db.Append([]byte("lz4"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+	require.NoError(t, db.Close())
+
+	// switching codecs keeps the existing chunks readable
+	db, err = New(folder, WithCompressor(ZstdCompressor{}))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	_, err = db.Append([]byte("zstd"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	var found []string
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		found = append(found, string(rec.Data))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"lz4", "zstd"}, found)
+}
+
+func TestNewZstdCompressor(t *testing.T) {
+	for _, level := range []int{0, -1, MaxZstdLevel + 1} {
+		_, err := NewZstdCompressor(level)
+		assert.Error(t, err, "level %d", level)
+	}
+
+	c, err := NewZstdCompressor(MinZstdLevel)
+	require.NoError(t, err)
+	assert.Equal(t, MinZstdLevel, c.Level)
+	assert.NoError(t, checkCompressor(c))
+}
+
+func TestCompressorFactory(t *testing.T) {
+	_, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCompressorFactory(nil))
+	assert.Error(t, err)
+
+	fast, err := NewZstdCompressor(1)
+	require.NoError(t, err)
+
+	mu := &sync.Mutex{}
+	var current Compressor = fast
+	factory := func() Compressor {
+		mu.Lock()
+		defer mu.Unlock()
+		return current
+	}
+
+	meta := NewInMemoryMetaDB()
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(meta), WithCompressor(NoCompressor{}),
+		WithCompressorFactory(factory))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	_, err = db.Append([]byte("zstd"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	// seals pick up the new compressor without reopening the writer
+	mu.Lock()
+	current = Lz4Compressor{}
+	mu.Unlock()
+	_, err = db.Append([]byte("lz4"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, CodecZstd, chunks[0].Codec)
+	assert.Equal(t, CodecLZ4, chunks[1].Codec)
+
+	var found []string
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		found = append(found, string(rec.Data))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"zstd", "lz4"}, found)
+}
+
+func TestCompressors_Roundtrip(t *testing.T) {
+	const Size = 4 << 20
+
+	compressors := []struct {
+		name         string
+		compressor   Compressor
+		decompressor Decompressor
+	}{
+		{"chain", ChainCompressor{CompressionLevel: 10}, ChainDecompressor{}},
+		{"lz4", Lz4Compressor{}, ChainDecompressor{}},
+		{"zstd", ZstdCompressor{}, ZstdDecompressor{}},
+	}
+
+	inputs := []struct {
+		name string
+		data []byte
+	}{
+		{"repetitive", bytes.Repeat([]byte("a fairly repetitive payload "), Size/28)},
+		{"random", genRandBytes(Size)},
+	}
+
+	for _, c := range compressors {
+		for _, input := range inputs {
+			t.Run(c.name+"_"+input.name, func(t *testing.T) {
+				compressed := &bytes.Buffer{}
+
+				zw, err := c.compressor.Compress(compressed)
+				require.NoError(t, err)
+				_, err = zw.Write(input.data)
+				require.NoError(t, err)
+				require.NoError(t, zw.Close())
+
+				zr, err := c.decompressor.Decompress(compressed)
+				require.NoError(t, err)
+				data, err := ioutil.ReadAll(zr)
+				require.NoError(t, err)
+
+				assert.True(t, bytes.Equal(input.data, data))
+			})
+		}
+	}
+}
+
+// identityCompressor is a custom codec for testing the registry, storing data without compression.
+type identityCompressor struct{}
+
+func (c identityCompressor) Compress(w io.Writer) (CompressionWriter, error) {
+	return nopCloser{w}, nil
+}
+
+func (c identityCompressor) Codec() uint32 {
+	return 42
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (n nopCloser) Close() error { return nil }
+
+type identityDecompressor struct{}
+
+func (d identityDecompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+func TestCompressorRegistry(t *testing.T) {
+	registry := NewCompressorRegistry()
+
+	_, ok := registry.Decompressor(CodecZstd)
+	assert.True(t, ok)
+
+	_, ok = registry.Decompressor(42)
+	assert.False(t, ok)
+
+	folder := getFolder()
+	meta := newBoltMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithCompressor(identityCompressor{}))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	pos, err := db.Append([]byte("custom codec"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	// unknown codecs are reported
+	reader := db.Reader()
+	_, err = reader.ReadAt(pos)
+	assert.Equal(t, ErrUnknownCodec, errors.Cause(err))
+
+	require.NoError(t, registry.RegisterDecompressor(42, func() Decompressor { return identityDecompressor{} }))
+	reader.registry = registry
+
+	rec, err := reader.ReadAt(pos)
+	require.NoError(t, err)
+	assert.Equal(t, "custom codec", string(rec.Data))
+}
+
+func TestCompressorRegistry_Register(t *testing.T) {
+	registry := NewCompressorRegistry()
+
+	err := registry.RegisterCompressor(CodecZstd, func() Compressor { return ZstdCompressor{} })
+	assert.Equal(t, ErrCodecRegistered, errors.Cause(err))
+
+	require.NoError(t, registry.RegisterCompressor(42, func() Compressor { return identityCompressor{} }))
+	require.NoError(t, registry.RegisterDecompressor(42, func() Decompressor { return identityDecompressor{} }))
+
+	err = registry.RegisterDecompressor(42, func() Decompressor { return identityDecompressor{} })
+	assert.Equal(t, ErrCodecRegistered, errors.Cause(err))
+
+	// the writer looks up its compressor by codec id
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()), WithCompressorRegistry(registry), WithCodec(42))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	pos, err := db.Append([]byte("registered codec"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	chunks, err := db.meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, uint32(42), chunks[0].Codec)
+
+	rec, err := db.Reader().ReadAt(pos)
+	require.NoError(t, err)
+	assert.Equal(t, "registered codec", string(rec.Data))
+
+	_, err = New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()), WithCodec(43))
+	assert.Equal(t, ErrUnknownCodec, errors.Cause(err))
+}
@@ -0,0 +1,92 @@
+package cellar
+
+import (
+	"context"
+)
+
+// Cursor iterates over the records of a cellar in the style of bufio.Scanner:
+//
+//	c := reader.Cursor(ctx)
+//	defer c.Close()
+//	for c.Next() {
+//		rec := c.Value()
+//		...
+//	}
+//	if err := c.Err(); err != nil {
+//		...
+//	}
+//
+// It is backed by the same scan as ScanAsync, which Close stops, so breaking out of the loop early does not
+// leak the scanning goroutine. A cursor is not safe for concurrent use.
+type Cursor struct {
+	vals   chan *Rec
+	errs   chan error
+	cancel context.CancelFunc
+
+	cur  *Rec
+	err  error
+	done bool
+}
+
+// Cursor returns a cursor over all records of the cellar. It must be closed once it is no longer needed.
+func (r *Reader) Cursor(ctx context.Context) *Cursor {
+	ctx, cancel := context.WithCancel(ctx)
+	vals, errs := r.ScanAsync(ctx, 0)
+
+	return &Cursor{
+		vals:   vals,
+		errs:   errs,
+		cancel: cancel,
+	}
+}
+
+// Next advances the cursor to the next record, returning false once all records were read, the scan failed,
+// or the context of the cursor was cancelled.
+func (c *Cursor) Next() bool {
+	if c.done {
+		return false
+	}
+
+	rec, ok := <-c.vals
+	if ok {
+		c.cur = rec
+		return true
+	}
+
+	c.err = <-c.errs
+	c.finish()
+	return false
+}
+
+// Value returns the record the cursor is positioned at, which is only valid after Next returned true.
+func (c *Cursor) Value() *Rec {
+	return c.cur
+}
+
+// Err returns the error which ended the iteration, or nil if all records were read or the cursor was closed.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// Close stops the scan backing the cursor, and waits for it to exit. Closing a cursor more than once is a
+// no-op.
+func (c *Cursor) Close() error {
+	if c.done {
+		return nil
+	}
+
+	c.cancel()
+	// drain the channels, so the scan can observe the cancellation and exit
+	for range c.vals {
+	}
+	<-c.errs
+
+	c.finish()
+	return nil
+}
+
+func (c *Cursor) finish() {
+	c.cancel()
+	c.cur = nil
+	c.done = true
+}
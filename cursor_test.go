@@ -0,0 +1,76 @@
+package cellar
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func newCursorReader(t *testing.T, records int) *Reader {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+
+	for i := 0; i < records; i++ {
+		_, err = w.Append(genSeedBytes(100, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	return NewReader(folder, newCipher(), newDecompressor(), meta)
+}
+
+func TestReader_Cursor(t *testing.T) {
+	reader := newCursorReader(t, 30)
+
+	c := reader.Cursor(context.Background())
+	defer c.Close()
+
+	var seeds []int
+	for c.Next() {
+		seeds = append(seeds, int(c.Value().Data[0]))
+	}
+	require.NoError(t, c.Err())
+	assert.Len(t, seeds, 30)
+	assert.Equal(t, 29, seeds[29])
+
+	assert.False(t, c.Next())
+	assert.Nil(t, c.Value())
+}
+
+func TestReader_Cursor_Close(t *testing.T) {
+	reader := newCursorReader(t, 30)
+
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	c := reader.Cursor(context.Background())
+	for i := 0; i < 5; i++ {
+		require.True(t, c.Next())
+	}
+
+	require.NoError(t, c.Close())
+	require.NoError(t, c.Close())
+	assert.False(t, c.Next())
+	assert.NoError(t, c.Err())
+}
+
+func TestReader_Cursor_Cancelled(t *testing.T) {
+	reader := newCursorReader(t, 30)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := reader.Cursor(ctx)
+	defer c.Close()
+
+	require.True(t, c.Next())
+	cancel()
+
+	for c.Next() {
+	}
+	assert.Equal(t, context.Canceled, errors.Cause(c.Err()))
+}
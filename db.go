@@ -1,17 +1,20 @@
 package cellar
 
 import (
+	"crypto/rand"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
-	"github.com/gofrs/flock"
 	"github.com/pkg/errors"
-	"go.etcd.io/bbolt"
 )
 
 const lockfile = "cellar.lock"
 
+// defaultBufferSize is the maximum size of the buffer, unless set through WithMaxBufferSize
+const defaultBufferSize = 100000
+
 // DB is a godlevel/convenience wrapper around Writer and Reader, ensuring only one writer exists per
 // folder, and storing the cipher for faster performance.
 type DB struct {
@@ -21,25 +24,106 @@ type DB struct {
 	mu     *sync.Mutex
 	writer *Writer
 	cipher Cipher
+	// ciphers are used to read chunks not encrypted with cipher
+	ciphers map[uint32]Cipher
+	// passphrase replaces cipher once the meta DB is opened, see WithPassphrase
+	passphrase string
+
+	// recordChecksums is recorded in the meta DB of new cellars, see WithRecordChecksums
+	recordChecksums bool
+	// recordTimestamps is recorded in the meta DB of new cellars, see WithRecordTimestamps
+	recordTimestamps bool
+	verifyOnRead     bool
+	skipMissing      bool
+	repairTruncated  bool
+
+	// dirShardLevels replaces the layout stored in the meta DB if dirSharding is set, see WithDirSharding
+	dirShardLevels int
+	dirSharding    bool
 
 	fileLock FileLock
 
 	compressor   Compressor
 	decompressor Decompressor
+	// compressorFactory replaces compressor at every seal, see WithCompressorFactory
+	compressorFactory func() Compressor
+	registry          *CompressorRegistry
+
+	// keyExtractor builds a bloom filter for every chunk, see WithKeyExtractor
+	keyExtractor func([]byte) []byte
+	// keyIndex maps the keys extracted by keyExtractor to their latest record, see WithKeyIndex
+	keyIndex bool
+
+	// dict is set on the zstd compressor, see WithCompressionDict
+	dict []byte
+	// dicts are used to read chunks compressed with a dictionary, by dictionary id
+	dicts map[uint32][]byte
+
+	// codec selects the compressor from the registry, unless a compressor is set explicitly
+	codec    uint32
+	useCodec bool
 
 	meta MetaDB
 
+	logger  Logger
+	metrics Metrics
+	trace   TraceHook
+
+	cache           *chunkCache
+	mmaps           *chunkMaps
+	scanConcurrency int
+	// maxInFlightChunks bounds the decompressed chunks held by scans, see WithMaxInFlightChunks
+	maxInFlightChunks int
+
+	maxValueSize int64
+
+	autoCheckpointRecords int64
+	autoCheckpointBytes   int64
+
+	durability Durability
+	// tempDir holds the temp files of large seals, see WithTempDir
+	tempDir string
+	// blockSize is the size of the blocks large seals are compressed in, and sealConcurrency the number of
+	// goroutines compressing them, see WithBlockSize and WithSealConcurrency
+	blockSize       int64
+	sealConcurrency int
+	// fs holds the buffer and chunk files, see WithFileSystem
+	fs FileSystem
+	// groupCommit is the window in which seals are committed together, 0 commits every seal on its own
+	groupCommit time.Duration
+
+	autoFlush     time.Duration
+	stopAutoFlush chan struct{}
+	autoFlushDone chan struct{}
+
+	// openTimeout is the time to wait for the locks of the cellar, see WithOpenTimeout
+	openTimeout time.Duration
+
 	readonly bool
+	closed   bool
+}
+
+// metaTimeout returns the time to wait for the lock of the bolt meta DB.
+func (db *DB) metaTimeout() time.Duration {
+	if db.openTimeout > 0 {
+		return db.openTimeout
+	}
+	return defaultMetaTimeout
 }
 
 // New is the constructor for DB
-func New(folder string, options ...Option) (*DB, error) {
+func New(folder string, options ...Option) (_ *DB, err error) {
 	db := &DB{
 		folder: folder,
-		buffer: 100000,
-
-		mu:       &sync.Mutex{},
-		readonly: false,
+		buffer: defaultBufferSize,
+		fs:     osFS{},
+
+		mu:              &sync.Mutex{},
+		readonly:        false,
+		scanConcurrency: 1,
+		logger:          stdLogger{},
+		metrics:         NopMetrics{},
+		trace:           nopTrace{},
 	}
 
 	for _, opt := range options {
@@ -49,27 +133,58 @@ func New(folder string, options ...Option) (*DB, error) {
 		}
 	}
 
+	if db.keyIndex && db.keyExtractor == nil {
+		return nil, errors.New("cellar: the key index needs a key extractor, see WithKeyExtractor")
+	}
+
+	// release what New acquires if it fails, so a failed New can be retried in the same process
+	ownMeta, ownWriter := db.meta == nil, db.writer == nil
+	defer func() {
+		if err == nil {
+			return
+		}
+		if ownWriter && db.writer != nil {
+			if cerr := db.writer.Close(); cerr != nil {
+				db.logger.Printf("cellar: can't close writer: %s", cerr)
+			}
+		}
+		if ownMeta && db.meta != nil {
+			if cerr := db.meta.Close(); cerr != nil {
+				db.logger.Printf("cellar: can't close meta DB: %s", cerr)
+			}
+		}
+		if db.fileLock != nil {
+			if uerr := db.fileLock.Unlock(); uerr != nil {
+				db.logger.Printf("cellar: can't unlock %s: %s", folder, uerr)
+			}
+		}
+	}()
+
 	// checking for nil allows us to create an options which supersede these routines.
 	if db.fileLock == nil {
-		// Create the lockile
-		file := flock.New(fmt.Sprintf("%s/%s", folder, lockfile))
-		locked, err := file.TryLock()
+		file, err := lockFolder(folder, db.openTimeout)
 		if err != nil {
 			return nil, err
 		}
-
-		if !locked {
-			return nil, errors.New("cellar: unable to acquire filelock")
-		}
-
 		db.fileLock = file
 	}
 
-	//TODO create a mock cipher which does not decrypt and encrypt
-	if db.cipher == nil {
+	if db.cipher == nil && db.passphrase == "" {
 		db.cipher = NewAES(defaultEncryptionKey)
 	}
 
+	if db.registry == nil {
+		db.registry = defaultRegistry
+	}
+
+	if err := db.selectCodec(); err != nil {
+		return nil, err
+	}
+
+	if err := db.selectDict(); err != nil {
+		return nil, err
+	}
+
 	if db.compressor == nil {
 		db.compressor = ChainCompressor{CompressionLevel: 10}
 	}
@@ -79,7 +194,7 @@ func New(folder string, options ...Option) (*DB, error) {
 	}
 
 	if db.meta == nil {
-		blt, err := bolt.Open(fmt.Sprintf("%s/%s", folder, "meta.bolt"), 0600, &bolt.Options{Timeout: 1 * time.Second})
+		blt, err := openBolt(fmt.Sprintf("%s/%s", folder, "meta.bolt"), db.metaTimeout(), false)
 		if err != nil {
 			return nil, err
 		}
@@ -90,6 +205,32 @@ func New(folder string, options ...Option) (*DB, error) {
 		}
 	}
 
+	if db.passphrase != "" {
+		cipher, err := db.passphraseCipher()
+		if err != nil {
+			return nil, err
+		}
+		db.cipher = cipher
+	}
+
+	if db.recordChecksums {
+		if err := db.enableRecordChecksums(); err != nil {
+			return nil, err
+		}
+	}
+
+	if db.recordTimestamps {
+		if err := db.enableRecordTimestamps(); err != nil {
+			return nil, err
+		}
+	}
+
+	if db.repairTruncated && !db.readonly {
+		if err := db.repairBuffer(); err != nil {
+			return nil, err
+		}
+	}
+
 	if db.writer == nil && !db.readonly {
 		err := db.newWriter()
 		if err != nil {
@@ -97,24 +238,115 @@ func New(folder string, options ...Option) (*DB, error) {
 		}
 	}
 
+	if db.keyIndex && db.writer != nil {
+		if err := db.openKeyIndex(); err != nil {
+			return nil, errors.Wrap(err, "openKeyIndex")
+		}
+	}
+
+	if db.autoFlush > 0 && db.writer != nil {
+		db.startAutoFlush()
+	}
+
 	return db, nil
 }
 
+// startAutoFlush checkpoints the writer every autoFlush interval until Close is called, bounding the
+// window in which appended records only exist in memory.
+func (db *DB) startAutoFlush() {
+	db.stopAutoFlush = make(chan struct{})
+	db.autoFlushDone = make(chan struct{})
+
+	go func() {
+		defer close(db.autoFlushDone)
+
+		ticker := time.NewTicker(db.autoFlush)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-db.stopAutoFlush:
+				return
+			case <-ticker.C:
+				db.mu.Lock()
+				if _, err := db.writer.Checkpoint(); err != nil {
+					db.logger.Printf("cellar: auto flush failed: %s", err)
+				}
+				db.mu.Unlock()
+			}
+		}
+	}()
+}
+
 // Write creates a writer using sync.Once, and then reuses the writer over procedures
+//
+// Appends are serialized by the writer rather than the DB, so appends waiting for a group commit do not hold
+// up others, see WithGroupCommit. Once the DB is closed, they fail with ErrClosed.
 func (db *DB) Append(data []byte) (pos int64, err error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
 	return db.writer.Append(data)
 }
 
-// Close ensures filelocks are cleared and resources closed. Readers derived from this DB instance will remain functional.
+// AppendBatch appends all records in order, returning the position of each of them.
+func (db *DB) AppendBatch(records [][]byte) (pos []int64, err error) {
+	return db.writer.AppendBatch(records)
+}
+
+// AppendNoCopy appends a record without retaining data, see Writer.AppendNoCopy.
+func (db *DB) AppendNoCopy(data []byte) (pos int64, err error) {
+	return db.writer.AppendNoCopy(data)
+}
+
+// AppendIndexed appends a record, also returning its index, see Writer.AppendIndexed.
+func (db *DB) AppendIndexed(data []byte) (pos int64, idx int64, err error) {
+	return db.writer.AppendIndexed(data)
+}
+
+// AppendAt appends a record stamped with ts, see WithRecordTimestamps.
+func (db *DB) AppendAt(ts time.Time, data []byte) (pos int64, err error) {
+	return db.writer.AppendAt(ts, data)
+}
+
+// AppendFrom appends a record of exactly size bytes read from r.
+func (db *DB) AppendFrom(r io.Reader, size int64) (pos int64, err error) {
+	return db.writer.AppendFrom(r, size)
+}
+
+// Close checkpoints the writer, so no appended records are lost, and ensures filelocks are cleared and
+// resources closed. Readers derived from this DB instance will remain functional. Appends racing with Close,
+// and calls returning an error made after it, fail with ErrClosed. Closing the DB again does nothing.
 func (db *DB) Close() (err error) {
+	// stop the auto flusher before taking the lock it needs
+	if db.stopAutoFlush != nil {
+		close(db.stopAutoFlush)
+		<-db.autoFlushDone
+		db.stopAutoFlush = nil
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+
+	defer func() {
+		if uerr := db.fileLock.Unlock(); uerr != nil && err == nil {
+			err = errors.Wrap(uerr, "unlock")
+		}
+	}()
 	defer db.meta.Close()
-	err = db.fileLock.Unlock()
-	if err != nil {
-		return
+
+	if db.mmaps != nil {
+		defer func() {
+			if merr := db.mmaps.Close(); merr != nil && err == nil {
+				err = errors.Wrap(merr, "unmap chunks")
+			}
+		}()
+	}
+
+	if db.writer == nil {
+		return nil
 	}
 	return db.writer.Close()
 }
@@ -124,19 +356,125 @@ func (db *DB) Checkpoint() (pos int64, err error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if db.closed {
+		return 0, ErrClosed
+	}
+
 	return db.writer.Checkpoint()
 }
 
-// SealTheBuffer explicitly flushes the old buffer and creates a new buffer
+// Flush writes the records held in memory to the buffer file, making them visible to readers of this DB.
 func (db *DB) Flush() (err error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if db.closed {
+		return ErrClosed
+	}
+
 	return db.writer.Flush()
 }
 
+// SealTheBuffer explicitly flushes the old buffer and creates a new buffer
+func (db *DB) SealTheBuffer() (err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	return db.writer.SealTheBuffer()
+}
+
+// SealTheBufferIfLargerThan seals the buffer only if it holds at least minBytes, see
+// Writer.SealTheBufferIfLargerThan.
+func (db *DB) SealTheBufferIfLargerThan(minBytes int64) (sealed bool, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return false, ErrClosed
+	}
+
+	return db.writer.SealTheBufferIfLargerThan(minBytes)
+}
+
+// ApplyRetention deletes all sealed chunks created more than maxAge ago, see Writer.ApplyRetention.
+func (db *DB) ApplyRetention(maxAge time.Duration) (chunks int, bytes int64, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return 0, 0, ErrClosed
+	}
+
+	return db.writer.ApplyRetention(maxAge)
+}
+
+// TrimToBytes deletes the oldest sealed chunks until at most maxTotal bytes remain, see Writer.TrimToBytes.
+func (db *DB) TrimToBytes(maxTotal int64) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return 0, ErrClosed
+	}
+
+	return db.writer.TrimToBytes(maxTotal)
+}
+
+// Compact merges runs of adjacent small chunks, see Writer.Compact. The read cache is cleared, since merged
+// chunks start at the same positions as the chunks they replace.
+func (db *DB) Compact(minChunks int, maxMergedBytes int64) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return 0, ErrClosed
+	}
+
+	compacted, err := db.writer.Compact(minChunks, maxMergedBytes)
+	if db.cache != nil && compacted > 0 {
+		db.cache.invalidate()
+	}
+	return compacted, err
+}
+
+// Truncate deletes all data of the cellar, see Writer.Truncate. The read cache and the chunk mappings are
+// cleared, since new chunks reuse the positions and file names of the deleted ones.
+func (db *DB) Truncate() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	err := db.writer.Truncate()
+	if db.cache != nil {
+		db.cache.invalidate()
+	}
+	return err
+}
+
+// Stats returns a snapshot of the state of the DB, see Writer.Stats.
+func (db *DB) Stats() Stats {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.writer.Stats()
+}
+
 // GetUserCheckpoint returns the position of a named checkpoint
 func (db *DB) GetUserCheckpoint(name string) (pos int64, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return 0, ErrClosed
+	}
+
 	return db.writer.GetUserCheckpoint(name)
 }
 
@@ -145,9 +483,49 @@ func (db *DB) PutUserCheckpoint(name string, pos int64) (err error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if db.closed {
+		return ErrClosed
+	}
+
 	return db.writer.PutUserCheckpoint(name, pos)
 }
 
+// ListUserCheckpoints returns the positions of all named checkpoints, see Writer.ListUserCheckpoints.
+func (db *DB) ListUserCheckpoints() (map[string]int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	return db.writer.ListUserCheckpoints()
+}
+
+// DeleteUserCheckpoint removes a named checkpoint, see Writer.DeleteUserCheckpoint.
+func (db *DB) DeleteUserCheckpoint(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	return db.writer.DeleteUserCheckpoint(name)
+}
+
+// CasUserCheckpoint moves a named checkpoint from expected to pos, see Writer.CasUserCheckpoint.
+func (db *DB) CasUserCheckpoint(name string, expected, pos int64) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return false, ErrClosed
+	}
+
+	return db.writer.CasUserCheckpoint(name, expected, pos)
+}
+
 // VolatilePos returns the current cursors location
 func (db *DB) VolatilePos() int64 {
 	db.mu.Lock()
@@ -156,9 +534,209 @@ func (db *DB) VolatilePos() int64 {
 	return db.writer.VolatilePos()
 }
 
-// Reader returns a new db reader. The reader remains active even if the DB is closed
+// SealedPos returns the end position of the last sealed chunk, see Writer.SealedPos.
+func (db *DB) SealedPos() int64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.writer.SealedPos()
+}
+
+// IsDurable reports whether the records before pos survive a crash, see Writer.IsDurable.
+func (db *DB) IsDurable(pos int64) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.writer.IsDurable(pos)
+}
+
+// BufferFillRatio returns how full the current buffer is, see Writer.BufferFillRatio.
+func (db *DB) BufferFillRatio() float64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.writer.BufferFillRatio()
+}
+
+// passphraseCipher derives the cipher for the passphrase of the DB, using the salt stored in the meta DB.
+// The salt is created the first time the DB is opened with a passphrase.
+func (db *DB) passphraseCipher() (Cipher, error) {
+	meta, err := db.meta.CellarMeta()
+	if err != nil {
+		return nil, errors.Wrap(err, "CellarMeta")
+	}
+
+	if len(meta.KeySalt) == 0 {
+		if db.readonly {
+			return nil, errors.Wrap(ErrReadOnly, "no passphrase salt")
+		}
+		meta.KeySalt = make([]byte, saltSize)
+		if _, err = io.ReadFull(rand.Reader, meta.KeySalt); err != nil {
+			return nil, errors.Wrap(err, "generate salt")
+		}
+		if err = db.meta.SetCellarMeta(meta); err != nil {
+			return nil, errors.Wrap(err, "SetCellarMeta")
+		}
+	}
+
+	key, err := DeriveKey(db.passphrase, meta.KeySalt)
+	if err != nil {
+		return nil, err
+	}
+	return NewAESGCMCipher(key)
+}
+
+// enableRecordChecksums records in the meta DB that records are written with checksums. This is only
+// possible before the first record is written, since existing records have no checksum.
+func (db *DB) enableRecordChecksums() error {
+	meta, err := db.meta.CellarMeta()
+	if err != nil {
+		return errors.Wrap(err, "CellarMeta")
+	}
+	if meta.RecordChecksums {
+		return nil
+	}
+
+	b, err := db.meta.GetBuffer()
+	if err != nil {
+		return errors.Wrap(err, "GetBuffer")
+	}
+	if b != nil && b.StartPos+b.Pos > 0 {
+		return ErrChecksumsEnabled
+	}
+
+	meta.RecordChecksums = true
+	if err = db.meta.SetCellarMeta(meta); err != nil {
+		return errors.Wrap(err, "SetCellarMeta")
+	}
+	return nil
+}
+
+// enableRecordTimestamps records in the meta DB that records are written with timestamps. Like checksums,
+// this is only possible before the first record is written.
+func (db *DB) enableRecordTimestamps() error {
+	meta, err := db.meta.CellarMeta()
+	if err != nil {
+		return errors.Wrap(err, "CellarMeta")
+	}
+	if meta.RecordTimestamps {
+		return nil
+	}
+
+	b, err := db.meta.GetBuffer()
+	if err != nil {
+		return errors.Wrap(err, "GetBuffer")
+	}
+	if b != nil && b.StartPos+b.Pos > 0 {
+		return ErrTimestampsEnabled
+	}
+
+	meta.RecordTimestamps = true
+	if err = db.meta.SetCellarMeta(meta); err != nil {
+		return errors.Wrap(err, "SetCellarMeta")
+	}
+	return nil
+}
+
+// repairBuffer rewinds the persisted buffer position if the buffer file was truncated, see
+// WithRepairTruncated.
+func (db *DB) repairBuffer() error {
+	b, err := db.meta.GetBuffer()
+	if err != nil || b == nil {
+		return errors.Wrap(err, "GetBuffer")
+	}
+
+	meta, err := db.meta.CellarMeta()
+	if err != nil {
+		return errors.Wrap(err, "CellarMeta")
+	}
+
+	pos := b.Pos
+	repaired, err := repairTruncatedBuffer(db.fs, b, db.folder, meta.RecordChecksums)
+	if err != nil || !repaired {
+		return err
+	}
+
+	db.logger.Printf("cellar: buffer %s was truncated, rewound from position %d to %d", b.FileName, b.StartPos+pos, b.StartPos+b.Pos)
+	return db.meta.PutBuffer(b)
+}
+
+// selectCodec looks up the compressor for the codec selected through WithCodec, unless a compressor was set
+// explicitly.
+func (db *DB) selectCodec() error {
+	if db.compressor != nil || !db.useCodec {
+		return nil
+	}
+
+	compressor, ok := db.registry.Compressor(db.codec)
+	if !ok {
+		return errors.Wrapf(ErrUnknownCodec, "codec %d", db.codec)
+	}
+	db.compressor = compressor
+	return nil
+}
+
+// selectDict sets the dictionary selected through WithCompressionDict on the compressor, which must be the
+// zstd compressor. Without an explicit compressor, the zstd compressor is used at its default level.
+func (db *DB) selectDict() error {
+	if db.dict == nil {
+		return nil
+	}
+
+	switch c := db.compressor.(type) {
+	case nil:
+		db.compressor = ZstdCompressor{Dict: db.dict}
+	case ZstdCompressor:
+		c.Dict = db.dict
+		db.compressor = c
+	case *ZstdCompressor:
+		db.compressor = ZstdCompressor{Level: c.Level, Dict: db.dict}
+	default:
+		return errors.Errorf("cellar: compression dictionaries require the zstd compressor, got codec %d", c.Codec())
+	}
+	return nil
+}
+
+// Reader returns a new db reader. The reader remains active even if the DB is closed. Since the reader shares
+// the writer of the DB, it sees all records in the current buffer up to the last Flush.
 func (db *DB) Reader() *Reader {
-	return NewReader(db.folder, db.cipher, db.decompressor, db.meta)
+	r := NewReader(db.folder, db.cipher, db.decompressor, db.meta)
+	if db.writer != nil {
+		r.buffer = db.writer.flushedBuffer
+		r.pendingKey = db.writer.pendingKey
+	}
+	r.registry = db.registry
+	r.ciphers = db.ciphers
+	r.dicts = db.dicts
+	r.cache = db.cache
+	r.mmaps = db.mmaps
+	if db.fs != nil {
+		r.fs = db.fs
+	}
+	r.ScanConcurrency = db.scanConcurrency
+	r.MaxInFlightChunks = db.maxInFlightChunks
+	r.VerifyOnRead = db.verifyOnRead
+	r.SkipMissingChunks = db.skipMissing
+	r.logger = db.logger
+	r.metrics = db.metrics
+	return r
+}
+
+// PinnedReader returns a new db reader pinned to the sealed chunks of the cellar as of now, which concurrent
+// appends and seals do not change, see Reader.Pin.
+func (db *DB) PinnedReader() (*Reader, error) {
+	db.mu.Lock()
+	closed := db.closed
+	db.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	r := db.Reader()
+	if err := r.Pin(); err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
 // Folder returns the DB folder
@@ -172,7 +750,7 @@ func (db *DB) Buffer() int64 {
 }
 
 func (db *DB) newWriter() error {
-	w, err := NewWriter(db.folder, db.buffer, db.cipher, db.compressor, db.meta)
+	w, err := newWriter(db.folder, db.meta, db)
 	if err != nil {
 		return err
 	}
@@ -2,8 +2,11 @@ package cellar
 
 import (
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -34,6 +37,23 @@ func TestDB_Close(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDB_Close_Twice(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(t, err)
+
+	require.NoError(t, db.Close())
+	assert.NoError(t, db.Close())
+
+	_, err = db.Append([]byte("late"))
+	assert.Equal(t, ErrClosed, errors.Cause(err))
+	_, err = db.ListUserCheckpoints()
+	assert.Equal(t, ErrClosed, err)
+	_, err = db.CasUserCheckpoint("consumer", 0, 1)
+	assert.Equal(t, ErrClosed, err)
+	_, err = db.PinnedReader()
+	assert.Equal(t, ErrClosed, err)
+}
+
 func TestDB_Append(t *testing.T) {
 	db, err := New(dbDir, WithNoFileLock, WithMetaDB(newBoltMetaDB()))
 	require.NoError(t, err)
@@ -97,6 +117,68 @@ func TestDB_GetUserCheckpoint(t *testing.T) {
 	assert.Equal(t, int64(1), pos)
 }
 
+func TestDB_ListUserCheckpoints(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	checkpoints, err := db.ListUserCheckpoints()
+	require.NoError(t, err)
+	assert.Empty(t, checkpoints)
+
+	require.NoError(t, db.PutUserCheckpoint("first", 1))
+	require.NoError(t, db.PutUserCheckpoint("second", 2))
+
+	checkpoints, err = db.ListUserCheckpoints()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"first": 1, "second": 2}, checkpoints)
+
+	require.NoError(t, db.DeleteUserCheckpoint("first"))
+	assert.Equal(t, ErrCheckpointNotExists, db.DeleteUserCheckpoint("first"))
+
+	_, err = db.GetUserCheckpoint("first")
+	assert.Equal(t, ErrCheckpointNotExists, err)
+
+	checkpoints, err = db.ListUserCheckpoints()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"second": 2}, checkpoints)
+}
+
+func TestDB_CasUserCheckpoint(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	require.NoError(t, db.PutUserCheckpoint("consumer", 0))
+
+	// consumers racing to advance the checkpoint by one each never lose an increment
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 25; n++ {
+				for {
+					pos, err := db.GetUserCheckpoint("consumer")
+					require.NoError(t, err)
+					swapped, err := db.CasUserCheckpoint("consumer", pos, pos+1)
+					require.NoError(t, err)
+					if swapped {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	pos, err := db.GetUserCheckpoint("consumer")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), pos)
+}
+
 func TestDB_SealTheBuffer(t *testing.T) {
 	db, err := New(dbDir, WithNoFileLock, WithMetaDB(newBoltMetaDB()))
 	require.NoError(t, err)
@@ -107,6 +189,22 @@ func TestDB_SealTheBuffer(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDB_Flush(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	pos, err := db.Append([]byte("flushed"))
+	require.NoError(t, err)
+	require.NoError(t, db.Flush())
+
+	// flushing neither seals the buffer nor moves it
+	assert.Equal(t, pos, db.VolatilePos())
+	assert.Equal(t, int64(0), db.SealedPos())
+	assert.False(t, db.IsDurable(pos))
+}
+
 func TestDB_Reader(t *testing.T) {
 	db, err := New(dbDir, WithNoFileLock, WithMetaDB(newBoltMetaDB()))
 	require.NoError(t, err)
@@ -116,3 +214,55 @@ func TestDB_Reader(t *testing.T) {
 	reader := db.Reader()
 	assert.NotNil(t, reader)
 }
+
+func TestDB_Close_Reopen(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder)
+	require.NoError(t, err)
+
+	inputs := []string{"first", "second", "third"}
+	for i, input := range inputs {
+		_, err = db.Append([]byte(input))
+		require.NoError(t, err)
+
+		if i == 0 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+
+	// closing without an explicit checkpoint must not lose the buffered records
+	require.NoError(t, db.Close())
+
+	db, err = New(folder)
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	var found []string
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		found = append(found, string(rec.Data))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, inputs, found)
+}
+
+func TestDB_AutoFlush(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()), WithAutoFlush(10*time.Millisecond))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	_, err = db.Append([]byte("TestDB_AutoFlush"))
+	require.NoError(t, err)
+
+	// the record becomes visible to readers without an explicit checkpoint
+	var count int64
+	for i := 0; i < 100 && count == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		count, err = db.Reader().Count()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,55 @@
+package cellar
+
+import (
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+var ErrUnknownDict = errors.New("cellar: no compression dictionary registered for id")
+
+// dictCompressor is implemented by compressors using a dictionary. The id of the dictionary is stored in
+// ChunkDto.DictID, and used to find the dictionary again when the chunk is read. An id of 0 means the chunk
+// was compressed without a dictionary.
+type dictCompressor interface {
+	Compressor
+	DictID() uint32
+}
+
+// TrainDictionary builds a zstd dictionary of at most size bytes from samples of typical records, for use
+// with WithCompressionDict. Dictionaries pay off for small, similar records, such as JSON objects sharing
+// their keys, which compress poorly on their own. The samples should be representative, and their total size
+// a multiple of the dictionary size.
+func TrainDictionary(samples [][]byte, size int) ([]byte, error) {
+	if len(samples) == 0 {
+		return nil, errors.New("cellar: no samples to train the dictionary on")
+	}
+	if size <= 0 {
+		return nil, errors.Errorf("cellar: dictionary size must be positive, got %d", size)
+	}
+
+	// the tables are built for the default level of ZstdCompressor, which is also orders of magnitude faster to
+	// train for than the best compression level
+	d, err := dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: size,
+		HashBytes:   6,
+		ZstdLevel:   zstd.SpeedDefault,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "BuildZstdDict")
+	}
+	return d, nil
+}
+
+// DictionaryID returns the id stored in a zstd dictionary, which identifies it in the chunks compressed with
+// it.
+func DictionaryID(d []byte) (uint32, error) {
+	info, err := zstd.InspectDictionary(d)
+	if err != nil {
+		return 0, errors.Wrap(err, "InspectDictionary")
+	}
+	if info.ID() == 0 {
+		return 0, errors.New("cellar: dictionary has no id")
+	}
+	return info.ID(), nil
+}
@@ -0,0 +1,139 @@
+package cellar
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func genJSONRecord(i int) []byte {
+	return []byte(fmt.Sprintf(`{"id":%d,"user":"user-%d","event":"page_view","path":"/products/%d","status":200}`, i, i%97, i%13))
+}
+
+func TestTrainDictionary(t *testing.T) {
+	_, err := TrainDictionary(nil, 1024)
+	assert.Error(t, err)
+
+	var samples [][]byte
+	for i := 0; i < 2000; i++ {
+		samples = append(samples, genJSONRecord(i))
+	}
+	_, err = TrainDictionary(samples, 0)
+	assert.Error(t, err)
+
+	dict, err := TrainDictionary(samples, 4096)
+	require.NoError(t, err)
+	assert.True(t, len(dict) <= 4096+1024, "dictionary of %d bytes", len(dict))
+
+	id, err := DictionaryID(dict)
+	require.NoError(t, err)
+	assert.NotZero(t, id)
+
+	_, err = DictionaryID([]byte("not a dictionary"))
+	assert.Error(t, err)
+}
+
+func TestDB_CompressionDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 2000; i++ {
+		samples = append(samples, genJSONRecord(i))
+	}
+	dict, err := TrainDictionary(samples, 4096)
+	require.NoError(t, err)
+	id, err := DictionaryID(dict)
+	require.NoError(t, err)
+
+	_, err = New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCompressionDict([]byte("junk")))
+	assert.Error(t, err)
+	_, err = New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCompressionDict(dict),
+		WithCompressor(Lz4Compressor{}))
+	assert.Error(t, err)
+
+	// records are sealed one by one, as small records end up in small chunks
+	write := func(options ...Option) (string, MetaDB, int64) {
+		folder := getFolder()
+		meta := NewInMemoryMetaDB()
+		options = append(options, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(2000))
+		db, err := New(folder, options...)
+		require.NoError(t, err)
+		defer checkedClose(db)
+
+		for i := 0; i < 100; i++ {
+			_, err = db.Append(genJSONRecord(i + 5000))
+			require.NoError(t, err)
+			require.NoError(t, db.SealTheBuffer())
+		}
+
+		var size int64
+		chunks, err := meta.ListChunks()
+		require.NoError(t, err)
+		for _, c := range chunks {
+			size += c.CompressedDiskSize
+		}
+		return folder, meta, size
+	}
+
+	_, _, plain := write(WithCodec(CodecZstd))
+	folder, meta, size := write(WithCompressionDict(dict))
+	assert.True(t, size < plain*3/4, "%d bytes with the dictionary, %d without", size, plain)
+
+	read := func(options ...Option) error {
+		options = append(options, WithNoFileLock, WithMetaDB(meta))
+		db, err := New(folder, options...)
+		require.NoError(t, err)
+		defer checkedClose(db)
+
+		infos, err := db.Reader().Chunks()
+		require.NoError(t, err)
+		require.Len(t, infos, 100)
+		assert.Equal(t, CodecZstd, infos[0].Codec)
+		assert.Equal(t, id, infos[0].DictID)
+
+		var i int
+		return db.Reader().ForEach(func(rec *Rec) error {
+			assert.Equal(t, genJSONRecord(i+5000), rec.Data)
+			i++
+			return nil
+		})
+	}
+
+	err = read()
+	assert.Equal(t, ErrUnknownDict, errors.Cause(err))
+	assert.NoError(t, read(WithCompressionDict(dict)))
+	assert.NoError(t, read(WithDecompressionDicts(dict)))
+}
+
+func TestDB_Compact_CompressionDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 2000; i++ {
+		samples = append(samples, genJSONRecord(i))
+	}
+	dict, err := TrainDictionary(samples, 4096)
+	require.NoError(t, err)
+
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCompressionDict(dict))
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	for i := 0; i < 4; i++ {
+		_, err = db.Append(genJSONRecord(i))
+		require.NoError(t, err)
+		require.NoError(t, db.SealTheBuffer())
+	}
+
+	// the chunks compressed with the dictionary are read back to be merged
+	compacted, err := db.Compact(2, 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, 3, compacted)
+
+	var i int
+	require.NoError(t, db.Reader().ForEach(func(rec *Rec) error {
+		assert.Equal(t, genJSONRecord(i), rec.Data)
+		i++
+		return nil
+	}))
+	assert.Equal(t, 4, i)
+}
@@ -6,12 +6,15 @@
 Package cellar is a generated protocol buffer package.
 
 It is generated from these files:
+
 	dto.proto
 
 It has these top-level messages:
+
 	ChunkDto
 	BufferDto
 	MetaDto
+	BlockDto
 */
 package cellar
 
@@ -31,11 +34,26 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type ChunkDto struct {
-	UncompressedByteSize int64  `protobuf:"varint,1,opt,name=uncompressedByteSize" json:"uncompressedByteSize,omitempty"`
-	CompressedDiskSize   int64  `protobuf:"varint,2,opt,name=compressedDiskSize" json:"compressedDiskSize,omitempty"`
-	Records              int64  `protobuf:"varint,3,opt,name=records" json:"records,omitempty"`
-	FileName             string `protobuf:"bytes,4,opt,name=fileName" json:"fileName,omitempty"`
-	StartPos             int64  `protobuf:"varint,5,opt,name=startPos" json:"startPos,omitempty"`
+	UncompressedByteSize int64       `protobuf:"varint,1,opt,name=uncompressedByteSize" json:"uncompressedByteSize,omitempty"`
+	CompressedDiskSize   int64       `protobuf:"varint,2,opt,name=compressedDiskSize" json:"compressedDiskSize,omitempty"`
+	Records              int64       `protobuf:"varint,3,opt,name=records" json:"records,omitempty"`
+	FileName             string      `protobuf:"bytes,4,opt,name=fileName" json:"fileName,omitempty"`
+	StartPos             int64       `protobuf:"varint,5,opt,name=startPos" json:"startPos,omitempty"`
+	Codec                uint32      `protobuf:"varint,6,opt,name=codec" json:"codec,omitempty"`
+	Cipher               uint32      `protobuf:"varint,7,opt,name=cipher" json:"cipher,omitempty"`
+	Nonce                []byte      `protobuf:"bytes,8,opt,name=nonce" json:"nonce,omitempty"`
+	KeyID                string      `protobuf:"bytes,9,opt,name=keyID" json:"keyID,omitempty"`
+	CreatedAtUnix        int64       `protobuf:"varint,10,opt,name=createdAtUnix" json:"createdAtUnix,omitempty"`
+	Checksum             uint32      `protobuf:"varint,11,opt,name=checksum" json:"checksum,omitempty"`
+	MinTimestamp         int64       `protobuf:"varint,12,opt,name=minTimestamp" json:"minTimestamp,omitempty"`
+	MaxTimestamp         int64       `protobuf:"varint,13,opt,name=maxTimestamp" json:"maxTimestamp,omitempty"`
+	StartIndex           int64       `protobuf:"varint,14,opt,name=startIndex" json:"startIndex,omitempty"`
+	DictID               uint32      `protobuf:"varint,15,opt,name=dictID" json:"dictID,omitempty"`
+	Bloom                []byte      `protobuf:"bytes,16,opt,name=bloom" json:"bloom,omitempty"`
+	BloomHashes          uint32      `protobuf:"varint,17,opt,name=bloomHashes" json:"bloomHashes,omitempty"`
+	HeaderSize           uint32      `protobuf:"varint,18,opt,name=headerSize" json:"headerSize,omitempty"`
+	DataChecksum         uint32      `protobuf:"varint,19,opt,name=dataChecksum" json:"dataChecksum,omitempty"`
+	Blocks               []*BlockDto `protobuf:"bytes,20,rep,name=blocks" json:"blocks,omitempty"`
 }
 
 func (m *ChunkDto) Reset()                    { *m = ChunkDto{} }
@@ -44,11 +62,14 @@ func (*ChunkDto) ProtoMessage()               {}
 func (*ChunkDto) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
 
 type BufferDto struct {
-	StartPos int64  `protobuf:"varint,1,opt,name=startPos" json:"startPos,omitempty"`
-	MaxBytes int64  `protobuf:"varint,2,opt,name=maxBytes" json:"maxBytes,omitempty"`
-	Records  int64  `protobuf:"varint,3,opt,name=records" json:"records,omitempty"`
-	Pos      int64  `protobuf:"varint,4,opt,name=pos" json:"pos,omitempty"`
-	FileName string `protobuf:"bytes,5,opt,name=fileName" json:"fileName,omitempty"`
+	StartPos     int64  `protobuf:"varint,1,opt,name=startPos" json:"startPos,omitempty"`
+	MaxBytes     int64  `protobuf:"varint,2,opt,name=maxBytes" json:"maxBytes,omitempty"`
+	Records      int64  `protobuf:"varint,3,opt,name=records" json:"records,omitempty"`
+	Pos          int64  `protobuf:"varint,4,opt,name=pos" json:"pos,omitempty"`
+	FileName     string `protobuf:"bytes,5,opt,name=fileName" json:"fileName,omitempty"`
+	MinTimestamp int64  `protobuf:"varint,6,opt,name=minTimestamp" json:"minTimestamp,omitempty"`
+	MaxTimestamp int64  `protobuf:"varint,7,opt,name=maxTimestamp" json:"maxTimestamp,omitempty"`
+	StartIndex   int64  `protobuf:"varint,8,opt,name=startIndex" json:"startIndex,omitempty"`
 }
 
 func (m *BufferDto) Reset()                    { *m = BufferDto{} }
@@ -57,8 +78,16 @@ func (*BufferDto) ProtoMessage()               {}
 func (*BufferDto) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
 
 type MetaDto struct {
-	MaxKeySize int64 `protobuf:"varint,1,opt,name=maxKeySize" json:"maxKeySize,omitempty"`
-	MaxValSize int64 `protobuf:"varint,2,opt,name=maxValSize" json:"maxValSize,omitempty"`
+	MaxKeySize          int64  `protobuf:"varint,1,opt,name=maxKeySize" json:"maxKeySize,omitempty"`
+	MaxValSize          int64  `protobuf:"varint,2,opt,name=maxValSize" json:"maxValSize,omitempty"`
+	KeySalt             []byte `protobuf:"bytes,3,opt,name=keySalt" json:"keySalt,omitempty"`
+	RecordChecksums     bool   `protobuf:"varint,4,opt,name=recordChecksums" json:"recordChecksums,omitempty"`
+	RecordTimestamps    bool   `protobuf:"varint,5,opt,name=recordTimestamps" json:"recordTimestamps,omitempty"`
+	UnorderedTimestamps bool   `protobuf:"varint,6,opt,name=unorderedTimestamps" json:"unorderedTimestamps,omitempty"`
+	DirShardLevels      int32  `protobuf:"varint,7,opt,name=dirShardLevels" json:"dirShardLevels,omitempty"`
+	RecordIndex         bool   `protobuf:"varint,8,opt,name=recordIndex" json:"recordIndex,omitempty"`
+	ChunkHeaderVersion  uint32 `protobuf:"varint,9,opt,name=chunkHeaderVersion" json:"chunkHeaderVersion,omitempty"`
+	FormatVersion       uint32 `protobuf:"varint,10,opt,name=formatVersion" json:"formatVersion,omitempty"`
 }
 
 func (m *MetaDto) Reset()                    { *m = MetaDto{} }
@@ -66,30 +95,64 @@ func (m *MetaDto) String() string            { return proto.CompactTextString(m)
 func (*MetaDto) ProtoMessage()               {}
 func (*MetaDto) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
 
+type BlockDto struct {
+	Pos     int64 `protobuf:"varint,1,opt,name=pos" json:"pos,omitempty"`
+	Size    int64 `protobuf:"varint,2,opt,name=size" json:"size,omitempty"`
+	Records int64 `protobuf:"varint,3,opt,name=records" json:"records,omitempty"`
+}
+
+func (m *BlockDto) Reset()                    { *m = BlockDto{} }
+func (m *BlockDto) String() string            { return proto.CompactTextString(m) }
+func (*BlockDto) ProtoMessage()               {}
+func (*BlockDto) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
 func init() {
 	proto.RegisterType((*ChunkDto)(nil), "cellar.ChunkDto")
 	proto.RegisterType((*BufferDto)(nil), "cellar.BufferDto")
 	proto.RegisterType((*MetaDto)(nil), "cellar.MetaDto")
+	proto.RegisterType((*BlockDto)(nil), "cellar.BlockDto")
 }
 
 func init() { proto.RegisterFile("dto.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 246 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x7c, 0x91, 0xcd, 0x4a, 0xc3, 0x40,
-	0x10, 0xc7, 0x59, 0x63, 0x3f, 0x32, 0x27, 0x59, 0x3c, 0x2c, 0x1e, 0xa4, 0xe4, 0xd4, 0x53, 0x0f,
-	0xfa, 0x06, 0xb5, 0x17, 0x11, 0x45, 0x22, 0x78, 0x5f, 0x93, 0x09, 0x86, 0xee, 0x76, 0xc2, 0xce,
-	0x06, 0x5a, 0x5f, 0xc1, 0x97, 0xf2, 0xd1, 0x64, 0x97, 0x1a, 0x37, 0x50, 0x7a, 0xcb, 0xff, 0x0b,
-	0x7e, 0x99, 0x85, 0xbc, 0xf6, 0xb4, 0xea, 0x1c, 0x79, 0x92, 0xd3, 0x0a, 0x8d, 0xd1, 0xae, 0xf8,
-	0x11, 0x30, 0x7f, 0xf8, 0xec, 0x77, 0xdb, 0x8d, 0x27, 0x79, 0x07, 0xd7, 0xfd, 0xae, 0x22, 0xdb,
-	0x39, 0x64, 0xc6, 0x7a, 0x7d, 0xf0, 0xf8, 0xd6, 0x7e, 0xa1, 0x12, 0x0b, 0xb1, 0xcc, 0xca, 0x93,
-	0x99, 0x5c, 0x81, 0xfc, 0x77, 0x37, 0x2d, 0x6f, 0xe3, 0xe2, 0x22, 0x2e, 0x4e, 0x24, 0x52, 0xc1,
-	0xcc, 0x61, 0x45, 0xae, 0x66, 0x95, 0xc5, 0xd2, 0x9f, 0x94, 0x37, 0x30, 0x6f, 0x5a, 0x83, 0x2f,
-	0xda, 0xa2, 0xba, 0x5c, 0x88, 0x65, 0x5e, 0x0e, 0x3a, 0x64, 0xec, 0xb5, 0xf3, 0xaf, 0xc4, 0x6a,
-	0x12, 0x67, 0x83, 0x2e, 0xbe, 0x05, 0xe4, 0xeb, 0xbe, 0x69, 0xd0, 0x85, 0x7f, 0x48, 0x9b, 0x62,
-	0xdc, 0x0c, 0x99, 0xd5, 0xfb, 0x80, 0xce, 0x47, 0xc2, 0x41, 0x9f, 0xe1, 0xba, 0x82, 0xac, 0x23,
-	0x8e, 0x48, 0x59, 0x19, 0x3e, 0x47, 0xa4, 0x93, 0x31, 0x69, 0xf1, 0x08, 0xb3, 0x67, 0xf4, 0x3a,
-	0xa0, 0xdc, 0x02, 0x58, 0xbd, 0x7f, 0xc2, 0x43, 0x72, 0xc4, 0xc4, 0x39, 0xe6, 0xef, 0xda, 0x24,
-	0x27, 0x4b, 0x9c, 0x8f, 0x69, 0x7c, 0xaa, 0xfb, 0xdf, 0x00, 0x00, 0x00, 0xff, 0xff, 0x8a, 0x58,
-	0x12, 0xdc, 0xb7, 0x01, 0x00, 0x00,
+	// 608 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x54, 0x4f, 0x6f, 0xda, 0x4e,
+	0x10, 0x95, 0x7f, 0x0e, 0xc6, 0x4c, 0x20, 0xe1, 0xb7, 0x89, 0xaa, 0x55, 0x0e, 0x11, 0x42, 0x55,
+	0x65, 0xf5, 0x80, 0xaa, 0xf4, 0x13, 0x34, 0xe1, 0x90, 0xf4, 0x9f, 0x2a, 0xa7, 0xcd, 0x7d, 0xf1,
+	0x0e, 0xc2, 0xc2, 0xf6, 0xa2, 0xdd, 0xa5, 0x82, 0x1e, 0x7b, 0xed, 0xc7, 0xed, 0x17, 0xa8, 0x76,
+	0x16, 0x83, 0xa1, 0xa8, 0xed, 0x8d, 0xf7, 0xe6, 0x2d, 0xeb, 0x37, 0xf3, 0x66, 0xa1, 0x23, 0xad,
+	0x1a, 0x2d, 0xb4, 0xb2, 0x8a, 0x45, 0x19, 0x16, 0x85, 0xd0, 0xc3, 0xef, 0x2d, 0x88, 0xef, 0x66,
+	0xcb, 0x6a, 0x3e, 0xb6, 0x8a, 0xdd, 0xc0, 0xe5, 0xb2, 0xca, 0x54, 0xb9, 0xd0, 0x68, 0x0c, 0xca,
+	0xdb, 0xb5, 0xc5, 0xc7, 0xfc, 0x1b, 0xf2, 0x60, 0x10, 0x24, 0x61, 0x7a, 0xb4, 0xc6, 0x46, 0xc0,
+	0x76, 0xec, 0x38, 0x37, 0x73, 0x3a, 0xf1, 0x1f, 0x9d, 0x38, 0x52, 0x61, 0x1c, 0xda, 0x1a, 0x33,
+	0xa5, 0xa5, 0xe1, 0x21, 0x89, 0x6a, 0xc8, 0xae, 0x20, 0x9e, 0xe6, 0x05, 0x7e, 0x14, 0x25, 0xf2,
+	0x93, 0x41, 0x90, 0x74, 0xd2, 0x2d, 0x76, 0x35, 0x63, 0x85, 0xb6, 0x9f, 0x94, 0xe1, 0x2d, 0x3a,
+	0xb6, 0xc5, 0xec, 0x12, 0x5a, 0x99, 0x92, 0x98, 0xf1, 0x68, 0x10, 0x24, 0xbd, 0xd4, 0x03, 0xf6,
+	0x0c, 0xa2, 0x2c, 0x5f, 0xcc, 0x50, 0xf3, 0x36, 0xd1, 0x1b, 0xe4, 0xd4, 0x95, 0xaa, 0x32, 0xe4,
+	0xf1, 0x20, 0x48, 0xba, 0xa9, 0x07, 0x8e, 0x9d, 0xe3, 0xfa, 0x61, 0xcc, 0x3b, 0x74, 0xb1, 0x07,
+	0xec, 0x39, 0xf4, 0x32, 0x8d, 0xc2, 0xa2, 0x7c, 0x63, 0xbf, 0x54, 0xf9, 0x8a, 0x03, 0x5d, 0xbd,
+	0x4f, 0xba, 0x6f, 0xcb, 0x66, 0x98, 0xcd, 0xcd, 0xb2, 0xe4, 0xa7, 0x74, 0xd7, 0x16, 0xb3, 0x21,
+	0x74, 0xcb, 0xbc, 0xfa, 0x9c, 0x97, 0x68, 0xac, 0x28, 0x17, 0xbc, 0x4b, 0x7f, 0xb0, 0xc7, 0x91,
+	0x46, 0xac, 0x76, 0x9a, 0xde, 0x46, 0xd3, 0xe0, 0xd8, 0x35, 0x00, 0xf9, 0x7d, 0xa8, 0x24, 0xae,
+	0xf8, 0x19, 0x29, 0x1a, 0x8c, 0x73, 0x2b, 0xf3, 0xcc, 0x3e, 0x8c, 0xf9, 0xb9, 0x77, 0xeb, 0x91,
+	0xf3, 0x35, 0x29, 0x94, 0x2a, 0x79, 0xdf, 0xbb, 0x25, 0xc0, 0x06, 0x70, 0x4a, 0x3f, 0xee, 0x85,
+	0x99, 0xa1, 0xe1, 0xff, 0xd3, 0x91, 0x26, 0xe5, 0xee, 0x9b, 0xa1, 0x90, 0xa8, 0x69, 0x9a, 0x8c,
+	0x04, 0x0d, 0xc6, 0x7d, 0xb3, 0x14, 0x56, 0xdc, 0xd5, 0xbe, 0x2f, 0x48, 0xb1, 0xc7, 0xb1, 0x04,
+	0xa2, 0x49, 0xa1, 0xb2, 0xb9, 0xe1, 0x97, 0x83, 0x30, 0x39, 0xbd, 0xe9, 0x8f, 0x7c, 0xe6, 0x46,
+	0xb7, 0x8e, 0x1d, 0x5b, 0x95, 0x6e, 0xea, 0xc3, 0x9f, 0x01, 0x74, 0x6e, 0x97, 0xd3, 0x29, 0x6a,
+	0x97, 0xc2, 0xe6, 0xac, 0x83, 0x83, 0x59, 0x5f, 0x41, 0x5c, 0x8a, 0x95, 0x0b, 0x9f, 0xd9, 0x64,
+	0x6c, 0x8b, 0xff, 0x90, 0xac, 0x3e, 0x84, 0x0b, 0x65, 0x28, 0x54, 0x61, 0x1a, 0x2e, 0xfc, 0xff,
+	0x6c, 0xb3, 0xd6, 0x3a, 0xc8, 0xda, 0xe1, 0xcc, 0xa2, 0x7f, 0x98, 0x59, 0xfb, 0xaf, 0x33, 0x8b,
+	0x0f, 0x67, 0x36, 0xfc, 0x11, 0x42, 0xfb, 0x03, 0x5a, 0xe1, 0x3c, 0x5f, 0x03, 0x94, 0x62, 0xf5,
+	0x0e, 0xd7, 0x8d, 0x7d, 0x6b, 0x30, 0x9b, 0xfa, 0x93, 0x28, 0x1a, 0xdb, 0xd5, 0x60, 0x9c, 0xf7,
+	0x39, 0xae, 0x1f, 0x45, 0x61, 0xc9, 0x7b, 0x37, 0xad, 0x21, 0x4b, 0xe0, 0xdc, 0xb7, 0xa1, 0x9e,
+	0x8b, 0xef, 0x43, 0x9c, 0x1e, 0xd2, 0xec, 0x25, 0xf4, 0x3d, 0xb5, 0xb5, 0xe0, 0x77, 0x2d, 0x4e,
+	0x7f, 0xe3, 0xd9, 0x2b, 0xb8, 0x58, 0x56, 0x4a, 0x4b, 0xd4, 0xd8, 0x94, 0x47, 0x24, 0x3f, 0x56,
+	0x62, 0x2f, 0xe0, 0x4c, 0xe6, 0xfa, 0x71, 0x26, 0xb4, 0x7c, 0x8f, 0x5f, 0xb1, 0x30, 0xd4, 0xb3,
+	0x56, 0x7a, 0xc0, 0xba, 0x6c, 0xfa, 0xdb, 0x76, 0x6d, 0x8b, 0xd3, 0x26, 0x45, 0x2f, 0x8e, 0x7b,
+	0xb1, 0xee, 0x29, 0x8e, 0x4f, 0xa8, 0x4d, 0xae, 0x2a, 0x5a, 0xdc, 0x5e, 0x7a, 0xa4, 0xe2, 0xb6,
+	0x78, 0xaa, 0x74, 0x29, 0x6c, 0x2d, 0x05, 0x92, 0xee, 0x93, 0xc3, 0xb7, 0x10, 0xd7, 0xb9, 0xac,
+	0xf3, 0x12, 0xec, 0xf2, 0xc2, 0xe0, 0xc4, 0xec, 0x3a, 0x7f, 0x62, 0x36, 0x3d, 0x3f, 0x9e, 0xb7,
+	0x49, 0x44, 0x6f, 0xec, 0xeb, 0x5f, 0x03, 0x00, 0x01, 0xce, 0x60, 0xd7, 0x70, 0x05, 0x00, 0x00,
 }
@@ -0,0 +1,32 @@
+package cellar
+
+// Durability selects how hard the writer works to get records onto stable storage, see WithDurability. It
+// trades throughput against the records a crash of the machine may take with it; a crash of the process
+// alone never loses checkpointed records, since they are written to the buffer file by then.
+type Durability int
+
+const (
+	// DurabilityFlush writes the buffer to its file on every flush and checkpoint, but leaves it to the
+	// operating system when the file reaches the disk. Sealed chunks are synced before they are recorded in
+	// the meta DB. A power loss may lose records checkpointed since the last seal. This is the default.
+	DurabilityFlush Durability = iota
+	// DurabilityNone never syncs, not even sealed chunks, leaving all writeback to the operating system. A
+	// power loss may lose any records, and may leave chunks recorded in the meta DB which never reached the
+	// disk. Meant for data which can be rebuilt, where only throughput matters.
+	DurabilityNone
+	// DurabilityFsync syncs the buffer file on every flush and checkpoint as well, so every checkpointed
+	// record survives a power loss, at the cost of an fsync per checkpoint.
+	DurabilityFsync
+)
+
+func (d Durability) String() string {
+	switch d {
+	case DurabilityFlush:
+		return "flush"
+	case DurabilityNone:
+		return "none"
+	case DurabilityFsync:
+		return "fsync"
+	}
+	return "unknown"
+}
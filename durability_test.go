@@ -0,0 +1,46 @@
+package cellar
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_Durability(t *testing.T) {
+	_, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithDurability(Durability(42)))
+	assert.Error(t, err)
+
+	for _, level := range []Durability{DurabilityFlush, DurabilityNone, DurabilityFsync} {
+		t.Run(level.String(), func(t *testing.T) {
+			folder := getFolder()
+			meta := NewInMemoryMetaDB()
+
+			db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000), WithDurability(level))
+			require.NoError(t, err)
+
+			for i := 0; i < 5; i++ {
+				_, err = db.Append(genSeedBytes(400, i))
+				require.NoError(t, err)
+			}
+			_, err = db.Checkpoint()
+			require.NoError(t, err)
+			// buffers started by seals keep the level
+			assert.Equal(t, level, db.writer.b.durability)
+			require.NoError(t, db.Close())
+
+			db, err = New(folder, WithNoFileLock, WithMetaDB(meta))
+			require.NoError(t, err)
+			defer db.Close()
+
+			var i int
+			err = db.Reader().ForEach(func(rec *Rec) error {
+				assert.Equal(t, genSeedBytes(400, i), rec.Data)
+				i++
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, 5, i)
+		})
+	}
+}
@@ -1,20 +1,59 @@
 package cellar
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
 	"io"
+	"io/ioutil"
 	"log"
 )
 
 var defaultEncryptionKey = []byte("estencryptionkey")
 
-// Cipher defines the interface needed to support encryption of the DB
+// Cipher ids, stored in ChunkDto.Cipher so readers know how a chunk was encrypted. Chunks written before
+// cipher ids were recorded have cipher 0, and are encrypted with AES in CFB mode.
+const (
+	CipherAES      uint32 = 0
+	CipherAESGCM   uint32 = 1
+	CipherChaCha20 uint32 = 2
+	CipherNone     uint32 = 3
+)
+
+var (
+	ErrDecrypt       = errors.New("cellar: chunk failed to decrypt")
+	ErrUnknownCipher = errors.New("cellar: no cipher configured for chunk")
+	ErrSelfTest      = errors.New("cellar: cipher failed its self test")
+)
+
+// argon2id parameters used by DeriveKey, following the recommendations of RFC 9106 for memory constrained
+// environments. Changing them changes the keys derived from existing passphrases.
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+
+	// saltSize is the size of the salt generated for passphrase derived keys
+	saltSize = 16
+)
+
+// Cipher defines the interface needed to support encryption of the DB. Everything written to the writer
+// returned by Encrypt must be passed through to w by the time it is closed.
+//
+// Ciphers reporting a NonceSize above 0 are given a unique nonce of that size for every chunk, which is
+// stored in ChunkDto.Nonce and passed back to Decrypt. Other ciphers are given a nil nonce.
 type Cipher interface {
-	Decrypt(src io.Reader) (io.Reader, error)
-	Encrypt(w io.Writer) (*cipher.StreamWriter, error)
+	Decrypt(src io.Reader, nonce []byte) (io.Reader, error)
+	Encrypt(w io.Writer, nonce []byte) (io.WriteCloser, error)
+	// Algorithm returns the id recorded in the chunks encrypted by the cipher.
+	Algorithm() uint32
+	// NonceSize returns the size of the nonce needed per chunk, or 0 if the cipher does not take one.
+	NonceSize() int
 }
 
 // WithAES returns the Cipher implementation based on AES
@@ -38,7 +77,8 @@ type AES struct {
 	block cipher.Block
 }
 
-func (a AES) Decrypt(src io.Reader) (io.Reader, error) {
+// Decrypt ignores the nonce, AES keeps its IV in front of the ciphertext.
+func (a AES) Decrypt(src io.Reader, nonce []byte) (io.Reader, error) {
 	iv := make([]byte, aes.BlockSize)
 
 	if _, err := src.Read(iv); err != nil {
@@ -50,7 +90,7 @@ func (a AES) Decrypt(src io.Reader) (io.Reader, error) {
 	return reader, nil
 }
 
-func (a AES) Encrypt(w io.Writer) (*cipher.StreamWriter, error) {
+func (a AES) Encrypt(w io.Writer, nonce []byte) (io.WriteCloser, error) {
 
 	iv := make([]byte, aes.BlockSize)
 
@@ -66,3 +106,247 @@ func (a AES) Encrypt(w io.Writer) (*cipher.StreamWriter, error) {
 	writer := &cipher.StreamWriter{S: stream, W: w}
 	return writer, nil
 }
+
+func (a AES) Algorithm() uint32 {
+	return CipherAES
+}
+
+func (a AES) NonceSize() int {
+	return 0
+}
+
+// selfTestVector is encrypted and decrypted again by SelfTest.
+var selfTestVector = []byte("cellar self test vector, spanning more than a single AES block")
+
+// SelfTest encrypts and decrypts a known vector with the cipher, returning an error if it does not survive
+// the roundtrip. NewWriter runs it, so a misconfigured cipher fails at startup instead of corrupting chunks.
+func SelfTest(c Cipher) error {
+	nonce, err := newNonce(c)
+	if err != nil {
+		return err
+	}
+
+	var sealed bytes.Buffer
+	w, err := c.Encrypt(&sealed, nonce)
+	if err != nil {
+		return errors.Wrap(err, "Encrypt")
+	}
+	if _, err = w.Write(selfTestVector); err != nil {
+		return errors.Wrap(err, "Write")
+	}
+	if err = w.Close(); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+
+	r, err := c.Decrypt(&sealed, nonce)
+	if err != nil {
+		return errors.Wrap(err, "Decrypt")
+	}
+	plain, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "ReadAll")
+	}
+
+	if !bytes.Equal(plain, selfTestVector) {
+		return ErrSelfTest
+	}
+	return nil
+}
+
+var _ Cipher = &NoCipher{}
+
+// NoCipher stores chunks unencrypted. It is used by NewWriter and NewReader when no cipher is given.
+type NoCipher struct{}
+
+func (n NoCipher) Decrypt(src io.Reader, nonce []byte) (io.Reader, error) {
+	return src, nil
+}
+
+func (n NoCipher) Encrypt(w io.Writer, nonce []byte) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (n NoCipher) Algorithm() uint32 {
+	return CipherNone
+}
+
+func (n NoCipher) NonceSize() int {
+	return 0
+}
+
+var _ Cipher = &AESGCM{}
+
+// AESGCM encrypts chunks with AES-256-GCM, using the nonce stored with every chunk. Unlike AES, tampering
+// with a chunk is detected when it is decrypted.
+//
+// Since GCM authenticates the chunk as a whole, chunks are held in memory while they are encrypted or
+// decrypted.
+type AESGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCMCipher returns an AES-256-GCM cipher, which requires a 32 byte key.
+func NewAESGCMCipher(key []byte) (*AESGCM, error) {
+	if len(key) != 32 {
+		return nil, errors.Errorf("cellar: AES-256-GCM requires a 32 byte key, got %d bytes", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "aes.NewCipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "cipher.NewGCM")
+	}
+	return &AESGCM{aead: aead}, nil
+}
+
+func (a *AESGCM) Decrypt(src io.Reader, nonce []byte) (io.Reader, error) {
+	return decryptAEAD(a.aead, src, nonce)
+}
+
+func (a *AESGCM) Encrypt(w io.Writer, nonce []byte) (io.WriteCloser, error) {
+	return &aeadWriter{aead: a.aead, w: w, nonce: nonce}, nil
+}
+
+func (a *AESGCM) Algorithm() uint32 {
+	return CipherAESGCM
+}
+
+func (a *AESGCM) NonceSize() int {
+	return a.aead.NonceSize()
+}
+
+var _ Cipher = &ChaCha20{}
+
+// ChaCha20 encrypts chunks with ChaCha20-Poly1305, which outperforms AESGCM on platforms without AES
+// hardware acceleration. Chunks are sealed the same way as by AESGCM.
+type ChaCha20 struct {
+	aead cipher.AEAD
+}
+
+// NewChaCha20Cipher returns a ChaCha20-Poly1305 cipher, which requires a 32 byte key.
+func NewChaCha20Cipher(key []byte) (*ChaCha20, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "chacha20poly1305.New")
+	}
+	return &ChaCha20{aead: aead}, nil
+}
+
+func (c *ChaCha20) Decrypt(src io.Reader, nonce []byte) (io.Reader, error) {
+	return decryptAEAD(c.aead, src, nonce)
+}
+
+func (c *ChaCha20) Encrypt(w io.Writer, nonce []byte) (io.WriteCloser, error) {
+	return &aeadWriter{aead: c.aead, w: w, nonce: nonce}, nil
+}
+
+func (c *ChaCha20) Algorithm() uint32 {
+	return CipherChaCha20
+}
+
+func (c *ChaCha20) NonceSize() int {
+	return c.aead.NonceSize()
+}
+
+// newNonce returns a random nonce for the cipher, or nil if it does not take one.
+func newNonce(c Cipher) ([]byte, error) {
+	if c.NonceSize() == 0 {
+		return nil, nil
+	}
+
+	nonce := make([]byte, c.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "generate nonce")
+	}
+	return nonce, nil
+}
+
+// growingWriter is implemented by encryptors which hold the whole chunk in memory, and allocate it at once
+// when told its size of n bytes up front.
+type growingWriter interface {
+	io.WriteCloser
+	grow(n int)
+}
+
+// aeadWriter collects the plaintext of a chunk, and seals it on Close, in place if it was grown to fit. Without
+// a nonce, a random one is generated and written in front of the ciphertext. Closing it again is a no-op.
+type aeadWriter struct {
+	aead   cipher.AEAD
+	w      io.Writer
+	nonce  []byte
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (a *aeadWriter) Write(p []byte) (int, error) {
+	return a.buf.Write(p)
+}
+
+func (a *aeadWriter) grow(n int) {
+	a.buf.Grow(n + a.aead.Overhead())
+}
+
+func (a *aeadWriter) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+
+	var sealed []byte
+	if len(a.nonce) == 0 {
+		nonce := make([]byte, a.aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return errors.Wrap(err, "generate nonce")
+		}
+		sealed = a.aead.Seal(nonce, nonce, a.buf.Bytes(), nil)
+	} else {
+		if len(a.nonce) != a.aead.NonceSize() {
+			return errors.Errorf("nonce of %d bytes, expected %d", len(a.nonce), a.aead.NonceSize())
+		}
+		plain := a.buf.Bytes()
+		sealed = a.aead.Seal(plain[:0], a.nonce, plain, nil)
+	}
+
+	if _, err := a.w.Write(sealed); err != nil {
+		return errors.Wrap(err, "Write")
+	}
+	return nil
+}
+
+// decryptAEAD decrypts and authenticates all of src. Without a nonce, src starts with the nonce, as written
+// by chunks sealed before nonces were stored in ChunkDto.
+func decryptAEAD(aead cipher.AEAD, src io.Reader, nonce []byte) (io.Reader, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadAll")
+	}
+
+	if len(nonce) == 0 {
+		if len(data) < aead.NonceSize() {
+			return nil, errors.Wrap(ErrDecrypt, "missing nonce")
+		}
+		nonce, data = data[:aead.NonceSize()], data[aead.NonceSize():]
+	}
+
+	plain, err := aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, errors.Wrap(ErrDecrypt, err.Error())
+	}
+	return bytes.NewReader(plain), nil
+}
+
+// DeriveKey derives a 32 byte key for NewAESGCMCipher from a passphrase using argon2id. The same passphrase
+// and salt always produce the same key.
+func DeriveKey(passphrase string, salt []byte) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("cellar: empty passphrase")
+	}
+	if len(salt) == 0 {
+		return nil, errors.New("cellar: empty salt")
+	}
+	return argon2.IDKey([]byte(passphrase), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen), nil
+}
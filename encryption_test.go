@@ -0,0 +1,253 @@
+package cellar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var gcmKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestNewAESGCMCipher_KeySize(t *testing.T) {
+	_, err := NewAESGCMCipher(key)
+	assert.Error(t, err)
+
+	_, err = NewAESGCMCipher(gcmKey)
+	assert.NoError(t, err)
+}
+
+func TestAESGCM_Roundtrip(t *testing.T) {
+	c, err := NewAESGCMCipher(gcmKey)
+	require.NoError(t, err)
+
+	folder := getFolder()
+	db, err := New(folder, WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCipher(c))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	var values [][]byte
+	for i := 0; i < 10; i++ {
+		values = append(values, genRandBytes(100))
+	}
+	_, err = db.AppendBatch(values)
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	var seen [][]byte
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		seen = append(seen, rec.Data)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, values, seen)
+}
+
+func TestAESGCM_DetectsTampering(t *testing.T) {
+	c, err := NewAESGCMCipher(gcmKey)
+	require.NoError(t, err)
+
+	folder := getFolder()
+	db, err := New(folder, WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCipher(c))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	pos, err := db.Append([]byte("TestAESGCM_DetectsTampering"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	chunks, err := db.meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+
+	for _, dto := range chunks {
+		loc := path.Join(folder, dto.FileName)
+		data, err := ioutil.ReadFile(loc)
+		require.NoError(t, err)
+		data[len(data)-1] ^= 0xff
+		require.NoError(t, ioutil.WriteFile(loc, data, os.ModePerm))
+	}
+
+	_, err = db.Reader().ReadAt(pos)
+	require.Error(t, err)
+	assert.Equal(t, ErrDecrypt, errors.Cause(err))
+}
+
+func TestChaCha20_Interop(t *testing.T) {
+	gcm, err := NewAESGCMCipher(gcmKey)
+	require.NoError(t, err)
+	chacha, err := NewChaCha20Cipher(gcmKey)
+	require.NoError(t, err)
+
+	folder := getFolder()
+	db, err := New(folder, WithCipher(gcm))
+	require.NoError(t, err)
+
+	pos, err := db.Append([]byte("sealed with AES-GCM"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+	require.NoError(t, db.Close())
+
+	db, err = New(folder, WithCipher(chacha), WithDecryptionCiphers(gcm))
+	require.NoError(t, err)
+
+	_, err = db.Append([]byte("sealed with ChaCha20-Poly1305"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	chunks, err := db.Reader().sortedChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, CipherAESGCM, chunks[0].Cipher)
+	assert.Equal(t, CipherChaCha20, chunks[1].Cipher)
+
+	var found []string
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		found = append(found, string(rec.Data))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sealed with AES-GCM", "sealed with ChaCha20-Poly1305"}, found)
+	require.NoError(t, db.Close())
+
+	// without the previous cipher, its chunks can not be read
+	db, err = New(folder, WithCipher(chacha))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	_, err = db.Reader().ReadAt(pos)
+	assert.Equal(t, ErrUnknownCipher, errors.Cause(err))
+}
+
+func TestAESGCM_NoncePerChunk(t *testing.T) {
+	c, err := NewAESGCMCipher(gcmKey)
+	require.NoError(t, err)
+
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCipher(c))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	for i := 0; i < 3; i++ {
+		_, err = db.Append([]byte("TestAESGCM_NoncePerChunk"))
+		require.NoError(t, err)
+		require.NoError(t, db.SealTheBuffer())
+	}
+
+	chunks, err := db.meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+
+	nonces := make(map[string]bool)
+	for _, dto := range chunks {
+		assert.Len(t, dto.Nonce, c.NonceSize())
+		nonces[string(dto.Nonce)] = true
+	}
+	assert.Len(t, nonces, 3)
+
+	count, err := db.Reader().Count()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestAESGCM_InlineNonce(t *testing.T) {
+	c, err := NewAESGCMCipher(gcmKey)
+	require.NoError(t, err)
+
+	// chunks sealed without a stored nonce carry it in front of the ciphertext
+	var sealed bytes.Buffer
+	w, err := c.Encrypt(&sealed, nil)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("TestAESGCM_InlineNonce"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r, err := c.Decrypt(&sealed, nil)
+	require.NoError(t, err)
+	plain, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "TestAESGCM_InlineNonce", string(plain))
+}
+
+func TestDeriveKey(t *testing.T) {
+	key, err := DeriveKey("correct horse battery staple", []byte("salt"))
+	require.NoError(t, err)
+	assert.Len(t, key, 32)
+
+	again, err := DeriveKey("correct horse battery staple", []byte("salt"))
+	require.NoError(t, err)
+	assert.Equal(t, key, again)
+
+	other, err := DeriveKey("correct horse battery staple", []byte("pepper"))
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+
+	_, err = DeriveKey("", []byte("salt"))
+	assert.Error(t, err)
+	_, err = DeriveKey("correct horse battery staple", nil)
+	assert.Error(t, err)
+}
+
+func TestWithPassphrase_Reopen(t *testing.T) {
+	folder := getFolder()
+	db, err := New(folder, WithPassphrase("correct horse battery staple"))
+	require.NoError(t, err)
+
+	pos, err := db.Append([]byte("TestWithPassphrase_Reopen"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+	// checkpoints must keep the salt
+	require.NoError(t, db.Close())
+
+	db, err = New(folder, WithPassphrase("correct horse battery staple"))
+	require.NoError(t, err)
+
+	rec, err := db.Reader().ReadAt(pos)
+	require.NoError(t, err)
+	assert.Equal(t, "TestWithPassphrase_Reopen", string(rec.Data))
+	require.NoError(t, db.Close())
+
+	db, err = New(folder, WithPassphrase("wrong passphrase"))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	_, err = db.Reader().ReadAt(pos)
+	assert.Equal(t, ErrDecrypt, errors.Cause(err))
+}
+
+// brokenCipher encrypts, but fails to decrypt what it wrote.
+type brokenCipher struct {
+	NoCipher
+}
+
+func (b brokenCipher) Decrypt(src io.Reader, nonce []byte) (io.Reader, error) {
+	return bytes.NewReader([]byte("garbage")), nil
+}
+
+func TestSelfTest(t *testing.T) {
+	gcm, err := NewAESGCMCipher(gcmKey)
+	require.NoError(t, err)
+
+	for _, c := range []Cipher{NoCipher{}, NewAES(key), gcm} {
+		assert.NoError(t, SelfTest(c))
+	}
+	assert.Equal(t, ErrSelfTest, SelfTest(brokenCipher{}))
+}
+
+func TestNewWriter_SelfTestFails(t *testing.T) {
+	_, err := OpenWriter(getFolder(), NewInMemoryMetaDB(), WithMaxBufferSize(1000), WithCipher(brokenCipher{}))
+	assert.Equal(t, ErrSelfTest, errors.Cause(err))
+
+	_, err = New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCipher(brokenCipher{}))
+	assert.Equal(t, ErrSelfTest, errors.Cause(err))
+}
@@ -0,0 +1,54 @@
+package cellar_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/carapace/cellar"
+)
+
+type event struct {
+	Kind   string `json:"kind"`
+	Amount int    `json:"amount"`
+}
+
+func ExampleTypedCellar() {
+	folder, err := ioutil.TempDir("", "cellar")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	meta := cellar.NewInMemoryMetaDB()
+
+	w, err := cellar.OpenWriter(folder, meta, cellar.WithMaxBufferSize(1000))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer w.Close()
+
+	events := cellar.NewJSONCellar[event](w, cellar.NewReader(folder, nil, nil, meta))
+
+	for _, e := range []event{{"deposit", 100}, {"withdrawal", 30}} {
+		if _, err = events.Append(e); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, err = w.Checkpoint(); err != nil {
+		log.Fatal(err)
+	}
+
+	err = events.Scan(func(pos int64, e event) error {
+		fmt.Printf("%d: %s of %d\n", pos, e.Kind, e.Amount)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// 32: deposit of 100
+	// 66: withdrawal of 30
+}
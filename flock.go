@@ -1,7 +1,111 @@
 package cellar
 
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var ErrLocked = errors.New("cellar: locked by another process")
+
+// lockRetryDelay is the interval at which a held lock is tried again, see WithOpenTimeout.
+const lockRetryDelay = 10 * time.Millisecond
+
+// defaultMetaTimeout is the time to wait for the lock of the bolt meta DB, unless set through WithOpenTimeout.
+const defaultMetaTimeout = 1 * time.Second
+
 type FileLock interface {
 	TryLock() (bool, error)
 	Lock() error
 	Unlock() error
 }
+
+// LockedError is returned when opening a cellar which another process holds the lock of. It wraps ErrLocked,
+// so errors.Is(err, ErrLocked) holds, while errors.As retrieves the holder.
+type LockedError struct {
+	// Path is the locked file, which is the lock file of the cellar or its bolt meta DB.
+	Path string
+	// PID is the process holding the lock, or 0 if it is unknown.
+	PID int
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("%s: %s", ErrLocked, e.Path)
+	}
+	return fmt.Sprintf("%s: %s is held by pid %d", ErrLocked, e.Path, e.PID)
+}
+
+// Cause returns ErrLocked, so errors.Cause identifies LockedError.
+func (e *LockedError) Cause() error {
+	return ErrLocked
+}
+
+// Unwrap returns ErrLocked, so errors.Is identifies LockedError.
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// tryLock takes lock, trying again until it is taken or timeout expires. A timeout of 0 tries once.
+func tryLock(lock FileLock, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := lock.TryLock()
+		if err != nil || locked {
+			return locked, err
+		}
+		if !time.Now().Before(deadline) {
+			return false, nil
+		}
+		time.Sleep(lockRetryDelay)
+	}
+}
+
+// lockFolder takes the lock file of the cellar in folder, and records the pid of this process in it so
+// processes failing to take the lock can report its holder.
+func lockFolder(folder string, timeout time.Duration) (FileLock, error) {
+	loc := path.Join(folder, lockfile)
+	file := flock.New(loc)
+
+	locked, err := tryLock(file, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, &LockedError{Path: loc, PID: lockHolder(loc)}
+	}
+
+	// the pid is informative only, so failing to record it is not an error
+	ioutil.WriteFile(loc, []byte(strconv.Itoa(os.Getpid())), 0644)
+	return file, nil
+}
+
+// lockHolder returns the pid recorded in the lock file at loc, or 0 if there is none.
+func lockHolder(loc string) int {
+	data, err := ioutil.ReadFile(loc)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// openBolt opens the bolt meta DB at loc, waiting up to timeout for another process to release it.
+func openBolt(loc string, timeout time.Duration, readOnly bool) (*bolt.DB, error) {
+	blt, err := bolt.Open(loc, 0600, &bolt.Options{Timeout: timeout, ReadOnly: readOnly})
+	if err == bolt.ErrTimeout {
+		return nil, &LockedError{Path: loc}
+	}
+	return blt, err
+}
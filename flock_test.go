@@ -0,0 +1,53 @@
+package cellar
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_Locked(t *testing.T) {
+	_, err := New(getFolder(), WithOpenTimeout(0))
+	assert.Error(t, err)
+
+	folder := getFolder()
+	db, err := New(folder, WithCompressor(Lz4Compressor{}))
+	require.NoError(t, err)
+
+	// the lock file reports its holder
+	_, err = New(folder)
+	assert.Equal(t, ErrLocked, errors.Cause(err))
+	assert.ErrorIs(t, err, ErrLocked)
+	var held *LockedError
+	require.True(t, errors.As(err, &held))
+	assert.Equal(t, os.Getpid(), held.PID)
+	locked, ok := err.(*LockedError)
+	require.True(t, ok)
+	assert.Equal(t, path.Join(folder, lockfile), locked.Path)
+	assert.Equal(t, os.Getpid(), locked.PID)
+	assert.Contains(t, err.Error(), "held by pid")
+
+	// as does the bolt meta DB, without the holder
+	start := time.Now()
+	_, err = New(folder, WithNoFileLock, WithOpenTimeout(50*time.Millisecond))
+	assert.Equal(t, ErrLocked, errors.Cause(err))
+	assert.True(t, time.Since(start) < time.Second)
+	locked, ok = err.(*LockedError)
+	require.True(t, ok)
+	assert.Equal(t, path.Join(folder, "meta.bolt"), locked.Path)
+	assert.Zero(t, locked.PID)
+
+	// opening waits for the lock to be released
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		checkedClose(db)
+	}()
+	db, err = New(folder, WithCompressor(Lz4Compressor{}), WithOpenTimeout(5*time.Second))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
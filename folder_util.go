@@ -4,10 +4,14 @@ import (
 	fmt "fmt"
 	"io/ioutil"
 	"os"
+	"sync"
 	"sync/atomic"
 )
 
-var folders = make(chan string, 100)
+var (
+	foldersMu = &sync.Mutex{}
+	folders   []string
+)
 var folderID int32
 
 // NewTempFolder creates a new unique empty folder.
@@ -21,14 +25,20 @@ func NewTempFolder(name string) string {
 	if folder, err = ioutil.TempDir("", fmt.Sprintf("test_%s_%d_", name, curr)); err != nil {
 		panic(err)
 	}
-	folders <- folder
+
+	foldersMu.Lock()
+	defer foldersMu.Unlock()
+	folders = append(folders, folder)
 	return folder
 }
 
 // RemoveTempFolders cleans up all test folders
 func RemoveTempFolders() {
-	close(folders)
-	for f := range folders {
+	foldersMu.Lock()
+	defer foldersMu.Unlock()
+
+	for _, f := range folders {
 		os.RemoveAll(f)
 	}
+	folders = nil
 }
@@ -0,0 +1,133 @@
+package cellar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrFormatVersion = errors.New("cellar: format version is newer than supported")
+
+// FormatVersion is the latest version of the layout of cellars on disk, which is recorded in
+// MetaDto.FormatVersion when a cellar is created. Cellars keep the version they were created with, and are
+// written in that layout, until they are upgraded with UpgradeFormat. The versions are:
+//
+//   - 0: cellars created before format versions were recorded;
+//   - 1: chunk files start with a header describing the chunk, see RebuildMeta.
+const FormatVersion = 1
+
+// formatChunkHeaders is the first format version whose chunk files have headers.
+const formatChunkHeaders = 1
+
+// checkFormat returns ErrFormatVersion if the cellar described by meta was written by a later version of
+// cellar, in a layout this version does not know.
+func checkFormat(meta *MetaDto) error {
+	if meta != nil && meta.FormatVersion > FormatVersion {
+		return errors.Wrapf(ErrFormatVersion, "version %d, supported up to %d", meta.FormatVersion, FormatVersion)
+	}
+	return nil
+}
+
+// UpgradeFormat migrates the cellar in dir to the latest format version, see FormatVersion. It is opened with
+// the options, which must include those needed to read it, such as its meta DB and cipher. Chunks written in
+// an older layout are rewritten with the current cipher and compressor, and replaced one by one like
+// compacted chunks, so readers see either the old chunk or the new one. Cellars at the latest version are
+// left untouched.
+//
+// The cellar must not be opened by another writer during the upgrade. An interrupted upgrade can be run
+// again, and continues with the chunks left in the old layout.
+func UpgradeFormat(dir string, options ...Option) (err error) {
+	db, err := New(dir, options...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := db.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	if db.writer == nil {
+		return ErrReadOnly
+	}
+	return db.writer.upgradeFormat()
+}
+
+// upgradeFormat rewrites the chunks without header, and records the latest format version once they are done.
+func (w *Writer) upgradeFormat() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+	if w.formatVersion >= FormatVersion {
+		return nil
+	}
+	if err := w.commitPending(); err != nil {
+		return err
+	}
+
+	chunks, err := w.db.ListChunksRange(0, math.MaxInt64, 0)
+	if err != nil {
+		return errors.Wrap(err, "ListChunksRange")
+	}
+
+	// chunks sealed from now on are written in the latest layout
+	w.formatVersion = FormatVersion
+
+	reader := w.chunkReader()
+
+	for _, c := range chunks {
+		if c.HeaderSize > 0 {
+			continue
+		}
+		if err = w.rewriteChunk(reader, c); err != nil {
+			return err
+		}
+	}
+
+	if err = w.db.SetCellarMeta(w.cellarMeta()); err != nil {
+		return errors.Wrap(err, "SetCellarMeta")
+	}
+	return nil
+}
+
+// rewriteChunk replaces the chunk c by a copy written in the current format, next to it.
+func (w *Writer) rewriteChunk(reader *Reader, c *ChunkDto) error {
+	data, err := reader.loadChunk(c)
+	if err != nil {
+		return errors.Wrapf(err, "load chunk %s", c.FileName)
+	}
+
+	name := fmt.Sprintf("%s-v%d.lz4", strings.TrimSuffix(c.FileName, ".lz4"), w.formatVersion)
+	info := &ChunkDto{
+		FileName:             name,
+		StartPos:             c.StartPos,
+		StartIndex:           c.StartIndex,
+		Records:              c.Records,
+		UncompressedByteSize: c.UncompressedByteSize,
+		CreatedAtUnix:        c.CreatedAtUnix,
+		MinTimestamp:         c.MinTimestamp,
+		MaxTimestamp:         c.MaxTimestamp,
+		Bloom:                c.Bloom,
+		BloomHashes:          c.BloomHashes,
+	}
+	dto, err := sealChunk(context.Background(), w.fs, path.Join(w.folder, name), bytes.NewReader(data), info, w.cellarMeta(), w.cipher, w.compressor, w.durability, w.tempDir, w.blockSize, w.sealConcurrency, w.trace)
+	if err != nil {
+		return errors.Wrapf(err, "seal chunk %s", name)
+	}
+
+	if err = w.db.ReplaceChunks([]int64{c.StartPos}, dto); err != nil {
+		return errors.Wrap(err, "ReplaceChunks")
+	}
+	w.countChunk(c, -1)
+	w.countChunk(dto, 1)
+
+	return w.removeChunkFile(c.FileName)
+}
@@ -0,0 +1,121 @@
+package cellar
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setFormatVersion changes the format version recorded for a cellar, as if it was written by another version.
+func setFormatVersion(t *testing.T, meta MetaDB, version uint32) {
+	cellarMeta, err := meta.CellarMeta()
+	require.NoError(t, err)
+	cellarMeta.FormatVersion = version
+	cellarMeta.ChunkHeaderVersion = 0
+	require.NoError(t, meta.SetCellarMeta(cellarMeta))
+}
+
+func TestUpgradeFormat(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+	options := []Option{WithNoFileLock, WithMetaDB(meta), WithCompressor(Lz4Compressor{}), WithRecordChecksums()}
+
+	db, err := New(folder, options...)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	cellarMeta, err := meta.CellarMeta()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(FormatVersion), cellarMeta.FormatVersion)
+
+	// a cellar from before format versions keeps being written without chunk headers
+	setFormatVersion(t, meta, 0)
+	db, err = New(folder, options...)
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		_, err = db.Append([]byte(fmt.Sprintf("record %d", i)))
+		require.NoError(t, err)
+		if i%10 == 9 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+	require.NoError(t, db.Close())
+
+	old, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, old, 3)
+	for _, c := range old {
+		assert.Zero(t, c.HeaderSize)
+	}
+	cellarMeta, err = meta.CellarMeta()
+	require.NoError(t, err)
+	assert.Zero(t, cellarMeta.FormatVersion)
+
+	require.NoError(t, UpgradeFormat(folder, options...))
+	require.NoError(t, UpgradeFormat(folder, options...))
+
+	cellarMeta, err = meta.CellarMeta()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(FormatVersion), cellarMeta.FormatVersion)
+	assert.Equal(t, uint32(chunkHeaderVersion), cellarMeta.ChunkHeaderVersion)
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	for _, c := range chunks {
+		assert.NotZero(t, c.HeaderSize)
+		assert.True(t, strings.HasSuffix(c.FileName, "-v1.lz4"), c.FileName)
+	}
+	for _, c := range old {
+		_, err = os.Stat(path.Join(folder, c.FileName))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	db, err = New(folder, options...)
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	report, err := db.Reader().Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Bad)
+	assert.Equal(t, int64(30), report.Records)
+
+	var i int
+	require.NoError(t, db.Reader().ForEach(func(rec *Rec) error {
+		assert.Equal(t, fmt.Sprintf("record %d", i), string(rec.Data))
+		i++
+		return nil
+	}))
+	assert.Equal(t, 30, i)
+}
+
+func TestFormatVersion_Newer(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta))
+	require.NoError(t, err)
+	_, err = db.Append([]byte("record"))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	setFormatVersion(t, meta, FormatVersion+1)
+
+	_, err = New(folder, WithNoFileLock, WithMetaDB(meta))
+	assert.Equal(t, ErrFormatVersion, errors.Cause(err))
+
+	_, err = OpenReadOnly(folder, WithMetaDB(meta))
+	assert.Equal(t, ErrFormatVersion, errors.Cause(err))
+
+	err = NewReader(folder, nil, nil, meta).ForEach(func(rec *Rec) error { return nil })
+	assert.Equal(t, ErrFormatVersion, errors.Cause(err))
+
+	assert.Equal(t, ErrFormatVersion, errors.Cause(UpgradeFormat(folder, WithNoFileLock, WithMetaDB(meta))))
+}
@@ -0,0 +1,86 @@
+package cellar
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// File is a file opened through a FileSystem. *os.File implements it.
+type File interface {
+	io.Reader
+	io.Writer
+	io.ReaderAt
+	io.WriterAt
+	io.Seeker
+	io.Closer
+
+	// Name returns the name the file was opened with.
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// FileSystem stores the buffer and chunk files of a cellar, see WithFileSystem. Names are slash separated
+// paths starting with the folder of the cellar. The methods behave like their counterparts in package os,
+// and errors for missing files must satisfy os.IsNotExist.
+//
+// The meta DB, the lock files and the temp files of WithTempDir are not part of it.
+type FileSystem interface {
+	// Open opens the file for reading.
+	Open(name string) (File, error)
+	// Create creates the file, or truncates an existing one, for reading and writing.
+	Create(name string) (File, error)
+	// OpenFile opens the file with the os.O_* flags in flag, creating it with perm if os.O_CREATE is set.
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Remove(name string) error
+	Stat(name string) (os.FileInfo, error)
+	Rename(oldname, newname string) error
+	// MkdirAll creates the directory and its missing parents. File systems without directories may do
+	// nothing.
+	MkdirAll(path string, perm os.FileMode) error
+}
+
+// osFS is the FileSystem of the operating system, and the default.
+type osFS struct{}
+
+var _ FileSystem = osFS{} // compile time assertion to verify we match the interface FileSystem
+
+func (osFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Create(name string) (File, error) {
+	return os.Create(name)
+}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// readFile reads the whole file name from fs, like ioutil.ReadFile.
+func readFile(fs FileSystem, name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
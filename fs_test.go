@@ -0,0 +1,297 @@
+package cellar
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memFS is a FileSystem keeping files in memory.
+type memFS struct {
+	mu    *sync.Mutex
+	files map[string]*memData
+	dirs  map[string]bool
+}
+
+type memData struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func newMemFS() *memFS {
+	return &memFS{
+		mu:    &sync.Mutex{},
+		files: make(map[string]*memData),
+		dirs:  map[string]bool{".": true, "/": true},
+	}
+}
+
+func (m *memFS) Open(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (m *memFS) Create(name string) (File, error) {
+	return m.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+}
+
+func (m *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = path.Clean(name)
+	d, ok := m.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		if !m.dirs[path.Dir(name)] {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		d = &memData{}
+		m.files[name] = d
+	}
+	if flag&os.O_TRUNC != 0 {
+		d.mu.Lock()
+		d.data = nil
+		d.mu.Unlock()
+	}
+	return &memFile{name: name, d: d}, nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = path.Clean(name)
+	if _, ok := m.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memFS) Stat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = path.Clean(name)
+	if m.dirs[name] {
+		return memInfo{name: path.Base(name), dir: true}, nil
+	}
+	d, ok := m.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return memInfo{name: path.Base(name), size: int64(len(d.data))}, nil
+}
+
+func (m *memFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.files[path.Clean(oldname)]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	delete(m.files, path.Clean(oldname))
+	m.files[path.Clean(newname)] = d
+	return nil
+}
+
+func (m *memFS) MkdirAll(dir string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for dir = path.Clean(dir); !m.dirs[dir]; dir = path.Dir(dir) {
+		m.dirs[dir] = true
+	}
+	return nil
+}
+
+// names returns the names of all files, sorted.
+func (m *memFS) names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var names []string
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type memFile struct {
+	name string
+	d    *memData
+	off  int64
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.off)
+	f.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+
+	if off >= int64(len(f.d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.d.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	n, err := f.WriteAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+
+	if end := off + int64(len(p)); end > int64(len(f.d.data)) {
+		f.d.data = append(f.d.data, make([]byte, end-int64(len(f.d.data)))...)
+	}
+	return copy(f.d.data[off:], p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		f.d.mu.Lock()
+		offset += int64(len(f.d.data))
+		f.d.mu.Unlock()
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+
+	if size <= int64(len(f.d.data)) {
+		f.d.data = f.d.data[:size]
+	} else {
+		f.d.data = append(f.d.data, make([]byte, size-int64(len(f.d.data)))...)
+	}
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.d.mu.Lock()
+	defer f.d.mu.Unlock()
+	return memInfo{name: path.Base(f.name), size: int64(len(f.d.data))}, nil
+}
+
+func (f *memFile) Name() string { return f.name }
+func (f *memFile) Sync() error  { return nil }
+func (f *memFile) Close() error { return nil }
+
+type memInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) ModTime() time.Time { return time.Time{} }
+func (i memInfo) IsDir() bool        { return i.dir }
+func (i memInfo) Sys() interface{}   { return nil }
+
+func (i memInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0700
+	}
+	return 0644
+}
+
+func TestDB_FileSystem(t *testing.T) {
+	fs := newMemFS()
+	folder := path.Join(getFolder(), "in-memory")
+	meta := NewInMemoryMetaDB()
+	options := []Option{WithFileSystem(fs), WithNoFileLock, WithMetaDB(meta), WithCompressor(Lz4Compressor{}),
+		WithDirSharding(1), WithRecordChecksums(), WithMmapReads()}
+
+	_, err := New(folder, WithFileSystem(nil))
+	assert.Error(t, err)
+
+	db, err := New(folder, options...)
+	require.NoError(t, err)
+
+	for i := 0; i < 30; i++ {
+		_, err = db.Append([]byte(fmt.Sprintf("record %d", i)))
+		require.NoError(t, err)
+		if i%10 == 9 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+	_, err = db.Append([]byte("buffered"))
+	require.NoError(t, err)
+	_, err = db.Compact(2, 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// nothing but the folder exists on disk
+	_, err = os.Stat(folder)
+	assert.True(t, os.IsNotExist(err))
+
+	var chunks int
+	for _, name := range fs.names() {
+		assert.True(t, strings.HasPrefix(name, folder), name)
+		if strings.HasSuffix(name, ".lz4") {
+			chunks++
+		}
+	}
+	// the three sealed chunks were merged into one
+	assert.Equal(t, 1, chunks)
+
+	db, err = New(folder, options...)
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	report, err := db.Reader().Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Bad)
+
+	var records []string
+	require.NoError(t, db.Reader().ForEach(func(rec *Rec) error {
+		records = append(records, string(rec.Data))
+		return nil
+	}))
+	require.Len(t, records, 31)
+	assert.Equal(t, "record 0", records[0])
+	assert.Equal(t, "buffered", records[30])
+
+	_, err = db.TrimToBytes(0)
+	require.NoError(t, err)
+	for _, name := range fs.names() {
+		assert.False(t, strings.HasSuffix(name, ".lz4"), name)
+	}
+}
@@ -0,0 +1,182 @@
+package cellar
+
+import (
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCommitTimeout is returned by appends which sealed the buffer, when the group commit of the seal did not
+// complete in time, see WithGroupCommit. The record is appended, and the seal is committed with a later group.
+var ErrCommitTimeout = errors.New("cellar: timed out waiting for the group commit of a seal")
+
+// ErrCommitFailed is returned by appends which sealed the buffer, when the group commit of the seal failed,
+// see WithGroupCommit. As with ErrCommitTimeout, the record is appended, and the seal is committed with a
+// later group, so appending the record again would duplicate it.
+var ErrCommitFailed = errors.New("cellar: the group commit of a seal failed, it is retried with a later group")
+
+// groupCommitTimeouts is the number of commit windows appends wait for the group commit of their seal.
+const groupCommitTimeouts = 10
+
+// pendingSeal is a chunk which is sealed, but not yet recorded in the meta DB.
+type pendingSeal struct {
+	chunk *ChunkDto
+	// buffer is the state of the sealed buffer, whose file is kept until the chunk is committed
+	buffer *BufferDto
+}
+
+// commitGroup is the set of seals committed in a single meta DB transaction.
+type commitGroup struct {
+	// done is closed once the group is committed, or failed with err
+	done    chan struct{}
+	err     error
+	timeout time.Duration
+}
+
+// await waits for the group to be committed. A nil group, of a writer without group commits, is committed
+// already.
+func (g *commitGroup) await() error {
+	if g == nil {
+		return nil
+	}
+
+	timer := time.NewTimer(g.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-g.done:
+		return g.err
+	case <-timer.C:
+		return ErrCommitTimeout
+	}
+}
+
+// groupCommitter collects the seals of the writer, which a background goroutine commits to the meta DB once
+// per window.
+type groupCommitter struct {
+	window time.Duration
+
+	pending []pendingSeal
+	// buffer is the state of the buffer started by the last pending seal
+	buffer *BufferDto
+	group  *commitGroup
+
+	// wake is signalled by the first seal of a group
+	wake chan struct{}
+	stop chan struct{}
+}
+
+func newGroupCommitter(window time.Duration) *groupCommitter {
+	gc := &groupCommitter{
+		window: window,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+	gc.nextGroup()
+	return gc
+}
+
+func (gc *groupCommitter) nextGroup() {
+	gc.group = &commitGroup{
+		done:    make(chan struct{}),
+		timeout: groupCommitTimeouts * gc.window,
+	}
+}
+
+// add queues a seal for the next commit, made along with the state of the buffer started by the seal.
+func (gc *groupCommitter) add(seal pendingSeal, buffer *BufferDto) {
+	gc.pending = append(gc.pending, seal)
+	gc.buffer = buffer
+	gc.signal()
+}
+
+// signal wakes the background goroutine to commit the pending seals with the next window.
+func (gc *groupCommitter) signal() {
+	select {
+	case gc.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sealGroup returns the group the last seal is committed with, or nil if the writer does not group commits.
+func (w *Writer) sealGroup() *commitGroup {
+	if w.committer == nil {
+		return nil
+	}
+	return w.committer.group
+}
+
+// runGroupCommits commits the pending seals once per window, until the writer is closed.
+func (w *Writer) runGroupCommits(gc *groupCommitter) {
+	for {
+		select {
+		case <-gc.wake:
+		case <-gc.stop:
+			return
+		}
+
+		// let the seals of the window join the group
+		timer := time.NewTimer(gc.window)
+		select {
+		case <-timer.C:
+		case <-gc.stop:
+			timer.Stop()
+			return
+		}
+
+		w.mu.Lock()
+		if !w.closed {
+			if err := w.commitPending(); err != nil {
+				w.logger.Printf("cellar: group commit failed: %s", err)
+			}
+		}
+		w.mu.Unlock()
+	}
+}
+
+// commitPending commits the pending seals, along with the state of the buffer following them.
+func (w *Writer) commitPending() error {
+	if w.committer == nil || len(w.committer.pending) == 0 {
+		return nil
+	}
+	return w.commitSeals(w.committer.buffer)
+}
+
+// commitSeals stores buffer in the meta DB, in the same transaction as the pending seals, if any. Once they
+// are committed, the files of the sealed buffers are removed and the appends waiting for the seals return.
+// If the commit fails, its waiters get ErrCommitFailed, and the seals are committed with the next group, which
+// is scheduled right away.
+func (w *Writer) commitSeals(buffer *BufferDto) error {
+	gc := w.committer
+	if gc == nil || len(gc.pending) == 0 {
+		return w.db.PutBuffer(buffer)
+	}
+
+	chunks := make([]*ChunkDto, len(gc.pending))
+	for i, seal := range gc.pending {
+		chunks[i] = seal.chunk
+	}
+
+	group := gc.group
+	gc.nextGroup()
+
+	err := w.db.AddChunks(chunks, buffer)
+	if err == nil {
+		for _, seal := range gc.pending {
+			w.indexChunk(seal.chunk)
+
+			oldBufferPath := path.Join(w.folder, seal.buffer.FileName)
+			if rerr := w.fs.Remove(oldBufferPath); rerr != nil {
+				w.logger.Printf("Can't remove old buffer %s: %s", oldBufferPath, rerr)
+			}
+		}
+		gc.pending = nil
+	} else {
+		group.err = errors.Wrap(ErrCommitFailed, err.Error())
+		gc.signal()
+	}
+
+	close(group.done)
+	return err
+}
@@ -0,0 +1,209 @@
+package cellar
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// groupCountingMeta counts the meta DB transactions recording chunks.
+type groupCountingMeta struct {
+	MetaDB
+	added   int64
+	commits int64
+}
+
+func (m *groupCountingMeta) AddChunk(pos int64, dto *ChunkDto) error {
+	atomic.AddInt64(&m.added, 1)
+	return m.MetaDB.AddChunk(pos, dto)
+}
+
+func (m *groupCountingMeta) AddChunks(chunks []*ChunkDto, buffer *BufferDto) error {
+	atomic.AddInt64(&m.commits, 1)
+	return m.MetaDB.AddChunks(chunks, buffer)
+}
+
+// failingCommitMeta fails the first group commit.
+type failingCommitMeta struct {
+	MetaDB
+	failed int32
+}
+
+func (m *failingCommitMeta) AddChunks(chunks []*ChunkDto, buffer *BufferDto) error {
+	if atomic.CompareAndSwapInt32(&m.failed, 0, 1) {
+		return errors.New("failing commit")
+	}
+	return m.MetaDB.AddChunks(chunks, buffer)
+}
+
+func TestDB_GroupCommit(t *testing.T) {
+	_, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithGroupCommit(0))
+	assert.Error(t, err)
+
+	folder := getFolder()
+	meta := &groupCountingMeta{MetaDB: NewInMemoryMetaDB()}
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000), WithCompressor(Lz4Compressor{}),
+		WithGroupCommit(20*time.Millisecond))
+	require.NoError(t, err)
+
+	const (
+		Writers = 8
+		Records = 200
+	)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < Writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < Records; j++ {
+				_, err := db.Append([]byte(fmt.Sprintf("%d-%04d", i, j)))
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// appends returned once their seals were committed
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, atomic.LoadInt64(&meta.added))
+	assert.True(t, atomic.LoadInt64(&meta.commits) < int64(len(chunks)), "%d commits of %d chunks", meta.commits, len(chunks))
+
+	require.NoError(t, db.Close())
+
+	db, err = New(folder, WithNoFileLock, WithMetaDB(meta))
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	seen := make(map[string]int)
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		seen[string(rec.Data)]++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, seen, Writers*Records)
+}
+
+func TestDB_GroupCommit_PendingSeals(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	// a window long enough for the seal to stay pending
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000), WithGroupCommit(time.Hour))
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	for i := 0; i < 3; i++ {
+		_, err = db.Append(genSeedBytes(100, i))
+		require.NoError(t, err)
+	}
+
+	db.writer.mu.Lock()
+	require.NoError(t, db.writer.sealTheBuffer(context.Background()))
+	db.writer.mu.Unlock()
+
+	_, err = db.Append(genSeedBytes(100, 3))
+	require.NoError(t, err)
+	require.NoError(t, db.Flush())
+
+	count := func() int {
+		var n int
+		require.NoError(t, db.Reader().ForEach(func(rec *Rec) error {
+			assert.Equal(t, genSeedBytes(100, n), rec.Data)
+			n++
+			return nil
+		}))
+		return n
+	}
+
+	// the pending chunk is not recorded, and readers keep reading the sealed buffer
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	assert.Len(t, chunks, 0)
+	assert.Equal(t, 3, count())
+
+	_, err = db.Checkpoint()
+	require.NoError(t, err)
+
+	chunks, err = meta.ListChunks()
+	require.NoError(t, err)
+	assert.Len(t, chunks, 1)
+	assert.Equal(t, 4, count())
+
+	_, err = os.Stat(path.Join(folder, "000000000000"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_GroupCommit_Retry(t *testing.T) {
+	meta := &failingCommitMeta{MetaDB: NewInMemoryMetaDB()}
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000),
+		WithGroupCommit(10*time.Millisecond))
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	// the third record seals the buffer, and waits for the failing commit
+	for i := 0; i < 3; i++ {
+		_, err = db.Append(genSeedBytes(400, i))
+		if i < 2 {
+			require.NoError(t, err)
+		}
+	}
+	assert.Equal(t, ErrCommitFailed, errors.Cause(err))
+
+	// the seal is committed with the next window, without further appends
+	assert.Eventually(t, func() bool {
+		chunks, err := meta.ListChunks()
+		return err == nil && len(chunks) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, db.Flush())
+	var seeds []int
+	require.NoError(t, db.Reader().ForEach(func(rec *Rec) error {
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	}))
+	assert.Equal(t, []int{0, 1, 2}, seeds)
+}
+
+func BenchmarkDB_Append_Concurrent(b *testing.B) {
+	benchmarkAppendConcurrent(b)
+}
+
+func BenchmarkDB_Append_Concurrent_GroupCommit(b *testing.B) {
+	benchmarkAppendConcurrent(b, WithGroupCommit(time.Millisecond))
+}
+
+// benchmarkAppendConcurrent appends from many goroutines to a bolt meta DB, which syncs every transaction,
+// with buffers small enough to seal every few appends.
+func benchmarkAppendConcurrent(b *testing.B, options ...Option) {
+	options = append(options, WithNoFileLock, WithMetaDB(newBoltMetaDB()), WithMaxBufferSize(4096),
+		WithCompressor(Lz4Compressor{}))
+	db, err := New(getFolder(), options...)
+	require.NoError(b, err)
+
+	defer checkedClose(db)
+
+	data := genSeedBytes(500, 0)
+
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := db.Append(data); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
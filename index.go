@@ -0,0 +1,69 @@
+package cellar
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// AppendIndexed is Append, also returning the index of the record. Records are numbered from 0 in the
+// order they are appended, whatever their size or encoding, and the numbering continues when the cellar is
+// reopened. Readers report the index of every record in ReaderInfo.Index and Rec.Index.
+func (w *Writer) AppendIndexed(data []byte) (pos int64, idx int64, err error) {
+	return w.appendAt(context.Background(), w.now(), data)
+}
+
+// loadRecordIndex numbers the records of cellars created before records were indexed, storing the index of
+// the first record of every chunk and of the buffer. Chunks deleted by retention are not counted, so the
+// numbering of such cellars starts at their oldest chunk. The meta DB records that the cellar was numbered,
+// so this happens only once.
+func (w *Writer) loadRecordIndex() error {
+	if w.recordIndex {
+		return nil
+	}
+
+	chunks, err := w.db.ListChunks()
+	if err != nil {
+		return errors.Wrap(err, "ListChunks")
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].StartPos < chunks[j].StartPos
+	})
+
+	var idx int64
+	for _, c := range chunks {
+		c.StartIndex = idx
+		if err = w.db.PutChunk(c.StartPos, c); err != nil {
+			return errors.Wrap(err, "PutChunk")
+		}
+		idx += c.Records
+	}
+
+	w.b.startIndex = idx
+	if err = w.db.PutBuffer(w.b.getState()); err != nil {
+		return errors.Wrap(err, "PutBuffer")
+	}
+
+	w.recordIndex = true
+	if err = w.db.SetCellarMeta(w.cellarMeta()); err != nil {
+		return errors.Wrap(err, "SetCellarMeta")
+	}
+	return nil
+}
+
+// recordsBefore counts the records of a decoded chunk starting before offset, which gives the index of the
+// record at offset relative to the first record of the chunk.
+func recordsBefore(chunk []byte, offset int, checksums bool) (int64, error) {
+	var n int64
+	pos := 0
+	for pos < len(chunk) && pos < offset {
+		_, _, next, err := decodeRecord(chunk, pos, checksums)
+		if err != nil {
+			return 0, errors.Wrapf(err, "offset %d", pos)
+		}
+		pos = next
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,117 @@
+package cellar
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter_AppendIndexed(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	// two records per chunk, the sequence continues once reopened
+	var positions, ends []int64
+	for i := 0; i < 7; i++ {
+		if i == 3 {
+			require.NoError(t, db.Close())
+			db, err = New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000))
+			require.NoError(t, err)
+		}
+		positions = append(positions, db.VolatilePos())
+		pos, idx, err := db.AppendIndexed(genSeedBytes(400, i))
+		require.NoError(t, err)
+		assert.Equal(t, int64(i), idx)
+		ends = append(ends, pos)
+	}
+	require.NoError(t, db.Flush())
+	defer db.Close()
+
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		assert.Equal(t, int64(rec.Data[0]), rec.Index)
+		return nil
+	})
+	require.NoError(t, err)
+
+	vals, errs := db.Reader().ScanReverse(context.Background())
+	for rec := range vals {
+		assert.Equal(t, int64(rec.Data[0]), rec.Index)
+	}
+	require.NoError(t, <-errs)
+
+	// scans starting in the middle of a chunk or of the buffer count the records skipped
+	for _, i := range []int{3, 6} {
+		vals, errs = db.Reader().ScanFrom(context.Background(), positions[i])
+		rec := <-vals
+		assert.Equal(t, int64(i), rec.Index)
+		for range vals {
+		}
+		require.NoError(t, <-errs)
+
+		rec, err = db.Reader().ReadAt(ends[i])
+		require.NoError(t, err)
+		assert.Equal(t, int64(i), rec.Index)
+	}
+
+	_, err = db.Compact(2, 2000)
+	require.NoError(t, err)
+
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		assert.Equal(t, int64(rec.Data[0]), rec.Index)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestWriter_AppendIndexed_Backfill(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000))
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = db.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	// forget the index, as in cellars created before records were indexed
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	for _, c := range chunks {
+		c.StartIndex = 0
+		require.NoError(t, meta.PutChunk(c.StartPos, c))
+	}
+	b, err := meta.GetBuffer()
+	require.NoError(t, err)
+	b.StartIndex = 0
+	require.NoError(t, meta.PutBuffer(b))
+	require.NoError(t, meta.SetCellarMeta(&MetaDto{}))
+
+	db, err = New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000))
+	require.NoError(t, err)
+	defer db.Close()
+
+	cellar, err := meta.CellarMeta()
+	require.NoError(t, err)
+	assert.True(t, cellar.RecordIndex)
+
+	_, idx, err := db.AppendIndexed(genSeedBytes(400, 5))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), idx)
+	require.NoError(t, db.Flush())
+
+	count := 0
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		assert.Equal(t, int64(rec.Data[0]), rec.Index)
+		count++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 6, count)
+}
@@ -0,0 +1,82 @@
+package cellar
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// jsonlRecord is a single line written by ExportJSONL. Exactly one of Data and Base64 is set: Data holds
+// payloads which are valid UTF-8 as is, Base64 holds any other payload. Pos is informational, and ignored on
+// import.
+type jsonlRecord struct {
+	Pos    int64   `json:"pos"`
+	Data   *string `json:"data,omitempty"`
+	Base64 *string `json:"base64,omitempty"`
+}
+
+// ExportJSONL writes every record to w as a line of JSON, holding its position and its payload. Payloads
+// which are valid UTF-8 are written as strings, others base64 encoded, unless ExportBase64 is set. Records are
+// streamed, so the export holds no more than a chunk in memory. Use ImportJSONL to read the export back.
+func (r *Reader) ExportJSONL(ctx context.Context, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	err := r.Scan(func(info *ReaderInfo, data []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		line := jsonlRecord{Pos: info.StartPos}
+		if !r.ExportBase64 && utf8.Valid(data) {
+			s := string(data)
+			line.Data = &s
+		} else {
+			s := base64.StdEncoding.EncodeToString(data)
+			line.Base64 = &s
+		}
+		return enc.Encode(&line)
+	})
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// ImportJSONL appends the records read from an export written by ExportJSONL, returning the number of
+// records appended. On error, the records appended before it remain. Like Append, it does not checkpoint.
+func (w *Writer) ImportJSONL(r io.Reader) (count int64, err error) {
+	dec := json.NewDecoder(r)
+
+	for {
+		var line jsonlRecord
+		if err = dec.Decode(&line); err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, errors.Wrapf(err, "decode record %d", count)
+		}
+
+		var data []byte
+		switch {
+		case line.Data != nil:
+			data = []byte(*line.Data)
+		case line.Base64 != nil:
+			if data, err = base64.StdEncoding.DecodeString(*line.Base64); err != nil {
+				return count, errors.Wrapf(err, "decode record %d", count)
+			}
+		default:
+			return count, errors.Errorf("cellar: record %d has no payload", count)
+		}
+
+		if _, err = w.Append(data); err != nil {
+			return count, errors.Wrapf(err, "append record %d", count)
+		}
+		count++
+	}
+}
@@ -0,0 +1,97 @@
+package cellar
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader_ExportJSONL(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	records := [][]byte{[]byte("hello"), {0xff, 0x00, 0xfe}, {}}
+	_, err = w.AppendBatch(records)
+	require.NoError(t, err)
+	_, err = w.Checkpoint()
+	require.NoError(t, err)
+
+	reader := NewReader(folder, newCipher(), newDecompressor(), meta)
+
+	var out bytes.Buffer
+	require.NoError(t, reader.ExportJSONL(context.Background(), &out))
+	assert.Equal(t, `{"pos":0,"data":"hello"}
+{"pos":6,"base64":"/wD+"}
+{"pos":10,"data":""}
+`, out.String())
+
+	reader.ExportBase64 = true
+	var encoded bytes.Buffer
+	require.NoError(t, reader.ExportJSONL(context.Background(), &encoded))
+	assert.Contains(t, encoded.String(), `{"pos":0,"base64":"aGVsbG8="}`)
+
+	// import both exports into a new cellar
+	target := NewInMemoryMetaDB()
+	iw, err := OpenWriter(getFolder(), target, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(iw)
+
+	count, err := iw.ImportJSONL(&out)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	count, err = iw.ImportJSONL(&encoded)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	_, err = iw.Checkpoint()
+	require.NoError(t, err)
+
+	var imported [][]byte
+	err = NewReader(iw.folder, newCipher(), newDecompressor(), target).ForEach(func(rec *Rec) error {
+		imported = append(imported, append([]byte{}, rec.Data...))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, append(records, records...), imported)
+}
+
+func TestReader_ExportJSONL_Cancelled(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	_, err = w.Append([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.Checkpoint()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var out bytes.Buffer
+	err = NewReader(folder, newCipher(), newDecompressor(), meta).ExportJSONL(ctx, &out)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+}
+
+func TestWriter_ImportJSONL_Invalid(t *testing.T) {
+	w, err := OpenWriter(getFolder(), NewInMemoryMetaDB(), WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	count, err := w.ImportJSONL(strings.NewReader(`{"pos":0,"data":"a"}
+{"pos":2}
+`))
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), count)
+}
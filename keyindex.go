@@ -0,0 +1,203 @@
+package cellar
+
+import (
+	"github.com/pkg/errors"
+)
+
+// keyIndexBatch is the number of keys stored per meta DB transaction while the key index catches up.
+const keyIndexBatch = 10000
+
+// keyIndex holds the keys of the records flushed since the key index was last stored in the meta DB.
+type keyIndex struct {
+	extractor func([]byte) []byte
+
+	// pending maps keys to the position of their latest record, for the records before pos
+	pending map[string]int64
+	pos     int64
+	// storedPos is the position up to which the meta DB holds the keys
+	storedPos int64
+}
+
+// forEachKey calls fn with the key and offset of every record in data which has a key.
+func forEachKey(data []byte, checksums, timestamps bool, extractor func([]byte) []byte, fn func(key []byte, offset int)) error {
+	for pos := 0; pos < len(data); {
+		record, next, err := readRecord(data, pos, 0, checksums)
+		if err != nil {
+			return errors.Wrap(err, "readRecord")
+		}
+		if timestamps {
+			if _, record, err = splitStamp(record); err != nil {
+				return errors.Wrapf(err, "offset %d", pos)
+			}
+		}
+		if key := extractor(record); len(key) > 0 {
+			fn(key, pos)
+		}
+		pos = next
+	}
+	return nil
+}
+
+// startKeyIndex makes the writer index the keys of the records it flushes from pos on, which is the position
+// up to which the meta DB holds the keys.
+func (w *Writer) startKeyIndex(extractor func([]byte) []byte, pos int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.keys = &keyIndex{
+		extractor: extractor,
+		pending:   make(map[string]int64),
+		pos:       pos,
+		storedPos: pos,
+	}
+}
+
+// indexKeys adds the keys of the records flushed since the last call to the pending keys, reading the records
+// back from the buffer file.
+func (w *Writer) indexKeys() error {
+	if w.keys == nil {
+		return nil
+	}
+
+	offset := w.keys.pos - w.b.startPos
+	if offset >= w.b.flushedPos {
+		return nil
+	}
+
+	data := make([]byte, w.b.flushedPos-offset)
+	if _, err := w.b.stream.ReadAt(data, offset); err != nil {
+		return errors.Wrap(err, "read buffer")
+	}
+
+	base := w.keys.pos
+	err := forEachKey(data, w.recordChecksums, w.recordTimestamps, w.keys.extractor, func(key []byte, offset int) {
+		w.keys.pending[string(key)] = base + int64(offset)
+	})
+	if err != nil {
+		return err
+	}
+	w.keys.pos = w.b.startPos + w.b.flushedPos
+	return nil
+}
+
+// storeKeys stores the pending keys in the meta DB. It is called once the records they refer to are
+// committed, so the key index never refers to records lost in a crash.
+func (w *Writer) storeKeys() error {
+	if w.keys == nil || w.keys.pos == w.keys.storedPos {
+		return nil
+	}
+
+	if err := w.db.PutKeys(w.keys.pending, w.keys.pos); err != nil {
+		return errors.Wrap(err, "PutKeys")
+	}
+	w.keys.pending = make(map[string]int64)
+	w.keys.storedPos = w.keys.pos
+	return nil
+}
+
+// pendingKey returns the position of the latest flushed record with key, if it is not stored in the meta DB
+// yet.
+func (w *Writer) pendingKey(key []byte) (int64, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.keys == nil {
+		return 0, false
+	}
+	pos, ok := w.keys.pending[string(key)]
+	return pos, ok
+}
+
+// openKeyIndex brings the key index up to date with the checkpointed records, and makes the writer index
+// the records appended from now on. The records past the position up to which the meta DB holds the keys
+// are scanned, which rebuilds the whole index if it is missing, or ahead of the cellar.
+func (db *DB) openKeyIndex() error {
+	pos, err := db.meta.KeyIndexPos()
+	if err != nil {
+		return errors.Wrap(err, "KeyIndexPos")
+	}
+
+	b := db.writer.b.getState()
+	end := b.StartPos + b.Pos
+	if pos > end {
+		// the index is ahead of the cellar, which was restored from an older copy, so its keys may point past
+		// the records they name and are dropped before reindexing
+		if err = db.meta.ClearKeys(); err != nil {
+			return errors.Wrap(err, "ClearKeys")
+		}
+		pos = 0
+	}
+
+	r := db.Reader()
+	first, err := r.firstPos()
+	if err != nil {
+		return err
+	}
+	if pos < first {
+		pos = first
+	}
+
+	if pos < end {
+		keys := make(map[string]int64)
+		err = r.scanRange(pos, end, func(info *ReaderInfo, data []byte) error {
+			if key := db.keyExtractor(data); len(key) > 0 {
+				keys[string(key)] = info.StartPos
+			}
+			if len(keys) < keyIndexBatch {
+				return nil
+			}
+			if err := db.meta.PutKeys(keys, info.NextPos); err != nil {
+				return err
+			}
+			keys = make(map[string]int64)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "scan")
+		}
+		if err = db.meta.PutKeys(keys, end); err != nil {
+			return errors.Wrap(err, "PutKeys")
+		}
+	}
+
+	db.writer.startKeyIndex(db.keyExtractor, end)
+	return nil
+}
+
+// GetByKey returns the latest record with the key, as extracted by the function passed to WithKeyExtractor,
+// or false if no record has the key. It looks the key up in the key index enabled with WithKeyIndex, rather
+// than scanning the cellar. Records with the key which were deleted by retention are not found.
+//
+// Readers of the DB see the keys of all flushed records. Readers created through NewReader or OpenReadOnly
+// see the keys stored in the meta DB, which holds the keys of the records up to the last checkpoint or seal.
+func (r *Reader) GetByKey(key []byte) (*Rec, bool, error) {
+	if r.pendingKey != nil {
+		if pos, ok := r.pendingKey(key); ok {
+			rec, err := r.readAt(pos, false)
+			if err == nil {
+				return rec, true, nil
+			}
+			// records after a pending group commit are not visible yet
+			if errors.Cause(err) != ErrOutOfRange {
+				return nil, false, err
+			}
+		}
+	}
+
+	pos, ok, err := r.metadb.GetKey(key)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "GetKey")
+	}
+	if !ok {
+		return nil, false, nil
+	}
+
+	rec, err := r.readAt(pos, false)
+	switch errors.Cause(err) {
+	case nil:
+		return rec, true, nil
+	case ErrTruncated, ErrOutOfRange:
+		return nil, false, nil
+	}
+	return nil, false, err
+}
@@ -0,0 +1,161 @@
+package cellar
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordValue returns the value of records formatted as "key:value".
+func recordValue(data []byte) string {
+	return string(data[bytes.IndexByte(data, ':')+1:])
+}
+
+func TestDB_KeyIndex(t *testing.T) {
+	_, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithKeyIndex())
+	assert.Error(t, err)
+
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000), WithCompressor(Lz4Compressor{}),
+		WithKeyExtractor(recordKey), WithKeyIndex())
+	require.NoError(t, err)
+
+	get := func(r *Reader, key string) (string, bool) {
+		rec, ok, err := r.GetByKey([]byte(key))
+		require.NoError(t, err)
+		if !ok {
+			return "", false
+		}
+		return recordValue(rec.Data), true
+	}
+
+	// records are indexed once flushed
+	for _, data := range []string{"a:1", "b:1", "no key", "a:2"} {
+		_, err = db.Append([]byte(data))
+		require.NoError(t, err)
+	}
+	_, ok := get(db.Reader(), "a")
+	assert.False(t, ok)
+
+	require.NoError(t, db.Flush())
+	value, ok := get(db.Reader(), "a")
+	assert.True(t, ok)
+	assert.Equal(t, "2", value)
+	_, ok = get(db.Reader(), "missing")
+	assert.False(t, ok)
+
+	// and stored in the meta DB by checkpoints
+	_, ok, err = meta.GetKey([]byte("a"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = db.Checkpoint()
+	require.NoError(t, err)
+	_, ok, err = meta.GetKey([]byte("a"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// and by seals, with later records replacing earlier ones across chunks
+	for i := 0; i < 100; i++ {
+		_, err = db.Append([]byte(fmt.Sprintf("k%d:%d", i%10, i)))
+		require.NoError(t, err)
+	}
+	_, err = db.Append([]byte("b:2"))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	pos, err := meta.KeyIndexPos()
+	require.NoError(t, err)
+
+	db, err = New(folder, WithNoFileLock, WithMetaDB(meta), WithKeyExtractor(recordKey), WithKeyIndex())
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	expected := map[string]string{"a": "2", "b": "2"}
+	for i := 90; i < 100; i++ {
+		expected[fmt.Sprintf("k%d", i%10)] = fmt.Sprint(i)
+	}
+	for key, val := range expected {
+		value, ok = get(db.Reader(), key)
+		assert.True(t, ok, key)
+		assert.Equal(t, val, value, key)
+	}
+
+	// readers not attached to the writer use the meta DB alone
+	reader := NewReader(folder, db.cipher, ChainDecompressor{}, meta)
+	value, ok = get(reader, "k3")
+	assert.True(t, ok)
+	assert.Equal(t, "93", value)
+
+	reopened, err := meta.KeyIndexPos()
+	require.NoError(t, err)
+	assert.Equal(t, pos, reopened)
+}
+
+func TestDB_KeyIndex_Rebuild(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000), WithCompressor(Lz4Compressor{}))
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		_, err = db.Append([]byte(fmt.Sprintf("k%d:%d", i%7, i)))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	// a cellar without index gets one on reopen
+	db, err = New(folder, WithNoFileLock, WithMetaDB(meta), WithKeyExtractor(recordKey), WithKeyIndex())
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	pos, err := meta.KeyIndexPos()
+	require.NoError(t, err)
+	assert.Equal(t, db.writer.b.startPos+db.writer.b.pos, pos)
+
+	for i := 93; i < 100; i++ {
+		rec, ok, err := db.Reader().GetByKey([]byte(fmt.Sprintf("k%d", i%7)))
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, fmt.Sprint(i), recordValue(rec.Data))
+	}
+}
+
+func TestDB_KeyIndex_Ahead(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000), WithCompressor(Lz4Compressor{}))
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		_, err = db.Append([]byte(fmt.Sprintf("k%d:%d", i%7, i)))
+		require.NoError(t, err)
+	}
+	end := db.VolatilePos()
+	require.NoError(t, db.Close())
+
+	// an index from a newer copy of the cellar holds keys of records the cellar does not have
+	require.NoError(t, meta.PutKeys(map[string]int64{"gone": end + 100}, end+200))
+
+	db, err = New(folder, WithNoFileLock, WithMetaDB(meta), WithKeyExtractor(recordKey), WithKeyIndex())
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	// the stale key would name whichever record is appended at its position later
+	_, ok, err := meta.GetKey([]byte("gone"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	rec, ok, err := db.Reader().GetByKey([]byte("k5"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "19", recordValue(rec.Data))
+
+	pos, err := meta.KeyIndexPos()
+	require.NoError(t, err)
+	assert.Equal(t, end, pos)
+}
@@ -0,0 +1,83 @@
+package cellar
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnknownKey = errors.New("cellar: key id not in keyring")
+)
+
+// KeyedCipher is implemented by ciphers holding several keys. The id of the key used for a chunk is stored in
+// ChunkDto.KeyID, and used to find the key again when the chunk is read.
+type KeyedCipher interface {
+	Cipher
+	// KeyID returns the id of the key new chunks are encrypted with.
+	KeyID() string
+	// ForKey returns the cipher for the key with the given id.
+	ForKey(id string) (Cipher, error)
+}
+
+var _ KeyedCipher = &Keyring{}
+
+// Keyring encrypts chunks with AES-256-GCM under the active key, while chunks encrypted under older keys
+// remain readable. Rotating a key only requires adding the new key and making it active; a key can be
+// removed from the keyring once no chunk encrypted with it is needed anymore.
+type Keyring struct {
+	ciphers  map[string]*AESGCM
+	activeID string
+}
+
+// NewKeyring returns a keyring for the given keys by id, all of which must be 32 bytes long. New chunks are
+// encrypted with the key of activeID.
+func NewKeyring(keys map[string][]byte, activeID string) (*Keyring, error) {
+	if _, ok := keys[activeID]; !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "active key %q", activeID)
+	}
+
+	ciphers := make(map[string]*AESGCM, len(keys))
+	for id, key := range keys {
+		c, err := NewAESGCMCipher(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "key %q", id)
+		}
+		ciphers[id] = c
+	}
+	return &Keyring{ciphers: ciphers, activeID: activeID}, nil
+}
+
+func (k *Keyring) Decrypt(src io.Reader, nonce []byte) (io.Reader, error) {
+	return k.ciphers[k.activeID].Decrypt(src, nonce)
+}
+
+func (k *Keyring) Encrypt(w io.Writer, nonce []byte) (io.WriteCloser, error) {
+	return k.ciphers[k.activeID].Encrypt(w, nonce)
+}
+
+func (k *Keyring) Algorithm() uint32 {
+	return CipherAESGCM
+}
+
+func (k *Keyring) NonceSize() int {
+	return k.ciphers[k.activeID].NonceSize()
+}
+
+func (k *Keyring) KeyID() string {
+	return k.activeID
+}
+
+// ForKey returns the cipher for the key with the given id. Chunks written without a key id are read with the
+// active key.
+func (k *Keyring) ForKey(id string) (Cipher, error) {
+	if id == "" {
+		id = k.activeID
+	}
+
+	c, ok := k.ciphers[id]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownKey, "key %q", id)
+	}
+	return c, nil
+}
@@ -0,0 +1,64 @@
+package cellar
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKeyring_ActiveKeyMissing(t *testing.T) {
+	_, err := NewKeyring(map[string][]byte{"a": gcmKey}, "b")
+	assert.Equal(t, ErrUnknownKey, errors.Cause(err))
+}
+
+func TestKeyring_Rotate(t *testing.T) {
+	oldKey := gcmKey
+	newKey := bytes.Repeat([]byte{7}, 32)
+
+	folder := getFolder()
+	db, err := New(folder, WithKeyring(map[string][]byte{"old": oldKey}, "old"))
+	require.NoError(t, err)
+
+	old, err := db.Append([]byte("under the old key"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+	require.NoError(t, db.Close())
+
+	db, err = New(folder, WithKeyring(map[string][]byte{"old": oldKey, "new": newKey}, "new"))
+	require.NoError(t, err)
+
+	pos, err := db.Append([]byte("under the new key"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	chunks, err := db.Reader().sortedChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "old", chunks[0].KeyID)
+	assert.Equal(t, "new", chunks[1].KeyID)
+
+	var found []string
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		found = append(found, string(rec.Data))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"under the old key", "under the new key"}, found)
+	require.NoError(t, db.Close())
+
+	// once the old key is retired, only its chunks become unreadable
+	db, err = New(folder, WithKeyring(map[string][]byte{"new": newKey}, "new"))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	_, err = db.Reader().ReadAt(old)
+	assert.Equal(t, ErrUnknownKey, errors.Cause(err))
+
+	rec, err := db.Reader().ReadAt(pos)
+	require.NoError(t, err)
+	assert.Equal(t, "under the new key", string(rec.Data))
+}
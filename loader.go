@@ -0,0 +1,88 @@
+package cellar
+
+// chunkLoader decompresses a list of chunks using up to n workers, handing them out in the order of the
+// list. Every chunk gets its own result channel, which acts as a reorder buffer for chunks finishing out of
+// order. A worker slot is released once its chunk is handed out, so at most n decompressed chunks are held
+// in memory at any time, besides the chunks handed out.
+//
+// With maxInFlight above 0, the chunks handed out count as well: a chunk is only loaded once fewer than
+// maxInFlight chunks are loaded and not released yet, whatever the number of workers.
+type chunkLoader struct {
+	results  []chan loadResult
+	slots    chan struct{}
+	inFlight chan struct{}
+	done     chan struct{}
+	pos      int
+}
+
+type loadResult struct {
+	chunk *[]byte
+	err   error
+}
+
+func (r *Reader) newChunkLoader(chunks []*ChunkDto, n, maxInFlight int) *chunkLoader {
+	if n < 1 {
+		n = 1
+	}
+
+	l := &chunkLoader{
+		results: make([]chan loadResult, len(chunks)),
+		slots:   make(chan struct{}, n),
+		done:    make(chan struct{}),
+	}
+	if maxInFlight > 0 {
+		l.inFlight = make(chan struct{}, maxInFlight)
+	}
+
+	for i := range chunks {
+		l.results[i] = make(chan loadResult, 1)
+	}
+
+	go func() {
+		for i, c := range chunks {
+			if l.inFlight != nil {
+				select {
+				case l.inFlight <- struct{}{}:
+				case <-l.done:
+					return
+				}
+			}
+
+			select {
+			case l.slots <- struct{}{}:
+			case <-l.done:
+				return
+			}
+
+			go func(c *ChunkDto, result chan loadResult) {
+				chunk, err := r.scanChunk(c)
+				result <- loadResult{chunk, err}
+			}(c, l.results[i])
+		}
+	}()
+
+	return l
+}
+
+// next returns the next chunk in order, blocking until it has been decompressed. The chunk is released with
+// Reader.releaseChunk once its records are replayed, and with release.
+func (l *chunkLoader) next() (*[]byte, error) {
+	res := <-l.results[l.pos]
+	l.pos++
+	<-l.slots
+	return res.chunk, res.err
+}
+
+// release lets the loader load another chunk in place of the chunk handed out by next, once its records are
+// replayed.
+func (l *chunkLoader) release() {
+	if l.inFlight != nil {
+		<-l.inFlight
+	}
+}
+
+// stop prevents any more chunks from being loaded. Chunks which are already being decompressed finish
+// in the background.
+func (l *chunkLoader) stop() {
+	close(l.done)
+}
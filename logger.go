@@ -0,0 +1,24 @@
+package cellar
+
+import (
+	"io/ioutil"
+	"log"
+)
+
+// Logger receives the warnings cellar logs while operating, such as failures to clean up old files. It is
+// implemented by *log.Logger, and is easily adapted to structured loggers.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+var _ Logger = &log.Logger{}
+
+// stdLogger is the default Logger, passing messages on to the standard logger of the log package.
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// discardLogger drops all messages.
+var discardLogger = log.New(ioutil.Discard, "", 0)
@@ -0,0 +1,51 @@
+package cellar
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingLogger struct {
+	mu       *sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func TestDB_WithLogger(t *testing.T) {
+	logger := &recordingLogger{mu: &sync.Mutex{}}
+
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithLogger(logger))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Append(genSeedBytes(50, 1))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	reader := db.Reader()
+	reader.Flags = RF_PrintChunks
+	require.NoError(t, reader.Scan(func(*ReaderInfo, []byte) error { return nil }))
+
+	require.Len(t, logger.messages, 1)
+	assert.Contains(t, logger.messages[0], "Loading chunk 0")
+}
+
+func TestDB_WithLogger_Nil(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithLogger(nil))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Append(genSeedBytes(50, 1))
+	require.NoError(t, err)
+	require.NoError(t, db.Flush())
+	assert.NoError(t, db.Reader().Scan(func(*ReaderInfo, []byte) error { return nil }))
+}
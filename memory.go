@@ -0,0 +1,323 @@
+package cellar
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+var _ MetaDB = &InMemoryMetaDB{} // compile time assertion to verify we match the interface metaDB
+
+// InMemoryMetaDB keeps the metadata of the cellar in memory, which makes it useful for tests and
+// throwaway cellars. All metadata is lost once the process exits. Dtos are copied going in and out,
+// mirroring the encoding done by the persistent backends.
+type InMemoryMetaDB struct {
+	mu *sync.Mutex
+
+	buffer      *BufferDto
+	meta        *MetaDto
+	chunks      map[int64]*ChunkDto
+	checkpoints map[string]int64
+	timeIndex   map[int64]int64
+	keyIndex    map[string]int64
+	keyIndexPos int64
+}
+
+// NewInMemoryMetaDB returns an empty in-memory meta DB, to be passed to New with WithMetaDB or to OpenWriter.
+func NewInMemoryMetaDB() *InMemoryMetaDB {
+	return &InMemoryMetaDB{
+		mu:          &sync.Mutex{},
+		chunks:      make(map[int64]*ChunkDto),
+		checkpoints: make(map[string]int64),
+		timeIndex:   make(map[int64]int64),
+		keyIndex:    make(map[string]int64),
+	}
+}
+
+func (m *InMemoryMetaDB) GetBuffer() (*BufferDto, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.buffer == nil {
+		return nil, nil
+	}
+	return proto.Clone(m.buffer).(*BufferDto), nil
+}
+
+func (m *InMemoryMetaDB) PutBuffer(dto *BufferDto) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.buffer = proto.Clone(dto).(*BufferDto)
+	return nil
+}
+
+func (m *InMemoryMetaDB) CellarMeta() (*MetaDto, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.meta == nil {
+		return &MetaDto{}, nil
+	}
+	return proto.Clone(m.meta).(*MetaDto), nil
+}
+
+func (m *InMemoryMetaDB) SetCellarMeta(dto *MetaDto) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.meta = proto.Clone(dto).(*MetaDto)
+	return nil
+}
+
+// ListChunks returns all chunks ordered by the position they were added at.
+func (m *InMemoryMetaDB) ListChunks() ([]*ChunkDto, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	positions := make([]int64, 0, len(m.chunks))
+	for pos := range m.chunks {
+		positions = append(positions, pos)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+
+	chunks := make([]*ChunkDto, 0, len(positions))
+	for _, pos := range positions {
+		chunks = append(chunks, proto.Clone(m.chunks[pos]).(*ChunkDto))
+	}
+	return chunks, nil
+}
+
+func (m *InMemoryMetaDB) ListChunksRange(fromPos, toPos int64, limit int) ([]*ChunkDto, error) {
+	chunks, err := m.ListChunks()
+	if err != nil {
+		return nil, err
+	}
+	return chunksInRange(chunks, fromPos, toPos, limit), nil
+}
+
+// chunksInRange selects the chunks overlapping [fromPos, toPos) from chunks ordered by their start position,
+// as ListChunksRange does.
+func chunksInRange(chunks []*ChunkDto, fromPos, toPos int64, limit int) []*ChunkDto {
+	// binary search for the first chunk ending after fromPos
+	i := sort.Search(len(chunks), func(i int) bool {
+		return chunks[i].StartPos+chunks[i].UncompressedByteSize > fromPos
+	})
+
+	inRange := []*ChunkDto{}
+	for _, c := range chunks[i:] {
+		if c.StartPos >= toPos {
+			break
+		}
+
+		inRange = append(inRange, c)
+		if limit > 0 && len(inRange) == limit {
+			break
+		}
+	}
+	return inRange
+}
+
+func (m *InMemoryMetaDB) AddChunk(pos int64, dto *ChunkDto) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.chunks[pos]; ok {
+		return ErrChunkExists
+	}
+	m.chunks[pos] = proto.Clone(dto).(*ChunkDto)
+	return nil
+}
+
+func (m *InMemoryMetaDB) AddChunks(chunks []*ChunkDto, buffer *BufferDto) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, dto := range chunks {
+		if _, ok := m.chunks[dto.StartPos]; ok {
+			return ErrChunkExists
+		}
+	}
+	for _, dto := range chunks {
+		m.chunks[dto.StartPos] = proto.Clone(dto).(*ChunkDto)
+	}
+	m.buffer = proto.Clone(buffer).(*BufferDto)
+	return nil
+}
+
+func (m *InMemoryMetaDB) PutChunk(pos int64, dto *ChunkDto) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chunks[pos] = proto.Clone(dto).(*ChunkDto)
+	return nil
+}
+
+func (m *InMemoryMetaDB) DeleteChunk(startPos int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.chunks, startPos)
+	return nil
+}
+
+func (m *InMemoryMetaDB) ReplaceChunks(old []int64, dto *ChunkDto) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, pos := range old {
+		delete(m.chunks, pos)
+	}
+	m.chunks[dto.StartPos] = proto.Clone(dto).(*ChunkDto)
+	return nil
+}
+
+func (m *InMemoryMetaDB) Truncate(buffer *BufferDto) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chunks = make(map[int64]*ChunkDto)
+	m.checkpoints = make(map[string]int64)
+	m.timeIndex = make(map[int64]int64)
+	m.keyIndex = make(map[string]int64)
+	m.keyIndexPos = 0
+	m.buffer = proto.Clone(buffer).(*BufferDto)
+	return nil
+}
+
+func (m *InMemoryMetaDB) PutCheckpoint(name string, pos int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.checkpoints[name] = pos
+	return nil
+}
+
+func (m *InMemoryMetaDB) GetCheckpoint(name string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pos, ok := m.checkpoints[name]
+	if !ok {
+		return 0, ErrCheckpointNotExists
+	}
+	return pos, nil
+}
+
+func (m *InMemoryMetaDB) ListCheckpoints() (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	checkpoints := make(map[string]int64, len(m.checkpoints))
+	for name, pos := range m.checkpoints {
+		checkpoints[name] = pos
+	}
+	return checkpoints, nil
+}
+
+func (m *InMemoryMetaDB) DeleteCheckpoint(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.checkpoints[name]; !ok {
+		return ErrCheckpointNotExists
+	}
+	delete(m.checkpoints, name)
+	return nil
+}
+
+func (m *InMemoryMetaDB) CasCheckpoint(name string, expected, pos int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.checkpoints[name]
+	if !ok {
+		return false, ErrCheckpointNotExists
+	}
+	if current != expected {
+		return false, nil
+	}
+	m.checkpoints[name] = pos
+	return true, nil
+}
+
+func (m *InMemoryMetaDB) PutTimeIndex(ts, pos int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.timeIndex[ts]; !ok {
+		m.timeIndex[ts] = pos
+	}
+	return nil
+}
+
+// SeekTimeIndex walks all samples, since the index is kept in a map.
+func (m *InMemoryMetaDB) SeekTimeIndex(ts int64) (pos int64, ok bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var latest int64
+	for sample, samplePos := range m.timeIndex {
+		if sample < ts && (!ok || sample > latest) {
+			latest, pos, ok = sample, samplePos, true
+		}
+	}
+	return pos, ok, nil
+}
+
+func (m *InMemoryMetaDB) ListTimeIndex() (map[int64]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	index := make(map[int64]int64, len(m.timeIndex))
+	for ts, pos := range m.timeIndex {
+		index[ts] = pos
+	}
+	return index, nil
+}
+
+func (m *InMemoryMetaDB) PutKeys(keys map[string]int64, indexedPos int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, pos := range keys {
+		m.keyIndex[key] = pos
+	}
+	m.keyIndexPos = indexedPos
+	return nil
+}
+
+func (m *InMemoryMetaDB) GetKey(key []byte) (pos int64, ok bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pos, ok = m.keyIndex[string(key)]
+	return pos, ok, nil
+}
+
+func (m *InMemoryMetaDB) KeyIndexPos() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.keyIndexPos, nil
+}
+
+func (m *InMemoryMetaDB) ClearKeys() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keyIndex = make(map[string]int64)
+	m.keyIndexPos = 0
+	return nil
+}
+
+// Close keeps the metadata, so the same InMemoryMetaDB can be used to reopen a cellar.
+func (m *InMemoryMetaDB) Close() error {
+	return nil
+}
+
+// Init does nothing, the maps are created by NewInMemoryMetaDB.
+func (m *InMemoryMetaDB) Init() error {
+	return nil
+}
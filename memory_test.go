@@ -0,0 +1,74 @@
+package cellar
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryMetaDB_AddChunk_ListChunk(t *testing.T) {
+	db := NewInMemoryMetaDB()
+
+	err := db.AddChunk(10, &ChunkDto{StartPos: 10, FileName: "second"})
+	require.NoError(t, err)
+
+	chunk := &ChunkDto{StartPos: 0, FileName: "first"}
+	err = db.AddChunk(0, chunk)
+	require.NoError(t, err)
+
+	// stored chunks are copies
+	chunk.FileName = "changed"
+
+	chunks, err := db.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "first", chunks[0].FileName)
+	assert.Equal(t, "second", chunks[1].FileName)
+}
+
+func TestInMemoryMetaDB_Checkpoint(t *testing.T) {
+	db := NewInMemoryMetaDB()
+
+	_, err := db.GetCheckpoint("missing")
+	assert.Equal(t, ErrCheckpointNotExists, err)
+
+	require.NoError(t, db.PutCheckpoint("name", 1))
+	require.NoError(t, db.PutCheckpoint("name", 2))
+
+	pos, err := db.GetCheckpoint("name")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pos)
+}
+
+func TestInMemoryMetaDB_Reopen(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta))
+	require.NoError(t, err)
+
+	inputs := []string{"first", "second", "third"}
+	for i, input := range inputs {
+		_, err = db.Append([]byte(input))
+		require.NoError(t, err)
+
+		if i == 0 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+	require.NoError(t, db.Close())
+
+	db, err = New(folder, WithNoFileLock, WithMetaDB(meta))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	var found []string
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		found = append(found, string(rec.Data))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, inputs, found)
+}
@@ -1,16 +1,85 @@
 package cellar
 
-// MetaDB defines an interface for databases storing metadata on the cellar DB.
-// the default implementation is based on either LMDB or Boltdb (K/V stores work best for this purpose)
+import "github.com/pkg/errors"
+
+var (
+	ErrCheckpointNotExists = errors.New("cellar: checkpoint does not exist")
+	ErrChunkExists         = errors.New("cellar: a chunk is already stored at this position")
+)
+
+// MetaDB defines an interface for databases storing metadata on the cellar DB. K/V stores work best for this
+// purpose; BoltMetaDB is the default implementation, SQLiteMetaDB and InMemoryMetaDB are provided as well.
+//
+// A cellar has a single writer, but readers may call the read methods concurrently with it, so
+// implementations must be safe for concurrent use.
 type MetaDB interface {
+	// GetBuffer returns the state of the buffer as of the last checkpoint, or nil if none was stored yet.
 	GetBuffer() (*BufferDto, error)
+	// PutBuffer replaces the stored buffer state.
 	PutBuffer(*BufferDto) error
+	// ListChunks returns all sealed chunks. The order is up to the implementation, readers sort chunks by
+	// their start position.
 	ListChunks() ([]*ChunkDto, error)
+	// ListChunksRange returns the chunks overlapping the positions [fromPos, toPos) ordered by their start
+	// position, returning at most limit chunks unless limit is 0 or less.
+	ListChunksRange(fromPos, toPos int64, limit int) ([]*ChunkDto, error)
+	// AddChunk stores a newly sealed chunk under its start position. A chunk already stored at the same
+	// position is kept, and ErrChunkExists is returned.
 	AddChunk(int64, *ChunkDto) error
+	// AddChunks stores sealed chunks under their start positions as AddChunk does, and replaces the stored
+	// buffer state, in a single transaction. It fails with ErrChunkExists, storing nothing, if any of the
+	// positions is taken. It commits the seals of a group, see WithGroupCommit.
+	AddChunks(chunks []*ChunkDto, buffer *BufferDto) error
+	// PutChunk stores a chunk under its start position, replacing any chunk at the same position. It is
+	// used to update the metadata of existing chunks.
+	PutChunk(int64, *ChunkDto) error
+	// DeleteChunk removes the chunk stored under its start position, leaving the chunk file in place.
+	// Deleting a chunk which does not exist is not an error.
+	DeleteChunk(startPos int64) error
+	// ReplaceChunks removes the chunks stored under the old start positions and adds dto in a single
+	// transaction, so readers see either the old chunks or the new one.
+	ReplaceChunks(old []int64, dto *ChunkDto) error
+	// Truncate removes all chunks, user checkpoints, samples of the time index and keys of the key index, and
+	// replaces the stored buffer state, in a single transaction. The cellar metadata is kept. See
+	// Writer.Truncate.
+	Truncate(buffer *BufferDto) error
+	// CellarMeta returns the metadata of the cellar, or an empty MetaDto if none was stored yet.
 	CellarMeta() (*MetaDto, error)
+	// SetCellarMeta replaces the metadata of the cellar.
 	SetCellarMeta(*MetaDto) error
+	// PutCheckpoint stores a named user checkpoint, replacing an existing one with the same name.
 	PutCheckpoint(name string, pos int64) error
+	// GetCheckpoint returns the position of a named user checkpoint, or ErrCheckpointNotExists.
 	GetCheckpoint(name string) (int64, error)
+	// ListCheckpoints returns the positions of all user checkpoints by name.
+	ListCheckpoints() (map[string]int64, error)
+	// DeleteCheckpoint removes a named user checkpoint, or returns ErrCheckpointNotExists.
+	DeleteCheckpoint(name string) error
+	// CasCheckpoint sets a named user checkpoint to pos if it is at expected, in a single transaction, and
+	// reports whether it was set. A missing checkpoint returns ErrCheckpointNotExists.
+	CasCheckpoint(name string, expected, pos int64) (bool, error)
+	// PutTimeIndex samples the timestamp ts, in unix nanos, at position pos in the sparse time index, see
+	// Reader.SeekTime. A timestamp which was sampled before keeps its earlier position.
+	PutTimeIndex(ts, pos int64) error
+	// SeekTimeIndex returns the position of the latest sample with a timestamp before ts, or false if there
+	// is none.
+	SeekTimeIndex(ts int64) (pos int64, ok bool, err error)
+	// ListTimeIndex returns the positions of all samples of the time index by timestamp.
+	ListTimeIndex() (map[int64]int64, error)
+	// PutKeys stores the positions of the latest records with the given keys in the key index, replacing the
+	// positions stored for them before, and records that all records before indexedPos are indexed, in a
+	// single transaction. See WithKeyIndex.
+	PutKeys(keys map[string]int64, indexedPos int64) error
+	// GetKey returns the position of the latest record with key in the key index, or false if there is none.
+	GetKey(key []byte) (pos int64, ok bool, err error)
+	// KeyIndexPos returns the position up to which records are indexed in the key index, or 0 if none are.
+	KeyIndexPos() (int64, error)
+	// ClearKeys removes all keys from the key index, and resets the position up to which records are indexed
+	// to 0, in a single transaction.
+	ClearKeys() error
+	// Close releases the resources of the meta DB.
 	Close() error
+	// Init prepares the storage, and must be called before the meta DB is used. It must be idempotent, so it
+	// can be called every time a cellar is opened.
 	Init() error
 }
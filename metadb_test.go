@@ -0,0 +1,286 @@
+package cellar
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// metaDBs returns a fresh instance of every MetaDB implementation, all of which must pass the same tests.
+func metaDBs() map[string]MetaDB {
+	return map[string]MetaDB{
+		"bolt":   newBoltMetaDB(),
+		"sqlite": newSQLiteMetaDB(),
+		"memory": NewInMemoryMetaDB(),
+	}
+}
+
+func TestMetaDB_Conformance(t *testing.T) {
+	for name, db := range metaDBs() {
+		t.Run(name, func(t *testing.T) {
+			defer checkedClose(db)
+
+			require.NoError(t, db.Init())
+
+			buf, err := db.GetBuffer()
+			require.NoError(t, err)
+			assert.Nil(t, buf)
+
+			require.NoError(t, db.PutBuffer(&BufferDto{FileName: "buffer", Pos: 10}))
+			buf, err = db.GetBuffer()
+			require.NoError(t, err)
+			assert.Equal(t, "buffer", buf.FileName)
+			assert.Equal(t, int64(10), buf.Pos)
+
+			meta, err := db.CellarMeta()
+			require.NoError(t, err)
+			assert.Equal(t, &MetaDto{}, meta)
+
+			require.NoError(t, db.SetCellarMeta(&MetaDto{MaxValSize: 42}))
+			meta, err = db.CellarMeta()
+			require.NoError(t, err)
+			assert.Equal(t, int64(42), meta.MaxValSize)
+
+			require.NoError(t, db.AddChunk(0, &ChunkDto{FileName: "first"}))
+			require.NoError(t, db.AddChunk(10, &ChunkDto{StartPos: 10, FileName: "second"}))
+			assert.Equal(t, ErrChunkExists, db.AddChunk(10, &ChunkDto{StartPos: 10, FileName: "duplicate"}))
+			require.NoError(t, db.PutChunk(10, &ChunkDto{StartPos: 10, FileName: "replaced"}))
+
+			chunks, err := db.ListChunks()
+			require.NoError(t, err)
+			var names []string
+			for _, c := range chunks {
+				names = append(names, c.FileName)
+			}
+			assert.ElementsMatch(t, []string{"first", "replaced"}, names)
+
+			// a taken position fails the whole group
+			err = db.AddChunks([]*ChunkDto{{StartPos: 20, FileName: "third"}, {StartPos: 10, FileName: "duplicate"}},
+				&BufferDto{FileName: "lost"})
+			assert.Equal(t, ErrChunkExists, err)
+			require.NoError(t, db.AddChunks([]*ChunkDto{{StartPos: 20, FileName: "third"}, {StartPos: 30, FileName: "fourth"}},
+				&BufferDto{FileName: "next", StartPos: 40}))
+
+			chunks, err = db.ListChunks()
+			require.NoError(t, err)
+			assert.Len(t, chunks, 4)
+			buf, err = db.GetBuffer()
+			require.NoError(t, err)
+			assert.Equal(t, "next", buf.FileName)
+
+			_, err = db.GetCheckpoint("missing")
+			assert.Equal(t, ErrCheckpointNotExists, err)
+
+			require.NoError(t, db.PutCheckpoint("name", 1))
+			require.NoError(t, db.PutCheckpoint("name", 2))
+			pos, err := db.GetCheckpoint("name")
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), pos)
+
+			require.NoError(t, db.PutCheckpoint("other", 3))
+			checkpoints, err := db.ListCheckpoints()
+			require.NoError(t, err)
+			assert.Equal(t, map[string]int64{"name": 2, "other": 3}, checkpoints)
+
+			require.NoError(t, db.DeleteCheckpoint("name"))
+			assert.Equal(t, ErrCheckpointNotExists, db.DeleteCheckpoint("name"))
+			checkpoints, err = db.ListCheckpoints()
+			require.NoError(t, err)
+			assert.Equal(t, map[string]int64{"other": 3}, checkpoints)
+
+			_, err = db.CasCheckpoint("name", 0, 1)
+			assert.Equal(t, ErrCheckpointNotExists, err)
+			swapped, err := db.CasCheckpoint("other", 2, 4)
+			require.NoError(t, err)
+			assert.False(t, swapped)
+			swapped, err = db.CasCheckpoint("other", 3, 4)
+			require.NoError(t, err)
+			assert.True(t, swapped)
+			pos, err = db.GetCheckpoint("other")
+			require.NoError(t, err)
+			assert.Equal(t, int64(4), pos)
+
+			_, ok, err := db.SeekTimeIndex(0)
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			require.NoError(t, db.PutTimeIndex(-5, 0))
+			require.NoError(t, db.PutTimeIndex(20, 10))
+			require.NoError(t, db.PutTimeIndex(20, 30))
+			require.NoError(t, db.PutTimeIndex(40, 50))
+
+			for ts, expected := range map[int64]int64{-4: 0, 20: 0, 21: 10, 40: 10, 100: 50} {
+				pos, ok, err = db.SeekTimeIndex(ts)
+				require.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, expected, pos, "timestamp %d", ts)
+			}
+			_, ok, err = db.SeekTimeIndex(-5)
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			index, err := db.ListTimeIndex()
+			require.NoError(t, err)
+			assert.Equal(t, map[int64]int64{-5: 0, 20: 10, 40: 50}, index)
+
+			indexedPos, err := db.KeyIndexPos()
+			require.NoError(t, err)
+			assert.Zero(t, indexedPos)
+			_, ok, err = db.GetKey([]byte("a"))
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			require.NoError(t, db.PutKeys(map[string]int64{"a": 10, "b": 20}, 30))
+			require.NoError(t, db.PutKeys(map[string]int64{"a": 40}, 50))
+			for key, expected := range map[string]int64{"a": 40, "b": 20} {
+				pos, ok, err = db.GetKey([]byte(key))
+				require.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, expected, pos, "key %s", key)
+			}
+			indexedPos, err = db.KeyIndexPos()
+			require.NoError(t, err)
+			assert.Equal(t, int64(50), indexedPos)
+
+			require.NoError(t, db.ClearKeys())
+			indexedPos, err = db.KeyIndexPos()
+			require.NoError(t, err)
+			assert.Zero(t, indexedPos)
+			_, ok, err = db.GetKey([]byte("a"))
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			// Init must not lose existing data
+			require.NoError(t, db.Init())
+			chunks, err = db.ListChunks()
+			require.NoError(t, err)
+			assert.Len(t, chunks, 4)
+		})
+	}
+}
+
+func TestMetaDB_ListChunksRange(t *testing.T) {
+	for name, db := range metaDBs() {
+		t.Run(name, func(t *testing.T) {
+			defer checkedClose(db)
+
+			// chunks of 10 bytes each, added out of order
+			for _, pos := range []int64{20, 0, 30, 10} {
+				require.NoError(t, db.AddChunk(pos, &ChunkDto{StartPos: pos, UncompressedByteSize: 10}))
+			}
+
+			starts := func(from, to int64, limit int) []int64 {
+				chunks, err := db.ListChunksRange(from, to, limit)
+				require.NoError(t, err)
+				res := []int64{}
+				for _, c := range chunks {
+					res = append(res, c.StartPos)
+				}
+				return res
+			}
+
+			assert.Equal(t, []int64{0, 10, 20, 30}, starts(0, 40, 0))
+			assert.Equal(t, []int64{10, 20}, starts(15, 25, 0))
+			assert.Equal(t, []int64{10, 20}, starts(10, 30, 0))
+			assert.Equal(t, []int64{30}, starts(35, 100, 0))
+			assert.Equal(t, []int64{0, 10}, starts(5, 40, 2))
+			assert.Equal(t, []int64{}, starts(40, 100, 0))
+		})
+	}
+}
+
+func TestMetaDB_DeleteChunk(t *testing.T) {
+	for name, db := range metaDBs() {
+		t.Run(name, func(t *testing.T) {
+			defer checkedClose(db)
+
+			for _, pos := range []int64{0, 10, 20} {
+				require.NoError(t, db.AddChunk(pos, &ChunkDto{StartPos: pos, UncompressedByteSize: 10}))
+			}
+
+			require.NoError(t, db.DeleteChunk(10))
+			// deleting again is a no-op
+			require.NoError(t, db.DeleteChunk(10))
+
+			chunks, err := db.ListChunks()
+			require.NoError(t, err)
+			var starts []int64
+			for _, c := range chunks {
+				starts = append(starts, c.StartPos)
+			}
+			assert.ElementsMatch(t, []int64{0, 20}, starts)
+
+			chunks, err = db.ListChunksRange(0, 30, 0)
+			require.NoError(t, err)
+			assert.Len(t, chunks, 2)
+		})
+	}
+}
+
+func TestMetaDB_ReplaceChunks(t *testing.T) {
+	for name, db := range metaDBs() {
+		t.Run(name, func(t *testing.T) {
+			defer checkedClose(db)
+
+			for _, pos := range []int64{0, 10, 20} {
+				require.NoError(t, db.AddChunk(pos, &ChunkDto{StartPos: pos, UncompressedByteSize: 10}))
+			}
+
+			merged := &ChunkDto{StartPos: 0, UncompressedByteSize: 20, FileName: "merged"}
+			require.NoError(t, db.ReplaceChunks([]int64{0, 10}, merged))
+
+			chunks, err := db.ListChunksRange(0, 30, 0)
+			require.NoError(t, err)
+			require.Len(t, chunks, 2)
+			assert.Equal(t, "merged", chunks[0].FileName)
+			assert.Equal(t, int64(20), chunks[1].StartPos)
+		})
+	}
+}
+
+func TestMetaDB_Truncate(t *testing.T) {
+	for name, db := range metaDBs() {
+		t.Run(name, func(t *testing.T) {
+			defer checkedClose(db)
+
+			require.NoError(t, db.SetCellarMeta(&MetaDto{MaxValSize: 42}))
+			require.NoError(t, db.AddChunks([]*ChunkDto{{StartPos: 0}, {StartPos: 10}}, &BufferDto{StartPos: 20}))
+			require.NoError(t, db.PutCheckpoint("user", 15))
+			require.NoError(t, db.PutTimeIndex(100, 10))
+			require.NoError(t, db.PutKeys(map[string]int64{"key": 10}, 20))
+
+			require.NoError(t, db.Truncate(&BufferDto{FileName: "empty"}))
+
+			chunks, err := db.ListChunks()
+			require.NoError(t, err)
+			assert.Empty(t, chunks)
+			checkpoints, err := db.ListCheckpoints()
+			require.NoError(t, err)
+			assert.Empty(t, checkpoints)
+			index, err := db.ListTimeIndex()
+			require.NoError(t, err)
+			assert.Empty(t, index)
+			_, ok, err := db.GetKey([]byte("key"))
+			require.NoError(t, err)
+			assert.False(t, ok)
+			pos, err := db.KeyIndexPos()
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), pos)
+
+			buf, err := db.GetBuffer()
+			require.NoError(t, err)
+			assert.Equal(t, "empty", buf.FileName)
+			assert.Equal(t, int64(0), buf.StartPos)
+			meta, err := db.CellarMeta()
+			require.NoError(t, err)
+			assert.Equal(t, int64(42), meta.MaxValSize)
+
+			// the meta DB stays usable
+			require.NoError(t, db.AddChunk(0, &ChunkDto{FileName: "new"}))
+			chunks, err = db.ListChunks()
+			require.NoError(t, err)
+			assert.Len(t, chunks, 1)
+		})
+	}
+}
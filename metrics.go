@@ -0,0 +1,35 @@
+package cellar
+
+// Metrics receives callbacks as records are appended, sealed and read, to bridge cellar to a metrics system
+// such as Prometheus. Writers call it while holding their lock, and readers from their scanning goroutines,
+// so implementations must be fast and safe for concurrent use.
+type Metrics interface {
+	// RecordAppended is called for every record appended, with the size of the record.
+	RecordAppended(bytes int64)
+	// BufferSealed is called for every buffer sealed into a chunk, with the size of the chunk before and
+	// after compression.
+	BufferSealed(compressed, uncompressed int64)
+	// ChunkCount is called with the number of sealed chunks whenever it changes, including by retention
+	// and compaction.
+	ChunkCount(chunks int64)
+	// RecordRead is called for every record passed to a reader op, with the size of the record.
+	RecordRead(bytes int64)
+}
+
+var _ Metrics = &NopMetrics{}
+
+// NopMetrics ignores all callbacks. It is used unless WithMetrics is given.
+type NopMetrics struct{}
+
+func (NopMetrics) RecordAppended(bytes int64)                  {}
+func (NopMetrics) BufferSealed(compressed, uncompressed int64) {}
+func (NopMetrics) ChunkCount(chunks int64)                     {}
+func (NopMetrics) RecordRead(bytes int64)                      {}
+
+// countReads wraps op to report every record read to metrics.
+func countReads(metrics Metrics, op ReadOp) ReadOp {
+	return func(info *ReaderInfo, data []byte) error {
+		metrics.RecordRead(int64(len(data)))
+		return op(info, data)
+	}
+}
@@ -0,0 +1,77 @@
+package cellar
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingMetrics struct {
+	mu *sync.Mutex
+
+	appended, appendedBytes int64
+	sealed, sealedBytes     int64
+	chunks                  int64
+	read, readBytes         int64
+}
+
+func (m *countingMetrics) RecordAppended(bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.appended++
+	m.appendedBytes += bytes
+}
+
+func (m *countingMetrics) BufferSealed(compressed, uncompressed int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sealed++
+	m.sealedBytes += uncompressed
+}
+
+func (m *countingMetrics) ChunkCount(chunks int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chunks = chunks
+}
+
+func (m *countingMetrics) RecordRead(bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.read++
+	m.readBytes += bytes
+}
+
+func TestDB_WithMetrics(t *testing.T) {
+	metrics := &countingMetrics{mu: &sync.Mutex{}}
+
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMetrics(metrics))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Append(genSeedBytes(100, 1))
+	require.NoError(t, err)
+	_, err = db.AppendBatch([][]byte{genSeedBytes(100, 2), genSeedBytes(100, 3)})
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+	pos, err := db.AppendFrom(bytes.NewReader(genSeedBytes(50, 4)), 50)
+	require.NoError(t, err)
+	require.NoError(t, db.Flush())
+
+	assert.Equal(t, int64(4), metrics.appended)
+	assert.Equal(t, int64(350), metrics.appendedBytes)
+	assert.Equal(t, int64(1), metrics.sealed)
+	assert.Equal(t, int64(306), metrics.sealedBytes)
+	assert.Equal(t, int64(1), metrics.chunks)
+
+	require.NoError(t, db.Reader().ForEach(func(*Rec) error { return nil }))
+	assert.Equal(t, int64(4), metrics.read)
+	assert.Equal(t, int64(350), metrics.readBytes)
+
+	_, err = db.Reader().ReadAt(pos)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), metrics.read)
+}
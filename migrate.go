@@ -0,0 +1,109 @@
+package cellar
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrMigrationMismatch = errors.New("cellar: migrated meta DB does not match its source")
+)
+
+// MigrateMeta copies all metadata of a cellar from src to dst: the chunks, the buffer state, the cellar
+// metadata, the user checkpoints and the time index. The chunk files themselves are left untouched, so a
+// cellar can switch its meta DB backend without rewriting any data. The cellar must not be written to during
+// the migration. The key index is not copied, but rebuilt when the cellar is opened with WithKeyIndex.
+//
+// Since every entry is overwritten in dst, an interrupted migration can simply be run again. Once done, dst
+// is verified to hold exactly the chunks, checkpoints and time index samples of src, so dst should start out
+// empty.
+func MigrateMeta(src, dst MetaDB) error {
+	chunks, err := src.ListChunks()
+	if err != nil {
+		return errors.Wrap(err, "ListChunks")
+	}
+	for _, c := range chunks {
+		if err = dst.PutChunk(c.StartPos, c); err != nil {
+			return errors.Wrapf(err, "PutChunk %d", c.StartPos)
+		}
+	}
+
+	checkpoints, err := src.ListCheckpoints()
+	if err != nil {
+		return errors.Wrap(err, "ListCheckpoints")
+	}
+	for name, pos := range checkpoints {
+		if err = dst.PutCheckpoint(name, pos); err != nil {
+			return errors.Wrapf(err, "PutCheckpoint %s", name)
+		}
+	}
+
+	index, err := src.ListTimeIndex()
+	if err != nil {
+		return errors.Wrap(err, "ListTimeIndex")
+	}
+	for ts, pos := range index {
+		if err = dst.PutTimeIndex(ts, pos); err != nil {
+			return errors.Wrapf(err, "PutTimeIndex %d", ts)
+		}
+	}
+
+	meta, err := src.CellarMeta()
+	if err != nil {
+		return errors.Wrap(err, "CellarMeta")
+	}
+	if err = dst.SetCellarMeta(meta); err != nil {
+		return errors.Wrap(err, "SetCellarMeta")
+	}
+
+	// the buffer goes last, since it marks the cellar as initialized
+	buffer, err := src.GetBuffer()
+	if err != nil {
+		return errors.Wrap(err, "GetBuffer")
+	}
+	if buffer != nil {
+		if err = dst.PutBuffer(buffer); err != nil {
+			return errors.Wrap(err, "PutBuffer")
+		}
+	}
+
+	return verifyMigration(dst, chunks, checkpoints, index, buffer)
+}
+
+func verifyMigration(dst MetaDB, chunks []*ChunkDto, checkpoints map[string]int64, index map[int64]int64,
+	buffer *BufferDto) error {
+	migrated, err := dst.ListChunks()
+	if err != nil {
+		return errors.Wrap(err, "ListChunks")
+	}
+	if len(migrated) != len(chunks) {
+		return errors.Wrapf(ErrMigrationMismatch, "%d chunks, expected %d", len(migrated), len(chunks))
+	}
+
+	migratedCheckpoints, err := dst.ListCheckpoints()
+	if err != nil {
+		return errors.Wrap(err, "ListCheckpoints")
+	}
+	if len(migratedCheckpoints) != len(checkpoints) {
+		return errors.Wrapf(ErrMigrationMismatch, "%d checkpoints, expected %d", len(migratedCheckpoints),
+			len(checkpoints))
+	}
+
+	migratedIndex, err := dst.ListTimeIndex()
+	if err != nil {
+		return errors.Wrap(err, "ListTimeIndex")
+	}
+	if len(migratedIndex) != len(index) {
+		return errors.Wrapf(ErrMigrationMismatch, "%d time index samples, expected %d", len(migratedIndex),
+			len(index))
+	}
+
+	migratedBuffer, err := dst.GetBuffer()
+	if err != nil {
+		return errors.Wrap(err, "GetBuffer")
+	}
+	if buffer != nil && !proto.Equal(buffer, migratedBuffer) {
+		return errors.Wrap(ErrMigrationMismatch, "buffer state differs")
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package cellar
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateMeta(t *testing.T) {
+	folder := getFolder()
+	src := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(src))
+	require.NoError(t, err)
+
+	inputs := []string{"first", "second", "third"}
+	for i, input := range inputs {
+		_, err = db.Append([]byte(input))
+		require.NoError(t, err)
+
+		if i < 2 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+	require.NoError(t, db.PutUserCheckpoint("consumer", 42))
+	require.NoError(t, db.Close())
+
+	dst := newBoltMetaDB()
+	require.NoError(t, MigrateMeta(src, dst))
+	// migrating again is harmless
+	require.NoError(t, MigrateMeta(src, dst))
+
+	db, err = New(folder, WithNoFileLock, WithMetaDB(dst))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	var found []string
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		found = append(found, string(rec.Data))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, inputs, found)
+
+	pos, err := db.GetUserCheckpoint("consumer")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), pos)
+}
+
+func TestMigrateMeta_Mismatch(t *testing.T) {
+	src := NewInMemoryMetaDB()
+	require.NoError(t, src.AddChunk(0, &ChunkDto{UncompressedByteSize: 10}))
+
+	dst := NewInMemoryMetaDB()
+	require.NoError(t, dst.AddChunk(10, &ChunkDto{StartPos: 10, UncompressedByteSize: 10}))
+
+	err := MigrateMeta(src, dst)
+	assert.Equal(t, ErrMigrationMismatch, errors.Cause(err))
+}
@@ -0,0 +1,144 @@
+package cellar
+
+import (
+	"container/list"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// maxChunkMaps bounds the number of chunk files mapped at once. Beyond it, the least recently used mapping is
+// dropped, and unmapped once no read uses it anymore.
+const maxChunkMaps = 256
+
+// chunkMaps holds the memory mappings of sealed chunk files, keyed by their path. Since sealed chunks are
+// never modified, a mapping stays valid while it is in use, even once the file is removed. The writer drops
+// the mappings of the chunk files retention, compaction and truncation remove, see remove. If mapping a file
+// fails, for example on platforms without mmap support, mapping is disabled and chunks are read from their
+// files instead.
+type chunkMaps struct {
+	mu *sync.Mutex
+
+	maps     map[string]*chunkMap
+	lru      *list.List
+	max      int
+	disabled bool
+}
+
+// chunkMap is the mapping of a chunk file, along with the number of reads using it.
+type chunkMap struct {
+	loc  string
+	data []byte
+	refs int
+
+	// dropped is set once the mapping is no longer in chunkMaps, so the last read using it unmaps it
+	dropped bool
+	el      *list.Element
+}
+
+func newChunkMaps() *chunkMaps {
+	return &chunkMaps{
+		mu:   &sync.Mutex{},
+		maps: make(map[string]*chunkMap),
+		lru:  list.New(),
+		max:  maxChunkMaps,
+	}
+}
+
+// get returns the mapping of the chunk file at loc, mapping it on first use, along with a func releasing it
+// once the caller is done with the data. It returns false once mapping is disabled, or if the file can't be
+// opened, so the caller reports the error of its own read. The failure disabling mapping is logged to logger.
+func (m *chunkMaps) get(loc string, logger Logger) ([]byte, func(), bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.disabled {
+		return nil, nil, false
+	}
+
+	cm, ok := m.maps[loc]
+	if ok {
+		m.lru.MoveToFront(cm.el)
+	} else {
+		f, err := os.Open(loc)
+		if err != nil {
+			return nil, nil, false
+		}
+		defer f.Close()
+
+		data, err := mmapFile(f)
+		if err != nil {
+			logger.Printf("cellar: can't map chunk %s, reading chunk files instead: %s", loc, err)
+			m.disabled = true
+			return nil, nil, false
+		}
+
+		cm = &chunkMap{loc: loc, data: data}
+		cm.el = m.lru.PushFront(cm)
+		m.maps[loc] = cm
+		for m.lru.Len() > m.max {
+			if err = m.drop(m.lru.Back().Value.(*chunkMap)); err != nil {
+				logger.Printf("cellar: %s", err)
+			}
+		}
+	}
+
+	cm.refs++
+	return cm.data, func() { m.release(cm, logger) }, true
+}
+
+// release ends a read of the mapping cm, unmapping it if it was dropped meanwhile.
+func (m *chunkMaps) release(cm *chunkMap, logger Logger) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cm.refs--
+	if cm.dropped && cm.refs == 0 {
+		if err := munmap(cm.data); err != nil {
+			logger.Printf("cellar: munmap %s: %s", cm.loc, err)
+		}
+	}
+}
+
+// drop removes the mapping cm, which is unmapped right away unless a read still uses it.
+func (m *chunkMaps) drop(cm *chunkMap) error {
+	m.lru.Remove(cm.el)
+	delete(m.maps, cm.loc)
+	cm.dropped = true
+
+	if cm.refs > 0 {
+		return nil
+	}
+	if err := munmap(cm.data); err != nil {
+		return errors.Wrapf(err, "munmap %s", cm.loc)
+	}
+	return nil
+}
+
+// remove drops the mapping of the chunk file at loc, if it is mapped, so the file is unmapped once it is
+// removed. A chunk file written under the same path later is mapped afresh.
+func (m *chunkMaps) remove(loc string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cm, ok := m.maps[loc]; ok {
+		return m.drop(cm)
+	}
+	return nil
+}
+
+// Close unmaps all chunk files, except those still used by reads, which are unmapped once the reads end.
+// Chunks mapped afterwards are kept until the next Close.
+func (m *chunkMaps) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var err error
+	for _, cm := range m.maps {
+		if derr := m.drop(cm); derr != nil && err == nil {
+			err = derr
+		}
+	}
+	return err
+}
@@ -0,0 +1,18 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package cellar
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// mmapFile always fails, so chunks are read from their files.
+func mmapFile(f *os.File) ([]byte, error) {
+	return nil, errors.New("mmap not supported on this platform")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,200 @@
+package cellar
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_MmapReads(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMaxBufferSize(1000), WithMmapReads())
+	require.NoError(t, err)
+
+	var positions []int64
+	for i := 0; i < 5; i++ {
+		pos, err := db.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+		positions = append(positions, pos)
+	}
+	require.NoError(t, db.Flush())
+
+	var seeds []int
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		assert.NoError(t, checkSeedBytes(rec.Data, int(rec.Data[0])))
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, seeds)
+	assert.Len(t, db.mmaps.maps, 2)
+
+	// mapped chunks stay readable once their file is removed
+	chunks, err := db.Reader().sortedChunks()
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(path.Join(folder, chunks[0].FileName)))
+
+	rec, err := db.Reader().ReadAt(positions[1])
+	require.NoError(t, err)
+	assert.Equal(t, genSeedBytes(400, 1), rec.Data)
+
+	require.NoError(t, db.Close())
+	assert.Empty(t, db.mmaps.maps)
+}
+
+func TestChunkMaps_Disabled(t *testing.T) {
+	folder := getFolder()
+	require.NoError(t, os.MkdirAll(folder, 0700))
+
+	// empty files can't be mapped, which disables mapping altogether
+	empty := path.Join(folder, "empty")
+	require.NoError(t, ioutil.WriteFile(empty, nil, 0600))
+
+	maps := newChunkMaps()
+	_, _, ok := maps.get(path.Join(folder, "missing"), stdLogger{})
+	assert.False(t, ok)
+	assert.False(t, maps.disabled)
+
+	_, _, ok = maps.get(empty, stdLogger{})
+	assert.False(t, ok)
+	assert.True(t, maps.disabled)
+	require.NoError(t, maps.Close())
+}
+
+func TestChunkMaps_LRU(t *testing.T) {
+	folder := getFolder()
+	require.NoError(t, os.MkdirAll(folder, 0700))
+
+	var locs []string
+	for i := 0; i < 3; i++ {
+		loc := path.Join(folder, fmt.Sprintf("chunk%d", i))
+		require.NoError(t, ioutil.WriteFile(loc, genSeedBytes(100, i), 0600))
+		locs = append(locs, loc)
+	}
+
+	maps := newChunkMaps()
+	maps.max = 2
+
+	first, release, ok := maps.get(locs[0], stdLogger{})
+	require.True(t, ok)
+	for _, loc := range locs[1:] {
+		_, done, ok := maps.get(loc, stdLogger{})
+		require.True(t, ok)
+		done()
+	}
+
+	// the least recently used mapping is dropped, but stays mapped while it is read
+	assert.Len(t, maps.maps, 2)
+	assert.NotContains(t, maps.maps, locs[0])
+	assert.NoError(t, checkSeedBytes(first, 0))
+	release()
+
+	// mappings which are used again are kept
+	_, done, ok := maps.get(locs[1], stdLogger{})
+	require.True(t, ok)
+	done()
+	_, done, ok = maps.get(locs[0], stdLogger{})
+	require.True(t, ok)
+	done()
+	assert.Contains(t, maps.maps, locs[0])
+	assert.Contains(t, maps.maps, locs[1])
+
+	require.NoError(t, maps.remove(locs[1]))
+	assert.Len(t, maps.maps, 1)
+	require.NoError(t, maps.Close())
+	assert.Empty(t, maps.maps)
+}
+
+func TestDB_MmapReads_RemovedChunks(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMmapReads())
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	for i := 0; i < 2; i++ {
+		_, err = db.Append(genSeedBytes(50, i))
+		require.NoError(t, err)
+		require.NoError(t, db.SealTheBuffer())
+	}
+	require.NoError(t, db.Reader().ForEach(func(rec *Rec) error { return nil }))
+	assert.Len(t, db.mmaps.maps, 2)
+
+	// the files of compacted chunks are unmapped once they are removed by the next Compact
+	_, err = db.Compact(2, 1000)
+	require.NoError(t, err)
+	_, err = db.Compact(2, 1000)
+	require.NoError(t, err)
+	assert.Empty(t, db.mmaps.maps)
+
+	require.NoError(t, db.Reader().ForEach(func(rec *Rec) error { return nil }))
+	assert.Len(t, db.mmaps.maps, 1)
+}
+
+func TestOpenReadOnly_MmapReads(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder, WithNoFileLock, WithMaxBufferSize(1000))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = db.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	r, err := OpenReadOnly(folder, WithMmapReads())
+	require.NoError(t, err)
+
+	count := 0
+	err = r.ForEach(func(rec *Rec) error {
+		count++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.Len(t, r.mmaps.maps, 1)
+
+	require.NoError(t, r.Close())
+	assert.Empty(t, r.mmaps.maps)
+}
+
+// BenchmarkReader_ReadAt reads records at random positions from chunk files, while
+// BenchmarkReader_ReadAt_Mmap decompresses them from memory mappings.
+func BenchmarkReader_ReadAt(b *testing.B) {
+	benchmarkReaderReadAt(b)
+}
+
+func BenchmarkReader_ReadAt_Mmap(b *testing.B) {
+	benchmarkReaderReadAt(b, WithMmapReads())
+}
+
+func benchmarkReaderReadAt(b *testing.B, options ...Option) {
+	options = append(options, WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMaxBufferSize(64*1024))
+	db, err := New(getFolder(), options...)
+	require.NoError(b, err)
+
+	defer checkedClose(db)
+
+	var positions []int64
+	for i := 0; i < 1000; i++ {
+		pos, err := db.Append(genSeedBytes(6000, i))
+		require.NoError(b, err)
+		positions = append(positions, pos)
+	}
+	require.NoError(b, db.SealTheBuffer())
+
+	reader := db.Reader()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = reader.ReadAt(positions[(i*7919)%len(positions)])
+		require.NoError(b, err)
+	}
+}
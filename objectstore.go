@@ -0,0 +1,224 @@
+package cellar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrObjectReadOnly = errors.New("cellar: sealed chunk objects can't be modified")
+
+// ObjectStore holds objects under keys, such as the objects of an S3-compatible bucket. Implementations
+// adapt the client of the store, and must be safe for concurrent use. Missing objects fail with an error
+// satisfying os.IsNotExist, such as os.ErrNotExist.
+type ObjectStore interface {
+	// Get returns the content of the object, which the caller closes.
+	Get(key string) (io.ReadCloser, error)
+	// Put stores the size bytes read from r as the object, replacing it if it exists.
+	Put(key string, r io.Reader, size int64) error
+	// Delete removes the object.
+	Delete(key string) error
+	// Size returns the size of the object in bytes.
+	Size(key string) (int64, error)
+}
+
+var _ FileSystem = &ObjectStoreFS{} // compile time assertion to verify we match the interface FileSystem
+
+// ObjectStoreFS is a FileSystem storing the sealed chunk files of the cellar in folder as objects of an
+// ObjectStore, keyed by their names within folder, while the buffer and everything else stays on disk. Since
+// chunks are immutable once sealed, they are written to a spool file on disk, and uploaded when the seal
+// closes the chunk file, before the chunk is recorded in the meta DB; a failed seal deletes the object
+// again. Reads fetch the whole object, so repeated reads are best served from a read cache, see
+// WithReadCache.
+//
+// Stores which are only eventually consistent may fail to return a chunk shortly after it was sealed, in
+// which case reads fail with ErrChunkFileMissing, or skip the chunk with Reader.SkipMissingChunks, until the
+// object shows up. Likewise, chunks removed by retention or compaction may still be listed by the store for
+// a while. RebuildMeta and Snapshot destinations work on disk only.
+type ObjectStoreFS struct {
+	store  ObjectStore
+	folder string
+	local  FileSystem
+}
+
+// NewObjectStoreFS returns the FileSystem for the cellar in folder, storing its sealed chunks in store. Chunks
+// are spooled in the default directory for temporary files while they are written.
+func NewObjectStoreFS(folder string, store ObjectStore) *ObjectStoreFS {
+	return &ObjectStoreFS{
+		store:  store,
+		folder: path.Clean(folder),
+		local:  osFS{},
+	}
+}
+
+// object returns the key of name, and whether name is a chunk file kept in the store.
+func (o *ObjectStoreFS) object(name string) (string, bool) {
+	name = path.Clean(name)
+	if !strings.HasSuffix(name, ".lz4") {
+		return "", false
+	}
+	return strings.TrimPrefix(name, o.folder+"/"), true
+}
+
+func (o *ObjectStoreFS) Open(name string) (File, error) {
+	key, ok := o.object(name)
+	if !ok {
+		return o.local.Open(name)
+	}
+
+	rc, err := o.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get %s", key)
+	}
+	return &objectFile{Reader: bytes.NewReader(data), name: name}, nil
+}
+
+func (o *ObjectStoreFS) Create(name string) (File, error) {
+	key, ok := o.object(name)
+	if !ok {
+		return o.local.Create(name)
+	}
+
+	f, err := ioutil.TempFile("", "cellar-chunk-")
+	if err != nil {
+		return nil, errors.Wrap(err, "TempFile")
+	}
+	return &spoolFile{File: f, name: name, key: key, store: o.store}, nil
+}
+
+// OpenFile opens files on disk; chunk objects can only be opened by Open and Create.
+func (o *ObjectStoreFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if _, ok := o.object(name); ok {
+		return nil, errors.Wrapf(ErrObjectReadOnly, "open %s", name)
+	}
+	return o.local.OpenFile(name, flag, perm)
+}
+
+func (o *ObjectStoreFS) Remove(name string) error {
+	key, ok := o.object(name)
+	if !ok {
+		return o.local.Remove(name)
+	}
+	return o.store.Delete(key)
+}
+
+func (o *ObjectStoreFS) Stat(name string) (os.FileInfo, error) {
+	key, ok := o.object(name)
+	if !ok {
+		return o.local.Stat(name)
+	}
+
+	size, err := o.store.Size(key)
+	if err != nil {
+		return nil, err
+	}
+	return objectInfo{name: path.Base(name), size: size}, nil
+}
+
+// Rename renames files on disk; chunk objects are never renamed.
+func (o *ObjectStoreFS) Rename(oldname, newname string) error {
+	_, oldObject := o.object(oldname)
+	_, newObject := o.object(newname)
+	if oldObject || newObject {
+		return errors.Wrapf(ErrObjectReadOnly, "rename %s", oldname)
+	}
+	return o.local.Rename(oldname, newname)
+}
+
+// MkdirAll creates the directory on disk, where the buffer of a sharded cellar may need it.
+func (o *ObjectStoreFS) MkdirAll(dir string, perm os.FileMode) error {
+	return o.local.MkdirAll(dir, perm)
+}
+
+// objectFile is a chunk object fetched from the store.
+type objectFile struct {
+	*bytes.Reader
+	name string
+}
+
+func (f *objectFile) Name() string {
+	return f.name
+}
+
+func (f *objectFile) Stat() (os.FileInfo, error) {
+	return objectInfo{name: path.Base(f.name), size: f.Size()}, nil
+}
+
+func (f *objectFile) Write([]byte) (int, error) {
+	return 0, ErrObjectReadOnly
+}
+
+func (f *objectFile) WriteAt([]byte, int64) (int, error) {
+	return 0, ErrObjectReadOnly
+}
+
+func (f *objectFile) Truncate(int64) error {
+	return ErrObjectReadOnly
+}
+
+func (f *objectFile) Sync() error {
+	return nil
+}
+
+func (f *objectFile) Close() error {
+	return nil
+}
+
+// spoolFile is a chunk being written, which is uploaded to the store when it is closed.
+type spoolFile struct {
+	*os.File
+	name  string
+	key   string
+	store ObjectStore
+}
+
+func (f *spoolFile) Name() string {
+	return f.name
+}
+
+// Close uploads the chunk, and removes the spool file.
+func (f *spoolFile) Close() (err error) {
+	defer func() {
+		if cerr := f.File.Close(); cerr != nil && err == nil {
+			err = errors.Wrap(cerr, "close spool file")
+		}
+		os.Remove(f.File.Name())
+	}()
+
+	size, err := f.File.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrap(err, "Seek")
+	}
+	if _, err = f.File.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "Seek")
+	}
+	if err = f.store.Put(f.key, f.File, size); err != nil {
+		return errors.Wrapf(err, "put %s", f.key)
+	}
+	return nil
+}
+
+// objectInfo describes a chunk object.
+type objectInfo struct {
+	name string
+	size int64
+}
+
+func (i objectInfo) Name() string       { return i.name }
+func (i objectInfo) Size() int64        { return i.size }
+func (i objectInfo) Mode() os.FileMode  { return 0444 }
+func (i objectInfo) ModTime() time.Time { return time.Time{} }
+func (i objectInfo) IsDir() bool        { return false }
+func (i objectInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,163 @@
+package cellar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is an ObjectStore keeping objects in memory, standing in for an S3-compatible bucket.
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	gets    int
+	failPut bool
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: make(map[string][]byte)}
+}
+
+func (s *memStore) Get(key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	s.gets++
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memStore) Put(key string, r io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size {
+		return errors.Errorf("read %d bytes, expected %d", len(data), size)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failPut {
+		return errors.New("bucket unavailable")
+	}
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[key]; !ok {
+		return os.ErrNotExist
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *memStore) Size(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.objects[key]
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	return int64(len(data)), nil
+}
+
+func (s *memStore) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestDB_ObjectStoreFS(t *testing.T) {
+	folder := getFolder()
+	store := newMemStore()
+
+	db, err := New(folder, WithFileSystem(NewObjectStoreFS(folder, store)), WithCompressor(Lz4Compressor{}),
+		WithDirSharding(1), WithReadCache(1<<20), WithMmapReads())
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	var positions []int64
+	for i := 0; i < 20; i++ {
+		pos, err := db.Append([]byte(fmt.Sprintf("record %d", i)))
+		require.NoError(t, err)
+		positions = append(positions, pos)
+		if i%10 == 9 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+	assert.Equal(t, []string{"000/000000000000.lz4", "000/000000000090.lz4"}, store.keys())
+
+	// only the buffer and the meta DB are on disk
+	err = filepath.Walk(folder, func(loc string, info os.FileInfo, err error) error {
+		assert.False(t, strings.HasSuffix(loc, ".lz4"), loc)
+		return err
+	})
+	require.NoError(t, err)
+
+	var records []string
+	require.NoError(t, db.Reader().ForEach(func(rec *Rec) error {
+		records = append(records, string(rec.Data))
+		return nil
+	}))
+	require.Len(t, records, 20)
+	assert.Equal(t, "record 19", records[19])
+
+	// repeated reads are served from the read cache
+	var gets int
+	for i := 0; i < 3; i++ {
+		rec, err := db.Reader().ReadAt(positions[12])
+		require.NoError(t, err)
+		assert.Equal(t, "record 12", string(rec.Data))
+		if i == 0 {
+			gets = store.gets
+		}
+	}
+	assert.Equal(t, gets, store.gets)
+
+	report, err := db.Reader().Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Bad)
+
+	// a failed upload keeps the buffer
+	_, err = db.Append([]byte("unsealed"))
+	require.NoError(t, err)
+	store.failPut = true
+	assert.Error(t, db.SealTheBuffer())
+	store.failPut = false
+	assert.Len(t, store.keys(), 2)
+	require.NoError(t, db.SealTheBuffer())
+	assert.Len(t, store.keys(), 3)
+
+	// retention deletes the objects
+	_, err = db.TrimToBytes(0)
+	require.NoError(t, err)
+	assert.Empty(t, store.keys())
+}
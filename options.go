@@ -1,15 +1,490 @@
 package cellar
 
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
 type Option func(db *DB) error
 
-// WithCipher allows for customizing the read/write encryption.
+// WithCipher allows for customizing the read/write encryption. A nil cipher disables encryption.
 func WithCipher(cipher Cipher) Option {
 	return func(db *DB) error {
+		if cipher == nil {
+			cipher = NoCipher{}
+		}
 		db.cipher = cipher
 		return nil
 	}
 }
 
+// WithKeyring encrypts new chunks with AES-256-GCM under the key of activeID, while chunks encrypted under the
+// other keys remain readable. See Keyring.
+func WithKeyring(keys map[string][]byte, activeID string) Option {
+	return func(db *DB) error {
+		keyring, err := NewKeyring(keys, activeID)
+		if err != nil {
+			return errors.Wrap(err, "NewKeyring")
+		}
+		db.cipher = keyring
+		return nil
+	}
+}
+
+// WithPassphrase encrypts the DB with AES-256-GCM, using a key derived from the passphrase by DeriveKey. The
+// salt is generated when the DB is first opened with a passphrase, and stored in the meta DB so the same
+// passphrase reproduces the key on reopen.
+//
+// Changing the passphrase of an existing DB requires re-encrypting all its chunks, since they remain
+// encrypted with the key of the old passphrase.
+func WithPassphrase(passphrase string) Option {
+	return func(db *DB) error {
+		if passphrase == "" {
+			return errors.New("cellar: empty passphrase")
+		}
+		db.passphrase = passphrase
+		return nil
+	}
+}
+
+// WithRecordChecksums follows the length prefix of every record with its CRC32, which is verified whenever
+// the record is read, so corrupted records fail with ErrChecksumMismatch instead of returning garbage. The
+// setting is recorded in the meta DB when the cellar is created, and applies for its whole lifetime; enabling
+// it on a cellar which already holds records fails with ErrChecksumsEnabled.
+func WithRecordChecksums() Option {
+	return func(db *DB) error {
+		db.recordChecksums = true
+		return nil
+	}
+}
+
+// WithRecordTimestamps starts the body of every record with the time it was appended at, encoded as varint
+// unix nanos, and keeps the range of timestamps of every chunk so Reader.ScanTimeRange can skip chunks.
+// Records are stamped with the time passed to Writer.AppendAt, or with the time of the append. Like
+// WithRecordChecksums, the setting is recorded in the meta DB when the cellar is created; enabling it on a
+// cellar which already holds records fails with ErrTimestampsEnabled.
+func WithRecordTimestamps() Option {
+	return func(db *DB) error {
+		db.recordTimestamps = true
+		return nil
+	}
+}
+
+// WithLogger routes the warnings logged by the DB, its writer and its readers to logger, instead of the
+// standard logger of the log package. A nil logger discards them.
+func WithLogger(logger Logger) Option {
+	return func(db *DB) error {
+		if logger == nil {
+			logger = discardLogger
+		}
+		db.logger = logger
+		return nil
+	}
+}
+
+// WithMetrics reports appended, sealed and read records to metrics. A nil metrics disables reporting.
+func WithMetrics(metrics Metrics) Option {
+	return func(db *DB) error {
+		if metrics == nil {
+			metrics = NopMetrics{}
+		}
+		db.metrics = metrics
+		return nil
+	}
+}
+
+// WithTraceHook traces the steps of sealing a buffer through hook, see the Span constants. A nil hook
+// disables tracing.
+func WithTraceHook(hook TraceHook) Option {
+	return func(db *DB) error {
+		if hook == nil {
+			hook = nopTrace{}
+		}
+		db.trace = hook
+		return nil
+	}
+}
+
+// WithRepairTruncated repairs a buffer file which is shorter than its checkpointed position when the DB is
+// opened, for example after a crash of the file system, instead of failing with ErrBufferTruncated. The
+// position is rewound to the end of the last complete record in the file, discarding the records which were
+// lost.
+func WithRepairTruncated() Option {
+	return func(db *DB) error {
+		db.repairTruncated = true
+		return nil
+	}
+}
+
+// WithVerifyOnRead makes readers of the DB verify the checksum of every chunk file they load, so corrupted
+// chunks fail with ErrChunkCorrupted before they are decrypted or decompressed. Chunks found in the read
+// cache are not verified again.
+func WithVerifyOnRead() Option {
+	return func(db *DB) error {
+		db.verifyOnRead = true
+		return nil
+	}
+}
+
+// WithSkipMissingChunks makes scans of readers of the DB skip chunks whose file was removed out of band,
+// logging them, rather than failing with ErrChunkFileMissing. This lets tools salvage the rest of a damaged
+// cellar; ReadAt of a record in a missing chunk still fails.
+func WithSkipMissingChunks() Option {
+	return func(db *DB) error {
+		db.skipMissing = true
+		return nil
+	}
+}
+
+// WithDecryptionCiphers adds ciphers which are only used to read chunks encrypted with them. This allows
+// switching the cipher of an existing DB, as long as the previous cipher is passed here.
+func WithDecryptionCiphers(ciphers ...Cipher) Option {
+	return func(db *DB) error {
+		if db.ciphers == nil {
+			db.ciphers = make(map[uint32]Cipher)
+		}
+		for _, c := range ciphers {
+			db.ciphers[c.Algorithm()] = c
+		}
+		return nil
+	}
+}
+
+// WithReadCache enables an LRU cache of decompressed chunks of at most bytes in size, shared by all readers
+// of the DB. This speeds up repeated random reads through Reader.ReadAt.
+func WithReadCache(bytes int64) Option {
+	return func(db *DB) error {
+		db.cache = newChunkCache(bytes)
+		return nil
+	}
+}
+
+// WithMmapReads makes readers of the DB memory-map sealed chunk files and decompress them straight from the
+// mapping, rather than reading them into the heap first. Mappings are shared by all readers of the DB and kept
+// for repeated random reads, up to the 256 most recently used chunk files. They are released once their chunk
+// is removed by retention, compaction or truncation, and by DB.Close, or by Reader.Close for readers returned
+// from OpenReadOnly. On platforms where mapping fails, chunks are read from their files.
+func WithMmapReads() Option {
+	return func(db *DB) error {
+		db.mmaps = newChunkMaps()
+		return nil
+	}
+}
+
+// WithScanConcurrency sets the number of chunks decompressed concurrently by scans of readers created from
+// the DB. Records are still returned in position order.
+func WithScanConcurrency(n int) Option {
+	return func(db *DB) error {
+		if n < 1 {
+			return errors.New("cellar: scan concurrency must be at least 1")
+		}
+		db.scanConcurrency = n
+		return nil
+	}
+}
+
+// WithMaxInFlightChunks bounds the number of decompressed chunks held by scans of readers created from the DB
+// to n, including the chunk being replayed, so scans feeding slow consumers, such as ScanAsync, decompress at
+// most n-1 chunks ahead of them. See Reader.MaxInFlightChunks.
+func WithMaxInFlightChunks(n int) Option {
+	return func(db *DB) error {
+		if n < 1 {
+			return errors.New("cellar: max in-flight chunks must be at least 1")
+		}
+		db.maxInFlightChunks = n
+		return nil
+	}
+}
+
+// WithAutoFlush checkpoints the writer in the background every interval, so that appended records do not
+// linger in memory on low-throughput streams. The background routine is stopped by DB.Close.
+func WithAutoFlush(interval time.Duration) Option {
+	return func(db *DB) error {
+		if interval <= 0 {
+			return errors.New("cellar: auto flush interval must be positive")
+		}
+		db.autoFlush = interval
+		return nil
+	}
+}
+
+// WithDirSharding spreads new buffers and chunks over levels of subdirectories named after their position,
+// see shardedName, so cellars with millions of chunks do not end up in one huge directory. Levels range from
+// 0, which keeps all files in the folder of the cellar, to 3. The layout is stored in the meta DB and kept
+// when the cellar is reopened without the option. Chunks record the path they were sealed at, so readers
+// need no configuration, and the layout of an existing cellar can be changed at any time.
+func WithDirSharding(levels int) Option {
+	return func(db *DB) error {
+		if levels < 0 || levels > maxDirShardLevels {
+			return errors.Errorf("cellar: dir sharding levels must be between 0 and %d, got %d", maxDirShardLevels, levels)
+		}
+		db.dirShardLevels = levels
+		db.dirSharding = true
+		return nil
+	}
+}
+
+// WithMaxBufferSize sets the maximum size of the buffer in bytes, which is the uncompressed size of the
+// chunks it is sealed into.
+func WithMaxBufferSize(n int64) Option {
+	return func(db *DB) error {
+		if n <= 0 {
+			return errors.Errorf("cellar: max buffer size must be positive, got %d", n)
+		}
+		db.buffer = n
+		return nil
+	}
+}
+
+// WithMaxValueSize limits the size of a single record to n bytes. Larger records are rejected by Append with
+// ErrValueTooLarge before anything is written to the buffer.
+func WithMaxValueSize(n int64) Option {
+	return func(db *DB) error {
+		if n <= 0 {
+			return errors.New("cellar: max value size must be positive")
+		}
+		db.maxValueSize = n
+		return nil
+	}
+}
+
+// WithAutoCheckpoint checkpoints the writer from within Append once everyRecords records or everyBytes bytes
+// have been appended since the last checkpoint, keeping the buffer position in the meta DB close to the
+// actual one. A threshold of 0 is ignored.
+func WithAutoCheckpoint(everyRecords int64, everyBytes int64) Option {
+	return func(db *DB) error {
+		if everyRecords < 0 || everyBytes < 0 {
+			return errors.New("cellar: auto checkpoint thresholds must not be negative")
+		}
+		db.autoCheckpointRecords = everyRecords
+		db.autoCheckpointBytes = everyBytes
+		return nil
+	}
+}
+
+// WithDurability selects when the writer syncs the buffer file and sealed chunks to disk, trading the records
+// a power loss may take with it against throughput. DurabilityFlush, the default, syncs sealed chunks only;
+// DurabilityFsync syncs the buffer file on every checkpoint too, and DurabilityNone never syncs. A crash of the
+// process alone never loses checkpointed records, whatever the level.
+func WithDurability(level Durability) Option {
+	return func(db *DB) error {
+		switch level {
+		case DurabilityFlush, DurabilityNone, DurabilityFsync:
+		default:
+			return errors.Errorf("cellar: unknown durability level %d", level)
+		}
+		db.durability = level
+		return nil
+	}
+}
+
+// WithTempDir compresses seals of buffers larger than 64MB, a fixed threshold, into a temp file in dir first,
+// for example on a faster disk than the cellar. This only applies to ciphers which authenticate chunks as a
+// whole, such as AESGCM and ChaCha20, which hold the compressed chunk in memory while it is encrypted whether
+// or not a temp dir is set. What the temp file saves is the growth of that memory: compressed straight into
+// the cipher, the chunk is reallocated and copied as it grows, briefly holding up to about twice its size,
+// while read back from the temp file, it is allocated once at its final size. Other ciphers stream chunks
+// straight into their files, and are not affected. Seals in blocks use dir for their blocks, see WithBlockSize.
+// Temp files are removed once the seal succeeds or fails. Compaction and UpgradeFormat use dir as well.
+func WithTempDir(dir string) Option {
+	return func(db *DB) error {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return errors.Wrap(err, "cellar: temp dir")
+		}
+		if !info.IsDir() {
+			return errors.Errorf("cellar: temp dir %s is not a directory", dir)
+		}
+		db.tempDir = dir
+		return nil
+	}
+}
+
+// WithSealConcurrency compresses buffers larger than the block size in blocks, by up to n goroutines, rather
+// than in a single stream, so large seals use several cores. The block size is 4MB unless set by
+// WithBlockSize. The compressor must allow concurrent calls of Compress, as all compressors of this package
+// do. n must be at least 1; a concurrency of 1 compresses blocks one after the other, and chunks in a single
+// stream unless WithBlockSize is given.
+func WithSealConcurrency(n int) Option {
+	return func(db *DB) error {
+		if n < 1 {
+			return errors.Errorf("cellar: seal concurrency must be at least 1, got %d", n)
+		}
+		db.sealConcurrency = n
+		return nil
+	}
+}
+
+// WithBlockSize compresses buffers larger than bytes in blocks of about bytes, each ending at a record
+// boundary, by as many goroutines as WithSealConcurrency allows. The blocks are read from the buffer file in
+// order, and compressed into a temp file in the directory set by WithTempDir, or the default directory for
+// temp files, before the chunk file is written, so seals hold only the blocks being compressed in memory.
+// Compaction and UpgradeFormat seal in blocks as well.
+//
+// The layout of the blocks is recorded with the chunk and in its header, so readers decompress the blocks of
+// a chunk concurrently, and Reader.ReadAt and Reader.ScanFrom decompress only the blocks holding the records
+// they read, unless the reader has a read cache, which holds whole chunks. Small blocks speed up random reads
+// at the cost of the compression ratio. Chunks compressed with LZ4 or zstd remain readable by versions of
+// cellar without blocks, which decompress them as one stream, and chunks sealed without blocks are always
+// decompressed as a whole. bytes must be positive.
+func WithBlockSize(bytes int64) Option {
+	return func(db *DB) error {
+		if bytes <= 0 {
+			return errors.Errorf("cellar: block size must be positive, got %d", bytes)
+		}
+		db.blockSize = bytes
+		return nil
+	}
+}
+
+// WithFileSystem stores the buffer and chunk files of the cellar in fs rather than on disk, for example in
+// memory for tests. The lock file and the bolt meta DB are still kept in folder on disk, so cellars whose
+// folder exists only in fs are opened with WithNoFileLock and WithMetaDB. Chunks in fs are never memory
+// mapped, see WithMmapReads. Snapshots are written to disk, and RebuildMeta reads chunk files from disk.
+func WithFileSystem(fs FileSystem) Option {
+	return func(db *DB) error {
+		if fs == nil {
+			return errors.New("cellar: file system must not be nil")
+		}
+		db.fs = fs
+		return nil
+	}
+}
+
+// WithGroupCommit lets the seals of buffers within window share a single meta DB transaction, and thereby a
+// single fsync of the meta DB, instead of committing every seal on its own. This pays off under heavy
+// concurrent appends, where a seal happens every few appends. A background goroutine commits the pending
+// seals once per window.
+//
+// Appends which seal the buffer wait for the commit of their seal, and fail with ErrCommitTimeout if it takes
+// more than ten windows, or with ErrCommitFailed if the commit fails; the record is appended regardless, so it
+// must not be appended again. Failed commits are retried with the next window. Records after a pending seal
+// become visible to readers once it is committed. Checkpoints, explicit seals and Close commit pending seals
+// right away.
+func WithGroupCommit(window time.Duration) Option {
+	return func(db *DB) error {
+		if window <= 0 {
+			return errors.New("cellar: group commit window must be positive")
+		}
+		db.groupCommit = window
+		return nil
+	}
+}
+
+// WithCompressor sets the compressor used when sealing buffers. Since every chunk records the codec it was
+// written with, the compressor can be changed over the lifetime of a cellar. A nil compressor disables
+// compression.
+func WithCompressor(compressor Compressor) Option {
+	return func(db *DB) error {
+		if compressor == nil {
+			compressor = NoCompressor{}
+		}
+		db.compressor = compressor
+		return nil
+	}
+}
+
+// WithCompressorFactory calls factory for the compressor of every seal, and of every compaction, so the
+// compressor or its level can be changed while the writer is open, for example to trade CPU for ratio based
+// on load. The factory is called under the writer lock and must be fast, and safe to call from any
+// goroutine. It takes precedence over WithCompressor and WithCodec; a nil compressor fails the seal.
+func WithCompressorFactory(factory func() Compressor) Option {
+	return func(db *DB) error {
+		if factory == nil {
+			return errors.New("cellar: compressor factory must not be nil")
+		}
+		db.compressorFactory = factory
+		return nil
+	}
+}
+
+// WithKeyExtractor builds a bloom filter over the keys of the records of every chunk sealed or compacted from
+// now on, which Reader.MayContain uses to skip chunks which cannot hold a key. The extractor returns the key of
+// a record, or an empty key for records without one, and is called with the data of every record when its
+// chunk is sealed, so it must be fast. The filters take about 10 bits per record in the meta DB.
+func WithKeyExtractor(extractor func([]byte) []byte) Option {
+	return func(db *DB) error {
+		if extractor == nil {
+			return errors.New("cellar: key extractor must not be nil")
+		}
+		db.keyExtractor = extractor
+		return nil
+	}
+}
+
+// WithKeyIndex maintains an index in the meta DB from the key of every record, as extracted by the function
+// passed to WithKeyExtractor, to the position of the latest record with the key, which Reader.GetByKey looks
+// up. A record appended later with the same key replaces the earlier one in the index, which turns the
+// cellar into an append-only key/value store.
+//
+// Keys are indexed as records are flushed, and stored in the meta DB with every checkpoint and seal. When the
+// DB is opened, the records not yet indexed are scanned, so a missing index, for example of a cellar which
+// did not use a key index before, is rebuilt, at the cost of reading the whole cellar once.
+func WithKeyIndex() Option {
+	return func(db *DB) error {
+		db.keyIndex = true
+		return nil
+	}
+}
+
+// WithCodec selects the compressor used when sealing buffers by its codec id, looking it up in the
+// compressor registry. An explicit WithCompressor takes precedence.
+func WithCodec(id uint32) Option {
+	return func(db *DB) error {
+		db.codec = id
+		db.useCodec = true
+		return nil
+	}
+}
+
+// WithCompressionDict compresses new chunks with zstd using the dictionary, built by TrainDictionary, which
+// shrinks cellars of small, similar records considerably. The id of the dictionary is recorded in every chunk
+// compressed with it, and the dictionary is needed to read those chunks again, so it must be kept along with
+// the cellar and passed whenever it is opened. After switching to a new dictionary, pass the previous ones to
+// WithDecompressionDicts.
+//
+// The dictionary applies to the zstd compressor, which it selects unless WithCompressor or WithCodec select
+// another one, which fails.
+func WithCompressionDict(dict []byte) Option {
+	return func(db *DB) error {
+		if err := WithDecompressionDicts(dict)(db); err != nil {
+			return err
+		}
+		db.dict = dict
+		return nil
+	}
+}
+
+// WithDecompressionDicts adds zstd dictionaries which are only used to read chunks compressed with them, see
+// WithCompressionDict.
+func WithDecompressionDicts(dicts ...[]byte) Option {
+	return func(db *DB) error {
+		if db.dicts == nil {
+			db.dicts = make(map[uint32][]byte)
+		}
+		for _, d := range dicts {
+			id, err := DictionaryID(d)
+			if err != nil {
+				return err
+			}
+			db.dicts[id] = d
+		}
+		return nil
+	}
+}
+
+// WithCompressorRegistry sets the registry used to find compressors by codec id, and the decompressor for
+// every chunk. It defaults to the package level registry extended through RegisterCompressor and
+// RegisterDecompressor.
+func WithCompressorRegistry(registry *CompressorRegistry) Option {
+	return func(db *DB) error {
+		db.registry = registry
+		return nil
+	}
+}
+
 func WithMetaDB(mdb MetaDB) Option {
 	return func(db *DB) error {
 		db.meta = mdb
@@ -17,6 +492,19 @@ func WithMetaDB(mdb MetaDB) Option {
 	}
 }
 
+// WithOpenTimeout sets how long opening the cellar waits for another process to release its lock file, and
+// its bolt meta DB, before failing with a LockedError. By default, a held lock file fails right away, and the
+// bolt meta DB is waited for for a second.
+func WithOpenTimeout(d time.Duration) Option {
+	return func(db *DB) error {
+		if d <= 0 {
+			return errors.Errorf("cellar: open timeout must be positive, got %s", d)
+		}
+		db.openTimeout = d
+		return nil
+	}
+}
+
 // MockLock mocks a flock (filelock)
 type MockLock struct{}
 
@@ -0,0 +1,91 @@
+package cellar
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// pinnedMetaDB serves the chunks and buffer captured by Reader.Pin, and passes everything else, such as the
+// cellar metadata and the time index, on to the meta DB of the cellar.
+type pinnedMetaDB struct {
+	MetaDB
+
+	// chunks are ordered by their start position
+	chunks []*ChunkDto
+	buffer *BufferDto
+
+	// unpinned is the buffer function of the reader before it was pinned
+	unpinned func() (*BufferDto, error)
+}
+
+func (p *pinnedMetaDB) GetBuffer() (*BufferDto, error) {
+	return p.buffer, nil
+}
+
+func (p *pinnedMetaDB) ListChunks() ([]*ChunkDto, error) {
+	// callers sort the chunks in place
+	return append([]*ChunkDto(nil), p.chunks...), nil
+}
+
+func (p *pinnedMetaDB) ListChunksRange(fromPos, toPos int64, limit int) ([]*ChunkDto, error) {
+	return chunksInRange(p.chunks, fromPos, toPos, limit), nil
+}
+
+// Pin pins the reader to a snapshot of the cellar: the sealed chunks as of now, up to SealedPos. Seals and
+// appends made afterwards do not change what the reader sees, however long its scans take, since the reader
+// no longer reads the chunk list from the meta DB. Records in the buffer are not part of the snapshot, as
+// the buffer is replaced when it is sealed; call SealTheBuffer first to include them.
+//
+// Chunk files are immutable, but compaction and retention remove the files of the chunks they replace or
+// delete. Scans of a pinned reader then fail with ErrChunkFileMissing, unless SkipMissingChunks is set.
+//
+// Pinning a pinned reader takes a new snapshot. Like Reset, Pin and Unpin must not be called while a scan of
+// the reader is running.
+func (r *Reader) Pin() error {
+	r.Unpin()
+
+	// the buffer is read before the chunks, so a seal in between shows up as a chunk past the buffer start
+	b, err := r.buffer()
+	if err != nil {
+		return err
+	}
+	chunks, err := r.metadb.ListChunks()
+	if err != nil {
+		return errors.Wrap(err, "ListChunks")
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].StartPos < chunks[j].StartPos
+	})
+
+	sealed := &BufferDto{}
+	if b != nil {
+		sealed.StartPos = b.StartPos
+		sealed.StartIndex = b.StartIndex
+	}
+	if len(chunks) > 0 {
+		last := chunks[len(chunks)-1]
+		if end := last.StartPos + last.UncompressedByteSize; end > sealed.StartPos {
+			sealed.StartPos = end
+			sealed.StartIndex = last.StartIndex + last.Records
+		}
+	}
+
+	r.metadb = &pinnedMetaDB{
+		MetaDB:   r.metadb,
+		chunks:   chunks,
+		buffer:   sealed,
+		unpinned: r.buffer,
+	}
+	r.buffer = r.metadb.GetBuffer
+	return nil
+}
+
+// Unpin releases the snapshot taken by Pin, so the reader sees the current state of the cellar again.
+// Unpinning a reader which is not pinned does nothing.
+func (r *Reader) Unpin() {
+	if p, ok := r.metadb.(*pinnedMetaDB); ok {
+		r.metadb = p.MetaDB
+		r.buffer = p.unpinned
+	}
+}
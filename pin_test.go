@@ -0,0 +1,80 @@
+package cellar
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_PinnedReader(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()), WithMaxBufferSize(1000),
+		WithCompressor(Lz4Compressor{}))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	for i := 0; i < 50; i++ {
+		_, err = db.Append([]byte(fmt.Sprintf("before-%d", i)))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.SealTheBuffer())
+
+	// still in the buffer, so not part of the snapshot
+	_, err = db.Append([]byte("unsealed"))
+	require.NoError(t, err)
+	require.NoError(t, db.Flush())
+
+	reader, err := db.PinnedReader()
+	require.NoError(t, err)
+
+	sealed := db.SealedPos()
+	pinnedPos, err := reader.SealedPos()
+	require.NoError(t, err)
+	assert.Equal(t, sealed, pinnedPos)
+
+	scan := func() []string {
+		var found []string
+		require.NoError(t, reader.ForEach(func(rec *Rec) error {
+			found = append(found, string(rec.Data))
+			return nil
+		}))
+		return found
+	}
+	expected := scan()
+	require.Len(t, expected, 50)
+
+	// appends sealing many buffers while the pinned reader scans
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			_, err := db.Append([]byte(fmt.Sprintf("during-%d", i)))
+			assert.NoError(t, err)
+		}
+	}()
+
+	for scanning := true; scanning; {
+		select {
+		case <-done:
+			scanning = false
+		default:
+		}
+		assert.Equal(t, expected, scan())
+	}
+
+	// the snapshot survives Reset
+	reader.Reset()
+	require.NoError(t, db.Flush())
+	assert.Equal(t, expected, scan())
+
+	chunks, err := db.meta.ListChunks()
+	require.NoError(t, err)
+	assert.True(t, len(chunks) > 1)
+
+	// unpinned, the reader sees the records appended meanwhile
+	reader.Unpin()
+	require.NoError(t, db.Flush())
+	assert.True(t, len(scan()) > len(expected))
+}
@@ -0,0 +1,27 @@
+package cellar
+
+import (
+	"sync"
+)
+
+// copyBufferSize matches the buffer io.Copy allocates for itself.
+const copyBufferSize = 32 * 1024
+
+// copyPool holds the buffers chunks are copied through when sealed, and chunkPool the buffers chunks are
+// decompressed into by scans with Reader.ReuseBuffers. Pointers are pooled, since putting a slice into a pool
+// allocates.
+var (
+	copyPool  = sync.Pool{New: func() interface{} { b := make([]byte, copyBufferSize); return &b }}
+	chunkPool = sync.Pool{New: func() interface{} { return new([]byte) }}
+)
+
+// getChunkBuffer returns a pooled buffer of size bytes. Buffers too small for the chunk are replaced, so the
+// pool settles on buffers of the maximum buffer size of the cellar.
+func getChunkBuffer(size int64) *[]byte {
+	buf := chunkPool.Get().(*[]byte)
+	if int64(cap(*buf)) < size {
+		*buf = make([]byte, size)
+	}
+	*buf = (*buf)[:size]
+	return buf
+}
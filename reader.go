@@ -1,16 +1,25 @@
 package cellar
 
 import (
+	"bytes"
 	"encoding/binary"
-	"fmt"
 	"io"
-	"log"
+	"math"
 	"os"
 	"path"
+	"sort"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+var (
+	ErrNotRecordBoundary = errors.New("cellar: position is not on a record boundary")
+	ErrOutOfRange        = errors.New("cellar: position is past the end of the cellar")
+	ErrTruncated         = errors.New("cellar: position was deleted by retention")
+	ErrChunkFileMissing  = errors.New("cellar: chunk file is missing")
+)
+
 type ReadFlag int
 
 const (
@@ -19,6 +28,18 @@ const (
 	RF_PrintChunks ReadFlag = 1 << 2
 )
 
+// DefaultPollInterval is the interval at which Follow checks for new records if the reader does not set one.
+const DefaultPollInterval = 100 * time.Millisecond
+
+// Reader reads the sealed chunks of the cellar, followed by the visible part of the current buffer. Readers
+// created through NewReader see the buffer up to its last checkpoint, readers created through DB.Reader see
+// it up to the last flush.
+//
+// Readers are safe for concurrent use, both with each other and with the writer: a single reader may run
+// several scans at once, and every scan sees each record of a prefix of the cellar exactly once, however the
+// writer seals buffers meanwhile. The decompression buffers of a scan are its own, and the read cache and
+// chunk mappings shared through the DB are locked. The exported fields, Reset, Pin and Unpin configure the
+// reader, and must not be changed while a scan of the reader is running.
 type Reader struct {
 	Folder      string
 	Flags       ReadFlag
@@ -26,13 +47,125 @@ type Reader struct {
 	EndPos      int64
 	LimitChunks int
 
+	// PollInterval is the interval at which Follow checks the meta DB for new records.
+	PollInterval time.Duration
+
+	// ScanConcurrency is the number of chunks Scan decompresses concurrently. Values below 2 load chunks
+	// one at a time.
+	ScanConcurrency int
+
+	// MaxInFlightChunks bounds the number of decompressed chunks a scan holds, counting the chunk whose
+	// records are being passed to the ReadOp, so Scan decompresses at most MaxInFlightChunks-1 chunks ahead
+	// of a slow ReadOp, whatever ScanConcurrency. The asynchronous scans block on the consumer while sending
+	// records, so this bounds their memory too; records queued in the value channel of ScanAsync keep their
+	// chunk in memory as well. 0 only bounds the chunks decompressed ahead, by ScanConcurrency.
+	MaxInFlightChunks int
+
+	// ReuseBuffers makes Scan and ForEach decompress chunks into pooled buffers, which are reused once the
+	// records of a chunk are replayed. The data passed to the ReadOp, or the Data of the Rec passed to the
+	// ForEach callback, is then only valid until it returns, and must be copied to be retained. Readers with a
+	// read cache, and the asynchronous scans, never reuse buffers.
+	ReuseBuffers bool
+
+	// VerifyOnRead compares every chunk file with its checksum before decrypting and decompressing it, see
+	// VerifyChunk.
+	VerifyOnRead bool
+
+	// SkipMissingChunks makes scans log chunks whose file is missing and continue with the next chunk,
+	// rather than failing with ErrChunkFileMissing. ReadAt always fails.
+	SkipMissingChunks bool
+
+	// ExportBase64 makes ExportJSONL encode all payloads as base64, including valid UTF-8.
+	ExportBase64 bool
+
 	cipher       Cipher
 	decompressor Decompressor
 	metadb       MetaDB
+
+	// registry holds the decompressors for chunks not written with the default codec
+	registry *CompressorRegistry
+
+	// ciphers holds the ciphers for chunks not encrypted with the cipher of the reader, by algorithm
+	ciphers map[uint32]Cipher
+
+	// dicts holds the dictionaries for chunks compressed with one, by dictionary id
+	dicts map[uint32][]byte
+
+	// pendingKey returns the position of a key flushed by the writer, but not stored in the key index yet
+	pendingKey func([]byte) (int64, bool)
+
+	// cache is optional, and holds decompressed chunks shared between readers
+	cache *chunkCache
+
+	// mmaps is optional, and holds the memory mappings of chunk files shared between readers
+	mmaps *chunkMaps
+
+	// fs holds the buffer and chunk files, see WithFileSystem
+	fs FileSystem
+
+	logger  Logger
+	metrics Metrics
+
+	// buffer returns the state of the current buffer, which defaults to the last checkpoint stored in
+	// the meta DB
+	buffer func() (*BufferDto, error)
+
+	// closers are the meta DB and chunk mappings owned by readers returned from OpenReadOnly
+	closers []io.Closer
 }
 
+// NewReader returns a reader for the cellar in folder. A nil cipher or decompressor reads chunks as
+// unencrypted or uncompressed, see NoCipher and NoCompressor.
 func NewReader(folder string, cipher Cipher, decompressor Decompressor, meta MetaDB) *Reader {
-	return &Reader{folder, RF_LoadBuffer, 0, 0, 0, cipher, decompressor, meta}
+	if cipher == nil {
+		cipher = NoCipher{}
+	}
+	if decompressor == nil {
+		decompressor = NoCompressor{}
+	}
+
+	return &Reader{
+		Folder:       folder,
+		Flags:        RF_LoadBuffer,
+		PollInterval: DefaultPollInterval,
+		cipher:       cipher,
+		decompressor: decompressor,
+		metadb:       meta,
+		buffer:       meta.GetBuffer,
+		registry:     defaultRegistry,
+		fs:           osFS{},
+		logger:       stdLogger{},
+		metrics:      NopMetrics{},
+	}
+}
+
+// Close releases the meta DB opened by OpenReadOnly, and unmaps the chunk files mapped by it. Readers which
+// do not own their meta DB have nothing to release, and Close is a no-op.
+func (r *Reader) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	r.closers = nil
+	return err
+}
+
+// Reset prepares the reader for a new query, clearing StartPos, EndPos and LimitChunks and restoring the
+// Flags of NewReader. Settings such as ScanConcurrency, ReuseBuffers or the read cache of a DB are kept, along
+// with the chunks in that cache, which is shared by all readers of the DB. Unless the reader is pinned, every
+// scan lists the chunks in the meta DB when it starts, so a reader can be reused for any number of scans and
+// sees the records appended since the last one. A pinned reader keeps its snapshot across Reset until Unpin,
+// see Pin.
+//
+// Several goroutines may scan the same reader at once, as long as none of them changes its fields. Reset
+// changes them, and must not be called while a scan of the reader is running.
+func (r *Reader) Reset() {
+	r.Flags = RF_LoadBuffer
+	r.StartPos = 0
+	r.EndPos = 0
+	r.LimitChunks = 0
 }
 
 type ReaderInfo struct {
@@ -42,32 +175,38 @@ type ReaderInfo struct {
 	StartPos int64
 	// global read pos
 	NextPos int64
+	// Timestamp is the time the record was appended at, in cellars storing record timestamps
+	Timestamp time.Time
+	// Index is the number of records appended before the record, see Writer.AppendIndexed
+	Index int64
 }
 
 type ReadOp func(pos *ReaderInfo, data []byte) error
 
 func (r *Reader) Scan(op ReadOp) error {
 
+	op = countReads(r.metrics, op)
+
 	var err error
 
 	loadBuffer := (r.Flags & RF_LoadBuffer) == RF_LoadBuffer
 	printChunks := (r.Flags & RF_PrintChunks) == RF_PrintChunks
 
-	b, err := r.metadb.GetBuffer()
+	format, err := r.recordFormat()
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(b.String())
-
-	chunks, err := r.metadb.ListChunks()
+	b, err := r.buffer()
 	if err != nil {
 		return err
 	}
 
+	chunks, err := r.sortedChunks()
 	if err != nil {
 		return errors.Wrap(err, "db.Read")
 	}
+	chunks = sealedBefore(chunks, b)
 
 	if b == nil && len(chunks) == 0 {
 		return nil
@@ -81,7 +220,9 @@ func (r *Reader) Scan(op ReadOp) error {
 			chunks = chunks[:r.LimitChunks]
 		}
 
-		for i, c := range chunks {
+		var selected []*ChunkDto
+
+		for _, c := range chunks {
 
 			endPos := c.StartPos + c.UncompressedByteSize
 
@@ -95,15 +236,25 @@ func (r *Reader) Scan(op ReadOp) error {
 				continue
 			}
 
-			chunk := make([]byte, c.UncompressedByteSize)
-			var file = path.Join(r.Folder, c.FileName)
+			selected = append(selected, c)
+		}
+
+		loader := r.newChunkLoader(selected, r.ScanConcurrency, r.MaxInFlightChunks)
+		defer loader.stop()
+
+		for i, c := range selected {
 
 			if printChunks {
-				log.Printf("Loading chunk %d %s with size %d", i, c.FileName, c.UncompressedByteSize)
+				r.logger.Printf("Loading chunk %d %s with size %d", i, c.FileName, c.UncompressedByteSize)
 			}
 
-			if chunk, err = r.loadChunkIntoBuffer(file, c.UncompressedByteSize, chunk); err != nil {
-				log.Panicf("Failed to load chunk %s", c.FileName)
+			var chunk *[]byte
+			if chunk, err = loader.next(); err != nil {
+				if r.skipMissing(err) {
+					loader.release()
+					continue
+				}
+				return errors.Wrapf(err, "load chunk %s", c.FileName)
 			}
 
 			info.ChunkPos = c.StartPos
@@ -113,8 +264,14 @@ func (r *Reader) Scan(op ReadOp) error {
 				// reader starts in the middle
 				chunkPos = int(r.StartPos - c.StartPos)
 			}
-
-			if err = replayChunk(info, chunk, op, chunkPos); err != nil {
+			var records int64
+			if records, err = recordsBefore(*chunk, chunkPos, format.checksums); err == nil {
+				info.Index = c.StartIndex + records
+				err = replayChunk(info, *chunk, op, chunkPos, format)
+			}
+			r.releaseChunk(chunk)
+			loader.release()
+			if err != nil {
 				return errors.Wrap(err, "Failed to read chunk")
 			}
 		}
@@ -127,22 +284,9 @@ func (r *Reader) Scan(op ReadOp) error {
 			return nil
 		}
 
-		loc := path.Join(r.Folder, b.FileName)
-
-		var f *os.File
-
-		if f, err = os.Open(loc); err != nil {
-			log.Panicf("Failed to open buffer file %s", loc)
-		}
-
-		curChunk := make([]byte, b.Pos)
-
-		var n int
-		if n, err = f.Read(curChunk); err != nil {
-			log.Panicf("Failed to read %d bytes from buffer %s", b.Pos, loc)
-		}
-		if n != int(b.Pos) {
-			log.Panic("Failed to read bytes")
+		var curChunk []byte
+		if curChunk, err = r.readBuffer(b); err != nil {
+			return err
 		}
 
 		info.ChunkPos = b.StartPos
@@ -152,9 +296,13 @@ func (r *Reader) Scan(op ReadOp) error {
 		if r.StartPos > b.StartPos {
 			chunkPos = int(r.StartPos - b.StartPos)
 		}
+		var records int64
+		if records, err = recordsBefore(curChunk, chunkPos, format.checksums); err != nil {
+			return errors.Wrap(err, "Failed to read chunk")
+		}
+		info.Index = b.StartIndex + records
 
-		fmt.Println("replaying chunks")
-		if err = replayChunk(info, curChunk, op, chunkPos); err != nil {
+		if err = replayChunk(info, curChunk, op, chunkPos, format); err != nil {
 			return errors.Wrap(err, "Failed to read chunk")
 		}
 
@@ -164,22 +312,149 @@ func (r *Reader) Scan(op ReadOp) error {
 
 }
 
-func readVarint(b []byte) (val int64, n int) {
+// ForEach calls fn for every record in the cellar on the calling goroutine, without the channel overhead of
+// ScanAsync. Iteration stops at the first error returned by fn, which is returned as is.
+func (r *Reader) ForEach(fn func(*Rec) error) error {
+	var fnErr error
+
+	err := r.Scan(func(ri *ReaderInfo, data []byte) error {
+		fnErr = fn(newRec(ri, data))
+		return fnErr
+	})
+
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+// Count returns the number of records in the cellar from the metadata alone, without reading any chunks.
+// Only the visible records of the current buffer are counted.
+func (r *Reader) Count() (int64, error) {
+	b, err := r.buffer()
+	if err != nil {
+		return 0, err
+	}
+
+	chunks, err := r.metadb.ListChunks()
+	if err != nil {
+		return 0, errors.Wrap(err, "db.Read")
+	}
+	chunks = sealedBefore(chunks, b)
+
+	var count int64
+	for _, c := range chunks {
+		count += c.Records
+	}
+
+	if b != nil {
+		count += b.Records
+	}
+	return count, nil
+}
+
+// SealedPos returns the end position of the last sealed chunk visible to the reader, which is the start
+// position of the current buffer. Records before it are read from chunks, and are durable.
+func (r *Reader) SealedPos() (int64, error) {
+	b, err := r.buffer()
+	if err != nil {
+		return 0, err
+	}
+	if b == nil {
+		return 0, nil
+	}
+	return b.StartPos, nil
+}
+
+// ChunkStat describes the size of a sealed chunk before and after compression.
+type ChunkStat struct {
+	StartPos         int64
+	Records          int64
+	CompressedSize   int64
+	UncompressedSize int64
+}
+
+// Ratio returns the compression ratio of the chunk, as uncompressed size over compressed size.
+func (c ChunkStat) Ratio() float64 {
+	if c.CompressedSize == 0 {
+		return 0
+	}
+	return float64(c.UncompressedSize) / float64(c.CompressedSize)
+}
+
+// ChunkStats returns the sizes of all sealed chunks, ordered by position, from the metadata alone.
+func (r *Reader) ChunkStats() ([]ChunkStat, error) {
+	chunks, err := r.sortedChunks()
+	if err != nil {
+		return nil, errors.Wrap(err, "db.Read")
+	}
+
+	stats := make([]ChunkStat, len(chunks))
+	for i, c := range chunks {
+		stats[i] = ChunkStat{
+			StartPos:         c.StartPos,
+			Records:          c.Records,
+			CompressedSize:   c.CompressedDiskSize,
+			UncompressedSize: c.UncompressedByteSize,
+		}
+	}
+	return stats, nil
+}
+
+// ChunkInfo describes the layout of a sealed chunk, for monitoring and debugging.
+type ChunkInfo struct {
+	ChunkStat
+
+	FileName string
+	Codec    uint32
+	// DictID is the id of the compression dictionary of the chunk, or 0 without one
+	DictID uint32
+	Cipher uint32
+	// CreatedAt is the time the chunk was sealed, and zero for chunks sealed before it was recorded.
+	CreatedAt time.Time
+}
 
-	val, n = binary.Varint(b)
-	if n <= 0 {
-		log.Panicf("Failed to read varint %d", n)
+func newChunkInfo(c *ChunkDto) ChunkInfo {
+	info := ChunkInfo{
+		ChunkStat: ChunkStat{
+			StartPos:         c.StartPos,
+			Records:          c.Records,
+			CompressedSize:   c.CompressedDiskSize,
+			UncompressedSize: c.UncompressedByteSize,
+		},
+		FileName: c.FileName,
+		Codec:    c.Codec,
+		DictID:   c.DictID,
+		Cipher:   c.Cipher,
+	}
+	if c.CreatedAtUnix != 0 {
+		info.CreatedAt = time.Unix(c.CreatedAtUnix, 0)
 	}
+	return info
+}
 
-	return
+// Chunks describes all sealed chunks ordered by position, from the metadata alone.
+func (r *Reader) Chunks() ([]ChunkInfo, error) {
+	chunks, err := r.sortedChunks()
+	if err != nil {
+		return nil, errors.Wrap(err, "db.Read")
+	}
 
+	infos := make([]ChunkInfo, len(chunks))
+	for i, c := range chunks {
+		infos[i] = newChunkInfo(c)
+	}
+	return infos, nil
 }
 
-func replayChunk(info *ReaderInfo, chunk []byte, op ReadOp, pos int) error {
+// replayChunk applies op to all records in the chunk starting at pos. The caller sets info.Index to the index
+// of the record at pos.
+func replayChunk(info *ReaderInfo, chunk []byte, op ReadOp, pos int, format recordFormat) error {
 
 	max := len(chunk)
 
 	var err error
+	var record []byte
 
 	// while we are not at the end,
 	// read first len
@@ -188,27 +463,161 @@ func replayChunk(info *ReaderInfo, chunk []byte, op ReadOp, pos int) error {
 
 		info.StartPos = int64(pos) + info.ChunkPos
 
-		recordSize, shift := readVarint(chunk[pos:])
+		if record, pos, err = readRecord(chunk, pos, info.ChunkPos, format.checksums); err != nil {
+			return err
+		}
+		if format.timestamps {
+			if info.Timestamp, record, err = splitStamp(record); err != nil {
+				return errors.Wrapf(err, "position %d", info.StartPos)
+			}
+		}
+
+		info.NextPos = int64(pos) + info.ChunkPos
+
+		if err = op(info, record); err != nil {
+			return errors.Wrap(err, "Failed to execute op")
+		}
+		info.Index++
+	}
+	return nil
+
+}
 
-		// move position by the header size
-		pos += shift
+// replayChunkReverse applies op to all records in the chunk, whose start offsets are offsets, starting with
+// the last one. The caller sets info.Index to the index of the first record of the chunk.
+func replayChunkReverse(info *ReaderInfo, chunk []byte, offsets []int, op ReadOp, format recordFormat) error {
 
-		// get chunk
-		record := chunk[pos : pos+int(recordSize)]
-		// apply chunk
+	var err error
+	var record []byte
 
-		pos += int(recordSize)
+	first := info.Index
 
-		info.NextPos = int64(pos) + info.ChunkPos
+	for i := len(offsets) - 1; i >= 0; i-- {
+
+		info.StartPos = int64(offsets[i]) + info.ChunkPos
+		info.Index = first + int64(i)
+
+		var next int
+		if record, next, err = readRecord(chunk, offsets[i], info.ChunkPos, format.checksums); err != nil {
+			return err
+		}
+		if format.timestamps {
+			if info.Timestamp, record, err = splitStamp(record); err != nil {
+				return errors.Wrapf(err, "position %d", info.StartPos)
+			}
+		}
+
+		info.NextPos = int64(next) + info.ChunkPos
 
 		if err = op(info, record); err != nil {
 			return errors.Wrap(err, "Failed to execute op")
 		}
-		// shift pos
-
 	}
 	return nil
+}
+
+// decodeRecord reads the length prefixed record starting at pos, returning the record and the
+// position of the next record. With checksums, the length prefix is followed by the CRC32 of the record,
+// which is returned as sum without verifying it, see readRecord. Length prefixes which can't be decoded or
+// reach past the end of the chunk return ErrRecordBounds.
+func decodeRecord(chunk []byte, pos int, checksums bool) (record []byte, sum uint32, next int, err error) {
+
+	recordSize, shift := binary.Varint(chunk[pos:])
+
+	header := shift
+	if checksums {
+		header += recordChecksumSize
+	}
+	if shift <= 0 || recordSize < 0 || int64(len(chunk)-pos-header) < recordSize {
+		return nil, 0, 0, ErrRecordBounds
+	}
+
+	if checksums {
+		sum = binary.BigEndian.Uint32(chunk[pos+shift:])
+	}
+
+	next = pos + header + int(recordSize)
+	return chunk[pos+header : next], sum, next, nil
+}
+
+// nextRecord returns the offset of the first record in the chunk starting at or after pos. Positions inside
+// a record are rounded up to the start of the next one.
+func nextRecord(chunk []byte, pos int, checksums bool) (int, error) {
+	offset := 0
+	for offset < len(chunk) && offset < pos {
+		_, _, next, err := decodeRecord(chunk, offset, checksums)
+		if err != nil {
+			return 0, errors.Wrapf(err, "offset %d", offset)
+		}
+		offset = next
+	}
+	return offset, nil
+}
+
+// recordEndingAt returns the offset of the record in the chunk ending at offset, along with the number of
+// records before it, or -1 if no record ends there.
+func recordEndingAt(chunk []byte, offset int, checksums bool) (int, int64, error) {
+	var n int64
+	pos := 0
+	for pos < len(chunk) && pos < offset {
+		_, _, next, err := decodeRecord(chunk, pos, checksums)
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "offset %d", pos)
+		}
+		if next == offset {
+			return pos, n, nil
+		}
+		pos = next
+		n++
+	}
+	return -1, 0, nil
+}
+
+// recordOffsets walks the length prefixes of a decoded chunk, returning the start offset of
+// every record in it.
+func recordOffsets(chunk []byte, checksums bool) ([]int, error) {
+	var offsets []int
+
+	pos := 0
+	for pos < len(chunk) {
+		offsets = append(offsets, pos)
+
+		_, _, next, err := decodeRecord(chunk, pos, checksums)
+		if err != nil {
+			return nil, errors.Wrapf(err, "offset %d", pos)
+		}
+		pos = next
+	}
+	return offsets, nil
+}
+
+// seekRecord returns the offset of the first record starting at or after pos in a chunk of size bytes with
+// the record offsets, along with its index within the chunk. Past the last record, it returns size and the
+// number of records.
+func seekRecord(offsets []int, pos int, size int) (int, int) {
+	i := sort.SearchInts(offsets, pos)
+	if i == len(offsets) {
+		return size, i
+	}
+	return offsets[i], i
+}
 
+// chunkOffsets returns the record offsets of the decoded chunk c, see recordOffsets. They are computed once
+// for chunks in the read cache, and kept along with them.
+func (r *Reader) chunkOffsets(c *ChunkDto, chunk []byte, checksums bool) ([]int, error) {
+	if r.cache == nil {
+		return recordOffsets(chunk, checksums)
+	}
+	if offsets, ok := r.cache.offsets(c.StartPos, chunk); ok {
+		return offsets, nil
+	}
+
+	offsets, err := recordOffsets(chunk, checksums)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.putOffsets(c.StartPos, chunk, offsets)
+	return offsets, nil
 }
 
 // TODO ask abdullin why this function exists
@@ -228,34 +637,752 @@ func replayChunk(info *ReaderInfo, chunk []byte, op ReadOp, pos int) error {
 // 	return bufferSize
 // }
 
-func (r Reader) loadChunkIntoBuffer(loc string, size int64, b []byte) ([]byte, error) {
+// decompressorFor returns the decompressor for the chunk. Chunks using the default codec are read with the
+// decompressor of the reader, and zstd chunks compressed with a dictionary with the dictionary recorded for
+// them. All others are looked up in the registry.
+func (r *Reader) decompressorFor(c *ChunkDto) (Decompressor, error) {
+	if c.Codec == CodecLZ4 {
+		return r.decompressor, nil
+	}
 
-	var decryptor, zr io.Reader
-	var err error
+	if c.Codec == CodecZstd && c.DictID != 0 {
+		dict, ok := r.dicts[c.DictID]
+		if !ok {
+			return nil, errors.Wrapf(ErrUnknownDict, "dictionary %d", c.DictID)
+		}
+		return ZstdDecompressor{Dicts: [][]byte{dict}}, nil
+	}
+
+	d, ok := r.registry.Decompressor(c.Codec)
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownCodec, "codec %d", c.Codec)
+	}
+	return d, nil
+}
+
+// cipherFor returns the cipher the chunk was encrypted with, picking the key recorded with the chunk if the
+// cipher holds several keys.
+func (r *Reader) cipherFor(c *ChunkDto) (Cipher, error) {
+	cipher := r.cipher
+	if cipher.Algorithm() != c.Cipher {
+		var ok bool
+		if cipher, ok = r.ciphers[c.Cipher]; !ok {
+			return nil, errors.Wrapf(ErrUnknownCipher, "cipher %d", c.Cipher)
+		}
+	}
 
-	var chunkFile *os.File
-	if chunkFile, err = os.Open(loc); err != nil {
-		log.Panicf("Failed to open chunk %s", loc)
+	if keyed, ok := cipher.(KeyedCipher); ok {
+		return keyed.ForKey(c.KeyID)
 	}
+	return cipher, nil
+}
 
-	defer chunkFile.Close()
+// openChunk opens the file of the chunk c at loc, returning the decrypted stream following its header, and
+// a func to close the file. Chunk files with a header are checked to match c, see checkChunkHeader.
+func (r Reader) openChunk(loc string, c *ChunkDto, cipher Cipher) (io.Reader, func(), error) {
+	var src io.Reader
+	done := func() {}
+
+	if data, release, ok := r.mapChunk(loc); ok {
+		src = bytes.NewReader(data)
+		done = release
+	} else {
+		chunkFile, err := r.fs.Open(loc)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "open chunk %s", loc)
+		}
+		src = chunkFile
+		done = func() { chunkFile.Close() }
+	}
+
+	if c.HeaderSize > 0 {
+		if err := checkChunkHeader(src, c); err != nil {
+			done()
+			return nil, nil, errors.Wrapf(err, "chunk %s", loc)
+		}
+	}
 
-	if decryptor, err = r.cipher.Decrypt(chunkFile); err != nil {
-		log.Panicf("Failed to chain decryptor for %s: %s", loc, err)
+	decryptor, err := cipher.Decrypt(src, c.Nonce)
+	if err != nil {
+		done()
+		return nil, nil, errors.Wrapf(err, "chain decryptor for %s", loc)
 	}
+	return decryptor, done, nil
+}
 
-	zr, err = r.decompressor.Decompress(decryptor)
+// loadChunkIntoBuffer decrypts and decompresses the file of the chunk c at loc into b. Chunks sealed in
+// blocks are decompressed concurrently, see WithBlockSize.
+func (r Reader) loadChunkIntoBuffer(loc string, c *ChunkDto, cipher Cipher, decompressor Decompressor, b []byte) ([]byte, error) {
+
+	decryptor, done, err := r.openChunk(loc, c, cipher)
 	if err != nil {
-		log.Panicf("Failed to chain decompressor for %s: %s", loc, err)
+		return nil, err
+	}
+	defer done()
+
+	size := c.UncompressedByteSize
+	if len(c.Blocks) > 0 {
+		if err = checkBlocks(c); err != nil {
+			return nil, err
+		}
+		if err = decompressBlocks(decryptor, c.Blocks, size, decompressor, b); err != nil {
+			return nil, errors.Wrapf(err, "read from chunk %s (%d)", loc, size)
+		}
+		return b[:size], nil
+	}
+
+	zr, err := decompressor.Decompress(decryptor)
+	if err != nil {
+		return nil, errors.Wrapf(err, "chain decompressor for %s", loc)
+	}
+	if closer, ok := zr.(io.Closer); ok {
+		// pooled decompressors are returned once the chunk is read
+		defer closer.Close()
 	}
 
 	var readBytes int
-	if readBytes, err = zr.Read(b); err != nil {
-		log.Panicf("Failed to read from chunk %s (%d): %s", loc, size, err)
+	if readBytes, err = io.ReadFull(zr, b); err != nil {
+		return nil, errors.Wrapf(err, "read from chunk %s (%d)", loc, size)
 	}
 
 	if int64(readBytes) != size {
-		log.Panicf("Read %d bytes but expected %d", readBytes, size)
+		return nil, errors.Errorf("read %d bytes but expected %d", readBytes, size)
 	}
 	return b[0:readBytes], nil
 }
+
+// mapChunk returns the memory mapping of the chunk file at loc, if the reader maps chunks, see WithMmapReads,
+// along with a func releasing it once the mapping is no longer used. Only files of the operating system can
+// be mapped.
+func (r Reader) mapChunk(loc string) ([]byte, func(), bool) {
+	if _, ok := r.fs.(osFS); !ok || r.mmaps == nil {
+		return nil, nil, false
+	}
+	return r.mmaps.get(loc, r.logger)
+}
+
+// sortedChunks lists all chunks in the meta DB ordered by their start position.
+func (r *Reader) sortedChunks() ([]*ChunkDto, error) {
+	chunks, err := r.metadb.ListChunks()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].StartPos < chunks[j].StartPos
+	})
+	return chunks, nil
+}
+
+// readBuffer loads the visible part of the buffer file. If the writer sealed the buffer since its state was
+// read, the file is gone, and the records are read from the chunk the buffer was sealed into.
+func (r *Reader) readBuffer(b *BufferDto) ([]byte, error) {
+
+	loc := path.Join(r.Folder, b.FileName)
+
+	f, err := r.fs.Open(loc)
+	if os.IsNotExist(err) {
+		return r.readSealedBuffer(b)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "open buffer")
+	}
+
+	defer f.Close()
+
+	curChunk := make([]byte, b.Pos)
+	if _, err = io.ReadFull(f, curChunk); err != nil {
+		return nil, errors.Wrapf(err, "read %d bytes from buffer %s", b.Pos, loc)
+	}
+	return curChunk, nil
+}
+
+// readSealedBuffer loads the visible part of a buffer from the chunk it was sealed into.
+func (r *Reader) readSealedBuffer(b *BufferDto) ([]byte, error) {
+	c, err := r.chunkAt(b.StartPos)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil || c.StartPos != b.StartPos || c.UncompressedByteSize < b.Pos {
+		return nil, errors.Errorf("cellar: buffer %s is gone, and not sealed into a chunk", b.FileName)
+	}
+
+	chunk, err := r.loadChunk(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "load sealed buffer")
+	}
+	return chunk[:b.Pos], nil
+}
+
+// sealedBefore drops the chunks sealed after the state of the buffer b was read, which start at or after b.
+// Reading the buffer state before the chunks, and dropping these chunks, makes every scan see each record
+// exactly once, however the writer seals concurrently.
+func sealedBefore(chunks []*ChunkDto, b *BufferDto) []*ChunkDto {
+	if b == nil {
+		return chunks
+	}
+
+	kept := chunks[:0]
+	for _, c := range chunks {
+		if c.StartPos < b.StartPos {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// scanReverse applies op to every record in the cellar, starting with the most recently appended one.
+// Chunks are visited in descending StartPos order, and since the length prefixes can only be
+// read front-to-back, every chunk is decoded into an offset table before being replayed in reverse, which is
+// kept in the read cache along with the chunk, see chunkOffsets.
+func (r *Reader) scanReverse(op ReadOp) error {
+
+	op = countReads(r.metrics, op)
+
+	var err error
+
+	loadBuffer := (r.Flags & RF_LoadBuffer) == RF_LoadBuffer
+
+	format, err := r.recordFormat()
+	if err != nil {
+		return err
+	}
+
+	b, err := r.buffer()
+	if err != nil {
+		return err
+	}
+
+	chunks, err := r.sortedChunks()
+	if err != nil {
+		return errors.Wrap(err, "db.Read")
+	}
+	chunks = sealedBefore(chunks, b)
+
+	info := &ReaderInfo{}
+
+	if loadBuffer && b != nil && b.Pos > 0 {
+
+		var curChunk []byte
+		if curChunk, err = r.readBuffer(b); err != nil {
+			return err
+		}
+
+		info.ChunkPos = b.StartPos
+		info.Index = b.StartIndex
+
+		offsets, err := recordOffsets(curChunk, format.checksums)
+		if err != nil {
+			return errors.Wrap(err, "Failed to read chunk")
+		}
+		if err = replayChunkReverse(info, curChunk, offsets, op, format); err != nil {
+			return errors.Wrap(err, "Failed to read chunk")
+		}
+	}
+
+	for i := len(chunks) - 1; i >= 0; i-- {
+		c := chunks[i]
+
+		var chunk []byte
+		if chunk, err = r.loadChunk(c); err != nil {
+			if r.skipMissing(err) {
+				continue
+			}
+			return errors.Wrapf(err, "load chunk %s", c.FileName)
+		}
+
+		info.ChunkPos = c.StartPos
+		info.Index = c.StartIndex
+
+		offsets, err := r.chunkOffsets(c, chunk, format.checksums)
+		if err != nil {
+			return errors.Wrap(err, "Failed to read chunk")
+		}
+		if err = replayChunkReverse(info, chunk, offsets, op, format); err != nil {
+			return errors.Wrap(err, "Failed to read chunk")
+		}
+	}
+
+	return nil
+}
+
+// errStopScan is returned by internal ops to end a scan early, for example once a range scan has passed its
+// end. It never reaches the caller.
+var errStopScan = errors.New("stop scan")
+
+// scanFrom applies op to every record starting at or after from.
+func (r *Reader) scanFrom(from int64, op ReadOp) error {
+	return r.scanRange(from, math.MaxInt64, op)
+}
+
+// scanRange applies op to every record whose start position lies in [from, to). Chunks ending before from
+// are skipped without being loaded, and reading stops as soon as a record starting at or after to is found.
+func (r *Reader) scanRange(from int64, to int64, op ReadOp) error {
+
+	op = countReads(r.metrics, op)
+
+	var err error
+
+	first, err := r.firstPos()
+	if err != nil {
+		return err
+	}
+	if from < first {
+		return errors.Wrapf(ErrTruncated, "position %d, first readable position %d", from, first)
+	}
+
+	format, err := r.recordFormat()
+	if err != nil {
+		return err
+	}
+
+	bounded := func(info *ReaderInfo, data []byte) error {
+		if info.StartPos >= to {
+			return errStopScan
+		}
+		return op(info, data)
+	}
+
+	b, err := r.buffer()
+	if err != nil {
+		return err
+	}
+
+	chunks, err := r.metadb.ListChunksRange(from, to, 0)
+	if err != nil {
+		return errors.Wrap(err, "db.Read")
+	}
+	chunks = sealedBefore(chunks, b)
+
+	info := &ReaderInfo{}
+
+	for _, c := range chunks {
+
+		// of chunks sealed in blocks, the blocks in front of from are left out
+		var chunk []byte
+		var start int
+		var records int64
+		if chunk, start, records, err = r.loadChunkFrom(c, from); err != nil {
+			if r.skipMissing(err) {
+				continue
+			}
+			return errors.Wrapf(err, "load chunk %s", c.FileName)
+		}
+
+		info.ChunkPos = c.StartPos
+
+		chunkPos := start
+		if start > 0 {
+			if from > c.StartPos+int64(start) {
+				var offset int
+				if offset, err = nextRecord(chunk[start:], int(from-c.StartPos)-start, format.checksums); err != nil {
+					return errors.Wrap(err, "Failed to read chunk")
+				}
+				chunkPos = start + offset
+			}
+			var before int64
+			if before, err = recordsBefore(chunk[start:], chunkPos-start, format.checksums); err != nil {
+				return errors.Wrap(err, "Failed to read chunk")
+			}
+			info.Index = c.StartIndex + records + before
+		} else {
+			// whole chunks skip to from through their offset table
+			var offsets []int
+			if offsets, err = r.chunkOffsets(c, chunk, format.checksums); err != nil {
+				return errors.Wrap(err, "Failed to read chunk")
+			}
+			var i int
+			chunkPos, i = seekRecord(offsets, int(from-c.StartPos), len(chunk))
+			info.Index = c.StartIndex + int64(i)
+		}
+
+		if err = replayChunk(info, chunk, bounded, chunkPos, format); err != nil {
+			if errors.Cause(err) == errStopScan {
+				return nil
+			}
+			return errors.Wrap(err, "Failed to read chunk")
+		}
+	}
+
+	if b == nil || b.Pos == 0 || b.StartPos+b.Pos <= from || b.StartPos >= to {
+		return nil
+	}
+
+	var curChunk []byte
+	if curChunk, err = r.readBuffer(b); err != nil {
+		return err
+	}
+
+	info.ChunkPos = b.StartPos
+
+	chunkPos := 0
+	if from > b.StartPos {
+		if chunkPos, err = nextRecord(curChunk, int(from-b.StartPos), format.checksums); err != nil {
+			return errors.Wrap(err, "Failed to read chunk")
+		}
+	}
+	records, err := recordsBefore(curChunk, chunkPos, format.checksums)
+	if err != nil {
+		return errors.Wrap(err, "Failed to read chunk")
+	}
+	info.Index = b.StartIndex + records
+
+	if err = replayChunk(info, curChunk, bounded, chunkPos, format); err != nil {
+		if errors.Cause(err) == errStopScan {
+			return nil
+		}
+		return errors.Wrap(err, "Failed to read chunk")
+	}
+	return nil
+}
+
+// loadChunk decompresses and decrypts a sealed chunk, going through the read cache if one is configured.
+// Chunks returned from the cache are shared, and must not be modified.
+func (r *Reader) loadChunk(c *ChunkDto) ([]byte, error) {
+	if r.cache != nil {
+		if chunk, ok := r.cache.get(c.StartPos); ok {
+			return chunk, nil
+		}
+	}
+
+	if r.VerifyOnRead {
+		if err := verifyChunkFile(r.fs, path.Join(r.Folder, c.FileName), c); err != nil {
+			return nil, missingChunk(c, err)
+		}
+	}
+
+	chunk, err := r.readChunk(c, nil)
+	if err != nil {
+		return nil, missingChunk(c, err)
+	}
+
+	if r.cache != nil {
+		r.cache.put(c.StartPos, chunk)
+	}
+	return chunk, nil
+}
+
+// missingChunk replaces the error of a chunk whose file does not exist with ErrChunkFileMissing, naming its
+// position.
+func missingChunk(c *ChunkDto, err error) error {
+	if os.IsNotExist(errors.Cause(err)) {
+		return errors.Wrapf(ErrChunkFileMissing, "chunk %s at position %d", c.FileName, c.StartPos)
+	}
+	return err
+}
+
+// skipMissing reports whether a scan continues after failing to load a chunk with err, which is the case
+// for missing chunk files with SkipMissingChunks. Skipped chunks are logged.
+func (r *Reader) skipMissing(err error) bool {
+	if !r.SkipMissingChunks || errors.Cause(err) != ErrChunkFileMissing {
+		return false
+	}
+	r.logger.Printf("cellar: skipping %s", err)
+	return true
+}
+
+// reusesBuffers reports whether scans decompress chunks into pooled buffers, see ReuseBuffers.
+func (r *Reader) reusesBuffers() bool {
+	return r.ReuseBuffers && r.cache == nil
+}
+
+// scanChunk loads a sealed chunk for Scan, into a pooled buffer if the reader reuses buffers. The chunk must
+// be released with releaseChunk once its records are replayed.
+func (r *Reader) scanChunk(c *ChunkDto) (*[]byte, error) {
+	if !r.reusesBuffers() {
+		chunk, err := r.loadChunk(c)
+		return &chunk, err
+	}
+
+	if r.VerifyOnRead {
+		if err := verifyChunkFile(r.fs, path.Join(r.Folder, c.FileName), c); err != nil {
+			return nil, missingChunk(c, err)
+		}
+	}
+
+	buf := getChunkBuffer(c.UncompressedByteSize)
+	if _, err := r.readChunk(c, *buf); err != nil {
+		chunkPool.Put(buf)
+		return nil, missingChunk(c, err)
+	}
+	return buf, nil
+}
+
+// releaseChunk returns a chunk loaded by scanChunk to the pool, unless it was allocated for the scan.
+func (r *Reader) releaseChunk(chunk *[]byte) {
+	if r.reusesBuffers() {
+		chunkPool.Put(chunk)
+	}
+}
+
+// readChunk decompresses and decrypts a sealed chunk from disk into buf, which must hold
+// UncompressedByteSize bytes. A nil buf allocates a new one.
+func (r *Reader) readChunk(c *ChunkDto, buf []byte) ([]byte, error) {
+	decompressor, err := r.decompressorFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	cipher, err := r.cipherFor(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if buf == nil {
+		buf = make([]byte, c.UncompressedByteSize)
+	}
+	var file = path.Join(r.Folder, c.FileName)
+
+	return r.loadChunkIntoBuffer(file, c, cipher, decompressor, buf)
+}
+
+// chunkAt returns the sealed chunk containing pos, or nil if there is none. The meta DB seeks to the chunk
+// through its ordered index rather than listing all chunks.
+func (r *Reader) chunkAt(pos int64) (*ChunkDto, error) {
+	chunks, err := r.metadb.ListChunksRange(pos, pos+1, 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListChunksRange")
+	}
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+	return chunks[0], nil
+}
+
+// VerifyChunk compares the file of the chunk starting at startPos with the checksum recorded when it was
+// sealed, without decrypting or decompressing it. A mismatch returns ErrChunkCorrupted. Chunks sealed before
+// checksums were recorded always pass.
+func (r *Reader) VerifyChunk(startPos int64) error {
+	c, err := r.chunkAt(startPos)
+	if err != nil {
+		return err
+	}
+	if c == nil || c.StartPos != startPos {
+		return errors.Wrapf(ErrChunkNotFound, "position %d", startPos)
+	}
+	return verifyChunkFile(r.fs, path.Join(r.Folder, c.FileName), c)
+}
+
+// RecordOffsets returns the offsets of the records in the sealed chunk starting at startPos, relative to
+// startPos, for tooling and debugging. The offsets are computed by decompressing the chunk, and kept in the
+// read cache along with it. A startPos which does not start a chunk returns ErrChunkNotFound.
+func (r *Reader) RecordOffsets(startPos int64) ([]int, error) {
+	c, err := r.chunkAt(startPos)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil || c.StartPos != startPos {
+		return nil, errors.Wrapf(ErrChunkNotFound, "position %d", startPos)
+	}
+
+	format, err := r.recordFormat()
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := r.loadChunk(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "loadChunk")
+	}
+
+	// the cached offsets are shared
+	offsets, err := r.chunkOffsets(c, chunk, format.checksums)
+	if err != nil {
+		return nil, err
+	}
+	return append([]int(nil), offsets...), nil
+}
+
+// ChunkAt returns the sealed chunk whose positions include pos. It returns false if pos lies in the current
+// buffer, or outside of the cellar.
+func (r *Reader) ChunkAt(pos int64) (ChunkInfo, bool, error) {
+	c, err := r.chunkAt(pos)
+	if err != nil || c == nil {
+		return ChunkInfo{}, false, err
+	}
+	return newChunkInfo(c), true, nil
+}
+
+// recordFormat describes how the records of a cellar are encoded.
+type recordFormat struct {
+	// checksums follow the length prefix of every record, see WithRecordChecksums
+	checksums bool
+	// timestamps start the body of every record, see WithRecordTimestamps, and unordered is set once a
+	// record was stamped before an earlier one
+	timestamps bool
+	unordered  bool
+	// headers start every chunk file sealed in the format of the cellar, see FormatVersion
+	headers bool
+}
+
+// recordFormat reads the record format of the cellar from its meta DB.
+func (r *Reader) recordFormat() (recordFormat, error) {
+	meta, err := r.metadb.CellarMeta()
+	if err != nil {
+		return recordFormat{}, errors.Wrap(err, "CellarMeta")
+	}
+	if meta == nil {
+		return recordFormat{}, nil
+	}
+	if err = checkFormat(meta); err != nil {
+		return recordFormat{}, err
+	}
+	return recordFormat{
+		checksums:  meta.RecordChecksums,
+		timestamps: meta.RecordTimestamps,
+		unordered:  meta.UnorderedTimestamps,
+		headers:    meta.FormatVersion >= formatChunkHeaders,
+	}, nil
+}
+
+// firstPos returns the lowest position which can be read. It is 0 unless the oldest chunks were deleted by
+// retention.
+func (r *Reader) firstPos() (int64, error) {
+	chunks, err := r.metadb.ListChunksRange(0, math.MaxInt64, 1)
+	if err != nil {
+		return 0, errors.Wrap(err, "ListChunksRange")
+	}
+	if len(chunks) == 1 {
+		return chunks[0].StartPos, nil
+	}
+
+	b, err := r.buffer()
+	if err != nil || b == nil {
+		return 0, err
+	}
+	return b.StartPos, nil
+}
+
+// ReadAt returns the single record ending at pos, which is the position Append, AppendBatch and the other
+// appends returned for it, and the Rec.NextPos of a scanned record. This mirrors ScanFrom, which reads the
+// records following pos. Positions in the visible part of the current buffer are resolved as well. A position
+// no record ends at returns ErrNotRecordBoundary.
+func (r *Reader) ReadAt(pos int64) (*Rec, error) {
+	if pos <= 0 {
+		return nil, ErrNotRecordBoundary
+	}
+	return r.readAt(pos, true)
+}
+
+// readAt returns the single record ending at pos if ending is set, and the one starting at pos otherwise, as
+// the key index refers to records by their start, see ReadAt.
+func (r *Reader) readAt(pos int64, ending bool) (*Rec, error) {
+
+	// the record is looked up by a byte it holds, which for records ending at pos is the one before pos
+	at := pos
+	if ending {
+		at = pos - 1
+	}
+
+	// the buffer is read before the chunk, so a seal in between leaves the record in either of them
+	b, err := r.buffer()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := r.chunkAt(at)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunk []byte
+	var chunkPos, startIndex int64
+	var whole bool
+
+	if c != nil && len(c.Blocks) > 1 && r.cache == nil {
+		// only the block holding the record is decompressed, and read like a chunk of its own
+		if chunk, chunkPos, startIndex, err = r.loadBlock(c, at); err != nil {
+			return nil, errors.Wrap(err, "loadBlock")
+		}
+	} else if c != nil {
+		if chunk, err = r.loadChunk(c); err != nil {
+			return nil, errors.Wrap(err, "loadChunk")
+		}
+		chunkPos = c.StartPos
+		startIndex = c.StartIndex
+		whole = true
+	} else {
+		first, err := r.firstPos()
+		if err != nil {
+			return nil, err
+		}
+		if at < first {
+			return nil, errors.Wrapf(ErrTruncated, "position %d, first readable position %d", pos, first)
+		}
+
+		if b == nil || at < b.StartPos || at >= b.StartPos+b.Pos {
+			return nil, ErrOutOfRange
+		}
+
+		if chunk, err = r.readBuffer(b); err != nil {
+			return nil, err
+		}
+		chunkPos = b.StartPos
+		startIndex = b.StartIndex
+	}
+
+	format, err := r.recordFormat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := int(pos - chunkPos)
+	var i int64
+	if whole && r.cache != nil {
+		// the offset table is built once per cached chunk, and kept along with it
+		offsets, err := r.chunkOffsets(c, chunk, format.checksums)
+		if err != nil {
+			return nil, err
+		}
+		j := sort.SearchInts(offsets, offset)
+		if ending {
+			// the record ending at offset is the one before the record starting there, or the last one
+			if j == 0 || (j == len(offsets) && offset != len(chunk)) || (j < len(offsets) && offsets[j] != offset) {
+				return nil, ErrNotRecordBoundary
+			}
+			j--
+			offset = offsets[j]
+		} else if j == len(offsets) || offsets[j] != offset {
+			return nil, ErrNotRecordBoundary
+		}
+		i = int64(j)
+	} else if ending {
+		// without a cache, the table would be rebuilt on every call, so only the records up to pos are walked
+		start, n, err := recordEndingAt(chunk, offset, format.checksums)
+		if err != nil {
+			return nil, err
+		}
+		if start < 0 {
+			return nil, ErrNotRecordBoundary
+		}
+		offset, i = start, n
+	} else {
+		start, err := nextRecord(chunk, offset, format.checksums)
+		if err != nil {
+			return nil, err
+		}
+		if start != offset || offset >= len(chunk) {
+			return nil, ErrNotRecordBoundary
+		}
+		if i, err = recordsBefore(chunk, offset, format.checksums); err != nil {
+			return nil, err
+		}
+	}
+
+	data, next, err := readRecord(chunk, offset, chunkPos, format.checksums)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &Rec{Data: data, ChunkPos: chunkPos, StartPos: chunkPos + int64(offset), NextPos: chunkPos + int64(next),
+		Index: startIndex + i}
+	if c != nil {
+		rec.ChunkPos = c.StartPos
+	}
+	if format.timestamps {
+		if rec.Timestamp, rec.Data, err = splitStamp(data); err != nil {
+			return nil, errors.Wrapf(err, "position %d", pos)
+		}
+	}
+	r.metrics.RecordRead(int64(len(rec.Data)))
+	return rec, nil
+}
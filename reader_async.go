@@ -2,34 +2,173 @@ package cellar
 
 import (
 	"context"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
+// Rec is a record returned by the asynchronous scans and by ReadAt.
 type Rec struct {
 	Data     []byte
 	ChunkPos int64
+	// StartPos is the position of the record, as taken by ScanFrom, and NextPos the position following it,
+	// which is the position Append returned for the record, as taken by ReadAt
 	StartPos int64
 	NextPos  int64
+
+	// Timestamp is the time the record was appended at, in cellars storing record timestamps
+	Timestamp time.Time
+	// Index is the number of records appended before the record, see Writer.AppendIndexed
+	Index int64
+}
+
+// newRec copies the position, timestamp and index of the record described by ri into a Rec holding data.
+func newRec(ri *ReaderInfo, data []byte) *Rec {
+	return &Rec{Data: data, ChunkPos: ri.ChunkPos, StartPos: ri.StartPos, NextPos: ri.NextPos, Timestamp: ri.Timestamp,
+		Index: ri.Index}
 }
 
 // ScanAsync runs Reader.Scan in a goroutine, returning the values obtained.
 //
-// ScanAsync honors context cancellations. If an error is received in the error channel, no more values will
-// be scanned and the routine exits.
+// ScanAsync honors context cancellations. The channels follow the same contract for all asynchronous
+// scans:
+//
+//   - if the scan fails, exactly one error is sent on the error channel, after which both channels are closed;
+//   - if the scan succeeds, both channels are closed without sending an error.
+//
+// The error channel is buffered, so consumers can range over the values first and receive from the error
+// channel afterwards, which yields either the error or nil.
 func (reader *Reader) ScanAsync(ctx context.Context, buffer int) (chan *Rec, chan error) {
+	return scanAsync(ctx, buffer, reader.withoutReuse().Scan)
+}
+
+// withoutReuse returns a copy of the reader which does not reuse buffers, for scans handing out records
+// which outlive the ReadOp.
+func (reader *Reader) withoutReuse() *Reader {
+	if !reader.ReuseBuffers {
+		return reader
+	}
+	r := *reader
+	r.ReuseBuffers = false
+	return &r
+}
+
+// ScanReverse runs a reverse scan in a goroutine, returning the values obtained starting with the most
+// recently appended record. It honors context cancellations the same way ScanAsync does.
+func (reader *Reader) ScanReverse(ctx context.Context) (chan *Rec, chan error) {
+	return scanAsync(ctx, 0, reader.scanReverse)
+}
+
+// ScanFrom runs a scan in a goroutine, returning all records starting at or after startPos. A startPos
+// inside a record is rounded up to the next record, and a startPos past the visible tail yields no
+// records at all. This allows resuming from a position stored with PutUserCheckpoint. A startPos deleted by
+// retention yields ErrTruncated.
+func (reader *Reader) ScanFrom(ctx context.Context, startPos int64) (chan *Rec, chan error) {
+	return scanAsync(ctx, 0, func(op ReadOp) error {
+		return reader.scanFrom(startPos, op)
+	})
+}
+
+// ScanRange runs a scan in a goroutine, returning only the records whose start position lies in [from, to).
+// The value channel is closed as soon as the scan passes to, without reading the remaining chunks.
+func (reader *Reader) ScanRange(ctx context.Context, from, to int64) (chan *Rec, chan error) {
+	return scanAsync(ctx, 0, func(op ReadOp) error {
+		return reader.scanRange(from, to, op)
+	})
+}
+
+// ScanLimit runs Reader.Scan in a goroutine, stopping after n records have been sent. Once the limit is
+// reached the producer stops reading and both channels are closed, so no context cancellation is needed
+// to tear it down. A limit of 0 means no limit.
+func (reader *Reader) ScanLimit(ctx context.Context, n int) (chan *Rec, chan error) {
+	reader = reader.withoutReuse()
+	return scanAsync(ctx, 0, func(op ReadOp) error {
+		if n <= 0 {
+			return reader.Scan(op)
+		}
+
+		seen := 0
+		err := reader.Scan(func(ri *ReaderInfo, data []byte) error {
+			if err := op(ri, data); err != nil {
+				return err
+			}
+			seen++
+			if seen == n {
+				return errStopScan
+			}
+			return nil
+		})
+		if errors.Cause(err) == errStopScan {
+			return nil
+		}
+		return err
+	})
+}
+
+// Follow behaves like tail -f: it scans up to the current tail of the cellar, and then keeps polling for new
+// records every PollInterval, sending them as they become visible. Since polling resumes from the position
+// following the last record sent, records moving from the buffer into a sealed chunk are neither skipped nor
+// sent twice.
+//
+// Follow runs until ctx is cancelled, after which both channels are closed without an error.
+func (reader *Reader) Follow(ctx context.Context) (chan *Rec, chan error) {
+	interval := reader.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	return scanAsync(ctx, 0, func(op ReadOp) error {
+		next, err := reader.firstPos()
+		if err != nil {
+			return err
+		}
+
+		for {
+			err := reader.scanFrom(next, func(ri *ReaderInfo, data []byte) error {
+				if err := op(ri, data); err != nil {
+					return err
+				}
+				next = ri.NextPos
+				return nil
+			})
+
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(interval):
+			}
+		}
+	})
+}
+
+// scanAsync runs scan in a goroutine, sending every record it reads on the returned value channel.
+func scanAsync(ctx context.Context, buffer int, scan func(op ReadOp) error) (chan *Rec, chan error) {
 	vals := make(chan *Rec, buffer)
-	errs := make(chan error)
+	errs := make(chan error, 1)
 
 	go func() {
-		// make sure we terminate the channel on scan read
-		defer close(vals)
+		// both channels are closed once the error, if any, has been sent
 		defer close(errs)
+		defer close(vals)
+
+		err := scan(func(ri *ReaderInfo, data []byte) error {
+			// a cancelled context takes precedence over a consumer which is still receiving
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 
-		err := reader.Scan(func(ri *ReaderInfo, data []byte) error {
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			default:
-				vals <- &Rec{data, ri.ChunkPos, ri.StartPos, ri.NextPos}
+			case vals <- newRec(ri, data):
 				return nil
 			}
 		})
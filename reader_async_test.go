@@ -2,13 +2,17 @@ package cellar
 
 import (
 	"context"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestReader_ScanAsync(t *testing.T) {
-	db, err := New(dbDir, WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	db, err := New(dbDir, WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()))
 	require.NoError(t, err)
 
 	// Write some testdata
@@ -20,24 +24,333 @@ func TestReader_ScanAsync(t *testing.T) {
 	reader := db.Reader()
 
 	var passed bool
-	vals, errchan := reader.ScanAsync(context.Background(), 1)
-	for {
-		select {
-		case <-errchan:
-			require.NoError(t, <-errchan)
-		case v, ok := <-vals:
-			if !ok {
-				break
-			}
-			if string(v.Data) == testdata {
-				passed = true
-				break
-			}
-		}
-		if passed {
-			break
+	vals, errs := reader.ScanAsync(context.Background(), 1)
+	for v := range vals {
+		if string(v.Data) == testdata {
+			passed = true
 		}
 	}
+	require.NoError(t, <-errs)
 	assert.True(t, passed)
 
+	// the error channel is closed once the scan is done
+	_, ok := <-errs
+	assert.False(t, ok)
+}
+
+func TestReader_ScanAsync_Positions(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	// records spread over chunks and the buffer
+	var starts, appended []int64
+	for i := 0; i < 5; i++ {
+		starts = append(starts, db.VolatilePos())
+		pos, err := db.Append(genSeedBytes(300, i))
+		require.NoError(t, err)
+		appended = append(appended, pos)
+	}
+	require.NoError(t, db.Flush())
+
+	reader := db.Reader()
+	vals, errs := reader.ScanAsync(context.Background(), 0)
+	i := 0
+	for rec := range vals {
+		assert.Equal(t, starts[i], rec.StartPos)
+		assert.Equal(t, appended[i], rec.NextPos)
+
+		// the position Append returned for a record reads it back
+		read, err := reader.ReadAt(rec.NextPos)
+		require.NoError(t, err)
+		assert.Equal(t, rec.Data, read.Data)
+		i++
+	}
+	require.NoError(t, <-errs)
+	assert.Equal(t, 5, i)
+}
+
+func TestReader_ScanAsync_Error(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	for i := 0; i < 3; i++ {
+		_, err = db.Append([]byte("TestReader_ScanAsync_Error"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.SealTheBuffer())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	vals, errs := db.Reader().ScanAsync(ctx, 0)
+
+	<-vals
+	cancel()
+
+	// drain whatever was in flight, the value channel must be closed
+	for range vals {
+	}
+
+	assert.Equal(t, context.Canceled, errors.Cause(<-errs))
+	_, ok := <-errs
+	assert.False(t, ok)
+}
+
+func TestReader_ScanReverse(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	inputs := []string{"first", "second", "third", "fourth", "fifth"}
+	for i, input := range inputs {
+		_, err = db.Append([]byte(input))
+		require.NoError(t, err)
+
+		// seal the first records into a chunk, leaving the rest in the buffer
+		if i == 2 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+	_, err = db.Checkpoint()
+	require.NoError(t, err)
+
+	var found []string
+	vals, errs := db.Reader().ScanReverse(context.Background())
+	for v := range vals {
+		found = append(found, string(v.Data))
+	}
+	require.NoError(t, <-errs)
+
+	assert.Equal(t, []string{"fifth", "fourth", "third", "second", "first"}, found)
+}
+
+func TestReader_ScanFrom(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	var positions []int64
+	inputs := []string{"first", "second", "third", "fourth", "fifth"}
+	for i, input := range inputs {
+		// positions returned by Append point to the end of the record
+		positions = append(positions, db.VolatilePos())
+		_, err = db.Append([]byte(input))
+		require.NoError(t, err)
+
+		if i == 2 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+	tail, err := db.Checkpoint()
+	require.NoError(t, err)
+
+	collect := func(startPos int64) []string {
+		var found []string
+		vals, errs := db.Reader().ScanFrom(context.Background(), startPos)
+		for v := range vals {
+			found = append(found, string(v.Data))
+		}
+		require.NoError(t, <-errs)
+		return found
+	}
+
+	assert.Equal(t, inputs, collect(0))
+	assert.Equal(t, inputs[1:], collect(positions[1]))
+	assert.Equal(t, inputs[3:], collect(positions[3]))
+	// positions within a record round up to the next record
+	assert.Equal(t, inputs[2:], collect(positions[1]+1))
+	assert.Equal(t, inputs[4:], collect(positions[3]+1))
+	assert.Empty(t, collect(tail))
+	assert.Empty(t, collect(tail+100))
+}
+
+func TestReader_ScanRange(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	var positions []int64
+	inputs := []string{"first", "second", "third", "fourth", "fifth"}
+	for i, input := range inputs {
+		positions = append(positions, db.VolatilePos())
+		_, err = db.Append([]byte(input))
+		require.NoError(t, err)
+
+		if i == 2 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+	tail, err := db.Checkpoint()
+	require.NoError(t, err)
+
+	collect := func(from, to int64) []string {
+		var found []string
+		vals, errs := db.Reader().ScanRange(context.Background(), from, to)
+		for v := range vals {
+			found = append(found, string(v.Data))
+		}
+		require.NoError(t, <-errs)
+		return found
+	}
+
+	assert.Equal(t, inputs, collect(0, tail))
+	assert.Equal(t, inputs[1:3], collect(positions[1], positions[3]))
+	assert.Equal(t, inputs[1:4], collect(positions[1], positions[3]+1))
+	// the range lies fully inside the buffer
+	assert.Equal(t, inputs[3:4], collect(positions[3], positions[4]))
+	assert.Empty(t, collect(positions[2], positions[2]))
+}
+
+func TestReader_ScanLimit(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	inputs := []string{"first", "second", "third", "fourth", "fifth"}
+	for _, input := range inputs {
+		_, err = db.Append([]byte(input))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.SealTheBuffer())
+
+	collect := func(n int) []string {
+		var found []string
+		vals, errs := db.Reader().ScanLimit(context.Background(), n)
+		for v := range vals {
+			found = append(found, string(v.Data))
+		}
+		require.NoError(t, <-errs)
+		return found
+	}
+
+	assert.Equal(t, inputs[:2], collect(2))
+	assert.Equal(t, inputs, collect(len(inputs)))
+	assert.Equal(t, inputs, collect(10))
+	// 0 means unlimited
+	assert.Equal(t, inputs, collect(0))
+}
+
+func TestReader_Follow(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	_, err = db.Append([]byte("existing"))
+	require.NoError(t, err)
+	_, err = db.Checkpoint()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader := db.Reader()
+	reader.PollInterval = 10 * time.Millisecond
+	vals, errs := reader.Follow(ctx)
+
+	assert.Equal(t, "existing", string((<-vals).Data))
+
+	// seal the buffer holding the record we already received, which should not be sent again
+	require.NoError(t, db.SealTheBuffer())
+
+	_, err = db.Append([]byte("appended"))
+	require.NoError(t, err)
+	_, err = db.Checkpoint()
+	require.NoError(t, err)
+
+	assert.Equal(t, "appended", string((<-vals).Data))
+
+	cancel()
+	for range vals {
+	}
+	assert.NoError(t, <-errs)
+}
+
+func TestReader_ScanAsync_Concurrent(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithScanConcurrency(4))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	var expected []int64
+	for i := 0; i < 50; i++ {
+		pos, err := db.Append(genSeedBytes(100, i))
+		require.NoError(t, err)
+		expected = append(expected, pos)
+
+		// produce plenty of small chunks
+		if i%3 == 0 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+	require.NoError(t, db.SealTheBuffer())
+
+	var found []int64
+	vals, errs := db.Reader().ScanAsync(context.Background(), 0)
+	for v := range vals {
+		require.NoError(t, checkSeedBytes(v.Data, len(found)))
+		found = append(found, v.NextPos)
+	}
+	require.NoError(t, <-errs)
+	assert.Equal(t, expected, found)
+}
+
+// countingDecompressor counts the chunks it decompresses.
+type countingDecompressor struct {
+	Decompressor
+	chunks *int32
+}
+
+func (c countingDecompressor) Decompress(src io.Reader) (io.Reader, error) {
+	atomic.AddInt32(c.chunks, 1)
+	return c.Decompressor.Decompress(src)
+}
+
+func TestReader_ScanAsync_MaxInFlightChunks(t *testing.T) {
+	_, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMaxInFlightChunks(0))
+	assert.Error(t, err)
+
+	folder := getFolder()
+	db, err := New(folder, WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCompressor(Lz4Compressor{}),
+		WithScanConcurrency(8), WithMaxInFlightChunks(2))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	// chunks of two records
+	for i := 0; i < 40; i++ {
+		_, err = db.Append(genSeedBytes(100, i))
+		require.NoError(t, err)
+		if i%2 == 1 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+
+	var loaded int32
+	reader := db.Reader()
+	reader.decompressor = countingDecompressor{reader.decompressor, &loaded}
+
+	// the slow consumer holds up the producer, which decompresses at most one chunk ahead
+	var seen int
+	vals, errs := reader.ScanAsync(context.Background(), 0)
+	for v := range vals {
+		require.NoError(t, checkSeedBytes(v.Data, seen))
+		// the chunk before is released once its last record was received
+		if seen%2 == 0 {
+			chunks := int32(seen/2) + 1
+			assert.True(t, atomic.LoadInt32(&loaded) <= chunks+1, "%d chunks loaded while reading chunk %d",
+				atomic.LoadInt32(&loaded), chunks)
+		}
+		seen++
+		time.Sleep(time.Millisecond)
+	}
+	require.NoError(t, <-errs)
+	assert.Equal(t, 40, seen)
+	assert.Equal(t, int32(20), loaded)
 }
@@ -1,11 +1,21 @@
 package cellar
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.etcd.io/bbolt"
-	"testing"
-	"time"
 )
 
 func TestReader_Scan(t *testing.T) {
@@ -68,3 +78,602 @@ func TestRestartingDBWorks(t *testing.T) {
 	assert.True(t, seen == 100)
 
 }
+
+func TestReader_ReadAt(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	// the positions Append returns, which point past the end of the record
+	var positions []int64
+	inputs := []string{"first", "second", "third", "fourth", "fifth"}
+	for i, input := range inputs {
+		pos, err := db.Append([]byte(input))
+		require.NoError(t, err)
+		positions = append(positions, pos)
+
+		if i == 2 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+	_, err = db.Checkpoint()
+	require.NoError(t, err)
+
+	reader := db.Reader()
+	start := int64(0)
+	for i, pos := range positions {
+		rec, err := reader.ReadAt(pos)
+		require.NoError(t, err)
+		assert.Equal(t, inputs[i], string(rec.Data))
+		assert.Equal(t, start, rec.StartPos)
+		assert.Equal(t, pos, rec.NextPos)
+		assert.Equal(t, int64(i), rec.Index)
+		start = pos
+	}
+
+	_, err = reader.ReadAt(0)
+	assert.Equal(t, ErrNotRecordBoundary, err)
+
+	_, err = reader.ReadAt(positions[1] - 1)
+	assert.Equal(t, ErrNotRecordBoundary, err)
+
+	_, err = reader.ReadAt(positions[4] - 1)
+	assert.Equal(t, ErrNotRecordBoundary, err)
+
+	_, err = reader.ReadAt(positions[4] + 1)
+	assert.Equal(t, ErrOutOfRange, err)
+}
+
+func TestReader_RecordOffsets(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithRecordChecksums(),
+		WithReadCache(1<<20))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	var offsets []int
+	for _, input := range []string{"first", "second", "third"} {
+		offsets = append(offsets, int(db.VolatilePos()))
+		_, err = db.Append([]byte(input))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.SealTheBuffer())
+	second := db.VolatilePos()
+	_, err = db.Append([]byte("fourth"))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	reader := db.Reader()
+	found, err := reader.RecordOffsets(0)
+	require.NoError(t, err)
+	assert.Equal(t, offsets, found)
+
+	found, err = reader.RecordOffsets(second)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0}, found)
+
+	_, err = reader.RecordOffsets(1)
+	assert.ErrorIs(t, err, ErrChunkNotFound)
+
+	// the offsets are kept in the read cache along with the chunk
+	chunk, ok := db.cache.get(0)
+	require.True(t, ok)
+	cached, ok := db.cache.offsets(0, chunk)
+	require.True(t, ok)
+	assert.Equal(t, offsets, cached)
+
+	// and back reads and skips into the chunk
+	rec, err := reader.ReadAt(second)
+	require.NoError(t, err)
+	assert.Equal(t, "third", string(rec.Data))
+	assert.Equal(t, int64(2), rec.Index)
+
+	var from []string
+	require.NoError(t, reader.scanFrom(int64(offsets[1])+1, func(info *ReaderInfo, data []byte) error {
+		from = append(from, string(data))
+		return nil
+	}))
+	assert.Equal(t, []string{"third", "fourth"}, from)
+
+	var reverse []string
+	require.NoError(t, reader.scanReverse(func(info *ReaderInfo, data []byte) error {
+		reverse = append(reverse, string(data))
+		return nil
+	}))
+	assert.Equal(t, []string{"fourth", "third", "second", "first"}, reverse)
+}
+
+func TestReader_ForEach(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	inputs := []string{"first", "second", "third"}
+	for _, input := range inputs {
+		_, err = db.Append([]byte(input))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.SealTheBuffer())
+
+	var found []string
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		found = append(found, string(rec.Data))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, inputs, found)
+
+	stop := errors.New("stop")
+	seen := 0
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		seen++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, seen)
+}
+
+func TestReader_Reset(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	for _, input := range []string{"first", "second"} {
+		_, err = db.Append([]byte(input))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.SealTheBuffer())
+
+	reader := db.Reader()
+	scan := func() []string {
+		var found []string
+		require.NoError(t, reader.ForEach(func(rec *Rec) error {
+			found = append(found, string(rec.Data))
+			return nil
+		}))
+		return found
+	}
+
+	// a query of the sealed chunk only
+	reader.Flags = RF_None
+	reader.StartPos = int64(len("first") + 1)
+	assert.Equal(t, []string{"second"}, scan())
+
+	_, err = db.Append([]byte("third"))
+	require.NoError(t, err)
+	require.NoError(t, db.Flush())
+
+	reader.Reset()
+	assert.Equal(t, []string{"first", "second", "third"}, scan())
+	assert.Equal(t, []string{"first", "second", "third"}, scan())
+}
+
+func TestReader_ReuseBuffers(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	// chunks of two records, followed by a record in the buffer
+	for i := 0; i < 7; i++ {
+		_, err = db.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Flush())
+
+	reader := db.Reader()
+	reader.ReuseBuffers = true
+	reader.VerifyOnRead = true
+
+	var records [][]byte
+	err = reader.ForEach(func(rec *Rec) error {
+		records = append(records, append([]byte(nil), rec.Data...))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, records, 7)
+	for i, data := range records {
+		assert.Equal(t, genSeedBytes(400, i), data)
+	}
+
+	// asynchronous scans hand out records which outlive the op
+	vals, errs := reader.ScanAsync(context.Background(), 10)
+	var recs []*Rec
+	for rec := range vals {
+		recs = append(recs, rec)
+	}
+	require.NoError(t, <-errs)
+	require.Len(t, recs, 7)
+	for i, rec := range recs {
+		assert.Equal(t, genSeedBytes(400, i), rec.Data)
+	}
+}
+
+// TestReader_Concurrent scans from many readers while the writer appends, each reader seeing a prefix of
+// the appended records. Run with -race to catch shared state on the read path.
+func TestReader_Concurrent(t *testing.T) {
+	for name, options := range map[string][]Option{
+		"plain":       nil,
+		"cached":      {WithReadCache(1 << 20)},
+		"mmap":        {WithMmapReads()},
+		"concurrency": {WithScanConcurrency(4)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			options = append(options, WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMaxBufferSize(2000),
+				WithCompressor(Lz4Compressor{}))
+			db, err := New(getFolder(), options...)
+			require.NoError(t, err)
+			defer checkedClose(db)
+
+			const (
+				Records = 300
+				Readers = 6
+			)
+
+			var positions []int64
+			for i := 0; i < 10; i++ {
+				pos, err := db.Append(genSeedBytes(300, i))
+				require.NoError(t, err)
+				positions = append(positions, pos)
+			}
+			require.NoError(t, db.Flush())
+
+			done := make(chan struct{})
+			wg := &sync.WaitGroup{}
+
+			// a shared reader is scanned from several goroutines at once
+			shared := db.Reader()
+
+			scan := func(reader *Reader) {
+				var n int
+				err := reader.ForEach(func(rec *Rec) error {
+					if !bytes.Equal(genSeedBytes(300, n), rec.Data) {
+						return fmt.Errorf("record %d at %d in chunk %d does not match", n, rec.StartPos, rec.ChunkPos)
+					}
+					n++
+					return nil
+				})
+				assert.NoError(t, err)
+				assert.True(t, n >= 10, "scanned %d records", n)
+			}
+
+			for i := 0; i < Readers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for {
+						select {
+						case <-done:
+							return
+						default:
+						}
+
+						switch i % 3 {
+						case 0:
+							scan(shared)
+						case 1:
+							reader := db.Reader()
+							reader.ReuseBuffers = true
+							scan(reader)
+						case 2:
+							reader := db.Reader()
+							for j, pos := range positions {
+								rec, err := reader.ReadAt(pos)
+								if assert.NoError(t, err) {
+									assert.Equal(t, genSeedBytes(300, j), rec.Data)
+								}
+							}
+						}
+					}
+				}(i)
+			}
+
+			for i := 10; i < Records; i++ {
+				_, err := db.Append(genSeedBytes(300, i))
+				require.NoError(t, err)
+				if i%7 == 0 {
+					require.NoError(t, db.Flush())
+				}
+			}
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
+func TestReader_SealedBuffer(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMaxBufferSize(1000),
+		WithCompressor(Lz4Compressor{}))
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	var positions []int64
+	for i := 0; i < 2; i++ {
+		pos, err := db.Append(genSeedBytes(300, i))
+		require.NoError(t, err)
+		positions = append(positions, pos)
+	}
+	require.NoError(t, db.Flush())
+
+	// the reader holds the state of a buffer, which the writer seals before its file is read
+	reader := db.Reader()
+	stale, err := reader.buffer()
+	require.NoError(t, err)
+	reader.buffer = func() (*BufferDto, error) { return stale, nil }
+
+	require.NoError(t, db.SealTheBuffer())
+	_, err = os.Stat(path.Join(reader.Folder, stale.FileName))
+	require.True(t, os.IsNotExist(err))
+
+	_, err = db.Append(genSeedBytes(300, 2))
+	require.NoError(t, err)
+	require.NoError(t, db.Flush())
+
+	var records [][]byte
+	require.NoError(t, reader.ForEach(func(rec *Rec) error {
+		records = append(records, rec.Data)
+		return nil
+	}))
+	assert.Equal(t, [][]byte{genSeedBytes(300, 0), genSeedBytes(300, 1)}, records)
+
+	count, err := reader.Count()
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+
+	rec, err := reader.ReadAt(positions[0])
+	require.NoError(t, err)
+	assert.Equal(t, genSeedBytes(300, 0), rec.Data)
+}
+
+// BenchmarkReader_Scan scans a cellar of 100 chunks, decompressing every chunk into a new buffer, while
+// BenchmarkReader_Scan_ReuseBuffers reuses pooled ones.
+func BenchmarkReader_Scan(b *testing.B) {
+	benchmarkReaderScan(b, false)
+}
+
+func BenchmarkReader_Scan_ReuseBuffers(b *testing.B) {
+	benchmarkReaderScan(b, true)
+}
+
+func benchmarkReaderScan(b *testing.B, reuse bool) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMaxBufferSize(64*1024))
+	require.NoError(b, err)
+
+	defer checkedClose(db)
+
+	for i := 0; i < 1000; i++ {
+		_, err = db.Append(genSeedBytes(6000, i))
+		require.NoError(b, err)
+	}
+	require.NoError(b, db.SealTheBuffer())
+
+	reader := db.Reader()
+	reader.ReuseBuffers = reuse
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = reader.Scan(func(info *ReaderInfo, data []byte) error { return nil })
+		require.NoError(b, err)
+	}
+}
+
+func TestReader_MissingChunk(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+	logger := &recordingLogger{mu: &sync.Mutex{}}
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000), WithLogger(logger))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	// chunks of two records, followed by a record in the buffer
+	var positions []int64
+	for i := 0; i < 5; i++ {
+		positions = append(positions, db.VolatilePos())
+		_, err = db.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Flush())
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	for _, c := range chunks {
+		if c.StartPos == positions[2] {
+			require.NoError(t, os.Remove(path.Join(folder, c.FileName)))
+		}
+	}
+
+	// reads are strict by default
+	err = db.Reader().Scan(func(*ReaderInfo, []byte) error { return nil })
+	assert.Equal(t, ErrChunkFileMissing, pkgerrors.Cause(err))
+	assert.Contains(t, err.Error(), fmt.Sprintf("position %d", positions[2]))
+
+	vals, errs := db.Reader().ScanReverse(context.Background())
+	for range vals {
+	}
+	assert.Equal(t, ErrChunkFileMissing, pkgerrors.Cause(<-errs))
+
+	_, err = db.Reader().ReadAt(positions[4])
+	assert.Equal(t, ErrChunkFileMissing, pkgerrors.Cause(err))
+
+	// the other chunks are read when skipping missing ones
+	reader := db.Reader()
+	reader.SkipMissingChunks = true
+
+	var seeds []int
+	err = reader.ForEach(func(rec *Rec) error {
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 4}, seeds)
+
+	vals, errs = reader.ScanFrom(context.Background(), positions[1])
+	seeds = nil
+	for rec := range vals {
+		seeds = append(seeds, int(rec.Data[0]))
+	}
+	require.NoError(t, <-errs)
+	assert.Equal(t, []int{1, 4}, seeds)
+
+	var skipped int
+	for _, msg := range logger.messages {
+		if strings.Contains(msg, "skipping") {
+			skipped++
+		}
+	}
+	assert.Equal(t, 2, skipped)
+}
+
+func TestReader_Count(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	count, err := db.Reader().Count()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	for i := 0; i < 5; i++ {
+		_, err = db.Append([]byte("TestReader_Count"))
+		require.NoError(t, err)
+
+		if i == 2 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+	_, err = db.Checkpoint()
+	require.NoError(t, err)
+
+	count, err = db.Reader().Count()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), count)
+}
+
+func TestReader_Flush_VolatileTail(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	_, err = db.Append([]byte("flushed"))
+	require.NoError(t, err)
+
+	// not flushed yet, so the record is still held in memory
+	count, err := db.Reader().Count()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	require.NoError(t, db.Flush())
+
+	var found []string
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		found = append(found, string(rec.Data))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"flushed"}, found)
+
+	// flushing neither seals nor checkpoints
+	chunks, err := db.meta.ListChunks()
+	require.NoError(t, err)
+	assert.Empty(t, chunks)
+
+	buf, err := db.meta.GetBuffer()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), buf.Pos)
+}
+
+func TestReader_ChunkStats(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	for i := 0; i < 2; i++ {
+		_, err = db.Append(makeSlice(1000))
+		require.NoError(t, err)
+		_, err = db.Append(makeSlice(1000))
+		require.NoError(t, err)
+		require.NoError(t, db.SealTheBuffer())
+	}
+
+	stats, err := db.Reader().ChunkStats()
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+
+	for i, stat := range stats {
+		assert.Equal(t, int64(i*2004), stat.StartPos)
+		assert.Equal(t, int64(2), stat.Records)
+		assert.Equal(t, int64(2004), stat.UncompressedSize)
+		// zeroes compress well
+		assert.True(t, stat.CompressedSize > 0)
+		assert.True(t, stat.Ratio() > 1)
+	}
+}
+
+func TestReader_Chunks(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()), WithCodec(CodecZstd))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	before := time.Now().Add(-time.Second)
+	for i := 0; i < 2; i++ {
+		_, err = db.Append(makeSlice(1000))
+		require.NoError(t, err)
+		require.NoError(t, db.SealTheBuffer())
+	}
+
+	infos, err := db.Reader().Chunks()
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+
+	for i, info := range infos {
+		assert.Equal(t, int64(i*1002), info.StartPos)
+		assert.Equal(t, int64(1), info.Records)
+		assert.Equal(t, int64(1002), info.UncompressedSize)
+		assert.True(t, info.CompressedSize > 0)
+		assert.Equal(t, CodecZstd, info.Codec)
+		assert.True(t, info.CreatedAt.After(before))
+		assert.NotEmpty(t, info.FileName)
+	}
+}
+
+func TestReader_ChunkAt(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	// three chunks of 102 bytes, and a record in the buffer
+	for i := 0; i < 3; i++ {
+		_, err = db.Append(makeSlice(100))
+		require.NoError(t, err)
+		require.NoError(t, db.SealTheBuffer())
+	}
+	_, err = db.Append(makeSlice(100))
+	require.NoError(t, err)
+
+	reader := db.Reader()
+	for _, pos := range []int64{0, 101, 102, 150, 305} {
+		info, ok, err := reader.ChunkAt(pos)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, pos/102*102, info.StartPos)
+		assert.Equal(t, int64(102), info.UncompressedSize)
+	}
+
+	for _, pos := range []int64{306, 350, 10000} {
+		_, ok, err := reader.ChunkAt(pos)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	}
+}
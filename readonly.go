@@ -0,0 +1,129 @@
+package cellar
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var ErrReadOnly = errors.New("cellar: not supported by a read-only cellar")
+
+// OpenReadOnly opens the cellar in folder for reading only, returning a reader over its records. It never
+// creates a buffer, takes the file lock of the cellar or writes to the meta DB, so it can be attached to a
+// cellar owned by another process. Unless a meta DB is passed with WithMetaDB, the bolt meta DB of the
+// cellar is opened read-only, and closed by Reader.Close, which also unmaps the chunks mapped with
+// WithMmapReads. Options which would modify the cellar, such as
+// WithRecordChecksums, WithRecordTimestamps and WithRepairTruncated, fail with ErrReadOnly.
+//
+// The reader sees the sealed chunks, and the current buffer up to the last checkpoint of the writing
+// process. Records appended since, or only flushed, are not visible until the writer checkpoints them.
+// Chunks removed by retention or compaction while a scan is running fail the scan, which can be retried.
+//
+// The bolt meta DB is locked exclusively by the process writing to it, so opening it read-only fails with a
+// LockedError after a second, or the time set with WithOpenTimeout, while the writer is running. Side-car
+// processes reading a live cellar need a meta DB supporting concurrent access, such as the SQLite meta DB,
+// passed to both processes with WithMetaDB. A bolt meta DB last written by a version storing chunk keys little
+// endian is not converted, as New would, but copied into memory, so the reader does not see later changes.
+func OpenReadOnly(folder string, options ...Option) (*Reader, error) {
+	db := &DB{
+		folder: folder,
+		buffer: defaultBufferSize,
+		fs:     osFS{},
+
+		readonly:        true,
+		scanConcurrency: 1,
+		logger:          stdLogger{},
+		metrics:         NopMetrics{},
+		trace:           nopTrace{},
+	}
+
+	for _, opt := range options {
+		if err := opt(db); err != nil {
+			return nil, err
+		}
+	}
+
+	if db.recordChecksums || db.recordTimestamps || db.repairTruncated {
+		return nil, ErrReadOnly
+	}
+
+	if db.cipher == nil && db.passphrase == "" {
+		db.cipher = NewAES(defaultEncryptionKey)
+	}
+
+	if db.registry == nil {
+		db.registry = defaultRegistry
+	}
+
+	if db.decompressor == nil {
+		db.decompressor = ChainDecompressor{}
+	}
+
+	var owned *bolt.DB
+	if db.meta == nil {
+		loc := fmt.Sprintf("%s/%s", folder, "meta.bolt")
+		// bolt creates missing files, even when opened read-only
+		if _, err := os.Stat(loc); err != nil {
+			return nil, errors.Wrap(err, "meta DB")
+		}
+
+		blt, err := openBolt(loc, db.metaTimeout(), true)
+		if errors.Cause(err) == ErrLocked {
+			return nil, errors.Wrap(err, "bolt meta DB is held by the writing process, side-car readers need a "+
+				"meta DB shared with it, such as SQLite passed with WithMetaDB")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		meta := &BoltMetaDB{DB: blt}
+		legacy, err := meta.legacyChunkKeys()
+		if err != nil {
+			blt.Close()
+			return nil, errors.Wrap(err, "meta DB")
+		}
+		if legacy {
+			// Init converts the chunk keys when the cellar is next opened for writing, until then the reader
+			// works on a copy of the metadata
+			snap, err := meta.snapshot()
+			blt.Close()
+			if err != nil {
+				return nil, errors.Wrap(err, "meta DB")
+			}
+			db.meta = snap
+		} else {
+			owned = blt
+			db.meta = meta
+		}
+	}
+
+	if db.passphrase != "" {
+		cipher, err := db.passphraseCipher()
+		if err != nil {
+			if owned != nil {
+				owned.Close()
+			}
+			return nil, err
+		}
+		db.cipher = cipher
+	}
+
+	// cellars in a newer layout are refused right away, rather than on the first read
+	if _, err := db.Reader().recordFormat(); err != nil {
+		if owned != nil {
+			owned.Close()
+		}
+		return nil, err
+	}
+
+	r := db.Reader()
+	if owned != nil {
+		r.closers = append(r.closers, owned)
+	}
+	if db.mmaps != nil {
+		r.closers = append(r.closers, db.mmaps)
+	}
+	return r, nil
+}
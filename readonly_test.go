@@ -0,0 +1,176 @@
+package cellar
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestOpenReadOnly(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder, WithNoFileLock, WithMaxBufferSize(1000))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = db.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	files, err := ioutil.ReadDir(folder)
+	require.NoError(t, err)
+	meta, err := ioutil.ReadFile(path.Join(folder, "meta.bolt"))
+	require.NoError(t, err)
+
+	r, err := OpenReadOnly(folder)
+	require.NoError(t, err)
+
+	var seeds []int
+	err = r.ForEach(func(rec *Rec) error {
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, seeds)
+	require.NoError(t, r.Close())
+
+	// neither the meta DB nor the folder were touched
+	after, err := ioutil.ReadDir(folder)
+	require.NoError(t, err)
+	assert.Len(t, after, len(files))
+	data, err := ioutil.ReadFile(path.Join(folder, "meta.bolt"))
+	require.NoError(t, err)
+	assert.Equal(t, meta, data)
+}
+
+func TestOpenReadOnly_Errors(t *testing.T) {
+	folder := getFolder()
+
+	// there is no cellar to read, and none is created
+	_, err := OpenReadOnly(folder)
+	assert.Error(t, err)
+
+	db, err := New(folder, WithNoFileLock)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = OpenReadOnly(folder, WithRecordChecksums())
+	assert.Equal(t, ErrReadOnly, err)
+
+	// the passphrase salt would have to be created
+	_, err = OpenReadOnly(folder, WithPassphrase("secret"))
+	assert.Equal(t, ErrReadOnly, errors.Cause(err))
+}
+
+func TestOpenReadOnly_WhileWriting(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder, WithNoFileLock, WithMaxBufferSize(1000))
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	// the bolt meta DB can't be shared with the writer
+	_, err = OpenReadOnly(folder, WithOpenTimeout(50*time.Millisecond))
+	var held *LockedError
+	assert.True(t, errors.As(err, &held))
+	assert.Contains(t, err.Error(), "SQLite")
+}
+
+func TestOpenReadOnly_WhileWriting_SQLite(t *testing.T) {
+	folder := getFolder()
+	loc := path.Join(folder, "meta.sqlite")
+
+	meta, err := OpenSQLiteMetaDB(loc)
+	require.NoError(t, err)
+	db, err := New(folder, WithNoFileLock, WithMaxBufferSize(1000), WithMetaDB(meta))
+	require.NoError(t, err)
+	defer checkedClose(meta)
+	defer checkedClose(db)
+
+	var positions []int64
+	for i := 0; i < 3; i++ {
+		pos, err := db.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+		positions = append(positions, pos)
+	}
+
+	sidecar, err := OpenSQLiteMetaDB(loc)
+	require.NoError(t, err)
+	defer checkedClose(sidecar)
+	r, err := OpenReadOnly(folder, WithMetaDB(sidecar))
+	require.NoError(t, err)
+	defer checkedClose(r)
+
+	// the first two records were sealed into a chunk when the third did not fit
+	for i, pos := range positions[:2] {
+		rec, err := r.ReadAt(pos)
+		require.NoError(t, err)
+		assert.NoError(t, checkSeedBytes(rec.Data, i))
+	}
+}
+
+func TestOpenReadOnly_LegacyChunkKeys(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder, WithNoFileLock, WithMaxBufferSize(1000))
+	require.NoError(t, err)
+	var positions []int64
+	for i := 0; i < 21; i++ {
+		pos, err := db.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+		positions = append(positions, pos)
+	}
+	require.NoError(t, db.Close())
+
+	// key the chunks little endian, as versions before Init converted them did
+	loc := path.Join(folder, "meta.bolt")
+	blt, err := openBolt(loc, time.Second, false)
+	require.NoError(t, err)
+	err = blt.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.Bucket(CellarBucketKey).Delete(ChunkKeyFormatKey))
+		chunks := tx.Bucket(ChunkTableKey)
+		var dtos []*ChunkDto
+		require.NoError(t, chunks.ForEach(func(k, v []byte) error {
+			dto := &ChunkDto{}
+			require.NoError(t, proto.Unmarshal(v, dto))
+			dtos = append(dtos, dto)
+			return nil
+		}))
+		require.Len(t, dtos, 10)
+		for _, dto := range dtos {
+			require.NoError(t, chunks.Delete(chunkKey(dto.StartPos)))
+			k := make([]byte, 8)
+			binary.LittleEndian.PutUint64(k, uint64(dto.StartPos))
+			v, err := proto.Marshal(dto)
+			require.NoError(t, err)
+			require.NoError(t, chunks.Put(k, v))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, blt.Close())
+	before, err := ioutil.ReadFile(loc)
+	require.NoError(t, err)
+
+	r, err := OpenReadOnly(folder)
+	require.NoError(t, err)
+	for i, pos := range positions {
+		rec, err := r.ReadAt(pos)
+		require.NoError(t, err)
+		assert.NoError(t, checkSeedBytes(rec.Data, i))
+	}
+	require.NoError(t, r.Close())
+
+	// the keys were left for New to convert
+	after, err := ioutil.ReadFile(loc)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
@@ -0,0 +1,153 @@
+package cellar
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrMetaNotEmpty = errors.New("cellar: meta DB to rebuild is not empty")
+
+// rebuiltChunk is a chunk found by RebuildMeta, along with the cellar metadata in its header.
+type rebuiltChunk struct {
+	chunk *ChunkDto
+	meta  *MetaDto
+}
+
+// readChunkFile derives the metadata of the chunk file at loc, named name within the cellar, from its
+// header, its size and its checksum.
+func readChunkFile(loc, name string) (*rebuiltChunk, error) {
+	f, err := os.Open(loc)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+	defer f.Close()
+
+	sum := crc32.NewIEEE()
+	c, meta, header, err := readChunkHeader(io.TeeReader(f, sum))
+	if err != nil {
+		return nil, err
+	}
+
+	// the checksum covers the whole file, header included
+	size, err := io.Copy(sum, f)
+	if err != nil {
+		return nil, errors.Wrap(err, "read chunk")
+	}
+
+	c.FileName = name
+	c.HeaderSize = uint32(header)
+	c.CompressedDiskSize = header + size
+	c.Checksum = sum.Sum32()
+	return &rebuiltChunk{chunk: c, meta: meta}, nil
+}
+
+// RebuildMeta restores the metadata of the cellar in dir into the empty meta DB db from the headers of its
+// chunk files, for cellars which lost their meta DB. The chunks are stored with the cellar metadata found in
+// the header of the last one, and the time index is sampled at every chunk. Chunks replaced by a compaction
+// whose files were not removed yet are left out. The cellar continues with an empty buffer following the last
+// chunk.
+//
+// What is not stored in chunk files is lost: the records appended since the last seal, the user checkpoints
+// and the bloom filters of the chunks. The key index is rebuilt when the cellar is opened with WithKeyIndex.
+// A dir without chunk files leaves db untouched, and chunk files without header, as written by cellars in
+// format version 0, fail with ErrNoChunkHeader, see UpgradeFormat. The cellar must not be opened during the
+// rebuild.
+func RebuildMeta(dir string, db MetaDB) error {
+	buffer, err := db.GetBuffer()
+	if err != nil {
+		return errors.Wrap(err, "GetBuffer")
+	}
+	if buffer != nil {
+		return ErrMetaNotEmpty
+	}
+
+	var found []*rebuiltChunk
+	err = filepath.Walk(dir, func(loc string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".lz4") {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, loc)
+		if err != nil {
+			return err
+		}
+		c, err := readChunkFile(loc, filepath.ToSlash(name))
+		if err != nil {
+			return errors.Wrapf(err, "chunk %s", name)
+		}
+		found = append(found, c)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "Walk")
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	// a merged chunk starts at the same position as the first chunk it replaces, and goes first
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i].chunk, found[j].chunk
+		if a.StartPos != b.StartPos {
+			return a.StartPos < b.StartPos
+		}
+		return a.UncompressedByteSize > b.UncompressedByteSize
+	})
+
+	var chunks []*rebuiltChunk
+	var end int64
+	for _, f := range found {
+		c := f.chunk
+		if len(chunks) > 0 && c.StartPos < end {
+			if c.StartPos+c.UncompressedByteSize <= end {
+				continue
+			}
+			return errors.Errorf("cellar: chunk %s overlaps chunk %s", c.FileName, chunks[len(chunks)-1].chunk.FileName)
+		}
+		chunks = append(chunks, f)
+		end = c.StartPos + c.UncompressedByteSize
+	}
+
+	last := chunks[len(chunks)-1]
+	for _, f := range chunks {
+		c := f.chunk
+		if err = db.PutChunk(c.StartPos, c); err != nil {
+			return errors.Wrapf(err, "PutChunk %d", c.StartPos)
+		}
+		if last.meta.RecordTimestamps && c.Records > 0 {
+			if err = db.PutTimeIndex(c.MinTimestamp, c.StartPos); err != nil {
+				return errors.Wrapf(err, "PutTimeIndex %d", c.StartPos)
+			}
+		}
+	}
+
+	if err = db.SetCellarMeta(last.meta); err != nil {
+		return errors.Wrap(err, "SetCellarMeta")
+	}
+
+	// the buffer goes last, since it marks the cellar as initialized
+	name := shardedName(fmt.Sprintf("%012d", end), int(last.meta.DirShardLevels))
+	if err = createShardDir(osFS{}, dir, name); err != nil {
+		return err
+	}
+	buffer = &BufferDto{
+		StartPos:   end,
+		StartIndex: last.chunk.StartIndex + last.chunk.Records,
+		MaxBytes:   defaultBufferSize,
+		FileName:   name,
+	}
+	if err = db.PutBuffer(buffer); err != nil {
+		return errors.Wrap(err, "PutBuffer")
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package cellar
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuildMeta(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+	options := []Option{WithNoFileLock, WithCompressor(Lz4Compressor{}), WithDirSharding(1), WithRecordTimestamps(),
+		WithRecordChecksums()}
+
+	db, err := New(folder, append(options, WithMetaDB(meta))...)
+	require.NoError(t, err)
+
+	// five chunks of ten records, the first two of which are merged
+	base := time.Unix(1500000000, 0)
+	var positions []int64
+	for i := 0; i < 50; i++ {
+		positions = append(positions, db.SealedPos()+db.writer.b.pos)
+		_, err = db.AppendAt(base.Add(time.Duration(i)*time.Second), []byte(fmt.Sprintf("record %d", i)))
+		require.NoError(t, err)
+		if i%10 == 9 {
+			require.NoError(t, db.SealTheBuffer())
+		}
+	}
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	first := chunks[0]
+	data, err := ioutil.ReadFile(path.Join(folder, first.FileName))
+	require.NoError(t, err)
+
+	_, err = db.Compact(2, 2*first.UncompressedByteSize+10)
+	require.NoError(t, err)
+
+	// records appended since the last seal are lost
+	_, err = db.AppendAt(base.Add(time.Hour), []byte("unsealed"))
+	require.NoError(t, err)
+	_, err = db.Checkpoint()
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// a chunk file left behind by the compaction
+	require.NoError(t, ioutil.WriteFile(path.Join(folder, first.FileName), data, 0644))
+
+	expected, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, expected, 4)
+
+	rebuilt := NewInMemoryMetaDB()
+	require.NoError(t, RebuildMeta(folder, rebuilt))
+	assert.Equal(t, ErrMetaNotEmpty, RebuildMeta(folder, rebuilt))
+
+	chunks, err = rebuilt.ListChunks()
+	require.NoError(t, err)
+	assert.Equal(t, sortChunks(expected), sortChunks(chunks))
+
+	cellarMeta, err := rebuilt.CellarMeta()
+	require.NoError(t, err)
+	assert.True(t, cellarMeta.RecordTimestamps)
+	assert.True(t, cellarMeta.RecordChecksums)
+	assert.Equal(t, int32(1), cellarMeta.DirShardLevels)
+
+	db, err = New(folder, append(options, WithMetaDB(rebuilt))...)
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	report, err := db.Reader().Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Bad)
+	assert.Equal(t, int64(50), report.Records)
+
+	var i int
+	require.NoError(t, db.Reader().ForEach(func(rec *Rec) error {
+		assert.Equal(t, fmt.Sprintf("record %d", i), string(rec.Data))
+		assert.Equal(t, positions[i], rec.StartPos)
+		i++
+		return nil
+	}))
+	assert.Equal(t, 50, i)
+
+	pos, err := db.Reader().SeekTime(base.Add(35 * time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, positions[35], pos)
+
+	// and the cellar continues after the last chunk
+	pos, err = db.Append([]byte("appended"))
+	require.NoError(t, err)
+	require.NoError(t, db.Flush())
+	rec, err := db.Reader().ReadAt(pos)
+	require.NoError(t, err)
+	assert.Equal(t, "appended", string(rec.Data))
+	assert.True(t, pos > positions[49])
+}
+
+func sortChunks(chunks []*ChunkDto) []*ChunkDto {
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].StartPos < chunks[j].StartPos
+	})
+	return chunks
+}
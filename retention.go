@@ -0,0 +1,198 @@
+package cellar
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ApplyRetention deletes all sealed chunks created more than maxAge ago, along with their files. Since a
+// chunk is created when its buffer is sealed, all its records are older than the chunk itself. The current
+// buffer is never deleted, and neither are chunks sealed before creation times were recorded.
+//
+// It returns the number of chunks deleted, and the disk space they used.
+func (w *Writer) ApplyRetention(maxAge time.Duration) (chunks int, bytes int64, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, 0, ErrClosed
+	}
+	if err := w.commitPending(); err != nil {
+		return 0, 0, err
+	}
+
+	all, err := w.db.ListChunks()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "ListChunks")
+	}
+
+	cutoff := w.now().Add(-maxAge).Unix()
+	for _, c := range all {
+		if c.CreatedAtUnix == 0 || c.CreatedAtUnix >= cutoff {
+			continue
+		}
+
+		if err = w.deleteChunk(c); err != nil {
+			return chunks, bytes, err
+		}
+		chunks++
+		bytes += c.CompressedDiskSize
+	}
+	return chunks, bytes, nil
+}
+
+// TrimToBytes deletes the oldest sealed chunks, along with their files, until the uncompressed size of the
+// remaining chunks is at most maxTotal. The current buffer is never deleted, and does not count towards
+// maxTotal.
+//
+// It returns the lowest position which can still be read. Readers asking for positions below it get
+// ErrTruncated.
+func (w *Writer) TrimToBytes(maxTotal int64) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if err := w.commitPending(); err != nil {
+		return 0, err
+	}
+
+	chunks, err := w.db.ListChunksRange(0, math.MaxInt64, 0)
+	if err != nil {
+		return 0, errors.Wrap(err, "ListChunksRange")
+	}
+
+	var total int64
+	for _, c := range chunks {
+		total += c.UncompressedByteSize
+	}
+
+	for len(chunks) > 0 && total > maxTotal {
+		if err = w.deleteChunk(chunks[0]); err != nil {
+			return chunks[0].StartPos, err
+		}
+		total -= chunks[0].UncompressedByteSize
+		chunks = chunks[1:]
+	}
+
+	if len(chunks) > 0 {
+		return chunks[0].StartPos, nil
+	}
+	return w.b.startPos, nil
+}
+
+// Truncate deletes all data of the cellar: every chunk along with its file, the records in the buffer, the
+// user checkpoints, the time index and the key index. The cellar continues with an empty buffer at position
+// 0, keeping its settings, so positions handed out before are reused by the records appended afterwards.
+// Unlike retention, which removes old chunks, nothing is kept.
+//
+// The metadata is cleared in a single meta DB transaction before any file is removed, so a crash leaves
+// either the old cellar or an empty one, at worst with chunk files nobody refers to.
+func (w *Writer) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+	if err := w.commitPending(); err != nil {
+		return err
+	}
+
+	chunks, err := w.db.ListChunks()
+	if err != nil {
+		return errors.Wrap(err, "ListChunks")
+	}
+
+	old := w.b
+	name := shardedName(fmt.Sprintf("%012d", 0), w.shardLevels)
+	buffer := &BufferDto{
+		MaxBytes: w.maxBufferSize,
+		FileName: name,
+	}
+
+	// the new buffer file is created before the meta DB refers to it, unless it replaces the file of the old
+	// buffer, which is only touched once the meta DB no longer refers to it
+	var b *Buffer
+	if old.fileName != name {
+		if b, err = createBufferFile(w.fs, 0, 0, w.maxBufferSize, w.folder, w.shardLevels, w.cipher, w.compressor); err != nil {
+			return errors.Wrap(err, "createBufferFile")
+		}
+	}
+	if err = w.db.Truncate(buffer); err != nil {
+		if b != nil {
+			b.close()
+			w.fs.Remove(path.Join(w.folder, name))
+		}
+		return errors.Wrap(err, "Truncate")
+	}
+
+	if err = old.close(); err != nil {
+		w.logger.Printf("cellar: can't close old buffer %s: %s", old.fileName, err)
+	}
+	if b != nil {
+		if err = w.fs.Remove(path.Join(w.folder, old.fileName)); err != nil && !os.IsNotExist(err) {
+			w.logger.Printf("cellar: can't remove old buffer %s: %s", old.fileName, err)
+		}
+	} else if b, err = createBufferFile(w.fs, 0, 0, w.maxBufferSize, w.folder, w.shardLevels, w.cipher, w.compressor); err != nil {
+		return errors.Wrap(err, "createBufferFile")
+	}
+	w.b = b
+	w.b.durability = w.durability
+
+	w.sealed = Stats{}
+	w.checkpointPos = 0
+	w.recordsSinceCheckpoint = 0
+	w.bytesSinceCheckpoint = 0
+	w.lastTimestamp = math.MinInt64
+	w.unorderedTimestamps = false
+	if w.keys != nil {
+		w.keys = &keyIndex{extractor: w.keys.extractor, pending: make(map[string]int64)}
+	}
+	w.metrics.ChunkCount(0)
+
+	if err = w.db.SetCellarMeta(w.cellarMeta()); err != nil {
+		return errors.Wrap(err, "SetCellarMeta")
+	}
+
+	for _, c := range chunks {
+		if err = w.removeChunkFile(c.FileName); err != nil {
+			return err
+		}
+	}
+	// new chunks reuse the names of the chunks replaced by Compact
+	return w.removeCompacted()
+}
+
+// deleteChunk removes a chunk from the meta DB, and then its file. A chunk file without metadata is merely
+// wasted space, while metadata without its file breaks readers.
+func (w *Writer) deleteChunk(c *ChunkDto) error {
+	if err := w.db.DeleteChunk(c.StartPos); err != nil {
+		return errors.Wrapf(err, "DeleteChunk %d", c.StartPos)
+	}
+	w.countChunk(c, -1)
+
+	return w.removeChunkFile(c.FileName)
+}
+
+// removeChunkFile removes the file of a chunk which is no longer in the meta DB, dropping its mapping so it is
+// unmapped once the reads using it end.
+func (w *Writer) removeChunkFile(name string) error {
+	loc := path.Join(w.folder, name)
+	if w.mmaps != nil {
+		if err := w.mmaps.remove(loc); err != nil {
+			w.logger.Printf("cellar: %s", err)
+		}
+	}
+
+	if err := w.fs.Remove(loc); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "remove chunk %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package cellar
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter_ApplyRetention(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+
+	defer checkedClose(w)
+
+	now := time.Unix(1000000, 0)
+	w.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, err = w.Append(genSeedBytes(100, i))
+		require.NoError(t, err)
+		require.NoError(t, w.SealTheBuffer())
+		now = now.Add(time.Hour)
+	}
+	_, err = w.Append(genSeedBytes(100, 3))
+	require.NoError(t, err)
+	_, err = w.Checkpoint()
+	require.NoError(t, err)
+
+	sealed, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, sealed, 3)
+
+	// the chunks were sealed 3h, 2h and 1h ago
+	chunks, bytes, err := w.ApplyRetention(90 * time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 2, chunks)
+	assert.Equal(t, sealed[0].CompressedDiskSize+sealed[1].CompressedDiskSize, bytes)
+
+	for _, c := range sealed[:2] {
+		_, err = os.Stat(path.Join(folder, c.FileName))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	var seeds []int
+	err = NewReader(folder, newCipher(), newDecompressor(), meta).ForEach(func(rec *Rec) error {
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, seeds)
+
+	// every sealed chunk is too old, but the buffer stays
+	chunks, _, err = w.ApplyRetention(0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, chunks)
+	count, err := NewReader(folder, newCipher(), newDecompressor(), meta).Count()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestWriter_TrimToBytes(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+
+	defer checkedClose(w)
+
+	// four chunks of a single 402 byte record each
+	for i := 0; i < 4; i++ {
+		_, err = w.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+		require.NoError(t, w.SealTheBuffer())
+	}
+	_, err = w.Append(genSeedBytes(400, 4))
+	require.NoError(t, err)
+	_, err = w.Checkpoint()
+	require.NoError(t, err)
+
+	minPos, err := w.TrimToBytes(1000)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2*402), minPos)
+
+	reader := NewReader(folder, newCipher(), newDecompressor(), meta)
+
+	var seeds []int
+	err = reader.ForEach(func(rec *Rec) error {
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3, 4}, seeds)
+
+	_, err = reader.ReadAt(402)
+	assert.Equal(t, ErrTruncated, errors.Cause(err))
+
+	_, err = reader.ReadAt(minPos)
+	assert.Equal(t, ErrTruncated, errors.Cause(err))
+
+	rec, err := reader.ReadAt(minPos + 402)
+	require.NoError(t, err)
+	assert.Equal(t, 2, int(rec.Data[0]))
+
+	_, errs := reader.ScanFrom(context.Background(), 0)
+	assert.Equal(t, ErrTruncated, errors.Cause(<-errs))
+
+	// the buffer is never trimmed
+	minPos, err = w.TrimToBytes(0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4*402), minPos)
+
+	count, err := reader.Count()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestDB_Truncate(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+	options := []Option{WithNoFileLock, WithMetaDB(meta), WithCompressor(Lz4Compressor{}), WithRecordTimestamps(),
+		WithReadCache(1 << 20), WithMmapReads()}
+
+	db, err := New(folder, options...)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = db.Append([]byte(fmt.Sprintf("old %d", i)))
+		require.NoError(t, err)
+		require.NoError(t, db.SealTheBuffer())
+	}
+	_, err = db.Append([]byte("old buffered"))
+	require.NoError(t, err)
+	require.NoError(t, db.PutUserCheckpoint("consumer", 10))
+	_, err = db.Checkpoint()
+	require.NoError(t, err)
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	// fills the read cache and maps the chunks
+	require.NoError(t, db.Reader().ForEach(func(rec *Rec) error { return nil }))
+
+	require.NoError(t, db.Truncate())
+
+	for _, c := range chunks {
+		_, err = os.Stat(path.Join(folder, c.FileName))
+		assert.True(t, os.IsNotExist(err))
+	}
+	checkpoints, err := db.ListUserCheckpoints()
+	require.NoError(t, err)
+	assert.Empty(t, checkpoints)
+	assert.Equal(t, Stats{}, db.Stats())
+
+	pos, err := db.Append([]byte("new 0"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), db.SealedPos())
+	require.NoError(t, db.SealTheBuffer())
+	_, err = db.Append([]byte("new 1"))
+	require.NoError(t, err)
+	require.NoError(t, db.Flush())
+
+	var records []string
+	require.NoError(t, db.Reader().ForEach(func(rec *Rec) error {
+		records = append(records, string(rec.Data))
+		return nil
+	}))
+	assert.Equal(t, []string{"new 0", "new 1"}, records)
+
+	rec, err := db.Reader().ReadAt(pos)
+	require.NoError(t, err)
+	assert.Equal(t, "new 0", string(rec.Data))
+	assert.Equal(t, pos, rec.NextPos)
+
+	// and the empty state survives a reopen
+	require.NoError(t, db.Close())
+	db, err = New(folder, options...)
+	require.NoError(t, err)
+	defer checkedClose(db)
+
+	records = nil
+	require.NoError(t, db.Reader().ForEach(func(rec *Rec) error {
+		records = append(records, string(rec.Data))
+		return nil
+	}))
+	assert.Equal(t, []string{"new 0", "new 1"}, records)
+}
+
+// failingTruncateMeta fails every Truncate of the meta DB.
+type failingTruncateMeta struct {
+	MetaDB
+}
+
+func (failingTruncateMeta) Truncate(*BufferDto) error {
+	return errors.New("truncate failed")
+}
+
+func TestDB_Truncate_MetaFails(t *testing.T) {
+	// without a seal the new buffer replaces the file of the old one, after a seal it has a file of its own
+	for _, seals := range []int{0, 1} {
+		db, err := New(getFolder(), WithNoFileLock, WithMetaDB(failingTruncateMeta{NewInMemoryMetaDB()}))
+		require.NoError(t, err)
+
+		for i := 0; i < seals; i++ {
+			_, err = db.Append([]byte("sealed"))
+			require.NoError(t, err)
+			require.NoError(t, db.SealTheBuffer())
+		}
+		_, err = db.Append([]byte("kept"))
+		require.NoError(t, err)
+
+		assert.Error(t, db.Truncate())
+
+		// the writer keeps its buffer
+		_, err = db.Append([]byte("appended"))
+		require.NoError(t, err)
+		require.NoError(t, db.Flush())
+		count, err := db.Reader().Count()
+		require.NoError(t, err)
+		assert.Equal(t, int64(seals+2), count)
+		require.NoError(t, db.Close())
+	}
+}
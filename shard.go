@@ -0,0 +1,36 @@
+package cellar
+
+import (
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// maxDirShardLevels limits sharding to the first 9 of the 12 digits of a file name, so the files of a
+// subdirectory still span a range of a billion positions.
+const maxDirShardLevels = 3
+
+// shardDigits is the number of digits of the file name making up one level of subdirectories.
+const shardDigits = 3
+
+// shardedName places the file name in levels of subdirectories, named after successive groups of
+// shardDigits digits of the name. The name 000123456789 is placed in 000/123/000123456789 with 2 levels.
+func shardedName(name string, levels int) string {
+	parts := make([]string, 0, levels+1)
+	for i := 0; i < levels && (i+1)*shardDigits <= len(name); i++ {
+		parts = append(parts, name[i*shardDigits:(i+1)*shardDigits])
+	}
+	return path.Join(append(parts, name)...)
+}
+
+// createShardDir creates the subdirectory of folder in fs holding the sharded file name.
+func createShardDir(fs FileSystem, folder, name string) error {
+	dir := path.Dir(name)
+	if dir == "." {
+		return nil
+	}
+	if err := fs.MkdirAll(path.Join(folder, dir), 0700); err != nil {
+		return errors.Wrapf(err, "create shard %s", dir)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package cellar
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedName(t *testing.T) {
+	assert.Equal(t, "000123456789", shardedName("000123456789", 0))
+	assert.Equal(t, "000/000123456789", shardedName("000123456789", 1))
+	assert.Equal(t, "000/123/456/000123456789", shardedName("000123456789", 3))
+	assert.Equal(t, "000/123/000123456789-000123457789.lz4", shardedName("000123456789-000123457789.lz4", 2))
+}
+
+func TestDB_DirSharding(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	_, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithDirSharding(4))
+	assert.Error(t, err)
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000), WithDirSharding(2))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = db.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+
+	// the layout is kept without the option
+	db, err = New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000))
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 3; i < 5; i++ {
+		_, err = db.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Flush())
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	for _, c := range chunks {
+		assert.Equal(t, shardedName(path.Base(c.FileName), 2), c.FileName)
+		_, err = os.Stat(path.Join(folder, c.FileName))
+		assert.NoError(t, err)
+	}
+
+	b, err := meta.GetBuffer()
+	require.NoError(t, err)
+	assert.Equal(t, "000/000/000000001608", b.FileName)
+
+	compacted, err := db.Compact(2, 2000)
+	require.NoError(t, err)
+	assert.Equal(t, 1, compacted)
+
+	var seeds []int
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, seeds)
+
+	dest := path.Join(getFolder(), "backup")
+	require.NoError(t, db.writer.Snapshot(dest))
+	chunks, err = meta.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	_, err = os.Stat(path.Join(dest, chunks[0].FileName))
+	assert.NoError(t, err)
+}
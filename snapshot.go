@@ -0,0 +1,114 @@
+package cellar
+
+import (
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var ErrSnapshotExists = errors.New("cellar: snapshot destination already holds a cellar")
+
+// Snapshot writes a consistent copy of the cellar as of now into destDir, which is created if needed. The
+// copy holds a bolt meta DB, the sealed chunks and the current buffer, and can be opened as a standalone
+// cellar with New, whatever the meta DB of the writer.
+//
+// Appends are blocked only while the writer checkpoints, and the meta DB and the buffer up to the checkpoint
+// are copied. Since chunks are immutable, they are copied after the lock is released. Chunks removed by
+// retention or compaction before they are copied fail the snapshot.
+func (w *Writer) Snapshot(destDir string) (err error) {
+	if err = os.MkdirAll(destDir, 0700); err != nil {
+		return errors.Wrap(err, "MkdirAll")
+	}
+
+	loc := path.Join(destDir, "meta.bolt")
+	if _, err = os.Stat(loc); err == nil {
+		return ErrSnapshotExists
+	}
+
+	blt, err := bolt.Open(loc, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return errors.Wrap(err, "bolt.Open")
+	}
+	defer func() {
+		if cerr := blt.Close(); cerr != nil && err == nil {
+			err = errors.Wrap(cerr, "Close")
+		}
+	}()
+
+	dst := &BoltMetaDB{DB: blt}
+	if err = dst.Init(); err != nil {
+		return errors.Wrap(err, "Init")
+	}
+
+	if err = w.snapshotState(destDir, dst); err != nil {
+		return err
+	}
+
+	chunks, err := dst.ListChunks()
+	if err != nil {
+		return errors.Wrap(err, "ListChunks")
+	}
+	for _, c := range chunks {
+		if err = copyFile(w.fs, path.Join(w.folder, c.FileName), path.Join(destDir, c.FileName), c.CompressedDiskSize); err != nil {
+			return errors.Wrapf(err, "copy chunk %s", c.FileName)
+		}
+	}
+	return nil
+}
+
+// snapshotState checkpoints the writer, and copies the meta DB into dst and the checkpointed buffer into
+// destDir, all while holding the append lock.
+func (w *Writer) snapshotState(destDir string, dst MetaDB) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	if _, err := w.checkpoint(); err != nil {
+		return errors.Wrap(err, "Checkpoint")
+	}
+
+	if err := MigrateMeta(w.db, dst); err != nil {
+		return errors.Wrap(err, "MigrateMeta")
+	}
+
+	if err := copyFile(w.fs, path.Join(w.folder, w.b.fileName), path.Join(destDir, w.b.fileName), w.b.pos); err != nil {
+		return errors.Wrap(err, "copy buffer")
+	}
+	return nil
+}
+
+// copyFile copies the first n bytes of src in fs into the new file dst on disk, and syncs it. The directory
+// of dst is created if needed, since chunks may be sharded into subdirectories.
+func copyFile(fs FileSystem, src, dst string, n int64) (err error) {
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err = os.MkdirAll(path.Dir(dst), 0700); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	if _, err = io.CopyN(out, in, n); err != nil {
+		return err
+	}
+	return out.Sync()
+}
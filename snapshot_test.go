@@ -0,0 +1,53 @@
+package cellar
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter_Snapshot(t *testing.T) {
+	folder := getFolder()
+
+	w, err := OpenWriter(folder, NewInMemoryMetaDB(), WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	// two sealed chunks, and a record in the buffer
+	for i := 0; i < 3; i++ {
+		_, err = w.Append(genSeedBytes(600, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.PutUserCheckpoint("consumer", 602))
+
+	dest := path.Join(getFolder(), "backup")
+	require.NoError(t, w.Snapshot(dest))
+
+	// records appended after the snapshot are not part of it
+	_, err = w.Append(genSeedBytes(100, 3))
+	require.NoError(t, err)
+
+	assert.Equal(t, ErrSnapshotExists, w.Snapshot(dest))
+
+	db, err := New(dest, WithNoFileLock, WithCipher(newCipher()))
+	require.NoError(t, err)
+	defer db.Close()
+
+	var seeds []int
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, seeds)
+
+	pos, err := db.GetUserCheckpoint("consumer")
+	require.NoError(t, err)
+	assert.Equal(t, int64(602), pos)
+
+	// the snapshot is a cellar of its own
+	_, err = db.Append(genSeedBytes(100, 4))
+	require.NoError(t, err)
+}
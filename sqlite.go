@@ -0,0 +1,461 @@
+package cellar
+
+import (
+	"database/sql"
+	"encoding/binary"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema holds the tables of the SQLite meta DB. Chunks are stored as their encoded ChunkDto, next to
+// columns mirroring its fields so chunk metadata can be queried with plain SQL.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS chunks (
+	pos               INTEGER PRIMARY KEY,
+	file_name         TEXT NOT NULL,
+	records           INTEGER NOT NULL,
+	uncompressed_size INTEGER NOT NULL,
+	compressed_size   INTEGER NOT NULL,
+	codec             INTEGER NOT NULL,
+	cipher            INTEGER NOT NULL,
+	key_id            TEXT NOT NULL,
+	dto               BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS checkpoints (
+	name TEXT PRIMARY KEY,
+	pos  INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS time_index (
+	ts  INTEGER PRIMARY KEY,
+	pos INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS key_index (
+	key BLOB PRIMARY KEY,
+	pos INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS state (
+	key TEXT PRIMARY KEY,
+	dto BLOB NOT NULL
+);
+`
+
+// keys of the state table
+const (
+	sqliteBufferKey      = "buffer"
+	sqliteCellarKey      = "cellar"
+	sqliteKeyIndexPosKey = "key_index_pos"
+)
+
+var _ MetaDB = &SQLiteMetaDB{} // compile time assertion to verify we match the interface metaDB
+
+// SQLiteMetaDB stores the metadata of the cellar in a single SQLite file, using the pure Go driver
+// registered as "sqlite".
+type SQLiteMetaDB struct {
+	*sql.DB
+}
+
+// OpenSQLiteMetaDB opens or creates the SQLite meta DB at path, and creates its tables.
+func OpenSQLiteMetaDB(path string) (*SQLiteMetaDB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql.Open")
+	}
+	// SQLite serializes writers, a single connection avoids SQLITE_BUSY errors between our own connections
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteMetaDB{DB: db}
+	if err = s.Init(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLiteMetaDB) GetBuffer() (*BufferDto, error) {
+	data, err := s.getState(sqliteBufferKey)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	buf := &BufferDto{}
+	if err := proto.Unmarshal(data, buf); err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+	return buf, nil
+}
+
+func (s *SQLiteMetaDB) PutBuffer(dto *BufferDto) error {
+	return s.putState(sqliteBufferKey, dto)
+}
+
+func (s *SQLiteMetaDB) CellarMeta() (*MetaDto, error) {
+	data, err := s.getState(sqliteCellarKey)
+	if err != nil {
+		return nil, err
+	}
+
+	dto := &MetaDto{}
+	if err := proto.Unmarshal(data, dto); err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+	return dto, nil
+}
+
+func (s *SQLiteMetaDB) SetCellarMeta(dto *MetaDto) error {
+	return s.putState(sqliteCellarKey, dto)
+}
+
+func (s *SQLiteMetaDB) ListChunks() ([]*ChunkDto, error) {
+	rows, err := s.Query(`SELECT dto FROM chunks ORDER BY pos`)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query")
+	}
+	return scanChunks(rows)
+}
+
+// scanChunks decodes the dto column of all rows, and closes them.
+func scanChunks(rows *sql.Rows) ([]*ChunkDto, error) {
+	defer rows.Close()
+
+	chunks := []*ChunkDto{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, errors.Wrap(err, "Scan")
+		}
+
+		chunk := &ChunkDto{}
+		if err := proto.Unmarshal(data, chunk); err != nil {
+			return nil, errors.Wrap(err, "Unmarshal")
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, errors.Wrap(rows.Err(), "rows")
+}
+
+func (s *SQLiteMetaDB) ListChunksRange(fromPos, toPos int64, limit int) ([]*ChunkDto, error) {
+	if limit <= 0 {
+		// SQLite treats a negative limit as no limit
+		limit = -1
+	}
+
+	rows, err := s.Query(`SELECT dto FROM chunks WHERE pos < ? AND pos + uncompressed_size > ? ORDER BY pos LIMIT ?`,
+		toPos, fromPos, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query")
+	}
+	return scanChunks(rows)
+}
+
+func (s *SQLiteMetaDB) AddChunk(pos int64, dto *ChunkDto) error {
+	tx, err := s.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Begin")
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err = tx.QueryRow(`SELECT COUNT(*) FROM chunks WHERE pos = ?`, pos).Scan(&n); err != nil {
+		return errors.Wrap(err, "select chunk")
+	}
+	if n > 0 {
+		return ErrChunkExists
+	}
+	if err = insertChunk(tx, pos, dto); err != nil {
+		return err
+	}
+	return errors.Wrap(tx.Commit(), "Commit")
+}
+
+func (s *SQLiteMetaDB) AddChunks(chunks []*ChunkDto, buffer *BufferDto) error {
+	tx, err := s.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Begin")
+	}
+	defer tx.Rollback()
+
+	for _, dto := range chunks {
+		var n int
+		if err = tx.QueryRow(`SELECT COUNT(*) FROM chunks WHERE pos = ?`, dto.StartPos).Scan(&n); err != nil {
+			return errors.Wrap(err, "select chunk")
+		}
+		if n > 0 {
+			return ErrChunkExists
+		}
+		if err = insertChunk(tx, dto.StartPos, dto); err != nil {
+			return err
+		}
+	}
+
+	val, err := proto.Marshal(buffer)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	if _, err = tx.Exec(`INSERT OR REPLACE INTO state (key, dto) VALUES (?, ?)`, sqliteBufferKey, val); err != nil {
+		return errors.Wrap(err, "insert state")
+	}
+	return errors.Wrap(tx.Commit(), "Commit")
+}
+
+func (s *SQLiteMetaDB) PutChunk(pos int64, dto *ChunkDto) error {
+	return insertChunk(s.DB, pos, dto)
+}
+
+// insertChunk stores dto under pos, through either the DB or a transaction.
+func insertChunk(db interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, pos int64, dto *ChunkDto) error {
+	val, err := proto.Marshal(dto)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	_, err = db.Exec(`INSERT OR REPLACE INTO chunks
+		(pos, file_name, records, uncompressed_size, compressed_size, codec, cipher, key_id, dto)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		pos, dto.FileName, dto.Records, dto.UncompressedByteSize, dto.CompressedDiskSize, dto.Codec, dto.Cipher,
+		dto.KeyID, val)
+	return errors.Wrap(err, "insert chunk")
+}
+
+func (s *SQLiteMetaDB) DeleteChunk(startPos int64) error {
+	_, err := s.Exec(`DELETE FROM chunks WHERE pos = ?`, startPos)
+	return errors.Wrap(err, "delete chunk")
+}
+
+func (s *SQLiteMetaDB) ReplaceChunks(old []int64, dto *ChunkDto) error {
+	tx, err := s.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Begin")
+	}
+	defer tx.Rollback()
+
+	for _, pos := range old {
+		if _, err = tx.Exec(`DELETE FROM chunks WHERE pos = ?`, pos); err != nil {
+			return errors.Wrap(err, "delete chunk")
+		}
+	}
+	if err = insertChunk(tx, dto.StartPos, dto); err != nil {
+		return err
+	}
+	return errors.Wrap(tx.Commit(), "Commit")
+}
+
+func (s *SQLiteMetaDB) Truncate(buffer *BufferDto) error {
+	tx, err := s.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Begin")
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"chunks", "checkpoints", "time_index", "key_index"} {
+		if _, err = tx.Exec(`DELETE FROM ` + table); err != nil {
+			return errors.Wrapf(err, "delete %s", table)
+		}
+	}
+	if _, err = tx.Exec(`DELETE FROM state WHERE key = ?`, sqliteKeyIndexPosKey); err != nil {
+		return errors.Wrap(err, "delete key index position")
+	}
+
+	val, err := proto.Marshal(buffer)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	if _, err = tx.Exec(`INSERT OR REPLACE INTO state (key, dto) VALUES (?, ?)`, sqliteBufferKey, val); err != nil {
+		return errors.Wrap(err, "insert state")
+	}
+	return errors.Wrap(tx.Commit(), "Commit")
+}
+
+func (s *SQLiteMetaDB) PutCheckpoint(name string, pos int64) error {
+	_, err := s.Exec(`INSERT OR REPLACE INTO checkpoints (name, pos) VALUES (?, ?)`, name, pos)
+	return errors.Wrap(err, "insert checkpoint")
+}
+
+func (s *SQLiteMetaDB) GetCheckpoint(name string) (pos int64, err error) {
+	err = s.QueryRow(`SELECT pos FROM checkpoints WHERE name = ?`, name).Scan(&pos)
+	if err == sql.ErrNoRows {
+		return 0, ErrCheckpointNotExists
+	}
+	return pos, errors.Wrap(err, "select checkpoint")
+}
+
+func (s *SQLiteMetaDB) ListCheckpoints() (map[string]int64, error) {
+	rows, err := s.Query(`SELECT name, pos FROM checkpoints`)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query")
+	}
+	defer rows.Close()
+
+	checkpoints := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var pos int64
+		if err := rows.Scan(&name, &pos); err != nil {
+			return nil, errors.Wrap(err, "Scan")
+		}
+		checkpoints[name] = pos
+	}
+	return checkpoints, errors.Wrap(rows.Err(), "rows")
+}
+
+func (s *SQLiteMetaDB) DeleteCheckpoint(name string) error {
+	res, err := s.Exec(`DELETE FROM checkpoints WHERE name = ?`, name)
+	if err != nil {
+		return errors.Wrap(err, "delete checkpoint")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "RowsAffected")
+	}
+	if n == 0 {
+		return ErrCheckpointNotExists
+	}
+	return nil
+}
+
+func (s *SQLiteMetaDB) CasCheckpoint(name string, expected, pos int64) (bool, error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return false, errors.Wrap(err, "Begin")
+	}
+	defer tx.Rollback()
+
+	var current int64
+	err = tx.QueryRow(`SELECT pos FROM checkpoints WHERE name = ?`, name).Scan(&current)
+	if err == sql.ErrNoRows {
+		return false, ErrCheckpointNotExists
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "select checkpoint")
+	}
+	if current != expected {
+		return false, nil
+	}
+
+	if _, err = tx.Exec(`UPDATE checkpoints SET pos = ? WHERE name = ?`, pos, name); err != nil {
+		return false, errors.Wrap(err, "update checkpoint")
+	}
+	if err = tx.Commit(); err != nil {
+		return false, errors.Wrap(err, "Commit")
+	}
+	return true, nil
+}
+
+func (s *SQLiteMetaDB) PutTimeIndex(ts, pos int64) error {
+	_, err := s.Exec(`INSERT OR IGNORE INTO time_index (ts, pos) VALUES (?, ?)`, ts, pos)
+	return errors.Wrap(err, "insert time index")
+}
+
+func (s *SQLiteMetaDB) SeekTimeIndex(ts int64) (pos int64, ok bool, err error) {
+	err = s.QueryRow(`SELECT pos FROM time_index WHERE ts < ? ORDER BY ts DESC LIMIT 1`, ts).Scan(&pos)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, errors.Wrap(err, "select time index")
+	}
+	return pos, true, nil
+}
+
+func (s *SQLiteMetaDB) ListTimeIndex() (map[int64]int64, error) {
+	rows, err := s.Query(`SELECT ts, pos FROM time_index`)
+	if err != nil {
+		return nil, errors.Wrap(err, "Query")
+	}
+	defer rows.Close()
+
+	index := make(map[int64]int64)
+	for rows.Next() {
+		var ts, pos int64
+		if err := rows.Scan(&ts, &pos); err != nil {
+			return nil, errors.Wrap(err, "Scan")
+		}
+		index[ts] = pos
+	}
+	return index, errors.Wrap(rows.Err(), "rows")
+}
+
+// Init creates all needed tables
+func (s *SQLiteMetaDB) Init() error {
+	_, err := s.Exec(sqliteSchema)
+	return errors.Wrap(err, "create tables")
+}
+
+func (s *SQLiteMetaDB) PutKeys(keys map[string]int64, indexedPos int64) error {
+	tx, err := s.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Begin")
+	}
+	defer tx.Rollback()
+
+	for key, pos := range keys {
+		if _, err = tx.Exec(`INSERT OR REPLACE INTO key_index (key, pos) VALUES (?, ?)`, []byte(key), pos); err != nil {
+			return errors.Wrap(err, "insert key")
+		}
+	}
+	if _, err = tx.Exec(`INSERT OR REPLACE INTO state (key, dto) VALUES (?, ?)`, sqliteKeyIndexPosKey, chunkKey(indexedPos)); err != nil {
+		return errors.Wrap(err, "insert key index position")
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "Commit")
+	}
+	return nil
+}
+
+func (s *SQLiteMetaDB) GetKey(key []byte) (pos int64, ok bool, err error) {
+	err = s.QueryRow(`SELECT pos FROM key_index WHERE key = ?`, key).Scan(&pos)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, errors.Wrap(err, "select key")
+	}
+	return pos, true, nil
+}
+
+// KeyIndexPos is kept in the state table, encoded as a big endian integer.
+func (s *SQLiteMetaDB) KeyIndexPos() (int64, error) {
+	data, err := s.getState(sqliteKeyIndexPosKey)
+	if err != nil || data == nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(data)), nil
+}
+
+func (s *SQLiteMetaDB) ClearKeys() error {
+	tx, err := s.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Begin")
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`DELETE FROM key_index`); err != nil {
+		return errors.Wrap(err, "delete key_index")
+	}
+	if _, err = tx.Exec(`DELETE FROM state WHERE key = ?`, sqliteKeyIndexPosKey); err != nil {
+		return errors.Wrap(err, "delete key index position")
+	}
+	return errors.Wrap(tx.Commit(), "Commit")
+}
+
+// getState returns the encoded dto stored under key, or nil if there is none.
+func (s *SQLiteMetaDB) getState(key string) (data []byte, err error) {
+	err = s.QueryRow(`SELECT dto FROM state WHERE key = ?`, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return data, errors.Wrap(err, "select state")
+}
+
+func (s *SQLiteMetaDB) putState(key string, dto proto.Message) error {
+	val, err := proto.Marshal(dto)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+
+	_, err = s.Exec(`INSERT OR REPLACE INTO state (key, dto) VALUES (?, ?)`, key, val)
+	return errors.Wrap(err, "insert state")
+}
@@ -0,0 +1,104 @@
+package cellar
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSQLiteMetaDB() *SQLiteMetaDB {
+	mu.Lock()
+	defer mu.Unlock()
+	asked++
+
+	db, err := OpenSQLiteMetaDB(fmt.Sprintf("testdata/meta%d.sqlite", asked))
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+func TestSQLiteMetaDB_AddChunk_ListChunk(t *testing.T) {
+	db := newSQLiteMetaDB()
+	defer checkedClose(db)
+
+	err := db.AddChunk(10, &ChunkDto{StartPos: 10, FileName: "second"})
+	require.NoError(t, err)
+
+	err = db.AddChunk(0, &ChunkDto{StartPos: 0, FileName: "first"})
+	require.NoError(t, err)
+
+	chunks, err := db.ListChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "first", chunks[0].FileName)
+	assert.Equal(t, "second", chunks[1].FileName)
+
+	// chunk metadata can be queried directly
+	var records int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM chunks WHERE file_name = 'second'`).Scan(&records))
+	assert.Equal(t, 1, records)
+}
+
+func TestSQLiteMetaDB_Checkpoint(t *testing.T) {
+	db := newSQLiteMetaDB()
+	defer checkedClose(db)
+
+	_, err := db.GetCheckpoint("missing")
+	assert.Equal(t, ErrCheckpointNotExists, err)
+
+	require.NoError(t, db.PutCheckpoint("name", 1))
+	require.NoError(t, db.PutCheckpoint("name", 2))
+
+	pos, err := db.GetCheckpoint("name")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pos)
+}
+
+func TestSQLiteMetaDB_Buffer_CellarMeta(t *testing.T) {
+	db := newSQLiteMetaDB()
+	defer checkedClose(db)
+
+	buf, err := db.GetBuffer()
+	require.NoError(t, err)
+	assert.Nil(t, buf)
+
+	require.NoError(t, db.PutBuffer(&BufferDto{FileName: "buffer", Pos: 10}))
+	buf, err = db.GetBuffer()
+	require.NoError(t, err)
+	assert.Equal(t, "buffer", buf.FileName)
+	assert.Equal(t, int64(10), buf.Pos)
+
+	meta, err := db.CellarMeta()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), meta.MaxValSize)
+
+	require.NoError(t, db.SetCellarMeta(&MetaDto{MaxValSize: 42}))
+	meta, err = db.CellarMeta()
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), meta.MaxValSize)
+}
+
+func TestSQLiteMetaDB_DB(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newSQLiteMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	inputs := []string{"first", "second", "third"}
+	for _, input := range inputs {
+		_, err = db.Append([]byte(input))
+		require.NoError(t, err)
+		require.NoError(t, db.SealTheBuffer())
+	}
+
+	var found []string
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		found = append(found, string(rec.Data))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, inputs, found)
+}
@@ -0,0 +1,56 @@
+package cellar
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Stats is a snapshot of the state of a writer, cheap enough to serve from health endpoints.
+type Stats struct {
+	// Records counts the records in sealed chunks and in the current buffer.
+	Records int64 `json:"records"`
+	Chunks  int64 `json:"chunks"`
+
+	// UncompressedBytes and CompressedBytes are the sizes of all sealed chunks, before and after compression.
+	UncompressedBytes int64 `json:"uncompressedBytes"`
+	CompressedBytes   int64 `json:"compressedBytes"`
+
+	// BufferPos is the position of the next record appended, and CheckpointPos the position persisted by the
+	// last checkpoint.
+	BufferPos     int64 `json:"bufferPos"`
+	CheckpointPos int64 `json:"checkpointPos"`
+}
+
+// Stats returns a snapshot of the writer. The chunk totals are maintained as chunks are sealed, compacted and
+// deleted, so no metadata is read.
+func (w *Writer) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats := w.sealed
+	stats.Records += w.b.records
+	stats.BufferPos = w.b.startPos + w.b.pos
+	stats.CheckpointPos = w.checkpointPos
+	return stats
+}
+
+// loadStats initializes the chunk totals from the meta DB.
+func (w *Writer) loadStats() error {
+	chunks, err := w.db.ListChunks()
+	if err != nil {
+		return errors.Wrap(err, "ListChunks")
+	}
+
+	for _, c := range chunks {
+		w.countChunk(c, 1)
+	}
+	return nil
+}
+
+// countChunk adds a sealed chunk to the totals returned by Stats, or removes it for a sign of -1.
+func (w *Writer) countChunk(c *ChunkDto, sign int64) {
+	w.sealed.Chunks += sign
+	w.sealed.Records += sign * c.Records
+	w.sealed.UncompressedBytes += sign * c.UncompressedByteSize
+	w.sealed.CompressedBytes += sign * c.CompressedDiskSize
+	w.metrics.ChunkCount(w.sealed.Chunks)
+}
@@ -0,0 +1,69 @@
+package cellar
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter_Stats(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = w.Append(genSeedBytes(100, i))
+		require.NoError(t, err)
+		require.NoError(t, w.SealTheBuffer())
+	}
+	_, err = w.Append(genSeedBytes(100, 3))
+	require.NoError(t, err)
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+
+	var compressed int64
+	for _, c := range chunks {
+		compressed += c.CompressedDiskSize
+	}
+
+	stats := w.Stats()
+	assert.Equal(t, Stats{
+		Records:           4,
+		Chunks:            3,
+		UncompressedBytes: 306,
+		CompressedBytes:   compressed,
+		BufferPos:         408,
+		CheckpointPos:     0,
+	}, stats)
+
+	_, err = w.Checkpoint()
+	require.NoError(t, err)
+	assert.Equal(t, int64(408), w.Stats().CheckpointPos)
+
+	// deleted chunks leave the totals
+	_, err = w.TrimToBytes(stats.UncompressedBytes - chunks[0].UncompressedByteSize)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), w.Stats().Chunks)
+	assert.Equal(t, int64(3), w.Stats().Records)
+	require.NoError(t, w.Close())
+
+	// a reopened writer starts from the meta DB
+	w, err = OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	reopened := w.Stats()
+	assert.Equal(t, int64(3), reopened.Records)
+	assert.Equal(t, int64(2), reopened.Chunks)
+	assert.Equal(t, int64(204), reopened.UncompressedBytes)
+	assert.Equal(t, int64(408), reopened.CheckpointPos)
+
+	data, err := json.Marshal(reopened)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"uncompressedBytes":204`)
+}
@@ -0,0 +1,235 @@
+package cellar
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrTimestampsEnabled  = errors.New("cellar: record timestamps can only be enabled on an empty cellar")
+	ErrTimestampsDisabled = errors.New("cellar: cellar does not store record timestamps")
+)
+
+// AppendAt appends a record stamped with ts, in cellars created with WithRecordTimestamps. Other cellars
+// fail with ErrTimestampsDisabled. Records appended through the other methods are stamped with the time of
+// the append.
+func (w *Writer) AppendAt(ts time.Time, data []byte) (int64, error) {
+	if !w.recordTimestamps {
+		return 0, ErrTimestampsDisabled
+	}
+	pos, _, err := w.appendAt(context.Background(), ts, data)
+	return pos, err
+}
+
+// trackTimestamp checks that ts does not precede the records appended so far. Otherwise the cellar is
+// marked as holding unordered timestamps in the meta DB right away, before the record is written, so readers
+// stop trusting the time index.
+func (w *Writer) trackTimestamp(ts time.Time) error {
+	if !w.recordTimestamps || w.unorderedTimestamps || ts.UnixNano() >= w.lastTimestamp {
+		return nil
+	}
+
+	w.unorderedTimestamps = true
+	if err := w.db.SetCellarMeta(w.cellarMeta()); err != nil {
+		return errors.Wrap(err, "SetCellarMeta")
+	}
+	return nil
+}
+
+// loadLastTimestamp initializes the latest timestamp appended so far. Unless timestamps are unordered, it is
+// the latest timestamp of the buffer, or of the last chunk if the buffer is empty.
+func (w *Writer) loadLastTimestamp() error {
+	w.lastTimestamp = math.MinInt64
+	if !w.recordTimestamps || w.unorderedTimestamps {
+		return nil
+	}
+
+	if w.b.records > 0 {
+		w.lastTimestamp = w.b.maxTimestamp
+		return nil
+	}
+
+	chunks, err := w.db.ListChunksRange(w.b.startPos-1, w.b.startPos, 1)
+	if err != nil {
+		return errors.Wrap(err, "ListChunksRange")
+	}
+	if len(chunks) == 1 {
+		w.lastTimestamp = chunks[0].MaxTimestamp
+	}
+	return nil
+}
+
+// indexChunk samples the earliest timestamp of a sealed chunk in the time index. Since the chunk is already
+// committed, a failure is only logged; a missing sample makes SeekTime start from an earlier chunk.
+func (w *Writer) indexChunk(dto *ChunkDto) {
+	if !w.recordTimestamps || dto.Records == 0 {
+		return
+	}
+	if err := w.db.PutTimeIndex(dto.MinTimestamp, dto.StartPos); err != nil {
+		w.logger.Printf("cellar: can't index chunk %s: %s", dto.FileName, err)
+	}
+}
+
+// splitStamp splits the body of a record in a cellar storing record timestamps into the timestamp and the
+// data of the record. Records too short to hold a timestamp return ErrRecordBounds.
+func splitStamp(record []byte) (time.Time, []byte, error) {
+	nanos, n := binary.Varint(record)
+	if n <= 0 {
+		return time.Time{}, nil, ErrRecordBounds
+	}
+	return time.Unix(0, nanos), record[n:], nil
+}
+
+// SeekTime returns the position of the first record stamped at or after t, or the position following the
+// last visible record if there is none. The search starts at the chunk sampled in the time index right
+// before t, so it reads about one chunk whatever the size of the cellar. Once records were appended out of
+// order, the time index no longer applies and the cellar is scanned from its first position. Cellars
+// without record timestamps fail with ErrTimestampsDisabled.
+func (r *Reader) SeekTime(t time.Time) (int64, error) {
+	format, err := r.recordFormat()
+	if err != nil {
+		return 0, err
+	}
+	if !format.timestamps {
+		return 0, ErrTimestampsDisabled
+	}
+
+	pos, err := r.timeSearchStart(t, format)
+	if err != nil {
+		return 0, err
+	}
+
+	err = r.scanFrom(pos, func(info *ReaderInfo, data []byte) error {
+		if !info.Timestamp.Before(t) {
+			pos = info.StartPos
+			return errStopScan
+		}
+		pos = info.NextPos
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pos, nil
+}
+
+// timeSearchStart returns the position from which the records stamped at or after t are found. With ordered
+// timestamps, this is the chunk sampled in the time index right before t, since all earlier records are
+// stamped before it. Otherwise it is the first readable position.
+func (r *Reader) timeSearchStart(t time.Time, format recordFormat) (int64, error) {
+	first, err := r.firstPos()
+	if err != nil || format.unordered {
+		return first, err
+	}
+
+	pos, ok, err := r.metadb.SeekTimeIndex(t.UnixNano())
+	if err != nil {
+		return 0, errors.Wrap(err, "SeekTimeIndex")
+	}
+	if !ok || pos < first {
+		// the sampled chunk was deleted by retention
+		return first, nil
+	}
+	return pos, nil
+}
+
+// ScanTimeRange runs a scan in a goroutine, returning the records stamped in [from, to) in position order.
+// The scan starts at the chunk found in the time index, see SeekTime, and skips chunks whose timestamps all
+// lie outside the range without loading them. Once records were appended out of order, the whole cellar is
+// considered. Cellars without record timestamps fail with ErrTimestampsDisabled.
+func (reader *Reader) ScanTimeRange(ctx context.Context, from, to time.Time) (chan *Rec, chan error) {
+	return scanAsync(ctx, 0, func(op ReadOp) error {
+		return reader.scanTimeRange(from, to, op)
+	})
+}
+
+// scanTimeRange applies op to every record stamped in [from, to). With ordered timestamps, it stops at the
+// first record stamped at or after to.
+func (r *Reader) scanTimeRange(from, to time.Time, op ReadOp) error {
+
+	op = countReads(r.metrics, op)
+
+	format, err := r.recordFormat()
+	if err != nil {
+		return err
+	}
+	if !format.timestamps {
+		return ErrTimestampsDisabled
+	}
+
+	bounded := func(info *ReaderInfo, data []byte) error {
+		if !info.Timestamp.Before(to) && !format.unordered {
+			return errStopScan
+		}
+		if info.Timestamp.Before(from) || !info.Timestamp.Before(to) {
+			return nil
+		}
+		return op(info, data)
+	}
+
+	start, err := r.timeSearchStart(from, format)
+	if err != nil {
+		return err
+	}
+
+	b, err := r.buffer()
+	if err != nil {
+		return err
+	}
+
+	chunks, err := r.metadb.ListChunksRange(start, math.MaxInt64, 0)
+	if err != nil {
+		return errors.Wrap(err, "db.Read")
+	}
+	chunks = sealedBefore(chunks, b)
+
+	info := &ReaderInfo{}
+
+	for _, c := range chunks {
+		if c.MaxTimestamp < from.UnixNano() || c.MinTimestamp >= to.UnixNano() {
+			continue
+		}
+
+		var chunk []byte
+		if chunk, err = r.loadChunk(c); err != nil {
+			if r.skipMissing(err) {
+				continue
+			}
+			return errors.Wrapf(err, "load chunk %s", c.FileName)
+		}
+
+		info.ChunkPos = c.StartPos
+		info.Index = c.StartIndex
+
+		if err = replayChunk(info, chunk, bounded, 0, format); err != nil {
+			if errors.Cause(err) == errStopScan {
+				return nil
+			}
+			return errors.Wrap(err, "Failed to read chunk")
+		}
+	}
+
+	if b == nil || b.Pos == 0 {
+		return nil
+	}
+
+	var curChunk []byte
+	if curChunk, err = r.readBuffer(b); err != nil {
+		return err
+	}
+
+	info.ChunkPos = b.StartPos
+	info.Index = b.StartIndex
+
+	if err = replayChunk(info, curChunk, bounded, 0, format); err != nil {
+		if errors.Cause(err) == errStopScan {
+			return nil
+		}
+		return errors.Wrap(err, "Failed to read chunk")
+	}
+	return nil
+}
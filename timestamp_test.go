@@ -0,0 +1,210 @@
+package cellar
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_RecordTimestamps(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000), WithRecordTimestamps())
+	require.NoError(t, err)
+
+	// two records per chunk, the last one is reopened before being sealed
+	var positions []int64
+	for i := 0; i < 6; i++ {
+		if i == 5 {
+			require.NoError(t, db.Close())
+			db, err = New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000))
+			require.NoError(t, err)
+		}
+		pos, err := db.AppendAt(t0.Add(time.Duration(i)*time.Hour), genSeedBytes(400, i))
+		require.NoError(t, err)
+		positions = append(positions, pos)
+	}
+	require.NoError(t, db.SealTheBuffer())
+	defer db.Close()
+
+	chunks, err := db.Reader().sortedChunks()
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	for i, c := range chunks {
+		assert.Equal(t, t0.Add(time.Duration(2*i)*time.Hour).UnixNano(), c.MinTimestamp)
+		assert.Equal(t, t0.Add(time.Duration(2*i+1)*time.Hour).UnixNano(), c.MaxTimestamp)
+	}
+
+	// chunks outside the range are not even loaded
+	require.NoError(t, os.Remove(path.Join(folder, chunks[0].FileName)))
+
+	vals, errs := db.Reader().ScanTimeRange(context.Background(), t0.Add(2*time.Hour), t0.Add(5*time.Hour))
+	var seeds []int
+	for rec := range vals {
+		seeds = append(seeds, int(rec.Data[0]))
+		assert.Equal(t, t0.Add(time.Duration(rec.Data[0])*time.Hour), rec.Timestamp.UTC())
+	}
+	require.NoError(t, <-errs)
+	assert.Equal(t, []int{2, 3, 4}, seeds)
+
+	rec, err := db.Reader().ReadAt(positions[2])
+	require.NoError(t, err)
+	assert.Equal(t, genSeedBytes(400, 2), rec.Data)
+	assert.Equal(t, t0.Add(2*time.Hour), rec.Timestamp.UTC())
+}
+
+func TestDB_RecordTimestamps_AppendTime(t *testing.T) {
+	folder := getFolder()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithCipher(nil), WithCompressor(nil),
+		WithRecordChecksums(), WithRecordTimestamps())
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Unix(1000, 0)
+	db.writer.now = func() time.Time { return now }
+
+	_, err = db.Append(genSeedBytes(50, 1))
+	require.NoError(t, err)
+	_, err = db.AppendFrom(bytes.NewReader(genSeedBytes(50, 2)), 50)
+	require.NoError(t, err)
+	_, err = db.AppendBatch([][]byte{genSeedBytes(50, 3)})
+	require.NoError(t, err)
+	require.NoError(t, db.Flush())
+
+	var seeds []int
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		assert.Equal(t, now, rec.Timestamp)
+		assert.NoError(t, checkSeedBytes(rec.Data, int(rec.Data[0])))
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, seeds)
+
+	report, err := db.Reader().Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+}
+
+func TestDB_RecordTimestamps_Disabled(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta))
+	require.NoError(t, err)
+
+	_, err = db.AppendAt(time.Now(), genSeedBytes(50, 1))
+	assert.Equal(t, ErrTimestampsDisabled, err)
+
+	_, err = db.Append(genSeedBytes(50, 1))
+	require.NoError(t, err)
+	require.NoError(t, db.Flush())
+
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		assert.True(t, rec.Timestamp.IsZero())
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, errs := db.Reader().ScanTimeRange(context.Background(), time.Time{}, time.Now())
+	assert.Equal(t, ErrTimestampsDisabled, <-errs)
+	require.NoError(t, db.Close())
+
+	_, err = New(folder, WithNoFileLock, WithMetaDB(meta), WithRecordTimestamps())
+	assert.Equal(t, ErrTimestampsEnabled, errors.Cause(err))
+}
+
+func TestReader_SeekTime(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000), WithRecordTimestamps())
+	require.NoError(t, err)
+	defer db.Close()
+
+	// two records per chunk, and the last one in the buffer
+	var positions []int64
+	for i := 0; i < 7; i++ {
+		positions = append(positions, db.VolatilePos())
+		_, err = db.AppendAt(t0.Add(time.Duration(i)*time.Hour), genSeedBytes(400, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Flush())
+
+	index, err := meta.ListTimeIndex()
+	require.NoError(t, err)
+	assert.Len(t, index, 3)
+
+	// the search starts at the sampled chunk, earlier chunks are not read
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(path.Join(folder, chunks[0].FileName)))
+
+	reader := db.Reader()
+	for _, test := range []struct {
+		t   time.Time
+		pos int64
+	}{
+		{t0.Add(3 * time.Hour), positions[3]},
+		{t0.Add(150 * time.Minute), positions[3]},
+		{t0.Add(4 * time.Hour), positions[4]},
+		{t0.Add(6 * time.Hour), positions[6]},
+		{t0.Add(7 * time.Hour), db.VolatilePos()},
+	} {
+		pos, err := reader.SeekTime(test.t)
+		require.NoError(t, err)
+		assert.Equal(t, test.pos, pos, "time %s", test.t)
+	}
+
+	vals, errs := reader.ScanTimeRange(context.Background(), t0.Add(3*time.Hour), t0.Add(5*time.Hour))
+	var seeds []int
+	for rec := range vals {
+		seeds = append(seeds, int(rec.Data[0]))
+	}
+	require.NoError(t, <-errs)
+	assert.Equal(t, []int{3, 4}, seeds)
+}
+
+func TestReader_SeekTime_Unordered(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	db, err := New(folder, WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000), WithRecordTimestamps())
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, hour := range []int{5, 6, 1, 2} {
+		_, err = db.AppendAt(t0.Add(time.Duration(hour)*time.Hour), genSeedBytes(400, hour))
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Flush())
+
+	cellar, err := meta.CellarMeta()
+	require.NoError(t, err)
+	assert.True(t, cellar.UnorderedTimestamps)
+
+	// the first record in position order is stamped after 1h already
+	pos, err := db.Reader().SeekTime(t0.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pos)
+
+	vals, errs := db.Reader().ScanTimeRange(context.Background(), t0.Add(time.Hour), t0.Add(3*time.Hour))
+	var seeds []int
+	for rec := range vals {
+		seeds = append(seeds, int(rec.Data[0]))
+	}
+	require.NoError(t, <-errs)
+	assert.Equal(t, []int{1, 2}, seeds)
+}
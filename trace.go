@@ -0,0 +1,32 @@
+package cellar
+
+// Span names passed to TraceHook.Begin.
+const (
+	// SpanSeal covers sealing a buffer into a chunk as a whole, including all spans below.
+	SpanSeal = "cellar.seal"
+	// SpanFlush covers flushing and syncing the buffer file before it is sealed.
+	SpanFlush = "cellar.flush"
+	// SpanCompress covers streaming the buffer through the compressor and the cipher. Streaming ciphers
+	// encrypt as part of it.
+	SpanCompress = "cellar.compress"
+	// SpanEncrypt covers closing the cipher, which is where AEAD ciphers encrypt the whole chunk.
+	SpanEncrypt = "cellar.encrypt"
+	// SpanSync covers syncing the chunk file to disk.
+	SpanSync = "cellar.sync"
+	// SpanMetaUpdate covers recording the chunk and the new buffer in the meta DB, and removing the old
+	// buffer file.
+	SpanMetaUpdate = "cellar.meta_update"
+)
+
+// TraceHook is called around the expensive steps of sealing a buffer, to attribute latency without
+// depending on a tracing library. Begin starts a span, and the returned function ends it.
+type TraceHook interface {
+	Begin(name string) func()
+}
+
+// nopTrace is used unless WithTraceHook is given.
+type nopTrace struct{}
+
+func (nopTrace) Begin(name string) func() {
+	return func() {}
+}
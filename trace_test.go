@@ -0,0 +1,88 @@
+package cellar
+
+import (
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingTrace struct {
+	mu    *sync.Mutex
+	spans []string
+}
+
+func (r *recordingTrace) Begin(name string) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, "begin "+name)
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.spans = append(r.spans, "end "+name)
+	}
+}
+
+func TestDB_WithTraceHook(t *testing.T) {
+	trace := &recordingTrace{mu: &sync.Mutex{}}
+
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithTraceHook(trace))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Append(genSeedBytes(100, 1))
+	require.NoError(t, err)
+	require.NoError(t, db.SealTheBuffer())
+
+	assert.Equal(t, []string{
+		"begin " + SpanSeal,
+		"begin " + SpanFlush, "end " + SpanFlush,
+		"begin " + SpanCompress, "end " + SpanCompress,
+		"begin " + SpanEncrypt, "end " + SpanEncrypt,
+		"begin " + SpanSync, "end " + SpanSync,
+		"begin " + SpanMetaUpdate, "end " + SpanMetaUpdate,
+		"end " + SpanSeal,
+	}, trace.spans)
+}
+
+// failingCompressor passes the compressor check of the writer, and fails to compress anything after.
+type failingCompressor struct {
+	checked *bool
+}
+
+func (c failingCompressor) Compress(w io.Writer) (CompressionWriter, error) {
+	if !*c.checked {
+		*c.checked = true
+		return NoCompressor{}.Compress(w)
+	}
+	return nil, errors.New("failing compressor")
+}
+
+func (c failingCompressor) Codec() uint32 {
+	return NoCompressor{}.Codec()
+}
+
+func TestDB_WithTraceHook_SealFails(t *testing.T) {
+	trace := &recordingTrace{mu: &sync.Mutex{}}
+
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithTraceHook(trace),
+		WithCompressor(failingCompressor{checked: new(bool)}))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Append(genSeedBytes(100, 1))
+	require.NoError(t, err)
+	assert.Error(t, db.SealTheBuffer())
+
+	// spans end when the seal fails as well
+	assert.Equal(t, []string{
+		"begin " + SpanSeal,
+		"begin " + SpanFlush, "end " + SpanFlush,
+		"begin " + SpanCompress, "end " + SpanCompress,
+		"end " + SpanSeal,
+	}, trace.spans)
+}
@@ -0,0 +1,73 @@
+package cellar
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// TypedCellar stores values of type T as records, encoding them with Marshal and decoding them with
+// Unmarshal. It is a thin layer over Writer and Reader, which remain usable for raw access to the same
+// records. Either of them may be nil, for cellars which are only written or only read.
+type TypedCellar[T any] struct {
+	Writer *Writer
+	Reader *Reader
+
+	Marshal   func(v T) ([]byte, error)
+	Unmarshal func(data []byte, v *T) error
+}
+
+// NewTypedCellar returns a typed cellar encoding its values with marshal and unmarshal.
+func NewTypedCellar[T any](w *Writer, r *Reader, marshal func(v T) ([]byte, error), unmarshal func(data []byte, v *T) error) *TypedCellar[T] {
+	return &TypedCellar[T]{
+		Writer:    w,
+		Reader:    r,
+		Marshal:   marshal,
+		Unmarshal: unmarshal,
+	}
+}
+
+// NewJSONCellar returns a typed cellar storing its values as JSON.
+func NewJSONCellar[T any](w *Writer, r *Reader) *TypedCellar[T] {
+	return NewTypedCellar[T](w, r,
+		func(v T) ([]byte, error) { return json.Marshal(v) },
+		func(data []byte, v *T) error { return json.Unmarshal(data, v) },
+	)
+}
+
+// Append encodes v and appends it as a record, see Writer.Append.
+func (c *TypedCellar[T]) Append(v T) (int64, error) {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return 0, errors.Wrap(err, "Marshal")
+	}
+	return c.Writer.Append(data)
+}
+
+// Scan decodes every record and calls fn with its position and value, stopping at the first error. The
+// position is the one Append returned for the record, as taken by ReadAt. Records failing to decode end the
+// scan with the error of Unmarshal.
+func (c *TypedCellar[T]) Scan(fn func(pos int64, v T) error) error {
+	return c.Reader.ForEach(func(rec *Rec) error {
+		var v T
+		if err := c.Unmarshal(rec.Data, &v); err != nil {
+			return errors.Wrapf(err, "Unmarshal record at %d", rec.StartPos)
+		}
+		return fn(rec.NextPos, v)
+	})
+}
+
+// ReadAt decodes the record Append returned pos for, see Reader.ReadAt.
+func (c *TypedCellar[T]) ReadAt(pos int64) (T, error) {
+	var v T
+
+	rec, err := c.Reader.ReadAt(pos)
+	if err != nil {
+		return v, err
+	}
+
+	if err = c.Unmarshal(rec.Data, &v); err != nil {
+		return v, errors.Wrapf(err, "Unmarshal record at %d", pos)
+	}
+	return v, nil
+}
@@ -0,0 +1,45 @@
+package cellar
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypedCellar(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	c := NewJSONCellar[map[string]int](w, NewReader(folder, newCipher(), newDecompressor(), meta))
+
+	_, err = c.Append(map[string]int{"a": 1})
+	require.NoError(t, err)
+	pos, err := c.Append(map[string]int{"b": 2})
+	require.NoError(t, err)
+
+	// raw records share the cellar with typed ones
+	_, err = w.Append([]byte("not json"))
+	require.NoError(t, err)
+	_, err = w.Checkpoint()
+	require.NoError(t, err)
+
+	v, err := c.ReadAt(pos)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"b": 2}, v)
+
+	var values []map[string]int
+	err = c.Scan(func(scanned int64, v map[string]int) error {
+		if len(values) == 1 {
+			assert.Equal(t, pos, scanned)
+		}
+		values = append(values, v)
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []map[string]int{{"a": 1}, {"b": 2}}, values)
+}
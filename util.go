@@ -10,10 +10,11 @@ var (
 	ErrIsFile = errors.New("provided folder is actually a path")
 )
 
-func ensureFolder(folder string) (err error) {
+// ensureFolder creates folder in fs, unless it exists as a directory.
+func ensureFolder(fs FileSystem, folder string) (err error) {
 
 	var stat os.FileInfo
-	if stat, err = os.Stat(folder); err == nil {
+	if stat, err = fs.Stat(folder); err == nil {
 		if stat.IsDir() {
 			return nil
 		}
@@ -22,7 +23,7 @@ func ensureFolder(folder string) (err error) {
 
 	if os.IsNotExist(err) {
 		// file does not exist - create
-		if err = os.MkdirAll(folder, 0644); err != nil {
+		if err = fs.MkdirAll(folder, 0644); err != nil {
 			return errors.Wrap(err, "MkdirAll")
 		}
 		return nil
@@ -6,16 +6,16 @@ import (
 )
 
 func Test_ensureFolder_folder_exists(t *testing.T) {
-	err := ensureFolder("testdata")
+	err := ensureFolder(osFS{}, "testdata")
 	assert.NoError(t, err)
 }
 
 func Test_ensureFolder_folder_is_file(t *testing.T) {
-	err := ensureFolder("util.go")
+	err := ensureFolder(osFS{}, "util.go")
 	assert.EqualError(t, err, ErrIsFile.Error())
 }
 
 func Test_ensureFolder_folder_not_exists(t *testing.T) {
-	err := ensureFolder("newfolder")
+	err := ensureFolder(osFS{}, "newfolder")
 	assert.NoError(t, err)
 }
@@ -0,0 +1,124 @@
+package cellar
+
+import (
+	"context"
+	"hash/crc32"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+var ErrRecordBounds = errors.New("cellar: record exceeds the bounds of its chunk")
+
+// VerifyReport is the outcome of Reader.Verify.
+type VerifyReport struct {
+	// Chunks is the number of chunks checked, and Records the number of records found in the good ones.
+	Chunks  int
+	Records int64
+
+	// Bad lists the chunks which failed verification, ordered by position.
+	Bad []BadChunk
+}
+
+// OK returns true if no bad chunks were found.
+func (v VerifyReport) OK() bool {
+	return len(v.Bad) == 0
+}
+
+// BadChunk describes a chunk which failed verification, and why.
+type BadChunk struct {
+	StartPos int64
+	FileName string
+	Err      error
+}
+
+// Verify checks the integrity of every sealed chunk: that its file exists with the recorded size and
+// checksum, that it decrypts and decompresses into the data it was sealed from, and that its records stay
+// within the chunk and match their checksums if the cellar stores them. Chunks failing any check are listed
+// in the report rather than ending the verification.
+//
+// Verify bypasses the read cache. It returns an error only if the meta DB cannot be read or ctx is
+// cancelled, in which case the report covers the chunks checked so far.
+func (r *Reader) Verify(ctx context.Context) (VerifyReport, error) {
+	var report VerifyReport
+
+	format, err := r.recordFormat()
+	if err != nil {
+		return report, err
+	}
+
+	chunks, err := r.sortedChunks()
+	if err != nil {
+		return report, errors.Wrap(err, "db.Read")
+	}
+
+	for _, c := range chunks {
+		if err = ctx.Err(); err != nil {
+			return report, err
+		}
+
+		report.Chunks++
+
+		if err = r.verifyChunk(c, format); err != nil {
+			report.Bad = append(report.Bad, BadChunk{StartPos: c.StartPos, FileName: c.FileName, Err: err})
+			continue
+		}
+		report.Records += c.Records
+	}
+	return report, nil
+}
+
+// verifyChunk runs all checks of Verify on a single chunk.
+func (r *Reader) verifyChunk(c *ChunkDto, format recordFormat) error {
+	loc := path.Join(r.Folder, c.FileName)
+
+	stat, err := r.fs.Stat(loc)
+	if err != nil {
+		return errors.Wrap(err, "Stat")
+	}
+	if stat.Size() != c.CompressedDiskSize {
+		return errors.Wrapf(ErrChunkCorrupted, "chunk file has %d bytes, expected %d", stat.Size(), c.CompressedDiskSize)
+	}
+
+	if err = verifyChunkFile(r.fs, loc, c); err != nil {
+		return err
+	}
+	// cellars upgraded to a format with chunk headers have no chunks left without
+	if format.headers && c.HeaderSize == 0 {
+		return ErrNoChunkHeader
+	}
+
+	chunk, err := r.readChunk(c, nil)
+	if err != nil {
+		return err
+	}
+	// chunks sealed before chunk headers have no checksum of their data
+	if c.DataChecksum != 0 && crc32.ChecksumIEEE(chunk) != c.DataChecksum {
+		return errors.Wrapf(ErrChunkCorrupted, "data of chunk %s", c.FileName)
+	}
+
+	records, _, err := validateRecords(chunk, c.StartPos, format.checksums)
+	if err != nil {
+		return err
+	}
+	if records != c.Records {
+		return errors.Wrapf(ErrChunkCorrupted, "chunk holds %d records, expected %d", records, c.Records)
+	}
+	return nil
+}
+
+// validateRecords walks the records of a decoded chunk starting at chunkPos, without trusting its length
+// prefixes. It returns the number of valid records found, and the offset following the last of them.
+func validateRecords(chunk []byte, chunkPos int64, checksums bool) (records int64, end int, err error) {
+	pos := 0
+	for pos < len(chunk) {
+		_, next, err := readRecord(chunk, pos, chunkPos, checksums)
+		if err != nil {
+			return records, pos, err
+		}
+
+		pos = next
+		records++
+	}
+	return records, pos, nil
+}
@@ -0,0 +1,94 @@
+package cellar
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader_Verify(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	for i := 0; i < 4; i++ {
+		_, err = w.Append(genSeedBytes(100, i))
+		require.NoError(t, err)
+		require.NoError(t, w.SealTheBuffer())
+	}
+
+	reader := NewReader(folder, newCipher(), newDecompressor(), meta)
+
+	report, err := reader.Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 4, report.Chunks)
+	assert.Equal(t, int64(4), report.Records)
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+
+	// remove the second chunk, and corrupt the fourth
+	require.NoError(t, os.Remove(path.Join(folder, chunks[1].FileName)))
+
+	file := path.Join(folder, chunks[3].FileName)
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	data[len(data)/2] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(file, data, 0644))
+
+	report, err = reader.Verify(context.Background())
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, 4, report.Chunks)
+	assert.Equal(t, int64(2), report.Records)
+
+	require.Len(t, report.Bad, 2)
+	assert.Equal(t, chunks[1].StartPos, report.Bad[0].StartPos)
+	assert.True(t, os.IsNotExist(errors.Cause(report.Bad[0].Err)))
+	assert.Equal(t, chunks[3].StartPos, report.Bad[1].StartPos)
+	assert.Equal(t, ErrChunkCorrupted, errors.Cause(report.Bad[1].Err))
+}
+
+func TestReader_Verify_Cancelled(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	_, err = w.Append(genSeedBytes(100, 1))
+	require.NoError(t, err)
+	require.NoError(t, w.SealTheBuffer())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report, err := NewReader(folder, newCipher(), newDecompressor(), meta).Verify(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 0, report.Chunks)
+}
+
+func Test_validateRecords(t *testing.T) {
+	// a valid record, followed by a length prefix of 10 with only 3 bytes
+	records, end, err := validateRecords([]byte{2, 1, 20, 1, 2, 3}, 100, false)
+	assert.Equal(t, ErrRecordBounds, errors.Cause(err))
+	assert.Contains(t, err.Error(), "position 102")
+	assert.Equal(t, int64(1), records)
+	assert.Equal(t, 2, end)
+
+	records, end, err = validateRecords([]byte{2, 1, 4, 1, 2}, 100, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), records)
+	assert.Equal(t, 5, end)
+}
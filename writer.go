@@ -1,30 +1,200 @@
 package cellar
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
-	"log"
-	"os"
+	"hash/crc32"
+	"io"
 	"path"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// Errors are returned wrapped with the context they occurred in, such as the size or position involved, so
+// callers tell them apart with errors.Is, or errors.Cause, rather than by comparing them.
+var (
+	ErrValueTooLarge  = errors.New("cellar: value exceeds the maximum value size")
+	ErrRecordTooLarge = errors.New("cellar: record does not fit in an empty buffer")
+	ErrClosed         = errors.New("cellar: writer is closed")
+	ErrNilCompressor  = errors.New("cellar: compressor factory returned nil")
+)
+
+// Writer appends records to the cellar. It is safe for concurrent use; all buffer mutations are serialized
+// by an internal mutex. Readers do not take this mutex and are not blocked by writers.
 type Writer struct {
+	mu *sync.Mutex
+
+	// closed is set once Close begins, after which all methods writing to the cellar fail with ErrClosed
+	closed bool
+
 	db            MetaDB
 	b             *Buffer
 	maxKeySize    int64
 	maxValSize    int64
+	keySalt       []byte
 	folder        string
 	maxBufferSize int64
 	cipher        Cipher
 	encodingBuf   []byte
 
 	compressor Compressor
+
+	// registry, ciphers and dicts are used to read chunks not written with the current compressor or cipher,
+	// see chunkReader
+	registry *CompressorRegistry
+	ciphers  map[uint32]Cipher
+	dicts    map[uint32][]byte
+
+	// recordChecksums follows the length prefix of every record with its CRC32, see WithRecordChecksums
+	recordChecksums bool
+
+	// recordTimestamps starts the body of every record with the time it was appended at, see
+	// WithRecordTimestamps. The timestamp is encoded into stampBuf.
+	recordTimestamps bool
+	stampBuf         []byte
+
+	// lastTimestamp is the latest timestamp appended so far. Once a record is stamped before it,
+	// unorderedTimestamps is set, see loadLastTimestamp.
+	lastTimestamp       int64
+	unorderedTimestamps bool
+
+	// now returns the time recorded for sealed chunks, and for records appended without a timestamp
+	now func() time.Time
+
+	logger  Logger
+	metrics Metrics
+	trace   TraceHook
+
+	// sealed holds the totals of the sealed chunks, and checkpointPos the position of the last checkpoint
+	sealed        Stats
+	checkpointPos int64
+
+	// recordIndex is set once the chunks and the buffer store the index of their first record, see
+	// AppendIndexed
+	recordIndex bool
+
+	// shardLevels is the number of subdirectories new buffers are placed in, see WithDirSharding
+	shardLevels int
+
+	// formatVersion is the layout new chunks are written in, see FormatVersion
+	formatVersion uint32
+
+	// hard limit on the size of a single record, 0 means no limit
+	valueSizeLimit int64
+
+	// checkpoint automatically once either threshold is crossed, 0 disables a threshold
+	autoCheckpointRecords  int64
+	autoCheckpointBytes    int64
+	recordsSinceCheckpoint int64
+	bytesSinceCheckpoint   int64
+
+	// durability selects which flushes and seals sync to disk, see WithDurability
+	durability Durability
+
+	// committer collects seals for group commits, nil unless WithGroupCommit is set
+	committer *groupCommitter
+
+	// compressorFactory replaces compressor at every seal, see WithCompressorFactory
+	compressorFactory func() Compressor
+
+	// keyExtractor returns the key recorded in the bloom filter of the chunk of a record, see WithKeyExtractor
+	keyExtractor func([]byte) []byte
+
+	// keys is set if the writer maintains the key index, see WithKeyIndex
+	keys *keyIndex
+
+	// tempDir holds the temp files of large seals, see WithTempDir
+	tempDir string
+
+	// blockSize is the size of the blocks large seals are compressed in, 0 compresses them in one stream, and
+	// sealConcurrency the number of goroutines compressing them, see WithBlockSize and WithSealConcurrency
+	blockSize       int64
+	sealConcurrency int
+
+	// fs holds the buffer and chunk files, see WithFileSystem
+	fs FileSystem
+
+	// mmaps holds the mappings of chunk files shared with the readers of the DB, nil unless WithMmapReads
+	mmaps *chunkMaps
+
+	// compacted holds the files of the chunks replaced by the last Compact, which are removed by the next
+	// Compact or by Close, so readers which listed the chunks before they were replaced can still read them
+	compacted []string
+}
+
+// OpenWriter returns a writer appending to the cellar in folder, whose metadata is kept in db. It takes the
+// same options as New, of which WithMaxBufferSize, WithCipher, WithKeyring, WithCompressor, WithCodec,
+// WithCompressorFactory, WithCompressionDict, WithCompressorRegistry, WithKeyExtractor, WithMaxValueSize,
+// WithAutoCheckpoint, WithDurability, WithTempDir, WithBlockSize, WithSealConcurrency, WithFileSystem,
+// WithGroupCommit, WithLogger, WithMetrics and WithTraceHook apply to writers; the others are ignored. Unless
+// a cipher or compressor is given, chunks are stored unencrypted and uncompressed.
+func OpenWriter(folder string, db MetaDB, options ...Option) (*Writer, error) {
+	cfg := &DB{
+		buffer:   defaultBufferSize,
+		registry: defaultRegistry,
+		fs:       osFS{},
+		logger:   stdLogger{},
+		metrics:  NopMetrics{},
+		trace:    nopTrace{},
+	}
+
+	for _, opt := range options {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.selectCodec(); err != nil {
+		return nil, err
+	}
+	if err := cfg.selectDict(); err != nil {
+		return nil, err
+	}
+	return newWriter(folder, db, cfg)
 }
 
+// NewWriter returns a writer appending to the cellar in folder. A nil cipher or compressor stores chunks
+// unencrypted or uncompressed, see NoCipher and NoCompressor.
+//
+// Deprecated: use OpenWriter, which takes these settings as options.
 func NewWriter(folder string, maxBufferSize int64, cipher Cipher, compressor Compressor, db MetaDB) (*Writer, error) {
-	err := ensureFolder(folder)
+	return OpenWriter(folder, db, WithMaxBufferSize(maxBufferSize), WithCipher(cipher), WithCompressor(compressor))
+}
+
+// newWriter returns a writer configured by the writer settings of cfg.
+func newWriter(folder string, db MetaDB, cfg *DB) (*Writer, error) {
+	maxBufferSize := cfg.buffer
+
+	cipher := cfg.cipher
+	if cipher == nil {
+		cipher = NoCipher{}
+	}
+	compressor := cfg.compressor
+	if cfg.compressorFactory != nil {
+		if compressor = cfg.compressorFactory(); compressor == nil {
+			return nil, ErrNilCompressor
+		}
+	}
+	if compressor == nil {
+		compressor = NoCompressor{}
+	}
+
+	if err := SelfTest(cipher); err != nil {
+		return nil, errors.Wrap(err, "cipher self test")
+	}
+	if err := checkCompressor(compressor); err != nil {
+		return nil, errors.Wrap(err, "compressor check")
+	}
+
+	fs := cfg.fs
+	if fs == nil {
+		fs = osFS{}
+	}
+
+	err := ensureFolder(fs, folder)
 	if err != nil {
 		return nil, err
 	}
@@ -32,40 +202,119 @@ func NewWriter(folder string, maxBufferSize int64, cipher Cipher, compressor Com
 	var meta *MetaDto
 	var b *Buffer
 
+	if meta, err = db.CellarMeta(); err != nil {
+		return nil, errors.Wrap(err, "lmdbGetCellarMeta")
+	}
+	if err = checkFormat(meta); err != nil {
+		return nil, err
+	}
+
+	var storedShardLevels int
+	if meta != nil {
+		storedShardLevels = int(meta.DirShardLevels)
+	}
+	shardLevels := storedShardLevels
+	if cfg.dirSharding {
+		shardLevels = cfg.dirShardLevels
+	}
+
 	dto, err := db.GetBuffer()
 	if err != nil {
 		return nil, err
 	}
 
+	// seal concurrency alone compresses large seals in blocks of the default size
+	blockSize := cfg.blockSize
+	if blockSize == 0 && cfg.sealConcurrency > 1 {
+		blockSize = defaultBlockSize
+	}
+
+	// new cellars are created in the latest layout, existing ones keep theirs until UpgradeFormat
+	formatVersion := uint32(FormatVersion)
+	if dto != nil && meta != nil {
+		formatVersion = meta.FormatVersion
+	}
+
 	if dto == nil {
-		b, err = createBuffer(db, 0, maxBufferSize, folder, cipher, compressor)
+		b, err = createBuffer(fs, db, 0, 0, maxBufferSize, folder, shardLevels, cipher, compressor)
 		if err != nil {
 			return nil, errors.Wrap(err, "SetNewBuffer")
 		}
 	} else {
-		b, err = openBuffer(dto, folder, cipher, compressor)
+		b, err = openBuffer(fs, dto, folder, cipher, compressor)
 		if err != nil {
 			return nil, errors.Wrap(err, "openBuffer")
 		}
 	}
-
-	if meta, err = db.CellarMeta(); err != nil {
-		return nil, errors.Wrap(err, "lmdbGetCellarMeta")
-	}
+	b.durability = cfg.durability
 
 	wr := &Writer{
+		mu:            &sync.Mutex{},
 		folder:        folder,
 		maxBufferSize: maxBufferSize,
 		cipher:        cipher,
-		encodingBuf:   make([]byte, binary.MaxVarintLen64),
+		encodingBuf:   make([]byte, binary.MaxVarintLen64+recordChecksumSize, 2*binary.MaxVarintLen64+recordChecksumSize),
+		stampBuf:      make([]byte, binary.MaxVarintLen64),
 		db:            db,
 		b:             b,
 		compressor:    compressor,
+		registry:      cfg.registry,
+		ciphers:       cfg.ciphers,
+		dicts:         cfg.dicts,
+		now:           time.Now,
+		shardLevels:   shardLevels,
+		logger:        cfg.logger,
+		metrics:       NopMetrics{},
+		trace:         cfg.trace,
+
+		valueSizeLimit:        cfg.maxValueSize,
+		autoCheckpointRecords: cfg.autoCheckpointRecords,
+		autoCheckpointBytes:   cfg.autoCheckpointBytes,
+		durability:            cfg.durability,
+		compressorFactory:     cfg.compressorFactory,
+		keyExtractor:          cfg.keyExtractor,
+		formatVersion:         formatVersion,
+		tempDir:               cfg.tempDir,
+		blockSize:             blockSize,
+		sealConcurrency:       cfg.sealConcurrency,
+		fs:                    fs,
+		mmaps:                 cfg.mmaps,
 	}
 
 	if meta != nil {
 		wr.maxKeySize = meta.MaxKeySize
 		wr.maxValSize = meta.MaxValSize
+		wr.keySalt = meta.KeySalt
+		wr.recordChecksums = meta.RecordChecksums
+		wr.recordTimestamps = meta.RecordTimestamps
+		wr.unorderedTimestamps = meta.UnorderedTimestamps
+		wr.recordIndex = meta.RecordIndex
+	}
+
+	if shardLevels != storedShardLevels || meta == nil || meta.FormatVersion != formatVersion {
+		if err = db.SetCellarMeta(wr.cellarMeta()); err != nil {
+			return nil, errors.Wrap(err, "SetCellarMeta")
+		}
+	}
+
+	wr.checkpointPos = b.startPos + b.pos
+	if err = wr.loadStats(); err != nil {
+		return nil, err
+	}
+	if err = wr.loadLastTimestamp(); err != nil {
+		return nil, err
+	}
+	if err = wr.loadRecordIndex(); err != nil {
+		return nil, err
+	}
+
+	// report the chunks found once they are all counted
+	wr.metrics = cfg.metrics
+	wr.metrics.ChunkCount(wr.sealed.Chunks)
+
+	if cfg.groupCommit > 0 {
+		wr.committer = newGroupCommitter(cfg.groupCommit)
+		go wr.runGroupCommits(wr.committer)
 	}
 
 	return wr, nil
@@ -73,33 +322,142 @@ func NewWriter(folder string, maxBufferSize int64, cipher Cipher, compressor Com
 }
 
 func (w *Writer) VolatilePos() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	if w.b != nil {
 		return w.b.startPos + w.b.pos
 	}
 	return 0
 }
 
+// SealedPos returns the end position of the last sealed chunk, which is the start position of the current
+// buffer. Records before it are durable, while records between it and VolatilePos are lost on a crash unless
+// they were checkpointed.
+func (w *Writer) SealedPos() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.b != nil {
+		return w.b.startPos
+	}
+	return 0
+}
+
+// IsDurable reports whether the records before pos survive a crash, so an append can be acknowledged once
+// IsDurable returns true for the position it returned. Records are durable once they are in a sealed chunk
+// recorded in the meta DB, or in the buffer up to the last checkpoint:
+//
+//   - Append and Flush leave records volatile, even though Flush makes them visible to readers;
+//   - Checkpoint, including the automatic checkpoints of WithAutoCheckpoint, makes all appended records durable;
+//   - SealTheBuffer makes the records of the sealed buffer durable, unless seals are committed in groups, see
+//     WithGroupCommit, in which case they are durable once their group is committed.
+//
+// Durable records are synced to disk as far as the durability level allows, see WithDurability. With
+// DurabilityNone, they survive a crash of the process but not of the machine.
+func (w *Writer) IsDurable(pos int64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.b == nil {
+		return false
+	}
+
+	// chunks waiting for their group commit are not recorded yet
+	sealed := w.b.startPos
+	if w.committer != nil && len(w.committer.pending) > 0 {
+		sealed = w.committer.pending[0].chunk.StartPos
+	}
+	if w.checkpointPos > sealed {
+		return pos <= w.checkpointPos
+	}
+	return pos <= sealed
+}
+
+// BufferFillRatio returns how full the current buffer is, from 0 for an empty buffer to 1 for a full one. The
+// buffer is sealed once the next record does not fit, so producers can use it to pace their appends, or to
+// call SealTheBuffer during idle periods instead of sealing in the middle of a burst.
+func (w *Writer) BufferFillRatio() float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.b == nil || w.b.maxBytes <= 0 {
+		return 0
+	}
+	return float64(w.b.pos) / float64(w.b.maxBytes)
+}
+
+// Append appends a record, and returns the position following it.
 func (w *Writer) Append(data []byte) (pos int64, err error) {
+	return w.AppendContext(context.Background(), data)
+}
+
+// AppendNoCopy is Append for callers reusing the memory of data, such as a scratch buffer filled for every
+// record. The record is written straight from data into the buffer, and data is not retained after return,
+// so the caller may modify it as soon as AppendNoCopy returns.
+func (w *Writer) AppendNoCopy(data []byte) (int64, error) {
+	pos, _, err := w.appendAt(context.Background(), w.now(), data)
+	return pos, err
+}
+
+// AppendContext is Append, giving up if ctx is done before the record is written. A seal of the full buffer
+// is aborted as well when ctx is done, leaving the buffer as it was, so the append can be retried.
+func (w *Writer) AppendContext(ctx context.Context, data []byte) (pos int64, err error) {
+	pos, _, err = w.appendAt(ctx, w.now(), data)
+	return pos, err
+}
+
+// appendAt appends a record, stamped with ts if the cellar stores record timestamps, and returns the position
+// following it and its record index.
+func (w *Writer) appendAt(ctx context.Context, ts time.Time, data []byte) (pos, idx int64, err error) {
+	if err = ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	w.mu.Lock()
+	var sealed *commitGroup
+	defer func() {
+		w.mu.Unlock()
+		if err == nil {
+			err = sealed.await()
+		}
+	}()
+
+	if w.closed {
+		return 0, 0, ErrClosed
+	}
 
 	dataLen := int64(len(data))
-	n := binary.PutVarint(w.encodingBuf, dataLen)
+	if w.valueSizeLimit > 0 && dataLen > w.valueSizeLimit {
+		return 0, 0, errors.Wrapf(ErrValueTooLarge, "value of %d bytes, limit %d", dataLen, w.valueSizeLimit)
+	}
 
-	totalSize := n + len(data)
+	if err = w.trackTimestamp(ts); err != nil {
+		return 0, 0, err
+	}
 
-	if !w.b.fits(int64(totalSize)) {
-		if err = w.Flush(); err != nil {
-			return 0, errors.Wrap(err, "SealTheBuffer")
+	header := w.dataHeader(ts, data)
+
+	totalSize := len(header) + len(data)
+
+	// records larger than the buffer are written to an empty buffer, which grows to hold them
+	if !w.b.fits(int64(totalSize)) && w.b.pos > 0 {
+		if err = w.sealTheBuffer(ctx); err != nil {
+			return 0, 0, errors.Wrap(err, "SealTheBuffer")
 		}
+		sealed = w.sealGroup()
 	}
 
-	if err = w.b.writeBytes(w.encodingBuf[0:n]); err != nil {
-		return 0, errors.Wrap(err, "write len prefix")
+	if err = w.b.writeBytes(header); err != nil {
+		return 0, 0, errors.Wrap(err, "write len prefix")
 	}
 	if err = w.b.writeBytes(data); err != nil {
-		return 0, errors.Wrap(err, "write body")
+		return 0, 0, errors.Wrap(err, "write body")
 	}
 
-	w.b.endRecord()
+	idx = w.b.startIndex + w.b.records
+	w.endRecord(ts)
+	w.metrics.RecordAppended(dataLen)
 
 	// update statistics
 	if dataLen > w.maxValSize {
@@ -108,79 +466,524 @@ func (w *Writer) Append(data []byte) (pos int64, err error) {
 
 	pos = w.b.startPos + w.b.pos
 
+	if err = w.maybeCheckpoint(int64(totalSize)); err != nil {
+		return 0, 0, errors.Wrap(err, "auto checkpoint")
+	}
+
+	return pos, idx, nil
+}
+
+// AppendFrom appends a record of exactly size bytes read from r, without holding the record in memory.
+// The buffer is sealed first if the record does not fit, and records which would not even fit in an empty
+// buffer are rejected with ErrRecordTooLarge. If r fails to provide size bytes, the partial record is
+// discarded and the error is returned.
+func (w *Writer) AppendFrom(r io.Reader, size int64) (pos int64, err error) {
+	w.mu.Lock()
+	var sealed *commitGroup
+	defer func() {
+		w.mu.Unlock()
+		if err == nil {
+			err = sealed.await()
+		}
+	}()
+
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	if w.valueSizeLimit > 0 && size > w.valueSizeLimit {
+		return 0, errors.Wrapf(ErrValueTooLarge, "value of %d bytes, limit %d", size, w.valueSizeLimit)
+	}
+
+	// the checksum is only known once the record has been copied, and is filled in afterwards
+	ts := w.now()
+	if err = w.trackTimestamp(ts); err != nil {
+		return 0, err
+	}
+	stamp := w.encodeStamp(ts)
+	header := append(w.encodeHeader(int64(len(stamp))+size, 0), stamp...)
+
+	totalSize := int64(len(header)) + size
+	if totalSize > w.maxBufferSize {
+		return 0, errors.Wrapf(ErrRecordTooLarge, "record of %d bytes, buffer of %d", totalSize, w.maxBufferSize)
+	}
+
+	if !w.b.fits(totalSize) {
+		if err = w.sealTheBuffer(context.Background()); err != nil {
+			return 0, errors.Wrap(err, "SealTheBuffer")
+		}
+		sealed = w.sealGroup()
+	}
+
+	start := w.b.pos
+
+	if err = w.b.writeBytes(header); err != nil {
+		return 0, errors.Wrap(err, "write len prefix")
+	}
+
+	hash := crc32.NewIEEE()
+	if w.recordChecksums {
+		hash.Write(stamp)
+		r = io.TeeReader(r, hash)
+	}
+
+	if err = w.b.writeFrom(r, size); err != nil {
+		if rewindErr := w.b.rewind(start); rewindErr != nil {
+			return 0, errors.Wrap(rewindErr, "rewind")
+		}
+		return 0, errors.Wrap(err, "write body")
+	}
+
+	if w.recordChecksums {
+		offset := len(header) - len(stamp) - recordChecksumSize
+		sum := header[offset : offset+recordChecksumSize]
+		binary.BigEndian.PutUint32(sum, hash.Sum32())
+		if err = w.b.patch(start+int64(offset), sum); err != nil {
+			return 0, errors.Wrap(err, "write checksum")
+		}
+	}
+
+	w.endRecord(ts)
+	w.metrics.RecordAppended(size)
+
+	if size > w.maxValSize {
+		w.maxValSize = size
+	}
+
+	pos = w.b.startPos + w.b.pos
+
+	if err = w.maybeCheckpoint(totalSize); err != nil {
+		return 0, errors.Wrap(err, "auto checkpoint")
+	}
+
 	return pos, nil
 }
 
-func createBuffer(db MetaDB, startPos int64, maxSize int64, folder string, cipher Cipher, compressor Compressor) (*Buffer, error) {
-	name := fmt.Sprintf("%012d", startPos)
+// maybeCheckpoint accounts for a record of the given size, and checkpoints once the auto checkpoint
+// thresholds are crossed.
+func (w *Writer) maybeCheckpoint(size int64) error {
+	w.recordsSinceCheckpoint++
+	w.bytesSinceCheckpoint += size
+
+	if (w.autoCheckpointRecords > 0 && w.recordsSinceCheckpoint >= w.autoCheckpointRecords) ||
+		(w.autoCheckpointBytes > 0 && w.bytesSinceCheckpoint >= w.autoCheckpointBytes) {
+		_, err := w.checkpoint()
+		return err
+	}
+	return nil
+}
+
+// AppendBatch appends all records in order, returning the position Append would have returned for each of
+// them. The buffer is sealed mid-batch whenever the next record does not fit, which commits the sealed
+// chunk before the remaining records are written.
+func (w *Writer) AppendBatch(records [][]byte) (positions []int64, err error) {
+	w.mu.Lock()
+	var sealed *commitGroup
+	defer func() {
+		w.mu.Unlock()
+		if err == nil {
+			err = sealed.await()
+		}
+	}()
+
+	if w.closed {
+		return nil, ErrClosed
+	}
+
+	if w.valueSizeLimit > 0 {
+		// reject the batch before any of it is written
+		for i, data := range records {
+			if int64(len(data)) > w.valueSizeLimit {
+				return nil, errors.Wrapf(ErrValueTooLarge, "record %d of %d bytes, limit %d", i, len(data), w.valueSizeLimit)
+			}
+		}
+	}
+
+	positions = make([]int64, len(records))
+	maxValSize := w.maxValSize
+	ts := w.now()
+	if err := w.trackTimestamp(ts); err != nil {
+		return nil, err
+	}
+
+	for i, data := range records {
+
+		dataLen := int64(len(data))
+		header := w.dataHeader(ts, data)
+		n := len(header)
+
+		if !w.b.fits(int64(n)+dataLen) && w.b.pos > 0 {
+			if err := w.sealTheBuffer(context.Background()); err != nil {
+				return nil, errors.Wrap(err, "SealTheBuffer")
+			}
+			sealed = w.sealGroup()
+		}
+
+		if err := w.b.writeBytes(header); err != nil {
+			return nil, errors.Wrap(err, "write len prefix")
+		}
+		if err := w.b.writeBytes(data); err != nil {
+			return nil, errors.Wrap(err, "write body")
+		}
+
+		w.endRecord(ts)
+		w.metrics.RecordAppended(dataLen)
+
+		if dataLen > maxValSize {
+			maxValSize = dataLen
+		}
+
+		positions[i] = w.b.startPos + w.b.pos
+
+		if err := w.maybeCheckpoint(int64(n) + dataLen); err != nil {
+			return nil, errors.Wrap(err, "auto checkpoint")
+		}
+	}
+
+	w.maxValSize = maxValSize
+	return positions, nil
+}
+
+// encodeHeader encodes the header of a record of the given size into encodingBuf: the length prefix,
+// followed by the checksum of the record if the cellar stores record checksums.
+func (w *Writer) encodeHeader(size int64, sum uint32) []byte {
+	n := binary.PutVarint(w.encodingBuf, size)
+	if w.recordChecksums {
+		binary.BigEndian.PutUint32(w.encodingBuf[n:], sum)
+		n += recordChecksumSize
+	}
+	return w.encodingBuf[:n]
+}
+
+// encodeStamp encodes ts as varint unix nanos into stampBuf if the cellar stores record timestamps, and
+// returns nothing otherwise. The timestamp starts the body of the record, so the length prefix and the
+// checksum cover it.
+func (w *Writer) encodeStamp(ts time.Time) []byte {
+	if !w.recordTimestamps {
+		return nil
+	}
+	n := binary.PutVarint(w.stampBuf, ts.UnixNano())
+	return w.stampBuf[:n]
+}
+
+// dataHeader encodes the header of data appended at ts, see encodeHeader, followed by the encoded
+// timestamp.
+func (w *Writer) dataHeader(ts time.Time, data []byte) []byte {
+	stamp := w.encodeStamp(ts)
+
+	var sum uint32
+	if w.recordChecksums {
+		sum = crc32.Update(crc32.ChecksumIEEE(stamp), crc32.IEEETable, data)
+	}
+	return append(w.encodeHeader(int64(len(stamp)+len(data)), sum), stamp...)
+}
+
+// endRecord completes the record appended at ts.
+func (w *Writer) endRecord(ts time.Time) {
+	if w.recordTimestamps {
+		w.b.stampRecord(ts.UnixNano())
+		if ts.UnixNano() > w.lastTimestamp {
+			w.lastTimestamp = ts.UnixNano()
+		}
+	}
+	w.b.endRecord()
+}
+
+// createBuffer creates the buffer starting at startPos with the record index startIndex in fs, placed in
+// shardLevels of subdirectories, see WithDirSharding.
+func createBuffer(fs FileSystem, db MetaDB, startPos, startIndex, maxSize int64, folder string, shardLevels int, cipher Cipher, compressor Compressor) (*Buffer, error) {
+	buf, err := createBufferFile(fs, startPos, startIndex, maxSize, folder, shardLevels, cipher, compressor)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.PutBuffer(buf.getState()); err != nil {
+		return nil, errors.Wrap(err, "lmdbPutBuffer")
+	}
+	return buf, nil
+}
+
+// createBufferFile creates the file of a new buffer, as createBuffer does, without storing the buffer in the
+// meta DB.
+func createBufferFile(fs FileSystem, startPos, startIndex, maxSize int64, folder string, shardLevels int, cipher Cipher, compressor Compressor) (*Buffer, error) {
+	name := shardedName(fmt.Sprintf("%012d", startPos), shardLevels)
+	if err := createShardDir(fs, folder, name); err != nil {
+		return nil, err
+	}
+
 	dto := &BufferDto{
 		Pos:      0,
 		StartPos: startPos,
 		MaxBytes: maxSize,
 		Records:  0,
 		FileName: name,
+
+		StartIndex: startIndex,
 	}
 	var err error
 	var buf *Buffer
 
-	if buf, err = openBuffer(dto, folder, cipher, compressor); err != nil {
+	if buf, err = openBuffer(fs, dto, folder, cipher, compressor); err != nil {
 		return nil, errors.Wrapf(err, "openBuffer %s", folder)
 	}
+	return buf, nil
+}
 
-	if err = db.PutBuffer(dto); err != nil {
-		return nil, errors.Wrap(err, "lmdbPutBuffer")
+// Flush writes the records held in memory to the buffer file, making them visible to readers created from
+// the same DB. It neither seals the buffer nor checkpoints it, so flushed records survive a crash of the
+// process only once they are checkpointed.
+//
+// In short:
+//
+//   - Flush writes buffered bytes to the buffer file;
+//   - Checkpoint flushes, and persists the buffer position in the meta DB so the records survive a restart;
+//   - SealTheBuffer compresses and encrypts the buffer into an immutable chunk, and starts a new buffer.
+//
+// How far each of them gets records towards the disk depends on the durability level, see WithDurability.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
 	}
-	return buf, nil
 
+	if err := w.b.flush(); err != nil {
+		return err
+	}
+	return w.indexKeys()
 }
 
-func (w *Writer) Flush() error {
+// SealTheBuffer flushes the current buffer, compresses it into a chunk, and replaces it with a new buffer.
+func (w *Writer) SealTheBuffer() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+
+	if err := w.sealTheBuffer(context.Background()); err != nil {
+		return err
+	}
+	return w.commitPending()
+}
+
+// SealTheBufferIfLargerThan seals the current buffer as SealTheBuffer does, but only if it holds at least
+// minBytes, and reports whether it was sealed. An empty buffer is never sealed. Periodic flushers use it to
+// seal during quiet periods without producing a flood of tiny chunks.
+func (w *Writer) SealTheBufferIfLargerThan(minBytes int64) (sealed bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return false, ErrClosed
+	}
+
+	if w.b.pos == 0 || w.b.pos < minBytes {
+		return false, nil
+	}
+
+	if err = w.sealTheBuffer(context.Background()); err != nil {
+		return false, err
+	}
+	if err = w.commitPending(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// sealTheBuffer seals the current buffer. If ctx is done before the chunk is recorded, or the chunk can't be
+// recorded in the meta DB, the seal is aborted and the current buffer is kept. An empty buffer is not sealed,
+// since its chunk would take the position of the next one.
+func (w *Writer) sealTheBuffer(ctx context.Context) error {
+	if w.b.pos == 0 {
+		return nil
+	}
+
+	defer w.trace.Begin(SpanSeal)()
 
 	var err error
 
 	oldBuffer := w.b
 	var newBuffer *Buffer
 
+	if err = w.refreshCompressor(); err != nil {
+		return err
+	}
+	oldBuffer.compressor = w.compressor
+
 	if err = oldBuffer.flush(); err != nil {
 		return errors.Wrap(err, "buffer.Flush")
 	}
+	if err = w.indexKeys(); err != nil {
+		return errors.Wrap(err, "indexKeys")
+	}
+
+	bloom, err := w.bufferBloom(oldBuffer)
+	if err != nil {
+		return err
+	}
 
 	var dto *ChunkDto
 
-	if dto, err = oldBuffer.compress(); err != nil {
+	if dto, err = oldBuffer.compress(ctx, w.cellarMeta(), w.now().Unix(), w.tempDir, w.blockSize, w.sealConcurrency, w.trace); err != nil {
 		return errors.Wrap(err, "compress")
 	}
+	if bloom != nil {
+		dto.Bloom = bloom.bits
+		dto.BloomHashes = bloom.hashes
+	}
 
 	newStartPos := dto.StartPos + dto.UncompressedByteSize
 
+	if w.committer != nil {
+		// the chunk is recorded by the next group commit, until then readers keep reading the sealed buffer
+		newBuffer, err = createBufferFile(w.fs, newStartPos, dto.StartIndex+dto.Records, w.maxBufferSize, w.folder, w.shardLevels, w.cipher, w.compressor)
+		if err != nil {
+			return errors.Wrap(err, "createBufferFile")
+		}
+		newBuffer.durability = w.durability
+		w.committer.add(pendingSeal{chunk: dto, buffer: oldBuffer.getState()}, newBuffer.getState())
+		w.countChunk(dto, 1)
+		w.metrics.BufferSealed(dto.CompressedDiskSize, dto.UncompressedByteSize)
+
+		w.b = newBuffer
+		w.closeSealed(oldBuffer)
+		return nil
+	}
+
+	end := w.trace.Begin(SpanMetaUpdate)
+	defer end()
+
 	err = w.db.AddChunk(dto.StartPos, dto)
 	if err != nil {
+		// keep writing to the old buffer, whose chunk is sealed again by the next seal
+		if rerr := w.removeChunkFile(dto.FileName); rerr != nil {
+			w.logger.Printf("cellar: %s", rerr)
+		}
+		if rerr := oldBuffer.resume(); rerr != nil {
+			return errors.Wrap(rerr, "resume buffer")
+		}
 		return err
 	}
+	w.countChunk(dto, 1)
+	w.indexChunk(dto)
+	w.metrics.BufferSealed(dto.CompressedDiskSize, dto.UncompressedByteSize)
 
-	newBuffer, err = createBuffer(w.db, newStartPos, w.maxBufferSize, w.folder, w.cipher, w.compressor)
+	newBuffer, err = createBuffer(w.fs, w.db, newStartPos, dto.StartIndex+dto.Records, w.maxBufferSize, w.folder, w.shardLevels, w.cipher, w.compressor)
 	if err != nil {
 		return errors.Wrap(err, "createBuffer")
 	}
 
+	newBuffer.durability = w.durability
 	w.b = newBuffer
+	w.closeSealed(oldBuffer)
+
+	if err = w.storeKeys(); err != nil {
+		return err
+	}
 
 	oldBufferPath := path.Join(w.folder, oldBuffer.fileName)
 
-	if err = os.Remove(oldBufferPath); err != nil {
-		log.Printf("Can't remove old buffer %s: %s", oldBufferPath, err)
+	if err = w.fs.Remove(oldBufferPath); err != nil {
+		w.logger.Printf("Can't remove old buffer %s: %s", oldBufferPath, err)
 	}
 	return nil
 
 }
 
-// Close disposes all resources
-func (w *Writer) Close() error {
+// closeSealed closes the file of a buffer which was sealed and replaced by a new buffer.
+func (w *Writer) closeSealed(b *Buffer) {
+	if err := b.close(); err != nil {
+		w.logger.Printf("cellar: can't close sealed buffer %s: %s", b.fileName, err)
+	}
+}
+
+// bufferBloom returns the bloom filter for the chunk the buffer is sealed into, reading the records back
+// from the flushed buffer file, or nil if the writer extracts no keys.
+func (w *Writer) bufferBloom(b *Buffer) (*bloomFilter, error) {
+	if w.keyExtractor == nil {
+		return nil, nil
+	}
 
-	// TODO: flush, checkpoint and close current buffer
+	data := make([]byte, b.pos)
+	if _, err := b.stream.ReadAt(data, 0); err != nil {
+		return nil, errors.Wrap(err, "read buffer")
+	}
+	return w.buildBloom(data, b.records)
+}
+
+// refreshCompressor asks the compressor factory, if any, for the compressor of the next seal.
+func (w *Writer) refreshCompressor() error {
+	if w.compressorFactory == nil {
+		return nil
+	}
+	compressor := w.compressorFactory()
+	if compressor == nil {
+		return ErrNilCompressor
+	}
+	w.compressor = compressor
 	return nil
 }
 
+// cellarMeta returns the metadata of the cellar, as kept by the writer.
+func (w *Writer) cellarMeta() *MetaDto {
+	meta := &MetaDto{
+		MaxKeySize:          w.maxKeySize,
+		MaxValSize:          w.maxValSize,
+		KeySalt:             w.keySalt,
+		RecordChecksums:     w.recordChecksums,
+		RecordTimestamps:    w.recordTimestamps,
+		UnorderedTimestamps: w.unorderedTimestamps,
+		DirShardLevels:      int32(w.shardLevels),
+		RecordIndex:         w.recordIndex,
+		FormatVersion:       w.formatVersion,
+	}
+	if w.formatVersion >= formatChunkHeaders {
+		meta.ChunkHeaderVersion = chunkHeaderVersion
+	}
+	return meta
+}
+
+// flushedBuffer returns the state of the current buffer as of its last flush.
+func (w *Writer) flushedBuffer() (*BufferDto, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// records after a pending seal become visible once it is committed
+	if w.committer != nil && len(w.committer.pending) > 0 {
+		return w.committer.pending[0].buffer, nil
+	}
+	return w.b.getFlushedState(), nil
+}
+
+// Close checkpoints the current buffer, so no appended records are lost, and closes the buffer file. The
+// meta DB is not closed, since it is not owned by the writer.
+//
+// Appends waiting for the writer while it closes, and any calls made afterwards, fail with ErrClosed, as
+// does closing the writer again. The writer is considered closed even if the final checkpoint fails.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+	if w.committer != nil {
+		close(w.committer.stop)
+	}
+
+	if _, err := w.checkpoint(); err != nil {
+		return errors.Wrap(err, "Checkpoint")
+	}
+	if err := w.removeCompacted(); err != nil {
+		w.logger.Printf("cellar: %s", err)
+	}
+	return w.b.close()
+}
+
 func (w *Writer) PutUserCheckpoint(name string, pos int64) error {
 	return w.db.PutCheckpoint(name, pos)
 }
@@ -189,31 +992,71 @@ func (w *Writer) GetUserCheckpoint(name string) (int64, error) {
 	return w.db.GetCheckpoint(name)
 }
 
+// ListUserCheckpoints returns the positions of all named checkpoints by name, for example to compute the lag
+// of every consumer.
+func (w *Writer) ListUserCheckpoints() (map[string]int64, error) {
+	return w.db.ListCheckpoints()
+}
+
+// DeleteUserCheckpoint removes a named checkpoint, for example of a consumer which went away. Deleting a
+// checkpoint which does not exist fails with ErrCheckpointNotExists.
+func (w *Writer) DeleteUserCheckpoint(name string) error {
+	return w.db.DeleteCheckpoint(name)
+}
+
+// CasUserCheckpoint moves a named checkpoint from expected to pos in a single meta DB transaction, so
+// cooperating consumers advancing the same checkpoint do not clobber each other. It returns false without an
+// error if the checkpoint is no longer at expected, in which case the caller reads it again and retries.
+// Checkpoints are created with PutUserCheckpoint; a missing one fails with ErrCheckpointNotExists.
+func (w *Writer) CasUserCheckpoint(name string, expected, pos int64) (bool, error) {
+	return w.db.CasCheckpoint(name, expected, pos)
+}
+
 func (w *Writer) Checkpoint() (int64, error) {
-	w.b.flush()
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	return w.checkpoint()
+}
+
+func (w *Writer) checkpoint() (int64, error) {
 	var err error
 
+	if err = w.b.flush(); err != nil {
+		return 0, errors.Wrap(err, "buffer.Flush")
+	}
+	if err = w.indexKeys(); err != nil {
+		return 0, errors.Wrap(err, "indexKeys")
+	}
+
 	dto := w.b.getState()
 
 	current := dto.StartPos + dto.Pos
 
-	err = w.db.PutBuffer(dto)
+	// commits pending seals along with the buffer
+	err = w.commitSeals(dto)
 	if err != nil {
 		return 0, err
 	}
 
-	meta := &MetaDto{
-		MaxKeySize: w.maxKeySize,
-		MaxValSize: w.maxValSize,
-	}
-
-	err = w.db.SetCellarMeta(meta)
+	err = w.db.SetCellarMeta(w.cellarMeta())
 
 	if err != nil {
 		return 0, errors.Wrap(err, "txn.Update")
 	}
 
+	if err = w.storeKeys(); err != nil {
+		return 0, err
+	}
+
+	w.recordsSinceCheckpoint = 0
+	w.bytesSinceCheckpoint = 0
+	w.checkpointPos = current
+
 	return current, nil
 
 }
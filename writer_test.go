@@ -1,11 +1,18 @@
 package cellar
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
+	"io/ioutil"
+	"path"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -105,6 +112,22 @@ func BenchmarkWriter_Append_Small(b *testing.B) {
 	}
 }
 
+// BenchmarkWriter_AppendNoCopy_Small compares to BenchmarkWriter_Append_Small, reusing a single record.
+func BenchmarkWriter_AppendNoCopy_Small(b *testing.B) {
+	const (
+		Message = "a fairly small message"
+	)
+	db, err := New(dbDir, WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(b, err)
+
+	record := []byte(Message)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err = db.AppendNoCopy(record)
+		require.NoError(b, err)
+	}
+}
+
 func BenchmarkWriter_Append_Medium(b *testing.B) {
 	const (
 		Message = "a medium sized message, this one is approximately three times as long."
@@ -131,3 +154,628 @@ func BenchmarkWriter_Append_Large(b *testing.B) {
 		require.NoError(b, err)
 	}
 }
+
+func TestWriter_AppendBatch(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	// enough records to seal the buffer a few times within the batch
+	var records [][]byte
+	for i := 0; i < 300; i++ {
+		records = append(records, genSeedBytes(1000, i))
+	}
+
+	positions, err := db.AppendBatch(records)
+	require.NoError(t, err)
+	require.Len(t, positions, len(records))
+
+	_, err = db.Checkpoint()
+	require.NoError(t, err)
+
+	chunks, err := db.meta.ListChunks()
+	require.NoError(t, err)
+	assert.True(t, len(chunks) > 1)
+
+	i := 0
+	err = db.Reader().ForEach(func(rec *Rec) error {
+		assert.Equal(t, positions[i], rec.NextPos)
+		require.NoError(t, checkSeedBytes(rec.Data, i))
+		i++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, len(records), i)
+}
+
+func BenchmarkWriter_AppendBatch_Small(b *testing.B) {
+	const (
+		Message = "a fairly small message"
+		Batch   = 100
+	)
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()))
+	require.NoError(b, err)
+
+	records := make([][]byte, Batch)
+	for i := range records {
+		records[i] = []byte(Message)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i += Batch {
+		_, err = db.AppendBatch(records)
+		require.NoError(b, err)
+	}
+}
+
+func TestWriter_AutoCheckpoint(t *testing.T) {
+	tcs := []struct {
+		name    string
+		records int64
+		bytes   int64
+	}{
+		{"records", 3, 0},
+		// every record takes 8 bytes including its length prefix
+		{"bytes", 0, 24},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()), WithAutoCheckpoint(tc.records, tc.bytes))
+			require.NoError(t, err)
+
+			defer checkedClose(db)
+
+			for i := 0; i < 7; i++ {
+				_, err = db.Append([]byte("record!"))
+				require.NoError(t, err)
+			}
+
+			buf, err := db.meta.GetBuffer()
+			require.NoError(t, err)
+			assert.Equal(t, int64(6), buf.Records)
+		})
+	}
+}
+
+func TestWriter_SealedPos(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	assert.Equal(t, int64(0), db.SealedPos())
+
+	// 402 bytes per record, two records per chunk
+	for i := 0; i < 3; i++ {
+		_, err = db.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(804), db.SealedPos())
+	assert.Equal(t, int64(1206), db.VolatilePos())
+
+	// readers of the DB see the same boundary
+	pos, err := db.Reader().SealedPos()
+	require.NoError(t, err)
+	assert.Equal(t, int64(804), pos)
+
+	require.NoError(t, db.SealTheBuffer())
+	assert.Equal(t, int64(1206), db.SealedPos())
+
+	pos, err = db.Reader().SealedPos()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1206), pos)
+}
+
+func TestWriter_BufferFillRatio(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	assert.Equal(t, 0.0, db.BufferFillRatio())
+
+	// 402 bytes per record, two records per chunk
+	_, err = db.Append(genSeedBytes(400, 0))
+	require.NoError(t, err)
+	assert.InDelta(t, 0.402, db.BufferFillRatio(), 1e-9)
+
+	_, err = db.Append(genSeedBytes(400, 1))
+	require.NoError(t, err)
+	assert.InDelta(t, 0.804, db.BufferFillRatio(), 1e-9)
+
+	// the third record starts a new buffer
+	_, err = db.Append(genSeedBytes(400, 2))
+	require.NoError(t, err)
+	assert.InDelta(t, 0.402, db.BufferFillRatio(), 1e-9)
+
+	require.NoError(t, db.SealTheBuffer())
+	assert.Equal(t, 0.0, db.BufferFillRatio())
+
+	assert.Equal(t, 0.0, (&Writer{mu: &sync.Mutex{}}).BufferFillRatio())
+}
+
+func TestWriter_IsDurable(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(NewInMemoryMetaDB()), WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	assert.True(t, db.IsDurable(0))
+
+	// flushed records are visible, but not durable
+	pos, err := db.Append(genSeedBytes(400, 0))
+	require.NoError(t, err)
+	assert.False(t, db.IsDurable(pos))
+	require.NoError(t, db.Flush())
+	assert.False(t, db.IsDurable(pos))
+
+	_, err = db.Checkpoint()
+	require.NoError(t, err)
+	assert.True(t, db.IsDurable(pos))
+
+	// a sealed buffer is durable, up to its end
+	next, err := db.Append(genSeedBytes(400, 1))
+	require.NoError(t, err)
+	last, err := db.Append(genSeedBytes(400, 2))
+	require.NoError(t, err)
+	assert.True(t, db.IsDurable(next))
+	assert.False(t, db.IsDurable(last))
+
+	_, err = db.Checkpoint()
+	require.NoError(t, err)
+	assert.True(t, db.IsDurable(last))
+	assert.False(t, db.IsDurable(last+1))
+}
+
+func TestWriter_SealTheBufferIfLargerThan(t *testing.T) {
+	meta := NewInMemoryMetaDB()
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(meta), WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	sealed, err := db.SealTheBufferIfLargerThan(0)
+	require.NoError(t, err)
+	assert.False(t, sealed, "empty buffer")
+
+	_, err = db.Append(genSeedBytes(400, 0))
+	require.NoError(t, err)
+
+	sealed, err = db.SealTheBufferIfLargerThan(403)
+	require.NoError(t, err)
+	assert.False(t, sealed)
+	assert.Equal(t, int64(0), db.SealedPos())
+
+	sealed, err = db.SealTheBufferIfLargerThan(402)
+	require.NoError(t, err)
+	assert.True(t, sealed)
+	assert.Equal(t, int64(402), db.SealedPos())
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	assert.Len(t, chunks, 1)
+}
+
+func TestWriter_MaxValueSize(t *testing.T) {
+	db, err := New(getFolder(), WithNoFileLock, WithMetaDB(newBoltMetaDB()), WithMaxValueSize(10))
+	require.NoError(t, err)
+
+	defer checkedClose(db)
+
+	_, err = db.Append(makeSlice(10))
+	assert.NoError(t, err)
+
+	pos := db.VolatilePos()
+	_, err = db.Append(makeSlice(11))
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+	assert.Contains(t, err.Error(), "value of 11 bytes, limit 10")
+
+	_, err = db.AppendBatch([][]byte{makeSlice(1), makeSlice(11)})
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+	assert.Contains(t, err.Error(), "record 1 of 11 bytes")
+
+	// nothing was written for the rejected records
+	assert.Equal(t, pos, db.VolatilePos())
+}
+
+func TestWriter_Append_Concurrent(t *testing.T) {
+	folder := getFolder()
+	meta := newBoltMetaDB()
+
+	// a small buffer to make sure concurrent appends seal it a couple of times
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+
+	const (
+		Writers = 8
+		Records = 100
+	)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < Writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < Records; j++ {
+				_, err := w.Append([]byte(fmt.Sprintf("%d-%d", i, j)))
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.NoError(t, w.Close())
+
+	seen := make(map[string]int)
+	err = NewReader(folder, newCipher(), newDecompressor(), meta).ForEach(func(rec *Rec) error {
+		seen[string(rec.Data)]++
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Len(t, seen, Writers*Records)
+	for record, count := range seen {
+		assert.Equal(t, 1, count, record)
+	}
+}
+
+// run with -race to verify the closed transition is free of data races
+func TestWriter_Close_Concurrent(t *testing.T) {
+	folder := getFolder()
+	meta := newBoltMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(Lz4Compressor{}))
+	require.NoError(t, err)
+
+	const Writers = 8
+
+	var appended int64
+	wg := &sync.WaitGroup{}
+	for i := 0; i < Writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				_, err := w.Append([]byte(fmt.Sprintf("%d-%d", i, j)))
+				if err != nil {
+					assert.Equal(t, ErrClosed, errors.Cause(err))
+					return
+				}
+				atomic.AddInt64(&appended, 1)
+			}
+		}(i)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, w.Close())
+	wg.Wait()
+
+	_, err = w.Append([]byte("late"))
+	assert.Equal(t, ErrClosed, errors.Cause(err))
+	_, err = w.Checkpoint()
+	assert.Equal(t, ErrClosed, errors.Cause(err))
+	assert.Equal(t, ErrClosed, errors.Cause(w.Close()))
+
+	// every acknowledged append survived the shutdown
+	var count int64
+	err = NewReader(folder, newCipher(), newDecompressor(), meta).ForEach(func(rec *Rec) error {
+		count++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, atomic.LoadInt64(&appended), count)
+}
+
+func TestWriter_AppendFrom(t *testing.T) {
+	folder := getFolder()
+	meta := newBoltMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+
+	_, err = w.AppendFrom(bytes.NewReader(genSeedBytes(600, 0)), 600)
+	require.NoError(t, err)
+
+	// does not fit next to the first record, so the buffer is sealed first
+	_, err = w.AppendFrom(bytes.NewReader(genSeedBytes(600, 1)), 600)
+	require.NoError(t, err)
+
+	_, err = w.AppendFrom(bytes.NewReader(genSeedBytes(1000, 2)), 1000)
+	assert.ErrorIs(t, err, ErrRecordTooLarge)
+
+	// the reader runs dry, the partial record must be discarded
+	pos := w.VolatilePos()
+	_, err = w.AppendFrom(bytes.NewReader(genSeedBytes(100, 3)), 200)
+	assert.Error(t, err)
+	assert.Equal(t, pos, w.VolatilePos())
+
+	_, err = w.AppendFrom(bytes.NewReader(genSeedBytes(100, 4)), 100)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Close())
+
+	var seeds []int
+	err = NewReader(folder, newCipher(), newDecompressor(), meta).ForEach(func(rec *Rec) error {
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 4}, seeds)
+}
+
+func TestWriter_AppendNoCopy(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()))
+	require.NoError(t, err)
+
+	// the same scratch is refilled for every record
+	scratch := make([]byte, 300)
+	for i := 0; i < 5; i++ {
+		copy(scratch, genSeedBytes(300, i))
+		_, err = w.AppendNoCopy(scratch)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	seen := 0
+	err = NewReader(folder, newCipher(), newDecompressor(), meta).ForEach(func(rec *Rec) error {
+		require.NoError(t, checkSeedBytes(rec.Data, seen))
+		seen++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, seen)
+}
+
+// cancelTrace cancels a context as soon as a span is started.
+type cancelTrace struct {
+	span   string
+	cancel context.CancelFunc
+}
+
+func (c cancelTrace) Begin(name string) func() {
+	if name == c.span {
+		c.cancel()
+	}
+	return func() {}
+}
+
+func TestWriter_AppendContext(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000), WithCipher(newCipher()), WithCompressor(newCompressor()),
+		WithTraceHook(cancelTrace{span: SpanCompress, cancel: cancel}))
+	require.NoError(t, err)
+
+	_, err = w.AppendContext(ctx, genSeedBytes(600, 0))
+	require.NoError(t, err)
+
+	// the record does not fit, and the seal it triggers is cancelled half way
+	pos := w.VolatilePos()
+	_, err = w.AppendContext(ctx, genSeedBytes(600, 1))
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.Equal(t, pos, w.VolatilePos())
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	assert.Empty(t, chunks)
+
+	files, err := ioutil.ReadDir(folder)
+	require.NoError(t, err)
+	for _, f := range files {
+		assert.NotContains(t, f.Name(), ".lz4")
+	}
+
+	// a done context is rejected before anything is written
+	_, err = w.AppendContext(ctx, genSeedBytes(10, 2))
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, pos, w.VolatilePos())
+
+	// the writer is still usable
+	_, err = w.AppendContext(context.Background(), genSeedBytes(600, 3))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	var seeds []int
+	err = NewReader(folder, newCipher(), newDecompressor(), meta).ForEach(func(rec *Rec) error {
+		seeds = append(seeds, int(rec.Data[0]))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 3}, seeds)
+}
+
+func TestWriter_NoCipherNoCompressor(t *testing.T) {
+	folder := getFolder()
+	meta := newBoltMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = w.Append(genSeedBytes(400, i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	require.NotEmpty(t, chunks)
+	for _, dto := range chunks {
+		assert.Equal(t, CodecNone, dto.Codec)
+		assert.Equal(t, CipherNone, dto.Cipher)
+		// chunks are stored as is, after their header
+		assert.Equal(t, dto.UncompressedByteSize+int64(dto.HeaderSize), dto.CompressedDiskSize)
+	}
+
+	seen := 0
+	err = NewReader(folder, nil, nil, meta).ForEach(func(rec *Rec) error {
+		require.NoError(t, checkSeedBytes(rec.Data, seen))
+		seen++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, seen)
+}
+
+func TestOpenWriter_Options(t *testing.T) {
+	w, err := OpenWriter(getFolder(), NewInMemoryMetaDB(), WithMaxBufferSize(500), WithMaxValueSize(100), WithCodec(CodecZstd))
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	assert.Equal(t, int64(500), w.maxBufferSize)
+	assert.Equal(t, CodecZstd, w.compressor.Codec())
+	assert.Equal(t, CipherNone, w.cipher.Algorithm())
+
+	_, err = w.Append(make([]byte, 101))
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+
+	_, err = OpenWriter(getFolder(), NewInMemoryMetaDB(), WithMaxBufferSize(0))
+	assert.Error(t, err)
+}
+
+func TestNewWriter_Deprecated(t *testing.T) {
+	w, err := NewWriter(getFolder(), 500, newCipher(), newCompressor(), NewInMemoryMetaDB())
+	require.NoError(t, err)
+	defer checkedClose(w)
+
+	assert.Equal(t, int64(500), w.maxBufferSize)
+	assert.Equal(t, CipherAES, w.cipher.Algorithm())
+}
+
+func TestWriter_Flush(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	defer checkedClose(w)
+
+	pos, err := w.Append(genSeedBytes(100, 0))
+	require.NoError(t, err)
+
+	file := path.Join(folder, w.b.fileName)
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(data[:pos], genSeedBytes(100, 0)))
+
+	// the records are written to the buffer file, but the meta DB still holds the previous position
+	require.NoError(t, w.Flush())
+	data, err = ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.True(t, bytes.Contains(data[:pos], genSeedBytes(100, 0)))
+
+	buf, err := meta.GetBuffer()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), buf.Pos)
+
+	count, err := NewReader(folder, nil, nil, meta).Count()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestWriter_SealTheBuffer_Twice(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	// sealing an empty buffer is a no-op, however often it is done
+	require.NoError(t, w.SealTheBuffer())
+	require.NoError(t, w.SealTheBuffer())
+
+	_, err = w.Append(genSeedBytes(100, 0))
+	require.NoError(t, err)
+	require.NoError(t, w.SealTheBuffer())
+	require.NoError(t, w.SealTheBuffer())
+	_, err = w.AppendBatch([][]byte{genSeedBytes(100, 1), genSeedBytes(100, 2)})
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	chunks, err := meta.ListChunks()
+	require.NoError(t, err)
+	assert.Len(t, chunks, 1)
+
+	seen := 0
+	err = NewReader(folder, nil, nil, meta).ForEach(func(rec *Rec) error {
+		require.NoError(t, checkSeedBytes(rec.Data, seen))
+		seen++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, seen)
+}
+
+func TestWriter_Append_OversizedFirstRecord(t *testing.T) {
+	folder := getFolder()
+	meta := NewInMemoryMetaDB()
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	// the record does not fit the empty buffer, which takes it rather than being sealed
+	_, err = w.Append(genSeedBytes(2000, 0))
+	require.NoError(t, err)
+	_, err = w.AppendBatch([][]byte{genSeedBytes(100, 1)})
+	require.NoError(t, err)
+	require.NoError(t, w.SealTheBuffer())
+	require.NoError(t, w.Close())
+
+	seen := 0
+	err = NewReader(folder, nil, nil, meta).ForEach(func(rec *Rec) error {
+		require.NoError(t, checkSeedBytes(rec.Data, seen))
+		seen++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, seen)
+}
+
+// failingChunkMeta fails recording the first chunk.
+type failingChunkMeta struct {
+	MetaDB
+	failed bool
+}
+
+func (m *failingChunkMeta) AddChunk(pos int64, dto *ChunkDto) error {
+	if !m.failed {
+		m.failed = true
+		return errors.New("add chunk failed")
+	}
+	return m.MetaDB.AddChunk(pos, dto)
+}
+
+func TestWriter_SealTheBuffer_AddChunkFails(t *testing.T) {
+	folder := getFolder()
+	meta := &failingChunkMeta{MetaDB: NewInMemoryMetaDB()}
+
+	w, err := OpenWriter(folder, meta, WithMaxBufferSize(1000))
+	require.NoError(t, err)
+
+	_, err = w.Append(genSeedBytes(100, 0))
+	require.NoError(t, err)
+	assert.Error(t, w.SealTheBuffer())
+
+	// the buffer is kept, and sealed along with the next records
+	_, err = w.Append(genSeedBytes(100, 1))
+	require.NoError(t, err)
+	require.NoError(t, w.SealTheBuffer())
+	require.NoError(t, w.Close())
+
+	seen := 0
+	err = NewReader(folder, nil, nil, meta).ForEach(func(rec *Rec) error {
+		require.NoError(t, checkSeedBytes(rec.Data, seen))
+		seen++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, seen)
+}